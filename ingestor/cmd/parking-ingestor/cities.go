@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+)
+
+// cityListing is a city as printed by the cities command
+type cityListing struct {
+	ID string `json:"id"`
+	api.CityInfo
+}
+
+// runCities prints the cities known to the ParkenDD API
+func runCities(args []string) error {
+	fs := flag.NewFlagSet("cities", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print cities as JSON instead of a table")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err)
+	}
+
+	cities, err := api.NewClient().GetCities()
+	if err != nil {
+		return err
+	}
+
+	listing := make([]cityListing, 0, len(cities))
+	for id, info := range cities {
+		listing = append(listing, cityListing{ID: id, CityInfo: info})
+	}
+	sort.Slice(listing, func(a, b int) bool {
+		if listing[a].Name != listing[b].Name {
+			return listing[a].Name < listing[b].Name
+		}
+		return listing[a].ID < listing[b].ID
+	})
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(listing)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tACTIVE\tLAT\tLNG")
+	for _, city := range listing {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%.4f\t%.4f\n",
+			city.ID, city.Name, city.ActiveSupport, city.Coords.Lat, city.Coords.Lng)
+	}
+	return w.Flush()
+}
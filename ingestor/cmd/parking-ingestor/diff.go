@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// runDiff reports the lots that are stored in only one of two databases or
+// whose metadata differs
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	city := fs.String("city", "", "Only compare lots of this city")
+	asJSON := fs.Bool("json", false, "Write the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if fs.NArg() != 2 {
+		return usageError(errors.New("diff expects two database files: diff [flags] a.db b.db"))
+	}
+	pathA, pathB := fs.Arg(0), fs.Arg(1)
+
+	a, err := database.OpenReadOnly(pathA)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer a.Close()
+	b, err := database.OpenReadOnly(pathB)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer b.Close()
+
+	diff, err := database.DiffLots(a, b, *city)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	}
+	return printDiff(diff, pathA, pathB)
+}
+
+// printDiff writes one line per lot only in one database and one line per
+// differing field
+func printDiff(diff *database.LotDiff, pathA, pathB string) error {
+	if diff.Empty() {
+		fmt.Println("No differences")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "LOT\tFIELD\t%s\t%s\n", pathA, pathB)
+	for _, id := range diff.OnlyA {
+		fmt.Fprintf(w, "%s\t\tpresent\tmissing\n", id)
+	}
+	for _, id := range diff.OnlyB {
+		fmt.Fprintf(w, "%s\t\tmissing\tpresent\n", id)
+	}
+	for _, change := range diff.Changed {
+		for _, field := range change.Fields {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", change.ID, field.Field, field.A, field.B)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\n%d only in %s, %d only in %s, %d changed\n",
+		len(diff.OnlyA), pathA, len(diff.OnlyB), pathB, len(diff.Changed))
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// runDownsample rolls old raw readings into aggregates and deletes them
+func runDownsample(args []string) error {
+	fs := flag.NewFlagSet("downsample", flag.ContinueOnError)
+	dbPath := fs.String("db", "parking.db", "Path to SQLite database file")
+	olderThan := fs.String("older-than", "30d", "Downsample readings older than this (e.g. 30d, 720h)")
+	to := fs.Duration("to", time.Hour, "Width of the aggregate buckets")
+	vacuum := fs.Bool("vacuum", false, "VACUUM afterwards to return the freed space to the file system")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err)
+	}
+
+	age, err := parseAge(*olderThan)
+	if err != nil {
+		return usageError(fmt.Errorf("invalid -older-than: %w", err))
+	}
+	if *to <= 0 || *to%time.Second != 0 {
+		return usageError(fmt.Errorf("invalid -to %v: must be a positive number of seconds", *to))
+	}
+
+	db, err := database.InitDB(*dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	// Completed batches are committed, so an interrupted run loses at most
+	// the batch in progress and the next run picks up from there
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cutoff := time.Now().Add(-age)
+	log.Printf("Downsampling readings before %s into %v buckets...", cutoff.UTC().Format(time.RFC3339), *to)
+	result, err := database.Downsample(ctx, db, cutoff, *to)
+	log.Printf("Downsampled %d readings into %d buckets in %d batches", result.Readings, result.Buckets, result.Batches)
+	if err != nil {
+		return err
+	}
+
+	if *vacuum {
+		before, after, err := database.Compact(db)
+		if err != nil {
+			return err
+		}
+		log.Printf("Compacted database from %d to %d bytes, reclaiming %d bytes", before, after, before-after)
+	}
+	return nil
+}
+
+// parseAge parses a duration, also accepting whole days such as "30d"
+func parseAge(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/url"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+	"github.com/niklas/parkmonitor/ingestor/internal/config"
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/parkmonitor"
+)
+
+// Exit codes, documented in the README
+const (
+	exitError    = 1 // any other failure
+	exitUsage    = 2 // unknown command, invalid flag or configuration
+	exitDatabase = 3 // the database could not be opened, migrated or used
+	exitNetwork  = 4 // the API could not be reached or answered with an error
+)
+
+// usageError marks err as caused by invalid command-line arguments
+func usageError(err error) error {
+	return &config.Error{Err: err}
+}
+
+// exitCode maps a command's error to the process exit code
+func exitCode(err error) int {
+	var (
+		configErr *config.Error
+		initErr   *database.InitError
+		sqliteErr sqlite3.Error
+		statusErr *api.StatusError
+		urlErr    *url.Error
+		netErr    net.Error
+		discErr   *parkmonitor.DiscoveryError
+	)
+	switch {
+	case errors.As(err, &configErr):
+		return exitUsage
+	case errors.As(err, &initErr), errors.As(err, &sqliteErr):
+		return exitDatabase
+	case errors.As(err, &statusErr), errors.As(err, &urlErr), errors.As(err, &netErr), errors.As(err, &discErr):
+		return exitNetwork
+	}
+	return exitError
+}
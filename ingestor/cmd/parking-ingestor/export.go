@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/export"
+)
+
+// runExport writes stored readings (CSV, JSON, Parquet) or lots (GeoJSON)
+// to a file
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dbPath := fs.String("db", "parking.db", "Path to SQLite database file")
+	format := fs.String("format", "csv", "Output format: "+strings.Join(append(export.Formats, "geojson"), ", "))
+	output := fs.String("o", "-", "Output file (- = stdout)")
+	city := fs.String("city", "", "Only export this city (empty = all cities)")
+	from := fs.String("from", "", "Only export readings at or after this time (RFC 3339 or YYYY-MM-DD)")
+	to := fs.String("to", "", "Only export readings before this time (RFC 3339 or YYYY-MM-DD)")
+	partition := fs.Bool("partition", false, "With -format parquet, write -o as a directory partitioned by city and date")
+	bands := fs.Int("occupancy-bands", 0, "With -format csv or json, replace free counts with this many occupancy bands, e.g. 4 for 0-25%, ... (0 = exact)")
+	cityNames := fs.String("city-names", "", "Comma-separated display names for cities, e.g. Dresden=Dresden (DD)")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if *partition && (*format != "parquet" || *output == "-") {
+		return usageError(errors.New("-partition requires -format parquet and an -o directory"))
+	}
+	if *bands < 0 || (*bands > 0 && *format != "csv" && *format != "json") {
+		return usageError(errors.New("-occupancy-bands must be positive and requires -format csv or json"))
+	}
+
+	filter := database.ExportFilter{City: *city}
+	var err error
+	if filter.From, err = parseExportTime(*from, time.UTC); err != nil {
+		return usageError(fmt.Errorf("invalid -from: %w", err))
+	}
+	if filter.To, err = parseExportTime(*to, time.UTC); err != nil {
+		return usageError(fmt.Errorf("invalid -to: %w", err))
+	}
+
+	db, err := database.InitDB(*dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	names, err := loadCityNames(db, *cityNames)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" && !*partition {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", *output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "geojson" {
+		lots, err := database.ExportLots(db, filter)
+		if err != nil {
+			return fmt.Errorf("failed to query lots: %w", err)
+		}
+		for idx := range lots {
+			lots[idx].CityName = names.Name(lots[idx].City, lots[idx].CityName)
+		}
+		n, err := export.WriteLotsGeoJSON(w, lots)
+		if err != nil {
+			return fmt.Errorf("failed to write GeoJSON: %w", err)
+		}
+		log.Printf("Exported %d lots (%d without coordinates skipped)", n, len(lots)-n)
+		return nil
+	}
+
+	var writer export.ReadingWriter
+	if *partition {
+		writer = export.NewPartitionedParquetWriter(*output)
+	} else if writer, err = export.NewReadingWriterWithOptions(*format, w, export.Options{OccupancyBands: *bands}); err != nil {
+		return usageError(err)
+	}
+
+	count := 0
+	err = database.ExportReadings(db, filter, func(r *database.ExportedReading) error {
+		count++
+		r.CityName = names.Name(r.City, r.CityName)
+		return writer.Write(r)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export readings: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", *format, err)
+	}
+
+	log.Printf("Exported %d readings", count)
+	return nil
+}
+
+// parseExportTime parses an RFC 3339 timestamp or a date starting at
+// midnight in loc; empty means no bound
+func parseExportTime(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, loc)
+}
+
+// loadCityNames parses a -city-names value, checking that every city has
+// stored metadata
+func loadCityNames(db *sql.DB, value string) (database.CityNames, error) {
+	names, err := database.ParseCityNames(value)
+	if err != nil {
+		return nil, usageError(fmt.Errorf("invalid -city-names: %w", err))
+	}
+	if len(names) == 0 {
+		return names, nil
+	}
+
+	cities, err := database.GetCities(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cities: %w", err)
+	}
+	ids := make([]string, len(cities))
+	for idx, c := range cities {
+		ids[idx] = c.ID
+	}
+	if err := names.Validate(ids); err != nil {
+		return nil, usageError(fmt.Errorf("invalid -city-names: %w", err))
+	}
+	return names, nil
+}
+
+// loadDisplayTZ resolves a -display-tz value: an IANA zone name such as
+// Europe/Berlin, "UTC" or "Local"
+func loadDisplayTZ(name string) (*time.Location, error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -display-tz: %w", err)
+	}
+	return loc, nil
+}
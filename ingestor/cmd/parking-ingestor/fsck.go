@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// runFsck reports readings that fail the integrity checks and optionally
+// repairs them
+func runFsck(args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	dbPath := fs.String("db", "parking.db", "Path to SQLite database file")
+	repair := fs.Bool("repair", false, "Fix the problems found (asks for confirmation)")
+	yes := fs.Bool("yes", false, "With -repair, skip the confirmation prompt")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if *yes && !*repair {
+		return usageError(errors.New("-yes requires -repair"))
+	}
+
+	db, err := database.InitDB(*dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	results, err := database.CheckIntegrity(ctx, db, false)
+	if err != nil {
+		return err
+	}
+	if err := printIntegrity(results, false); err != nil {
+		return err
+	}
+
+	var found int64
+	for _, r := range results {
+		found += r.Found
+	}
+	if found == 0 || !*repair {
+		return nil
+	}
+
+	if !*yes && !confirm(fmt.Sprintf("Repair %d readings in %s?", found, *dbPath)) {
+		return errors.New("repair cancelled")
+	}
+	if results, err = database.CheckIntegrity(ctx, db, true); err != nil {
+		return err
+	}
+	fmt.Println()
+	return printIntegrity(results, true)
+}
+
+// printIntegrity writes one line per integrity check
+func printIntegrity(results []database.IntegrityResult, repaired bool) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if repaired {
+		fmt.Fprintln(w, "CHECK\tREPAIRED\tACTION")
+	} else {
+		fmt.Fprintln(w, "CHECK\tFOUND\tDESCRIPTION")
+	}
+	for _, r := range results {
+		if repaired {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", r.Check.Name, r.Repaired, r.Check.Fix)
+		} else {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", r.Check.Name, r.Found, r.Check.Description)
+		}
+	}
+	return w.Flush()
+}
+
+// confirm asks a yes/no question on stdin; anything but "yes" or "y" is no
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s Type 'yes' to continue: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "yes" || answer == "y"
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// runGaps reports time ranges in which lots have no readings
+func runGaps(args []string) error {
+	fs := flag.NewFlagSet("gaps", flag.ContinueOnError)
+	dbPath := fs.String("db", "parking.db", "Path to SQLite database file")
+	lotID := fs.String("lot", "", "Only check this lot (empty = all lots)")
+	city := fs.String("city", "", "Only check lots in this city (empty = all cities)")
+	interval := fs.Duration("interval", 5*time.Minute, "Expected interval between readings")
+	tolerance := fs.Float64("tolerance", database.DefaultGapTolerance, "Report gaps longer than this many intervals")
+	from := fs.String("from", "", "Start of the checked range (RFC 3339 or YYYY-MM-DD, default 7 days ago)")
+	to := fs.String("to", "", "End of the checked range (RFC 3339 or YYYY-MM-DD, default now)")
+	displayTZ := fs.String("display-tz", "UTC", "Time zone for printed times and -from/-to dates (e.g. Europe/Berlin, Local)")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err)
+	}
+
+	loc, err := loadDisplayTZ(*displayTZ)
+	if err != nil {
+		return usageError(err)
+	}
+
+	end, err := parseExportTime(*to, loc)
+	if err != nil {
+		return usageError(fmt.Errorf("invalid -to: %w", err))
+	}
+	if end.IsZero() {
+		end = time.Now()
+	}
+	start, err := parseExportTime(*from, loc)
+	if err != nil {
+		return usageError(fmt.Errorf("invalid -from: %w", err))
+	}
+	if start.IsZero() {
+		start = end.AddDate(0, 0, -7)
+	}
+
+	db, err := database.InitDB(*dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	lotIDs := []string{*lotID}
+	if *lotID == "" {
+		lots, err := database.ListLots(db, *city)
+		if err != nil {
+			return fmt.Errorf("failed to list lots: %w", err)
+		}
+		lotIDs = lotIDs[:0]
+		for _, lot := range lots {
+			lotIDs = append(lotIDs, lot.ID)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LOT\tSTART\tEND\tDURATION")
+
+	var total time.Duration
+	count := 0
+	for _, id := range lotIDs {
+		gaps, err := database.FindGapsWithTolerance(db, id, *interval, *tolerance, start, end)
+		if err != nil {
+			return fmt.Errorf("failed to find gaps for %s: %w", id, err)
+		}
+		for _, gap := range gaps {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", id,
+				gap.Start.In(loc).Format(time.RFC3339), gap.End.In(loc).Format(time.RFC3339), gap.Duration().Round(time.Second))
+			total += gap.Duration()
+			count++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// Coverage relates missing time to the time all checked lots could
+	// have been observed
+	expected := end.Sub(start) * time.Duration(len(lotIDs))
+	if expected > 0 {
+		fmt.Printf("\n%d gaps in %d lots, %.1f%% coverage\n", count, len(lotIDs), 100*(1-total.Seconds()/expected.Seconds()))
+	}
+	return nil
+}
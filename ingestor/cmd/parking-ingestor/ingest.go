@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/config"
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/ingestor"
+	"github.com/niklas/parkmonitor/ingestor/internal/metrics"
+	"github.com/niklas/parkmonitor/ingestor/internal/server"
+	"github.com/niklas/parkmonitor/ingestor/parkmonitor"
+	"golang.org/x/time/rate"
+)
+
+// runIngest polls the configured cities forever and stores the readings
+func runIngest(args []string) error {
+	cfg, err := parkmonitor.ParseFlags(args)
+	if err != nil {
+		return err
+	}
+
+	closeLog := redirectLog(cfg)
+	defer closeLog()
+
+	log.Printf("Starting parking ingestor...")
+	log.Printf("Database: %s", cfg.DBPath)
+	log.Printf("Polling interval: %v", cfg.Interval)
+
+	if cfg.MigrateOnly {
+		return migrateOnly(cfg.DBPath)
+	}
+
+	if err := apiTLS(cfg).Validate(); err != nil {
+		return err
+	}
+	if cfg.MetricsAddr != "" {
+		startMetricsServer(cfg)
+	}
+
+	// A signal while discovering cities gives up right away
+	startCtx, stopStart := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	mon, err := parkmonitor.NewContext(startCtx, cfg)
+	stopStart()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Monitoring cities: %s", strings.Join(mon.Cities(), ", "))
+
+	if cfg.APIAddr != "" && cfg.ReplayDir == "" {
+		startAPIServer(cfg, mon)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopOnSignal(ctx, cancel, mon)
+
+	err = mon.Start(ctx)
+	// Close flushes the -emit-json events first, so the summary is the
+	// last line on stdout
+	summary := mon.Summary()
+	if cerr := mon.Close(); err == nil {
+		err = cerr
+	}
+	reportSummary(cfg, summary)
+	return err
+}
+
+// stopOnSignal stops mon on the first SIGINT or SIGTERM: a poll cycle in
+// progress gets -shutdown-timeout to commit before it is cancelled and
+// rolled back, and a replay is cancelled. A second signal kills the
+// process. Nothing happens once ctx is done.
+func stopOnSignal(ctx context.Context, cancel context.CancelFunc, mon *parkmonitor.Monitor) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			log.Printf("Received %v, shutting down (again to force)", sig)
+			signal.Stop(signals)
+			mon.Stop()
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// reportSummary logs what the run ingested and, with -summary-json, also
+// writes it to stdout
+func reportSummary(cfg *config.Config, summary *ingestor.Summary) {
+	for _, line := range strings.Split(summary.String(), "\n") {
+		log.Print(line)
+	}
+	if cfg.SummaryJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(summary); err != nil {
+			log.Printf("Warning: failed to write summary: %v", err)
+		}
+	}
+}
+
+// migrateOnly applies pending schema migrations and reports the version
+func migrateOnly(dbPath string) error {
+	db, err := database.InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	version, err := database.SchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	log.Printf("Database migrated to schema version %d", version)
+	return nil
+}
+
+// startAPIServer serves the read API with on-demand refreshes in the
+// background for the lifetime of the process
+func startAPIServer(cfg *config.Config, mon *parkmonitor.Monitor) {
+	limit := rate.Limit(cfg.RefreshPerMinute / 60)
+	burst := max(int(cfg.RefreshPerMinute), 1)
+	handler := server.New(mon.DB(),
+		server.WithRefresher(mon, limit, burst),
+		server.WithEvents(mon.Events()),
+		server.WithCityNames(cfg.CityNames),
+		server.WithAuth(apiAuth(cfg)),
+	).Handler()
+
+	srv := &http.Server{Addr: cfg.APIAddr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Printf("Serving read API on %s%s", cfg.APIAddr, tlsNote(apiTLS(cfg)))
+		if err := apiTLS(cfg).ListenAndServe(srv); err != nil {
+			log.Printf("Warning: read API server stopped: %v", err)
+		}
+	}()
+}
+
+// apiAuth returns the read API credentials from cfg
+func apiAuth(cfg *config.Config) server.Auth {
+	return server.Auth{APIKey: cfg.APIKey, User: cfg.APIUser, Password: cfg.APIPass}
+}
+
+// apiTLS returns the read API certificate from cfg
+func apiTLS(cfg *config.Config) server.TLS {
+	return server.TLS{CertFile: cfg.TLSCert, KeyFile: cfg.TLSKey}
+}
+
+// tlsNote marks HTTPS listeners in the startup log
+func tlsNote(t server.TLS) string {
+	if t.Enabled() {
+		return " (HTTPS)"
+	}
+	return ""
+}
+
+// startMetricsServer serves Prometheus metrics and a health summary in the
+// background for the lifetime of the process
+func startMetricsServer(cfg *config.Config) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Default.Handler())
+	mux.HandleFunc("GET /healthz", handleHealth)
+
+	var handler http.Handler = mux
+	if cfg.MetricsAuth {
+		handler = server.RequireAuth(apiAuth(cfg), mux)
+	}
+
+	var tls server.TLS
+	if cfg.MetricsTLS {
+		tls = apiTLS(cfg)
+	}
+
+	addr := cfg.MetricsAddr
+	srv := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Printf("Serving metrics on %s%s", addr, tlsNote(tls))
+		if err := tls.ListenAndServe(srv); err != nil {
+			log.Printf("Warning: metrics server stopped: %v", err)
+		}
+	}()
+}
+
+// cityHealth reports how stale a city's upstream data is
+type cityHealth struct {
+	ingestor.CityFreshness
+	AgeSeconds float64 `json:"age_seconds"`
+}
+
+// handleHealth reports liveness along with each city's upstream data age
+func handleHealth(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now()
+	cities := []cityHealth{}
+	for _, f := range ingestor.Freshness() {
+		cities = append(cities, cityHealth{CityFreshness: f, AgeSeconds: now.Sub(f.LastUpdated).Seconds()})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Status string       `json:"status"`
+		Cities []cityHealth `json:"cities"`
+	}{Status: "ok", Cities: cities})
+}
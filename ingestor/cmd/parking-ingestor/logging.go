@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/config"
+)
+
+// redirectLog sends the standard logger to cfg.LogFile with size-based
+// rotation. Each log line is a single write, and the rotating writer holds
+// a lock across rotation, so no line is split or dropped. The returned
+// function restores stderr and closes the file.
+func redirectLog(cfg *config.Config) func() {
+	if cfg.LogFile == "" {
+		return func() {}
+	}
+
+	logFile := &lumberjack.Logger{
+		Filename:   cfg.LogFile,
+		MaxSize:    cfg.LogMaxSizeMB,
+		MaxBackups: cfg.LogMaxBackups,
+		MaxAge:     cfg.LogMaxAgeDays,
+		LocalTime:  true,
+	}
+	log.Printf("Logging to %s", cfg.LogFile)
+	log.SetOutput(logFile)
+
+	return func() {
+		log.SetOutput(os.Stderr)
+		if err := logFile.Close(); err != nil {
+			log.Printf("Warning: failed to close log file: %v", err)
+		}
+	}
+}
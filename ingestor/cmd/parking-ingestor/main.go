@@ -1,48 +1,107 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 
-	"github.com/niklas/parkmonitor/ingestor/internal/api"
-	"github.com/niklas/parkmonitor/ingestor/internal/config"
-	"github.com/niklas/parkmonitor/ingestor/internal/database"
-	"github.com/niklas/parkmonitor/ingestor/internal/ingestor"
+	"github.com/niklas/parkmonitor/ingestor/internal/version"
 )
 
+// command is a CLI subcommand with its own flag set
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// defaultCommand runs when no subcommand is given, keeping the original
+// "parking-ingestor -db ... -cities ..." invocation working
+const defaultCommand = "ingest"
+
+var commands = []command{
+	{name: "ingest", summary: "Poll the ParkenDD API and store readings (default)", run: runIngest},
+	{name: "cities", summary: "List the cities available from the ParkenDD API", run: runCities},
+	{name: "serve", summary: "Serve stored data over a read-only HTTP API", run: runServe},
+	{name: "gaps", summary: "Report time ranges in which lots have no readings", run: runGaps},
+	{name: "export", summary: "Export stored readings as CSV or JSON, or lots as GeoJSON", run: runExport},
+	{name: "downsample", summary: "Roll old readings into aggregates and delete the raw rows", run: runDownsample},
+	{name: "prune", summary: "Delete all but the latest readings of each lot", run: runPrune},
+	{name: "refresh-lots", summary: "Update lot metadata for all monitored cities without storing readings", run: runRefreshLots},
+	{name: "fsck", summary: "Check stored readings for integrity problems and optionally repair them", run: runFsck},
+	{name: "diff", summary: "Compare the lots stored in two databases", run: runDiff},
+	{name: "rebuild-derived", summary: "Recompute derived tables from the stored lots and readings", run: runRebuildDerived},
+	{name: "snapshot", summary: "Export or import current lots and latest readings as a compact snapshot", run: runSnapshot},
+}
+
 func main() {
-	cfg := config.ParseFlags()
+	name, args := defaultCommand, os.Args[1:]
+	if len(args) > 0 && (!strings.HasPrefix(args[0], "-") || isVersionFlag(args[0])) {
+		name, args = args[0], args[1:]
+	}
 
-	log.Printf("Starting parking ingestor...")
-	log.Printf("Database: %s", cfg.DBPath)
-	log.Printf("Polling interval: %v", cfg.Interval)
+	switch name {
+	case "help":
+		usage()
+		return
+	case "version", "-version", "--version":
+		fmt.Println(version.String())
+		return
+	}
 
-	// Create API client
-	client := api.NewClient()
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		usage()
+		os.Exit(exitUsage)
+	}
 
-	// If no cities specified, fetch all available cities
-	if len(cfg.Cities) == 0 {
-		log.Printf("No cities specified, fetching all available cities...")
-		citiesMap, err := client.GetCities()
-		if err != nil {
-			log.Fatalf("Failed to fetch cities: %v", err)
-		}
-		for cityID := range citiesMap {
-			cfg.Cities = append(cfg.Cities, cityID)
+	if err := cmd.run(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
 		}
-		log.Printf("Found %d cities", len(cfg.Cities))
+		os.Exit(reportError(cmd.name, err))
 	}
+}
 
-	log.Printf("Monitoring cities: %s", strings.Join(cfg.Cities, ", "))
+// reportError prints a command's error to stderr, separate from the
+// operational log, and returns the exit code for it
+func reportError(name string, err error) int {
+	prog := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "%s %s: %v\n", prog, name, err)
 
-	// Initialize database
-	db, err := database.InitDB(cfg.DBPath)
-	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	code := exitCode(err)
+	if code == exitUsage {
+		fmt.Fprintf(os.Stderr, "Run '%s %s -h' for usage.\n", prog, name)
 	}
-	defer db.Close()
+	return code
+}
 
-	// Create ingestor and start
-	ing := ingestor.New(db, client, cfg.Cities, cfg.Interval)
-	ing.Start()
+// isVersionFlag reports whether arg asks for the version instead of a command
+func isVersionFlag(arg string) bool {
+	return arg == "-version" || arg == "--version"
+}
+
+// findCommand looks up a subcommand by name
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// usage prints the list of available subcommands
+func usage() {
+	prog := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", prog)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-13s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "  %-13s %s\n", "version", "Print version information (also -version)")
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for command-specific flags.\n", prog)
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// runPrune deletes all but the latest readings of each lot once, like
+// ingest does hourly with -keep-last
+func runPrune(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	dbPath := fs.String("db", "parking.db", "Path to SQLite database file")
+	keepLast := fs.Int("keep-last", 0, "Number of latest readings to keep per lot (required)")
+	vacuum := fs.Bool("vacuum", false, "VACUUM afterwards to return the freed space to the file system")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if *keepLast <= 0 {
+		return usageError(fmt.Errorf("invalid -keep-last %d: must be positive", *keepLast))
+	}
+
+	db, err := database.InitDB(*dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	// The delete is a single statement, so an interrupted run deletes
+	// nothing
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	deleted, err := database.PruneReadingsKeepLast(ctx, db, *keepLast)
+	if err != nil {
+		return err
+	}
+	log.Printf("Pruned %d readings beyond the latest %d per lot", deleted, *keepLast)
+
+	if *vacuum {
+		before, after, err := database.Compact(db)
+		if err != nil {
+			return err
+		}
+		log.Printf("Compacted database from %d to %d bytes, reclaiming %d bytes", before, after, before-after)
+	}
+	return nil
+}
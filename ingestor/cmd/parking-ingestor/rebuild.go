@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// runRebuildDerived recomputes the derived tables from the raw lots and
+// readings
+func runRebuildDerived(args []string) error {
+	fs := flag.NewFlagSet("rebuild-derived", flag.ContinueOnError)
+	dbPath := fs.String("db", "parking.db", "Path to SQLite database file")
+	batch := fs.Int("batch", database.DefaultRebuildBatchLots, "Number of lots recomputed per transaction")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if *batch <= 0 {
+		return usageError(fmt.Errorf("invalid -batch %d: must be positive", *batch))
+	}
+
+	db, err := database.InitDB(*dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	// Completed batches are checkpointed, so an interrupted run resumes
+	// with the batch in progress
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	results, err := database.RebuildDerived(ctx, db, *batch, func(p database.RebuildProgress) {
+		log.Printf("Rebuilding %s: %d/%d lots", p.Table, p.Lots, p.Total)
+	})
+	for _, r := range results {
+		log.Printf("Rebuilt %s: %d rows", r.Table, r.Rows)
+	}
+	return err
+}
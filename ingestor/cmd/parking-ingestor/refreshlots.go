@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/niklas/parkmonitor/ingestor/parkmonitor"
+)
+
+// runRefreshLots fetches every monitored city once and upserts its lots,
+// skipping readings. It accepts the ingest flags.
+func runRefreshLots(args []string) error {
+	cfg, err := parkmonitor.ParseFlags(args)
+	if err != nil {
+		return err
+	}
+
+	mon, err := parkmonitor.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Refreshing lots of: %s", strings.Join(mon.Cities(), ", "))
+	err = mon.RefreshLots(context.Background())
+	if cerr := mon.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
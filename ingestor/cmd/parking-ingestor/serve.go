@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/server"
+)
+
+// runServe serves the read API over HTTP
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	dbPath := fs.String("db", "parking.db", "Path to SQLite database file")
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	var auth server.Auth
+	fs.StringVar(&auth.APIKey, "api-key", "", "Bearer token required for every request (empty = none)")
+	fs.StringVar(&auth.User, "api-user", "", "Basic auth user required for every request (empty = none)")
+	fs.StringVar(&auth.Password, "api-pass", "", "Basic auth password for -api-user")
+	var tls server.TLS
+	fs.StringVar(&tls.CertFile, "tls-cert", "", "Certificate file to serve over HTTPS (requires -tls-key)")
+	fs.StringVar(&tls.KeyFile, "tls-key", "", "Private key file for -tls-cert")
+	cityNames := fs.String("city-names", "", "Comma-separated display names for cities, e.g. Dresden=Dresden (DD)")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if err := auth.Validate(); err != nil {
+		return usageError(err)
+	}
+	if (tls.CertFile == "") != (tls.KeyFile == "") {
+		return usageError(errors.New("-tls-cert and -tls-key must be set together"))
+	}
+	if err := tls.Validate(); err != nil {
+		return err
+	}
+
+	db, err := database.InitDB(*dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	names, err := loadCityNames(db, *cityNames)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           server.New(db, server.WithAuth(auth), server.WithCityNames(names)).Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Printf("Serving read API on %s%s (database: %s)", *addr, tlsNote(tls), *dbPath)
+	return tls.ListenAndServe(srv)
+}
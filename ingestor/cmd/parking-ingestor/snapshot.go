@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// runSnapshot writes the current lots and latest readings to a compact
+// snapshot file, or seeds an empty database from one
+func runSnapshot(args []string) error {
+	if len(args) == 0 {
+		return usageError(errors.New("snapshot expects export or import: snapshot export|import [flags] file"))
+	}
+	action, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("snapshot "+action, flag.ContinueOnError)
+	dbPath := fs.String("db", "parking.db", "Path to SQLite database file")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if fs.NArg() != 1 {
+		return usageError(fmt.Errorf("snapshot %s expects one snapshot file", action))
+	}
+	path := fs.Arg(0)
+
+	switch action {
+	case "export":
+		return exportSnapshot(*dbPath, path)
+	case "import":
+		return importSnapshot(*dbPath, path)
+	default:
+		return usageError(fmt.Errorf("unknown snapshot action %q, expected export or import", action))
+	}
+}
+
+// exportSnapshot writes the state of the database at dbPath to path
+func exportSnapshot(dbPath, path string) error {
+	db, err := database.InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	snapshot, err := database.TakeSnapshot(context.Background(), db)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if err := database.WriteSnapshot(f, snapshot); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	log.Printf("Wrote snapshot of %d cities, %d lots and %d readings to %s",
+		len(snapshot.Cities), len(snapshot.Lots), len(snapshot.Readings), path)
+	return nil
+}
+
+// importSnapshot seeds the empty database at dbPath from the snapshot at
+// path, creating it if needed
+func importSnapshot(dbPath, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	snapshot, err := database.ReadSnapshot(f)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.InitDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	if err := database.RestoreSnapshot(context.Background(), db, snapshot); err != nil {
+		return err
+	}
+	log.Printf("Restored %d cities, %d lots and %d readings from the snapshot taken %s",
+		len(snapshot.Cities), len(snapshot.Lots), len(snapshot.Readings), snapshot.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+	return nil
+}
@@ -0,0 +1,220 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/backoff"
+)
+
+// Config is the alerting section loaded from a JSON file
+type Config struct {
+	// Notifier selects where events go: webhook (default), stdout or email
+	Notifier string `json:"notifier"`
+
+	WebhookURL string   `json:"webhook_url"`
+	Timeout    Duration `json:"timeout"`
+	Retries    int      `json:"retries"`
+
+	Email EmailConfig `json:"email"`
+
+	Rules []Rule `json:"rules"`
+
+	// Jitter randomizes the webhook's retry delays. It is not read from
+	// the file; the ingestor sets it from -retry-jitter.
+	Jitter backoff.Jitter `json:"-"`
+}
+
+// Rule describes when a lot is considered to be in alert state.
+// A zero threshold disables that condition; if both are set either one
+// triggers the alert.
+type Rule struct {
+	LotID          string  `json:"lot_id"`
+	FreeBelow      int     `json:"free_below,omitempty"`
+	OccupancyAbove float64 `json:"occupancy_above,omitempty"`
+}
+
+// Duration is a time.Duration that unmarshals from strings like "10s"
+type Duration time.Duration
+
+// UnmarshalJSON parses a Go duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// LoadConfig reads and validates an alert configuration file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse alert config: %w", err)
+	}
+
+	if _, err := newNotifier(&cfg); err != nil {
+		return nil, fmt.Errorf("alert config: %w", err)
+	}
+	for i, rule := range cfg.Rules {
+		if rule.LotID == "" {
+			return nil, fmt.Errorf("alert config: rule %d has no lot_id", i)
+		}
+		if rule.FreeBelow <= 0 && rule.OccupancyAbove <= 0 {
+			return nil, fmt.Errorf("alert config: rule for %s has no threshold", rule.LotID)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// Observation is the latest state of a lot after a poll
+type Observation struct {
+	LotID     string
+	LotName   string
+	City      string
+	Free      int
+	Total     int
+	Timestamp time.Time
+}
+
+// Occupancy returns the occupied fraction of the lot (0 when total is unknown)
+func (o Observation) Occupancy() float64 {
+	if o.Total <= 0 {
+		return 0
+	}
+	return 1 - float64(o.Free)/float64(o.Total)
+}
+
+// Event is sent to the notifier when a rule changes state
+type Event struct {
+	Status    string    `json:"status"` // "triggered" or "resolved"
+	LotID     string    `json:"lot_id"`
+	LotName   string    `json:"lot_name"`
+	City      string    `json:"city"`
+	Free      int       `json:"free"`
+	Total     int       `json:"total"`
+	Occupancy float64   `json:"occupancy"`
+	Rule      Rule      `json:"rule"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+const (
+	StatusTriggered = "triggered"
+	StatusResolved  = "resolved"
+)
+
+// Engine evaluates rules against observations and notifies on transitions.
+// An alert fires once when a lot enters the alert state and once more when
+// it leaves it, not on every cycle in between.
+type Engine struct {
+	rules    []Rule
+	notifier Notifier
+
+	mu     sync.Mutex
+	firing map[int]bool // rule index -> currently in alert state
+}
+
+// NewEngine creates an alert engine delivering to the configured notifier
+// through a Queue, so Evaluate never waits for a delivery. The caller must
+// Close the engine to deliver the queued events.
+func NewEngine(cfg *Config) (*Engine, error) {
+	notifier, err := newNotifier(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewEngineWithNotifier(cfg.Rules, NewQueue(notifier, queueBuffer)), nil
+}
+
+// NewEngineWithNotifier creates an alert engine delivering to notifier
+func NewEngineWithNotifier(rules []Rule, notifier Notifier) *Engine {
+	return &Engine{
+		rules:    rules,
+		notifier: notifier,
+		firing:   make(map[int]bool),
+	}
+}
+
+// Evaluate checks every rule whose lot is among the observations and sends
+// an event for each state transition. Delivery failures are logged, not
+// returned, so alerting never interferes with ingestion.
+func (e *Engine) Evaluate(ctx context.Context, observations []Observation) {
+	byLot := make(map[string]Observation, len(observations))
+	for _, obs := range observations {
+		byLot[obs.LotID] = obs
+	}
+
+	for idx, rule := range e.rules {
+		obs, ok := byLot[rule.LotID]
+		if !ok {
+			continue
+		}
+
+		active := matches(rule, obs)
+
+		e.mu.Lock()
+		wasActive := e.firing[idx]
+		e.firing[idx] = active
+		e.mu.Unlock()
+
+		if active == wasActive {
+			continue
+		}
+
+		status := StatusResolved
+		if active {
+			status = StatusTriggered
+		}
+
+		event := Event{
+			Status:    status,
+			LotID:     obs.LotID,
+			LotName:   obs.LotName,
+			City:      obs.City,
+			Free:      obs.Free,
+			Total:     obs.Total,
+			Occupancy: obs.Occupancy(),
+			Rule:      rule,
+			Timestamp: obs.Timestamp,
+		}
+
+		log.Printf("Alert %s for lot %s (%d/%d free)", status, obs.LotID, obs.Free, obs.Total)
+		if err := e.notifier.Notify(ctx, event); err != nil {
+			log.Printf("Failed to deliver alert for lot %s: %v", obs.LotID, err)
+		}
+	}
+}
+
+// Close waits until queued events are delivered when the engine's notifier
+// is a Queue
+func (e *Engine) Close() {
+	if q, ok := e.notifier.(*Queue); ok {
+		q.Close()
+	}
+}
+
+// matches reports whether the observation violates the rule's thresholds
+func matches(rule Rule, obs Observation) bool {
+	if rule.FreeBelow > 0 && obs.Free < rule.FreeBelow {
+		return true
+	}
+	if rule.OccupancyAbove > 0 && obs.Occupancy() > rule.OccupancyAbove {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,363 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/backoff"
+)
+
+type recordingNotifier struct {
+	events []Event
+	err    error
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, event Event) error {
+	r.events = append(r.events, event)
+	return r.err
+}
+
+func TestEngineFiresOnTransitions(t *testing.T) {
+	notifier := &recordingNotifier{}
+	engine := NewEngineWithNotifier([]Rule{{LotID: "lot1", FreeBelow: 10}}, notifier)
+	ctx := context.Background()
+
+	observe := func(free int) {
+		engine.Evaluate(ctx, []Observation{{LotID: "lot1", Free: free, Total: 100}})
+	}
+
+	observe(50) // fine
+	observe(5)  // triggers
+	observe(3)  // still full, no new event
+	observe(20) // resolves
+	observe(30) // fine
+
+	if len(notifier.events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(notifier.events))
+	}
+	if notifier.events[0].Status != StatusTriggered || notifier.events[0].Free != 5 {
+		t.Errorf("Unexpected first event: %+v", notifier.events[0])
+	}
+	if notifier.events[1].Status != StatusResolved || notifier.events[1].Free != 20 {
+		t.Errorf("Unexpected second event: %+v", notifier.events[1])
+	}
+}
+
+func TestEngineNotifyFailureIsNotFatal(t *testing.T) {
+	notifier := &recordingNotifier{err: errors.New("unreachable")}
+	engine := NewEngineWithNotifier([]Rule{
+		{LotID: "lot1", FreeBelow: 10},
+		{LotID: "lot2", FreeBelow: 10},
+	}, notifier)
+
+	engine.Evaluate(context.Background(), []Observation{
+		{LotID: "lot1", Free: 5, Total: 100},
+		{LotID: "lot2", Free: 5, Total: 100},
+	})
+	if len(notifier.events) != 2 {
+		t.Errorf("Expected both rules to notify despite failures, got %d events", len(notifier.events))
+	}
+}
+
+func TestEngineStreamNotifier(t *testing.T) {
+	var out bytes.Buffer
+	engine := NewEngineWithNotifier([]Rule{{LotID: "lot1", OccupancyAbove: 0.9}}, NewStream(&out))
+	for _, free := range []int{5, 4, 50} {
+		engine.Evaluate(context.Background(), []Observation{{LotID: "lot1", City: "Dresden", Free: free, Total: 100}})
+	}
+
+	var statuses []string
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var event Event
+		if err := dec.Decode(&event); err != nil {
+			t.Fatalf("Failed to decode event: %v", err)
+		}
+		statuses = append(statuses, event.Status)
+	}
+	if len(statuses) != 2 || statuses[0] != StatusTriggered || statuses[1] != StatusResolved {
+		t.Errorf("got %v, expected [triggered resolved]", statuses)
+	}
+}
+
+// serveSMTP accepts one connection on l and answers it like a minimal SMTP
+// server offering PLAIN authentication. The received conversation is sent
+// on the returned channel once the client quits.
+func serveSMTP(l net.Listener) <-chan string {
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		var log strings.Builder
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			log.WriteString(line + "\n")
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				tp.PrintfLine("250-localhost")
+				tp.PrintfLine("250 AUTH PLAIN")
+			case strings.HasPrefix(line, "AUTH"):
+				tp.PrintfLine("235 Authenticated")
+			case line == "DATA":
+				tp.PrintfLine("354 Go ahead")
+				body, err := tp.ReadDotLines()
+				if err != nil {
+					return
+				}
+				log.WriteString(strings.Join(body, "\n") + "\n")
+				tp.PrintfLine("250 Queued")
+			case line == "QUIT":
+				tp.PrintfLine("221 Bye")
+				received <- log.String()
+				return
+			default:
+				tp.PrintfLine("250 OK")
+			}
+		}
+	}()
+	return received
+}
+
+// blockingNotifier blocks every delivery until release is closed
+type blockingNotifier struct {
+	release   chan struct{}
+	delivered atomic.Int32
+}
+
+func (b *blockingNotifier) Notify(ctx context.Context, event Event) error {
+	<-b.release
+	b.delivered.Add(1)
+	return nil
+}
+
+func TestEngineQueueDoesNotBlock(t *testing.T) {
+	notifier := &blockingNotifier{release: make(chan struct{})}
+	rules := []Rule{{LotID: "lot1", FreeBelow: 10}, {LotID: "lot2", FreeBelow: 10}, {LotID: "lot3", FreeBelow: 10}}
+	engine := NewEngineWithNotifier(rules, NewQueue(notifier, 1))
+	dropped := alertsDropped.Value()
+
+	done := make(chan struct{})
+	go func() {
+		engine.Evaluate(context.Background(), []Observation{
+			{LotID: "lot1", Free: 0, Total: 100},
+			{LotID: "lot2", Free: 0, Total: 100},
+			{LotID: "lot3", Free: 0, Total: 100},
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Evaluate blocked on a stalled notifier")
+	}
+
+	// One event is in flight and one queued, so at least one was dropped
+	lost := alertsDropped.Value() - dropped
+	if lost < 1 {
+		t.Errorf("got %v dropped events, expected at least 1", lost)
+	}
+
+	close(notifier.release)
+	engine.Close()
+	if got := float64(notifier.delivered.Load()); got != 3-lost {
+		t.Errorf("got %v delivered events, expected %v", got, 3-lost)
+	}
+}
+
+func TestEmailNotify(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := serveSMTP(l)
+
+	email := NewEmail(EmailConfig{Addr: l.Addr().String(), Username: "alerts", Password: "secret", From: "alerts@example.com", To: []string{"ops@example.com"}}, time.Second)
+	event := Event{Status: StatusTriggered, LotID: "lot1", LotName: "Altmarkt", City: "Dresden", Free: 5, Total: 100, Occupancy: 0.95}
+	if err := email.Notify(context.Background(), event); err != nil {
+		t.Fatalf("Notify() error: %v", err)
+	}
+
+	got := <-received
+	for _, want := range []string{"AUTH PLAIN", "MAIL FROM:<alerts@example.com>", "RCPT TO:<ops@example.com>", "Subject: Parking alert triggered: Altmarkt"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Conversation is missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestEmailNotifyStalledServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// Accept connections but never send a greeting
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		ctx     time.Duration
+	}{
+		{"timeout", 50 * time.Millisecond, time.Minute},
+		{"context", time.Minute, 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := NewEmail(EmailConfig{Addr: l.Addr().String(), From: "alerts@example.com", To: []string{"ops@example.com"}}, tt.timeout)
+			ctx, cancel := context.WithTimeout(context.Background(), tt.ctx)
+			defer cancel()
+
+			start := time.Now()
+			if err := email.Notify(ctx, Event{Status: StatusTriggered, LotID: "lot1"}); err == nil {
+				t.Fatal("Expected an error from a stalled server")
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Notify() returned after %v, expected it to give up early", elapsed)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     Rule
+		obs      Observation
+		expected bool
+	}{
+		{"free below threshold", Rule{FreeBelow: 10}, Observation{Free: 9, Total: 100}, true},
+		{"free at threshold", Rule{FreeBelow: 10}, Observation{Free: 10, Total: 100}, false},
+		{"occupancy above threshold", Rule{OccupancyAbove: 0.95}, Observation{Free: 4, Total: 100}, true},
+		{"occupancy below threshold", Rule{OccupancyAbove: 0.95}, Observation{Free: 6, Total: 100}, false},
+		{"unknown total", Rule{OccupancyAbove: 0.95}, Observation{Free: 0, Total: 0}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matches(tt.rule, tt.obs); got != tt.expected {
+				t.Errorf("matches() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	var attempts int32
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook(server.URL, time.Second, 2, backoff.JitterFull)
+	webhook.backoff.Base = time.Millisecond
+
+	if err := webhook.Notify(context.Background(), Event{Status: StatusTriggered, LotID: "lot1"}); err != nil {
+		t.Fatalf("Notify() error: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+	if received.LotID != "lot1" {
+		t.Errorf("Expected payload for lot1, got %+v", received)
+	}
+
+	webhook.retries = 0
+	atomic.StoreInt32(&attempts, 0)
+	if err := webhook.Notify(context.Background(), Event{}); err == nil {
+		t.Error("Expected error when retries are exhausted")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	content := `{
+		"webhook_url": "http://example.com/hook",
+		"timeout": "5s",
+		"retries": 2,
+		"rules": [{"lot_id": "lot1", "free_below": 10}]
+	}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error: %v", err)
+	}
+	if time.Duration(cfg.Timeout) != 5*time.Second {
+		t.Errorf("Expected timeout 5s, got %v", time.Duration(cfg.Timeout))
+	}
+	if len(cfg.Rules) != 1 || cfg.Rules[0].FreeBelow != 10 {
+		t.Errorf("Unexpected rules: %+v", cfg.Rules)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"webhook_url": "x", "rules": [{"lot_id": "lot1"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("Expected error for rule without threshold")
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{"stdout", `{"notifier": "stdout"}`, true},
+		{"email", `{"notifier": "email", "email": {"addr": "mail:25", "from": "a@example.com", "to": ["b@example.com"]}}`, true},
+		{"email without recipients", `{"notifier": "email", "email": {"addr": "mail:25", "from": "a@example.com"}}`, false},
+		{"unknown notifier", `{"notifier": "pager"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := LoadConfig(path)
+			if (err == nil) != tt.valid {
+				t.Fatalf("got error %v, expected valid %v", err, tt.valid)
+			}
+			if err != nil {
+				return
+			}
+			if _, err := NewEngine(cfg); err != nil {
+				t.Errorf("NewEngine() error: %v", err)
+			}
+		})
+	}
+}
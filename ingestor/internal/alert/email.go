@@ -0,0 +1,130 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+const defaultEmailTimeout = 10 * time.Second
+
+// EmailConfig configures delivery of alert events by email
+type EmailConfig struct {
+	// Addr is the SMTP server as host:port
+	Addr     string   `json:"addr"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// Email sends every event as a plain text mail
+type Email struct {
+	cfg     EmailConfig
+	timeout time.Duration
+	dial    func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// NewEmail creates an email notifier. Each delivery is bounded by timeout.
+// With a username, the server must support PLAIN authentication over TLS.
+func NewEmail(cfg EmailConfig, timeout time.Duration) *Email {
+	if timeout <= 0 {
+		timeout = defaultEmailTimeout
+	}
+	return &Email{cfg: cfg, timeout: timeout, dial: (&net.Dialer{}).DialContext}
+}
+
+// Notify mails the event to all recipients. The whole SMTP conversation is
+// aborted when ctx is done or the timeout expires, so a stalled server
+// cannot hold up ingestion.
+func (e *Email) Notify(ctx context.Context, event Event) error {
+	host, _, err := net.SplitHostPort(e.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP address %q: %w", e.cfg.Addr, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	conn, err := e.dial(ctx, "tcp", e.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	// Unblock any pending read or write when ctx is cancelled early
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if err := e.send(conn, host, e.message(event)); err != nil {
+		return fmt.Errorf("failed to send alert mail: %w", err)
+	}
+	return nil
+}
+
+// send runs the SMTP conversation on conn like smtp.SendMail, upgrading to
+// TLS when the server offers it
+func (e *Email) send(conn net.Conn, host string, msg []byte) error {
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if e.cfg.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("SMTP server does not support authentication")
+		}
+		if err := c.Auth(smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range e.cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message renders the event as an RFC 5322 message
+func (e *Email) message(event Event) []byte {
+	name := event.LotName
+	if name == "" {
+		name = event.LotID
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", e.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: Parking alert %s: %s\r\n", event.Status, name)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "Lot %s (%s, %s) is %s.\r\n", name, event.LotID, event.City, event.Status)
+	fmt.Fprintf(&b, "Free: %d of %d (%.0f%% occupied)\r\n", event.Free, event.Total, event.Occupancy*100)
+	fmt.Fprintf(&b, "Time: %s\r\n", event.Timestamp.Format("2006-01-02 15:04:05 MST"))
+	return b.Bytes()
+}
@@ -0,0 +1,64 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notifier values select where alert events are delivered
+const (
+	NotifierWebhook = "webhook"
+	NotifierStdout  = "stdout"
+	NotifierEmail   = "email"
+)
+
+// Notifier delivers alert events, e.g. to a webhook or by email. The
+// engine only calls Notify; a failed delivery is logged by the engine and
+// never stops ingestion.
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// newNotifier creates the notifier selected by cfg.Notifier, failing when
+// settings it needs are missing
+func newNotifier(cfg *Config) (Notifier, error) {
+	switch cfg.Notifier {
+	case "", NotifierWebhook:
+		if cfg.WebhookURL == "" {
+			return nil, fmt.Errorf("webhook_url is required")
+		}
+		return NewWebhook(cfg.WebhookURL, time.Duration(cfg.Timeout), cfg.Retries, cfg.Jitter), nil
+	case NotifierStdout:
+		return NewStream(os.Stdout), nil
+	case NotifierEmail:
+		if cfg.Email.Addr == "" || cfg.Email.From == "" || len(cfg.Email.To) == 0 {
+			return nil, fmt.Errorf("email needs addr, from and to")
+		}
+		return NewEmail(cfg.Email, time.Duration(cfg.Timeout)), nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q (expected webhook, stdout or email)", cfg.Notifier)
+	}
+}
+
+// Stream writes events to w as JSON, one per line
+type Stream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewStream creates a notifier writing to w
+func NewStream(w io.Writer) *Stream {
+	return &Stream{enc: json.NewEncoder(w)}
+}
+
+// Notify writes the event as a single line
+func (s *Stream) Notify(ctx context.Context, event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(event)
+}
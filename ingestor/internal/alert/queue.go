@@ -0,0 +1,84 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/metrics"
+)
+
+// queueBuffer is how many events NewEngine queues for a slow notifier
+// before dropping new ones
+const queueBuffer = 100
+
+// ErrQueueFull is returned by Queue.Notify for events that did not fit
+var ErrQueueFull = errors.New("alert queue full")
+
+var alertsDropped = metrics.Default.NewCounterVec("parkmonitor_alerts_dropped_total",
+	"Alert events dropped because the notifier fell behind.")
+
+// Queue delivers events to a notifier from a background goroutine. Notify
+// only queues the event in a bounded buffer, so a slow or unreachable
+// notifier never blocks ingestion: events that do not fit are dropped and
+// counted.
+type Queue struct {
+	mu     sync.Mutex
+	closed bool
+	events chan Event
+	done   chan struct{}
+}
+
+// NewQueue starts delivering events to notifier, queueing up to buffer of
+// them
+func NewQueue(notifier Notifier, buffer int) *Queue {
+	q := &Queue{
+		events: make(chan Event, buffer),
+		done:   make(chan struct{}),
+	}
+	go q.run(notifier)
+	return q
+}
+
+// run delivers the queued events. Deliveries are independent of the poll
+// that raised them; each notifier bounds its own attempts.
+func (q *Queue) run(notifier Notifier) {
+	defer close(q.done)
+	for event := range q.events {
+		if err := notifier.Notify(context.Background(), event); err != nil {
+			log.Printf("Failed to deliver alert for lot %s: %v", event.LotID, err)
+		}
+	}
+}
+
+// Notify queues the event without blocking. Delivery failures are logged
+// by the queue, so the only error is ErrQueueFull.
+func (q *Queue) Notify(ctx context.Context, event Event) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+
+	select {
+	case q.events <- event:
+		return nil
+	default:
+		alertsDropped.Inc()
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting events and waits until the queued ones are
+// delivered
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+	q.mu.Unlock()
+
+	<-q.done
+}
@@ -0,0 +1,90 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/backoff"
+)
+
+const (
+	defaultWebhookTimeout = 10 * time.Second
+	webhookRetryDelay     = time.Second
+)
+
+// Webhook posts events as JSON to a URL
+type Webhook struct {
+	url        string
+	retries    int
+	backoff    backoff.Policy
+	httpClient *http.Client
+}
+
+// NewWebhook creates a webhook sender. Each attempt is bounded by timeout and
+// failed deliveries are retried up to retries additional times, waiting
+// with exponential backoff randomized by jitter.
+func NewWebhook(url string, timeout time.Duration, retries int, jitter backoff.Jitter) *Webhook {
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	if retries < 0 {
+		retries = 0
+	}
+	return &Webhook{
+		url:        url,
+		retries:    retries,
+		backoff:    backoff.Policy{Base: webhookRetryDelay, Jitter: jitter},
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// Notify posts the event, retrying on network errors and non-2xx responses
+func (w *Webhook) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(w.backoff.Delay(attempt)):
+			}
+		}
+
+		if lastErr = w.post(ctx, body); lastErr == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("webhook failed after %d attempts: %w", w.retries+1, lastErr)
+}
+
+// post performs a single delivery attempt
+func (w *Webhook) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
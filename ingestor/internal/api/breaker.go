@@ -0,0 +1,180 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/metrics"
+)
+
+// ErrCircuitOpen is returned without contacting the API while the circuit
+// breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open: API is failing, not sending requests")
+
+var (
+	breakerState = metrics.Default.NewGaugeVec("parkmonitor_api_circuit_state",
+		"State of the API circuit breaker: 0 closed, 1 half-open, 2 open.")
+	breakerRejected = metrics.Default.NewCounterVec("parkmonitor_api_circuit_rejected_total",
+		"API requests failed fast because the circuit breaker was open.")
+)
+
+// BreakerOptions configures the circuit breaker shared by all requests of
+// a client. The zero value disables it.
+type BreakerOptions struct {
+	// Failures within Window open the circuit (0 = no breaker). Network
+	// errors, 429 and 5xx responses count as failures.
+	Failures int
+	Window   time.Duration
+
+	// Cooldown is how long the circuit stays open before a single trial
+	// request is let through
+	Cooldown time.Duration
+}
+
+// circuitState is the state of a breaker, valued as its metric
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitHalfOpen:
+		return "half-open"
+	case circuitOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+// breaker is a circuit breaker: closed, it passes requests and counts
+// failures; open, it rejects them until the cooldown has passed; half-open,
+// it lets one trial request through, whose outcome closes or reopens it
+type breaker struct {
+	opts BreakerOptions
+	now  func() time.Time
+
+	mu       sync.Mutex
+	state    circuitState
+	failures []time.Time
+	openedAt time.Time
+	trial    bool
+}
+
+// newBreaker returns a breaker, or nil when opts disable it
+func newBreaker(opts BreakerOptions) *breaker {
+	if opts.Failures <= 0 {
+		return nil
+	}
+	breakerState.Set(float64(circuitClosed))
+	return &breaker{opts: opts, now: time.Now}
+}
+
+// allow reports whether a request may be sent. A nil breaker allows all.
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitOpen && b.now().Sub(b.openedAt) >= b.opts.Cooldown {
+		b.setState(circuitHalfOpen)
+	}
+	switch {
+	case b.state == circuitOpen, b.state == circuitHalfOpen && b.trial:
+		breakerRejected.Inc()
+		return ErrCircuitOpen
+	case b.state == circuitHalfOpen:
+		b.trial = true
+	}
+	return nil
+}
+
+// record reports the outcome of an allowed request
+func (b *breaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if b.state == circuitHalfOpen {
+		b.trial = false
+		if failed {
+			b.open(now)
+		} else {
+			b.failures = nil
+			b.setState(circuitClosed)
+		}
+		return
+	}
+	if !failed || b.state == circuitOpen {
+		return
+	}
+
+	// Keep the failures within the window only
+	cutoff := now.Add(-b.opts.Window)
+	kept := b.failures[:0]
+	for _, at := range b.failures {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	b.failures = append(kept, now)
+	if len(b.failures) >= b.opts.Failures {
+		b.open(now)
+	}
+}
+
+// abandon reports that an allowed request ended without an answer from
+// the API, e.g. because its context was cancelled, so it proves nothing
+func (b *breaker) abandon() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// open trips the breaker at now
+func (b *breaker) open(now time.Time) {
+	b.openedAt = now
+	b.failures = nil
+	b.setState(circuitOpen)
+}
+
+// setState changes the state, logging and exporting transitions
+func (b *breaker) setState(state circuitState) {
+	if state == b.state {
+		return
+	}
+	switch state {
+	case circuitOpen:
+		log.Printf("Warning: API circuit breaker opened after %d failures, pausing requests for %v", b.opts.Failures, b.opts.Cooldown)
+	default:
+		log.Printf("API circuit breaker %s", state)
+	}
+	b.state = state
+	breakerState.Set(float64(state))
+}
+
+// failedResponse reports whether a response counts as a breaker failure:
+// the API is overloaded or broken rather than the request being wrong
+func failedResponse(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// String describes the options for startup logs
+func (o BreakerOptions) String() string {
+	return fmt.Sprintf("%d failures within %v, cooldown %v", o.Failures, o.Window, o.Cooldown)
+}
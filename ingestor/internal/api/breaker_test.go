@@ -0,0 +1,129 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := newBreaker(BreakerOptions{Failures: 3, Window: time.Minute, Cooldown: 30 * time.Second})
+	b.now = func() time.Time { return now }
+
+	fail := func() {
+		t.Helper()
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow() error while %v: %v", b.state, err)
+		}
+		b.record(true)
+	}
+
+	// Failures outside the window do not add up
+	fail()
+	fail()
+	now = now.Add(2 * time.Minute)
+	fail()
+	if b.state != circuitClosed {
+		t.Fatalf("got %v, expected closed with failures spread out", b.state)
+	}
+
+	// Three failures within the window open the circuit
+	fail()
+	fail()
+	if b.state != circuitOpen {
+		t.Fatalf("got %v, expected open", b.state)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v, expected ErrCircuitOpen", err)
+	}
+
+	// After the cooldown, one trial request is let through; a failed trial
+	// reopens the circuit
+	now = now.Add(30 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() error for the trial: %v", err)
+	}
+	if b.state != circuitHalfOpen {
+		t.Fatalf("got %v, expected half-open", b.state)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v, expected a second request during the trial to be rejected", err)
+	}
+	b.record(true)
+	if b.state != circuitOpen {
+		t.Fatalf("got %v, expected open after a failed trial", b.state)
+	}
+
+	// A successful trial closes it
+	now = now.Add(30 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() error for the trial: %v", err)
+	}
+	b.record(false)
+	if b.state != circuitClosed {
+		t.Fatalf("got %v, expected closed after a successful trial", b.state)
+	}
+	if got := breakerState.Value(); got != float64(circuitClosed) {
+		t.Errorf("got state metric %v, expected %v", got, float64(circuitClosed))
+	}
+
+	// An abandoned trial frees the slot for the next one
+	fail()
+	fail()
+	fail()
+	now = now.Add(30 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() error for the trial: %v", err)
+	}
+	b.abandon()
+	if err := b.allow(); err != nil {
+		t.Errorf("allow() error after an abandoned trial: %v", err)
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var requests, healthy int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(testCityJSON))
+	}))
+	defer server.Close()
+
+	client := NewClientWithOptions(Options{
+		BaseURL: server.URL,
+		Breaker: BreakerOptions{Failures: 2, Window: time.Minute, Cooldown: 20 * time.Millisecond},
+	})
+
+	for range 2 {
+		if _, err := client.GetCityParkingData("Dresden"); err == nil {
+			t.Fatal("Expected an error from the failing API")
+		}
+	}
+	rejected := breakerRejected.Value()
+	if _, err := client.GetCityParkingData("Dresden"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v, expected ErrCircuitOpen", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("got %d requests, expected the open circuit to send none", got)
+	}
+	if got := breakerRejected.Value() - rejected; got != 1 {
+		t.Errorf("got %v rejections, expected 1", got)
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(30 * time.Millisecond)
+	if _, err := client.GetCityParkingData("Dresden"); err != nil {
+		t.Fatalf("GetCityParkingData() error after the cooldown: %v", err)
+	}
+	if _, err := client.GetCityParkingData("Dresden"); err != nil {
+		t.Errorf("GetCityParkingData() error with the circuit closed: %v", err)
+	}
+}
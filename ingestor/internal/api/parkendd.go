@@ -1,35 +1,346 @@
 package api
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/version"
+	"golang.org/x/time/rate"
 )
 
 const (
 	BaseURL = "https://api.parkendd.de"
 )
 
+// ErrNotModified is returned by GetCityParkingData when conditional
+// requests are enabled and the city has not changed since its last response
+var ErrNotModified = errors.New("not modified since last fetch")
+
+// StatusError is returned when the API answers with a status other than
+// 200 OK
+type StatusError struct {
+	StatusCode int
+	// City is empty for the city listing
+	City string
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if e.City == "" {
+		return fmt.Sprintf("API returned status %d: %s", e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("API returned status %d for %s: %s", e.StatusCode, e.City, e.Body)
+}
+
 // Client handles API requests to ParkenDD
 type Client struct {
 	httpClient *http.Client
+	baseURL    string
+	rawDir     string
+	limiter    *rate.Limiter
+
+	// authorization is the Authorization header sent with every request
+	// (empty = none). It must never appear in logs or errors.
+	authorization string
+
+	// breaker fails requests fast during sustained outages; nil = none
+	breaker *breaker
+
+	// retry configures retries of failed requests; retries counts them
+	retry   RetryOptions
+	retries atomic.Int64
+
+	// conns holds a token per outbound request until its body is closed;
+	// nil means unlimited
+	conns chan struct{}
+
+	// conditional enables If-None-Match/If-Modified-Since requests using
+	// the validators of each city's last stored response. They are kept in
+	// memory only, so a restarted client fetches every city in full once.
+	conditional  bool
+	validatorsMu sync.Mutex
+	validators   map[string]validators
+}
+
+// validators are the caching headers of a city's last stored response, the
+// watermark a conditional request compares against
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+// Options configures optional client behavior. The zero value talks to the
+// public ParkenDD API.
+type Options struct {
+	// BaseURL of a ParkenDD-compatible API (default BaseURL)
+	BaseURL string
+
+	// RawDir, when set, receives a copy of every successful city response
+	// as <RawDir>/<city>/<timestamp>.json
+	RawDir string
+
+	// RateLimit caps outbound requests per second across all goroutines
+	// sharing the client (0 = unlimited). Burst is how many requests may
+	// be sent at once before the limit applies (minimum 1).
+	RateLimit float64
+	Burst     int
+
+	// MaxConnections caps requests in flight across all goroutines sharing
+	// the client, including reading their bodies (0 = unlimited). Unlike
+	// RateLimit it bounds open connections when city polls and on-demand
+	// refreshes overlap.
+	MaxConnections int
+
+	// Proxy routes every request through this HTTP, HTTPS or SOCKS5
+	// proxy. When nil, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	// environment variables apply.
+	Proxy *url.URL
+
+	// Token authenticates every request to an API that requires it, e.g. a
+	// private mirror. It is sent as "Authorization: Bearer <Token>", or
+	// verbatim when it already names a scheme such as "Token abc".
+	Token string
+
+	// Breaker stops sending requests for a while after repeated failures,
+	// returning ErrCircuitOpen instead (zero value = no breaker)
+	Breaker BreakerOptions
+
+	// Retry retries requests failing with a network error or 5xx response
+	// with exponential backoff (zero value = no retries). Every attempt
+	// waits for the rate limiter and counts towards the breaker.
+	Retry RetryOptions
+
+	// Conditional sends the ETag and Last-Modified values of each city's
+	// last response passed to MarkStored, so an unchanged city costs a 304
+	// response instead of a full download and GetCityParkingData returns
+	// ErrNotModified. Servers that send neither header are always fetched
+	// in full.
+	Conditional bool
 }
 
 // NewClient creates a new ParkenDD API client
 func NewClient() *Client {
+	return NewClientWithOptions(Options{})
+}
+
+// NewClientWithBaseURL creates a client for a ParkenDD-compatible API served
+// at baseURL, e.g. a self-hosted mirror or a test server
+func NewClientWithBaseURL(baseURL string) *Client {
+	return NewClientWithOptions(Options{BaseURL: baseURL})
+}
+
+// NewClientWithOptions creates a client with the given options
+func NewClientWithOptions(opts Options) *Client {
+	baseURL := opts.BaseURL
+	if baseURL == "" {
+		baseURL = BaseURL
+	}
+
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	if opts.RateLimit > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.RateLimit), max(opts.Burst, 1))
+	}
+
+	var conns chan struct{}
+	if opts.MaxConnections > 0 {
+		conns = make(chan struct{}, opts.MaxConnections)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.Proxy != nil {
+		transport.Proxy = http.ProxyURL(opts.Proxy)
+	}
+
 	return &Client{
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   30 * time.Second,
+			Transport: transport,
 		},
+		baseURL: strings.TrimRight(baseURL, "/"),
+		rawDir:  opts.RawDir,
+		limiter: limiter,
+		conns:   conns,
+		breaker: newBreaker(opts.Breaker),
+		retry:   opts.Retry,
+
+		authorization: authorizationHeader(opts.Token),
+
+		conditional: opts.Conditional,
+		validators:  make(map[string]validators),
+	}
+}
+
+// authorizationHeader returns the Authorization header value for token
+func authorizationHeader(token string) string {
+	if token == "" || strings.Contains(token, " ") {
+		return token
+	}
+	return "Bearer " + token
+}
+
+// ParseProxy parses a proxy URL such as http://proxy.example.com:3128
+func ParseProxy(value string) (*url.URL, error) {
+	proxy, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q: expected http, https or socks5", proxy.Scheme)
+	}
+	if proxy.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", value)
+	}
+	return proxy, nil
+}
+
+// get performs a GET request advertising compression support and returns
+// the response with its body transparently decompressed. It first waits
+// for the rate limiter and a free connection, giving up when ctx is
+// cancelled. The connection is released when the body is closed.
+// Setting Accept-Encoding ourselves disables the transport's automatic gzip
+// handling, so the decoding has to happen here.
+func (c *Client) get(ctx context.Context, url string) (*http.Response, error) {
+	return c.getIfChanged(ctx, url, validators{})
+}
+
+// getIfChanged is get, made conditional on the given validators when any
+// are set
+func (c *Client) getIfChanged(ctx context.Context, url string, v validators) (*http.Response, error) {
+	return c.getWithRetry(ctx, url, v)
+}
+
+// getOnce sends a single attempt of getIfChanged
+func (c *Client) getOnce(ctx context.Context, url string, v validators) (*http.Response, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("rate limiter: %w", err)
+	}
+	release, err := c.acquireConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	req.Header.Set("User-Agent", version.UserAgent())
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+	if v.etag != "" {
+		req.Header.Set("If-None-Match", v.etag)
+	}
+	if v.lastModified != "" {
+		req.Header.Set("If-Modified-Since", v.lastModified)
+	}
+
+	if err := c.breaker.allow(); err != nil {
+		release()
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		release()
+		if ctx.Err() != nil {
+			c.breaker.abandon()
+		} else {
+			c.breaker.record(true)
+		}
+		return nil, err
+	}
+	c.breaker.record(failedResponse(resp))
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+
+	if err := decompressBody(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
 	}
+	return resp, nil
 }
 
-// APIResponse represents the root API response
-type APIResponse struct {
-	Cities map[string]CityInfo `json:"cities"`
+// acquireConn waits for a free connection slot and returns the function
+// that frees it again
+func (c *Client) acquireConn(ctx context.Context) (func(), error) {
+	if c.conns == nil {
+		return func() {}, nil
+	}
+	select {
+	case c.conns <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("connection limit: %w", ctx.Err())
+	}
+	var once sync.Once
+	return func() { once.Do(func() { <-c.conns }) }, nil
+}
+
+// releasingBody frees the request's connection slot when it is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+// decompressBody replaces resp.Body with a decompressing reader based on the
+// Content-Encoding header
+func decompressBody(resp *http.Response) error {
+	var (
+		reader io.ReadCloser
+		err    error
+	)
+
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip":
+		reader, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		reader, err = zlib.NewReader(resp.Body)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decompress response: %w", err)
+	}
+
+	resp.Body = &decompressedBody{ReadCloser: reader, raw: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	return nil
+}
+
+// decompressedBody closes both the decompressor and the underlying body
+type decompressedBody struct {
+	io.ReadCloser
+	raw io.ReadCloser
+}
+
+func (b *decompressedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if rawErr := b.raw.Close(); err == nil {
+		err = rawErr
+	}
+	return err
 }
 
 // CityInfo represents city metadata
@@ -53,6 +364,25 @@ type CityParkingData struct {
 	LastUpdated    string
 	Lots           []ParkingLot
 	LotReadings    []ParkingLotReading
+
+	// LastDownloadedAt and LastUpdatedAt are the parsed forms of
+	// LastDownloaded and LastUpdated; they are zero when the API sent an
+	// empty or unparseable value
+	LastDownloadedAt time.Time
+	LastUpdatedAt    time.Time
+
+	// FetchedAt is when the data was retrieved from the API
+	FetchedAt time.Time
+	// Replayed is set for captures decoded by a ReplayClient, which were
+	// fetched long before they are ingested
+	Replayed bool
+
+	// validators of the response, which become the city's watermark once
+	// MarkStored is called
+	validators validators
+
+	// SkippedLots counts lot objects that could not be decoded
+	SkippedLots int
 }
 
 // ParkingLot represents a parking lot/garage
@@ -66,6 +396,11 @@ type ParkingLot struct {
 	Latitude  sql.NullFloat64
 	Longitude sql.NullFloat64
 	Region    sql.NullString
+
+	// Extras holds the lot object's fields that are not decoded above, such
+	// as forecast flags or series, as a JSON object. It is nil when there
+	// are none.
+	Extras json.RawMessage
 }
 
 // ParkingLotReading contains the free/state info for a reading
@@ -73,25 +408,57 @@ type ParkingLotReading struct {
 	LotID string
 	Free  int
 	State string
+
+	// Forecast is ParkenDD's flag for lots that publish forecasts. With
+	// state nodata, the lot has no live count and only forecasts.
+	Forecast bool
 }
 
 // parkingLotAPI represents API parking lot data
 type parkingLotAPI struct {
-	ID       string  `json:"id"`
-	Name     string  `json:"name"`
-	Address  string  `json:"address"`
-	Coords   *Coords `json:"coords"`
-	LotType  string  `json:"lot_type"`
-	Free     int     `json:"free"`
-	Total    int     `json:"total"`
-	State    string  `json:"state"`
-	Region   string  `json:"region"`
-	Forecast bool    `json:"forecast"`
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	Address string  `json:"address"`
+	Coords  *Coords `json:"coords"`
+	LotType string  `json:"lot_type"`
+	Free    int     `json:"free"`
+	Total   int     `json:"total"`
+	State   string  `json:"state"`
+	Region  string  `json:"region"`
+
+	// Forecast is a flag in ParkenDD but a series in some sources, so it
+	// is decoded leniently; either way it is also kept in Extras
+	Forecast json.RawMessage `json:"forecast"`
+}
+
+// knownLotFields are the lot keys decoded into parkingLotAPI; any other
+// key is kept in ParkingLot.Extras
+var knownLotFields = []string{"id", "name", "address", "coords", "lot_type", "free", "total", "state", "region"}
+
+// lotExtras returns the fields of a raw lot object that are not in
+// knownLotFields, or nil if there are none
+func lotExtras(raw json.RawMessage) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	for _, key := range knownLotFields {
+		delete(fields, key)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(fields)
 }
 
 // GetCities fetches the list of available cities
 func (c *Client) GetCities() (map[string]CityInfo, error) {
-	resp, err := c.httpClient.Get(BaseURL)
+	return c.GetCitiesContext(context.Background())
+}
+
+// GetCitiesContext is GetCities, aborting the request when ctx is done
+func (c *Client) GetCitiesContext(ctx context.Context) (map[string]CityInfo, error) {
+	resp, err := c.get(ctx, c.baseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch cities: %w", err)
 	}
@@ -99,56 +466,156 @@ func (c *Client) GetCities() (map[string]CityInfo, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
-	var apiResp APIResponse
+	// Decode cities one by one, so a single malformed entry does not lose
+	// the whole list
+	var apiResp struct {
+		Cities map[string]json.RawMessage `json:"cities"`
+	}
 	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	return apiResp.Cities, nil
+	cities := make(map[string]CityInfo, len(apiResp.Cities))
+	for id, raw := range apiResp.Cities {
+		var info CityInfo
+		if err := json.Unmarshal(raw, &info); err != nil {
+			log.Printf("Warning: skipping city %s with malformed metadata: %v", id, err)
+			continue
+		}
+		cities[id] = info
+	}
+	if len(cities) == 0 && len(apiResp.Cities) > 0 {
+		return nil, errors.New("failed to decode response: every city is malformed")
+	}
+	return cities, nil
 }
 
 // GetCityParkingData fetches parking data for a specific city
 func (c *Client) GetCityParkingData(city string) (*CityParkingData, error) {
-	url := fmt.Sprintf("%s/%s", BaseURL, city)
+	return c.GetCityParkingDataContext(context.Background(), city)
+}
 
-	resp, err := c.httpClient.Get(url)
+// GetCityParkingDataContext is GetCityParkingData, aborting the request,
+// including reading its body, when ctx is done
+func (c *Client) GetCityParkingDataContext(ctx context.Context, city string) (*CityParkingData, error) {
+	url := fmt.Sprintf("%s/%s", c.baseURL, city)
+
+	var v validators
+	if c.conditional {
+		c.validatorsMu.Lock()
+		v = c.validators[city]
+		c.validatorsMu.Unlock()
+	}
+
+	resp, err := c.getIfChanged(ctx, url, v)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch parking data for %s: %w", city, err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && v != (validators{}) {
+		return nil, fmt.Errorf("%s: %w", city, ErrNotModified)
+	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API returned status %d for %s: %s", resp.StatusCode, city, string(body))
+		return nil, &StatusError{StatusCode: resp.StatusCode, City: city, Body: string(body)}
+	}
+
+	fetchedAt := time.Now().UTC()
+
+	// Only buffer the body when it has to be captured; the common path
+	// decodes straight from the stream
+	var body io.Reader = resp.Body
+	if c.rawDir != "" {
+		raw, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response for %s: %w", city, err)
+		}
+		if err := SaveRaw(c.rawDir, city, fetchedAt, raw); err != nil {
+			log.Printf("Warning: failed to store raw response for %s: %v", city, err)
+		}
+		body = bytes.NewReader(raw)
+	}
+
+	data, err := ParseCityParkingData(city, body)
+	if err != nil {
+		return nil, err
+	}
+	data.FetchedAt = fetchedAt
+	data.validators = validators{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}
+	return data, nil
+}
+
+// MarkStored makes data, as returned by GetCityParkingData, the watermark
+// of the city's next conditional request. Callers invoke it only once the
+// data is safely stored: a response that was fetched but lost must not be
+// answered with 304 Not Modified on the next poll.
+func (c *Client) MarkStored(city string, data *CityParkingData) {
+	if !c.conditional {
+		return
 	}
+	c.validatorsMu.Lock()
+	defer c.validatorsMu.Unlock()
+	c.validators[city] = data.validators
+}
 
+// ParseCityParkingData decodes a city response as returned by the API.
+// Lots are decoded one by one so a single malformed lot is skipped (and
+// counted in SkippedLots) instead of failing the whole city.
+func ParseCityParkingData(city string, r io.Reader) (*CityParkingData, error) {
 	var data struct {
-		LastDownloaded string          `json:"last_downloaded"`
-		LastUpdated    string          `json:"last_updated"`
-		Lots           []parkingLotAPI `json:"lots"`
+		LastDownloaded string            `json:"last_downloaded"`
+		LastUpdated    string            `json:"last_updated"`
+		Lots           []json.RawMessage `json:"lots"`
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
 		return nil, fmt.Errorf("failed to decode response for %s: %w", city, err)
 	}
 
 	result := &CityParkingData{
 		LastDownloaded: data.LastDownloaded,
 		LastUpdated:    data.LastUpdated,
-		Lots:           make([]ParkingLot, len(data.Lots)),
-		LotReadings:    make([]ParkingLotReading, len(data.Lots)),
+		Lots:           make([]ParkingLot, 0, len(data.Lots)),
+		LotReadings:    make([]ParkingLotReading, 0, len(data.Lots)),
+	}
+
+	if t, err := ParseTimestamp(data.LastDownloaded); err == nil {
+		result.LastDownloadedAt = t
+	}
+	if t, err := ParseTimestamp(data.LastUpdated); err == nil {
+		result.LastUpdatedAt = t
+	} else if data.LastUpdated != "" {
+		log.Printf("Warning: %s sent an unparseable last_updated %q: %v", city, data.LastUpdated, err)
 	}
 
 	// Convert API lots to internal format
-	for i, lot := range data.Lots {
+	for i, raw := range data.Lots {
+		var lot parkingLotAPI
+		if err := json.Unmarshal(raw, &lot); err != nil {
+			log.Printf("Warning: skipping malformed lot #%d for %s: %v", i, city, err)
+			result.SkippedLots++
+			continue
+		}
+		extras, err := lotExtras(raw)
+		if err != nil {
+			log.Printf("Warning: skipping malformed lot #%d for %s: %v", i, city, err)
+			result.SkippedLots++
+			continue
+		}
+
 		dbLot := ParkingLot{
-			ID:    lot.ID,
-			City:  city,
-			Name:  lot.Name,
-			Total: lot.Total,
+			ID:     lot.ID,
+			City:   city,
+			Name:   lot.Name,
+			Total:  lot.Total,
+			Extras: extras,
 		}
 
 		if lot.Address != "" {
@@ -168,18 +635,41 @@ func (c *Client) GetCityParkingData(city string) (*CityParkingData, error) {
 			dbLot.Longitude.Valid = true
 		}
 
-		if lot.Region != "" {
-			dbLot.Region.String = lot.Region
+		// Blank regions mean the lot belongs to none
+		if region := strings.TrimSpace(lot.Region); region != "" {
+			dbLot.Region.String = region
 			dbLot.Region.Valid = true
 		}
 
-		result.Lots[i] = dbLot
-		result.LotReadings[i] = ParkingLotReading{
-			LotID: lot.ID,
-			Free:  lot.Free,
-			State: lot.State,
-		}
+		result.Lots = append(result.Lots, dbLot)
+		result.LotReadings = append(result.LotReadings, ParkingLotReading{
+			LotID:    lot.ID,
+			Free:     lot.Free,
+			State:    lot.State,
+			Forecast: string(lot.Forecast) == "true",
+		})
 	}
 
 	return result, nil
 }
+
+// timestampLayouts are the formats ParkenDD sources use for last_updated
+// and last_downloaded. Most omit the zone; those values are in UTC.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+}
+
+// ParseTimestamp parses an API timestamp, treating zone-less values as UTC
+func ParseTimestamp(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("empty timestamp")
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", value)
+}
@@ -1,7 +1,21 @@
 package api
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/version"
 )
 
 func TestNewClient(t *testing.T) {
@@ -15,3 +29,488 @@ func TestNewClient(t *testing.T) {
 		t.Error("Client http client is nil")
 	}
 }
+
+const testCityJSON = `{
+	"last_downloaded": "2024-01-01T12:00:00",
+	"last_updated": "2024-01-01T11:55:00",
+	"lots": [
+		{"id": "lot1", "name": "Altmarkt", "total": 400, "free": 120, "state": "open",
+		 "coords": {"lat": 51.05, "lng": 13.74}, "lot_type": "Tiefgarage"}
+	]
+}`
+
+func TestGetCityParkingDataCompressed(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		compress func([]byte) []byte
+	}{
+		{
+			name:     "gzip",
+			encoding: "gzip",
+			compress: func(b []byte) []byte {
+				var buf bytes.Buffer
+				w := gzip.NewWriter(&buf)
+				w.Write(b)
+				w.Close()
+				return buf.Bytes()
+			},
+		},
+		{
+			name:     "deflate",
+			encoding: "deflate",
+			compress: func(b []byte) []byte {
+				var buf bytes.Buffer
+				w := zlib.NewWriter(&buf)
+				w.Write(b)
+				w.Close()
+				return buf.Bytes()
+			},
+		},
+		{
+			name:     "identity",
+			encoding: "",
+			compress: func(b []byte) []byte { return b },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Accept-Encoding"); got != "gzip, deflate" {
+					t.Errorf("Accept-Encoding = %q, expected %q", got, "gzip, deflate")
+				}
+				if got := r.Header.Get("User-Agent"); got != version.UserAgent() {
+					t.Errorf("User-Agent = %q, expected %q", got, version.UserAgent())
+				}
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.Write(tt.compress([]byte(testCityJSON)))
+			}))
+			defer server.Close()
+
+			client := NewClientWithBaseURL(server.URL)
+
+			data, err := client.GetCityParkingData("Dresden")
+			if err != nil {
+				t.Fatalf("GetCityParkingData() error: %v", err)
+			}
+
+			if len(data.Lots) != 1 {
+				t.Fatalf("Expected 1 lot, got %d", len(data.Lots))
+			}
+			if data.Lots[0].Name != "Altmarkt" {
+				t.Errorf("Expected lot name 'Altmarkt', got '%s'", data.Lots[0].Name)
+			}
+			if data.LotReadings[0].Free != 120 {
+				t.Errorf("Expected 120 free, got %d", data.LotReadings[0].Free)
+			}
+		})
+	}
+}
+
+func TestGetCityParkingDataRawDir(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testCityJSON))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := NewClientWithOptions(Options{BaseURL: server.URL, RawDir: dir})
+
+	data, err := client.GetCityParkingData("Dresden")
+	if err != nil {
+		t.Fatalf("GetCityParkingData() error: %v", err)
+	}
+	if len(data.Lots) != 1 {
+		t.Fatalf("Expected 1 lot, got %d", len(data.Lots))
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "Dresden", "*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 raw file, got %v (err %v)", files, err)
+	}
+	raw, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != testCityJSON {
+		t.Errorf("Raw file content does not match response body")
+	}
+}
+
+func TestGetCitiesSkipsMalformedCities(t *testing.T) {
+	body := `{"cities": {
+		"Dresden": {"name": "Dresden", "active_support": true},
+		"Broken": {"name": 42}
+	}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	cities, err := NewClientWithBaseURL(server.URL).GetCities()
+	if err != nil {
+		t.Fatalf("GetCities() error: %v", err)
+	}
+	if len(cities) != 1 || cities["Dresden"].Name != "Dresden" {
+		t.Errorf("Expected only Dresden, got %+v", cities)
+	}
+
+	body = `{"cities": {"Broken": {"name": 42}}}`
+	if _, err := NewClientWithBaseURL(server.URL).GetCities(); err == nil {
+		t.Error("Expected an error when every city is malformed")
+	}
+}
+
+func TestParseCityParkingDataSkipsMalformedLots(t *testing.T) {
+	payload := `{
+		"last_updated": "2024-01-01T11:55:00",
+		"lots": [
+			{"id": "good1", "name": "Altmarkt", "total": 400, "free": 120, "state": "open", "region": "  "},
+			{"id": "bad1", "name": "Broken", "total": "many", "free": 3, "state": "open"},
+			{"id": "good2", "name": "Zwinger", "total": 200, "free": 10, "state": "open"},
+			{"id": "bad2", "coords": {"lat": "north"}},
+			"not an object"
+		]
+	}`
+
+	data, err := ParseCityParkingData("Dresden", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("ParseCityParkingData() error: %v", err)
+	}
+
+	if len(data.Lots) != 2 || len(data.LotReadings) != 2 {
+		t.Fatalf("Expected 2 valid lots, got %d lots and %d readings", len(data.Lots), len(data.LotReadings))
+	}
+	if data.Lots[0].ID != "good1" || data.Lots[1].ID != "good2" {
+		t.Errorf("Unexpected lots %s, %s", data.Lots[0].ID, data.Lots[1].ID)
+	}
+	if data.LotReadings[1].LotID != "good2" || data.LotReadings[1].Free != 10 {
+		t.Errorf("Readings out of sync with lots: %+v", data.LotReadings[1])
+	}
+	if data.SkippedLots != 3 {
+		t.Errorf("Expected 3 skipped lots, got %d", data.SkippedLots)
+	}
+	if data.Lots[0].Region.Valid {
+		t.Errorf("Expected a blank region to be NULL, got %q", data.Lots[0].Region.String)
+	}
+
+	if _, err := ParseCityParkingData("Dresden", strings.NewReader(`{"lots": `)); err == nil {
+		t.Error("Expected error for truncated document")
+	}
+}
+
+func TestParseCityParkingDataExtras(t *testing.T) {
+	payload := `{
+		"lots": [
+			{"id": "plain", "name": "Altmarkt", "total": 400, "free": 120, "state": "open"},
+			{"id": "flagged", "name": "Postplatz", "total": 100, "free": 0, "state": "nodata", "forecast": true},
+			{"id": "extra", "name": "Zwinger", "total": 200, "free": 10, "state": "open",
+			 "forecast": [{"time": "2024-01-01T13:00:00", "free": 8}], "opening_hours": "24/7"}
+		]
+	}`
+
+	data, err := ParseCityParkingData("Dresden", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("ParseCityParkingData() error: %v", err)
+	}
+	if len(data.Lots) != 3 {
+		t.Fatalf("Expected 3 lots, got %d", len(data.Lots))
+	}
+	if data.Lots[0].Extras != nil {
+		t.Errorf("got extras %s for a lot with only known fields, expected none", data.Lots[0].Extras)
+	}
+
+	expected := `{"forecast":[{"time":"2024-01-01T13:00:00","free":8}],"opening_hours":"24/7"}`
+	if got := string(data.Lots[2].Extras); got != expected {
+		t.Errorf("got extras %s, expected %s", got, expected)
+	}
+	if data.Lots[2].Total != 200 || data.LotReadings[2].Free != 10 {
+		t.Errorf("Known fields not decoded: %+v, %+v", data.Lots[2], data.LotReadings[2])
+	}
+
+	// Only ParkenDD's boolean flag marks a lot as publishing forecasts
+	for idx, expected := range []bool{false, true, false} {
+		if got := data.LotReadings[idx].Forecast; got != expected {
+			t.Errorf("got forecast %v for %s, expected %v", got, data.LotReadings[idx].LotID, expected)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Time
+		wantErr  bool
+	}{
+		{"2024-01-01T11:55:00", time.Date(2024, 1, 1, 11, 55, 0, 0, time.UTC), false},
+		{"2024-01-01T11:55:00.250", time.Date(2024, 1, 1, 11, 55, 0, 250000000, time.UTC), false},
+		{"2024-01-01 11:55:00", time.Date(2024, 1, 1, 11, 55, 0, 0, time.UTC), false},
+		{"2024-01-01T12:55:00+01:00", time.Date(2024, 1, 1, 11, 55, 0, 0, time.UTC), false},
+		{"", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTimestamp(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.expected) {
+			t.Errorf("ParseTimestamp(%q) got %v, expected %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestParseCityParkingDataTimestamps(t *testing.T) {
+	data, err := ParseCityParkingData("Dresden", strings.NewReader(testCityJSON))
+	if err != nil {
+		t.Fatalf("ParseCityParkingData() error: %v", err)
+	}
+
+	if expected := time.Date(2024, 1, 1, 11, 55, 0, 0, time.UTC); !data.LastUpdatedAt.Equal(expected) {
+		t.Errorf("LastUpdatedAt got %v, expected %v", data.LastUpdatedAt, expected)
+	}
+	if expected := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC); !data.LastDownloadedAt.Equal(expected) {
+		t.Errorf("LastDownloadedAt got %v, expected %v", data.LastDownloadedAt, expected)
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testCityJSON))
+	}))
+	defer server.Close()
+
+	client := NewClientWithOptions(Options{BaseURL: server.URL, RateLimit: 20, Burst: 1})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := client.GetCityParkingData("Dresden"); err != nil {
+			t.Fatalf("GetCityParkingData() error: %v", err)
+		}
+	}
+	// The first request uses the burst, the next two wait 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected rate limited requests to take at least 100ms, took %v", elapsed)
+	}
+
+	// Waiting for the limiter respects cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.get(ctx, server.URL+"/Dresden"); err == nil {
+		t.Error("Expected an error for a cancelled context")
+	}
+}
+
+func TestClientMaxConnections(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(testCityJSON))
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	client := NewClientWithOptions(Options{BaseURL: server.URL, MaxConnections: 2})
+
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.GetCityParkingData("Dresden"); err != nil {
+				t.Errorf("GetCityParkingData() error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak != 2 {
+		t.Errorf("got %d requests in flight at most, expected 2", peak)
+	}
+
+	// Waiting for a connection respects cancellation
+	client.conns <- struct{}{}
+	client.conns <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.get(ctx, server.URL+"/Dresden"); err == nil {
+		t.Error("Expected an error while every connection is taken")
+	}
+}
+
+func TestClientProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Proxies receive the absolute URL of the target
+		proxied = append(proxied, r.URL.String())
+		w.Write([]byte(testCityJSON))
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := ParseProxy(proxy.URL)
+	if err != nil {
+		t.Fatalf("ParseProxy() error: %v", err)
+	}
+	client := NewClientWithOptions(Options{BaseURL: "http://parkendd.invalid", Proxy: proxyURL})
+	if _, err := client.GetCityParkingData("Dresden"); err != nil {
+		t.Fatalf("GetCityParkingData() error: %v", err)
+	}
+	if len(proxied) != 1 || proxied[0] != "http://parkendd.invalid/Dresden" {
+		t.Errorf("got proxied requests %v, expected http://parkendd.invalid/Dresden", proxied)
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(testCityJSON))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		token    string
+		expected string
+	}{
+		{"s3cret", "Bearer s3cret"},
+		{"Token s3cret", "Token s3cret"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got = nil
+		client := NewClientWithOptions(Options{BaseURL: server.URL, Token: tt.token})
+		_, err := client.GetCityParkingData("Dresden")
+		if len(got) != 1 || got[0] != tt.expected {
+			t.Errorf("token %q: got Authorization %q, expected %q", tt.token, got, tt.expected)
+		}
+		if tt.token == "" && err == nil {
+			t.Error("Expected the unauthenticated request to fail")
+		}
+		if tt.token != "" && err != nil {
+			t.Errorf("token %q: GetCityParkingData() error: %v", tt.token, err)
+		}
+	}
+
+	// Errors never carry the token
+	client := NewClientWithOptions(Options{BaseURL: "http://127.0.0.1:1", Token: "s3cret"})
+	if _, err := client.GetCityParkingData("Dresden"); err == nil || strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("Expected an error without the token, got %v", err)
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"http://proxy.example.com:3128", false},
+		{"socks5://127.0.0.1:1080", false},
+		{"proxy.example.com:3128", true},
+		{"ftp://proxy.example.com", true},
+		{"http://", true},
+	}
+	for _, tt := range tests {
+		if _, err := ParseProxy(tt.value); (err != nil) != tt.wantErr {
+			t.Errorf("ParseProxy(%q) got error %v, expected error: %v", tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGetCityParkingDataConditional(t *testing.T) {
+	const etag = `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/Plain" {
+			w.Write([]byte(testCityJSON))
+			return
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(testCityJSON))
+	}))
+	defer server.Close()
+
+	client := NewClientWithOptions(Options{BaseURL: server.URL, Conditional: true})
+
+	data, err := client.GetCityParkingData("Dresden")
+	if err != nil {
+		t.Fatalf("First GetCityParkingData() error: %v", err)
+	}
+
+	// Until the response is marked as stored it is fetched in full again
+	if data, err = client.GetCityParkingData("Dresden"); err != nil {
+		t.Fatalf("Unmarked GetCityParkingData() error: %v", err)
+	}
+	client.MarkStored("Dresden", data)
+	if _, err := client.GetCityParkingData("Dresden"); !errors.Is(err, ErrNotModified) {
+		t.Errorf("got error %v, expected ErrNotModified", err)
+	}
+
+	// Without validators every request is a full fetch
+	for i := 0; i < 2; i++ {
+		data, err := client.GetCityParkingData("Plain")
+		if err != nil {
+			t.Fatalf("GetCityParkingData() without validators error: %v", err)
+		}
+		client.MarkStored("Plain", data)
+	}
+
+	// Clients without conditional requests never send validators
+	plain := NewClientWithBaseURL(server.URL)
+	for i := 0; i < 2; i++ {
+		data, err := plain.GetCityParkingData("Dresden")
+		if err != nil {
+			t.Fatalf("Unconditional GetCityParkingData() error: %v", err)
+		}
+		plain.MarkStored("Dresden", data)
+	}
+}
+
+func TestGetCityParkingDataContextCancel(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-unblock:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	client := NewClientWithBaseURL(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := client.GetCityParkingDataContext(ctx, "Dresden")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the request to stop when cancelled, took %v", elapsed)
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RawTimeFormat is the UTC timestamp layout used for raw response file names
+const RawTimeFormat = "20060102T150405Z"
+
+// RawPath returns where a raw response for city fetched at t is stored
+func RawPath(dir, city string, t time.Time) string {
+	return filepath.Join(dir, city, t.UTC().Format(RawTimeFormat)+".json")
+}
+
+// SaveRaw writes a raw API response body for later inspection or replay
+func SaveRaw(dir, city string, t time.Time, body []byte) error {
+	path := RawPath(dir, city, t)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, body, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// ErrNoMoreCaptures is returned by ReplayClient once a city's captures are used up
+var ErrNoMoreCaptures = errors.New("no more captured responses")
+
+// Capture is a raw response stored by SaveRaw
+type Capture struct {
+	City      string
+	FetchedAt time.Time
+	Path      string
+}
+
+// ReplayClient serves previously captured raw responses instead of the live
+// API. Each call for a city returns that city's next capture in
+// chronological order, decoded exactly like a live response.
+type ReplayClient struct {
+	captures []Capture
+	pending  map[string][]Capture
+}
+
+// NewReplayClient loads the captures below dir as written by SaveRaw
+func NewReplayClient(dir string) (*ReplayClient, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ReplayClient{pending: make(map[string][]Capture)}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		fetchedAt, err := time.Parse(RawTimeFormat, name)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected capture file name %s: %w", path, err)
+		}
+		r.captures = append(r.captures, Capture{
+			City:      filepath.Base(filepath.Dir(path)),
+			FetchedAt: fetchedAt,
+			Path:      path,
+		})
+	}
+
+	sort.SliceStable(r.captures, func(a, b int) bool {
+		return r.captures[a].FetchedAt.Before(r.captures[b].FetchedAt)
+	})
+	for _, c := range r.captures {
+		r.pending[c.City] = append(r.pending[c.City], c)
+	}
+
+	return r, nil
+}
+
+// Captures returns all loaded captures in chronological order
+func (r *ReplayClient) Captures() []Capture {
+	return r.captures
+}
+
+// Cities returns the sorted list of cities with captures
+func (r *ReplayClient) Cities() []string {
+	cities := make([]string, 0, len(r.pending))
+	for city := range r.pending {
+		cities = append(cities, city)
+	}
+	sort.Strings(cities)
+	return cities
+}
+
+// GetCityParkingData decodes the next capture for city, stamped with the
+// time it was originally fetched
+func (r *ReplayClient) GetCityParkingData(city string) (*CityParkingData, error) {
+	return r.GetCityParkingDataContext(context.Background(), city)
+}
+
+// GetCityParkingDataContext is GetCityParkingData, failing without
+// consuming a capture when ctx is done
+func (r *ReplayClient) GetCityParkingDataContext(ctx context.Context, city string) (*CityParkingData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	queue := r.pending[city]
+	if len(queue) == 0 {
+		return nil, fmt.Errorf("%s: %w", city, ErrNoMoreCaptures)
+	}
+	capture := queue[0]
+	r.pending[city] = queue[1:]
+
+	f, err := os.Open(capture.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := ParseCityParkingData(city, f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", capture.Path, err)
+	}
+	data.FetchedAt = capture.FetchedAt
+	data.Replayed = true
+	return data, nil
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReplayClient(t *testing.T) {
+	dir := t.TempDir()
+	first := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(5 * time.Minute)
+
+	// Written out of order to check chronological replay
+	for _, ts := range []time.Time{second, first} {
+		if err := SaveRaw(dir, "Dresden", ts, []byte(testCityJSON)); err != nil {
+			t.Fatalf("SaveRaw() error: %v", err)
+		}
+	}
+	if err := SaveRaw(dir, "Basel", first, []byte(testCityJSON)); err != nil {
+		t.Fatalf("SaveRaw() error: %v", err)
+	}
+
+	replay, err := NewReplayClient(dir)
+	if err != nil {
+		t.Fatalf("NewReplayClient() error: %v", err)
+	}
+
+	if got := replay.Cities(); len(got) != 2 || got[0] != "Basel" || got[1] != "Dresden" {
+		t.Errorf("Unexpected cities: %v", got)
+	}
+	if got := len(replay.Captures()); got != 3 {
+		t.Errorf("Expected 3 captures, got %d", got)
+	}
+
+	for _, expected := range []time.Time{first, second} {
+		data, err := replay.GetCityParkingData("Dresden")
+		if err != nil {
+			t.Fatalf("GetCityParkingData() error: %v", err)
+		}
+		if !data.FetchedAt.Equal(expected) {
+			t.Errorf("Expected FetchedAt %v, got %v", expected, data.FetchedAt)
+		}
+		if !data.Replayed {
+			t.Error("Expected replayed data to be marked as Replayed")
+		}
+		if len(data.Lots) != 1 || data.Lots[0].City != "Dresden" {
+			t.Errorf("Unexpected lots: %+v", data.Lots)
+		}
+	}
+
+	if _, err := replay.GetCityParkingData("Dresden"); !errors.Is(err, ErrNoMoreCaptures) {
+		t.Errorf("Expected ErrNoMoreCaptures, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/backoff"
+	"github.com/niklas/parkmonitor/ingestor/internal/metrics"
+)
+
+var retriesTotal = metrics.Default.NewCounterVec("parkmonitor_api_retries_total",
+	"API requests retried after a network error or 5xx response.")
+
+// RetryOptions configures how a client retries failed requests. The zero
+// value disables retries.
+type RetryOptions struct {
+	// MaxRetries is how many times a request is retried after a network
+	// error or 5xx response (0 = never). Other statuses, such as 404, are
+	// returned immediately.
+	MaxRetries int
+
+	// BaseDelay is the wait before the first retry; it doubles after every
+	// retry, up to MaxDelay (0 = no cap)
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Jitter    backoff.Jitter
+
+	// MaxElapsed caps the time from the first attempt until the last retry
+	// starts, so a failing request cannot hold up a poll for minutes
+	// (0 = no cap)
+	MaxElapsed time.Duration
+}
+
+// policy returns the backoff between retries
+func (o RetryOptions) policy() backoff.Policy {
+	return backoff.Policy{Base: o.BaseDelay, Max: o.MaxDelay, Jitter: o.Jitter}
+}
+
+// Retries returns how many requests the client has retried since it was
+// created
+func (c *Client) Retries() int64 {
+	return c.retries.Load()
+}
+
+// getWithRetry is getOnce, retried as configured by the client's
+// RetryOptions. After the last attempt its response or error is returned
+// as is, so a final 5xx response reaches the caller like any other status.
+func (c *Client) getWithRetry(ctx context.Context, url string, v validators) (*http.Response, error) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		resp, err := c.getOnce(ctx, url, v)
+		if !retryable(ctx, resp, err) || attempt > c.retry.MaxRetries {
+			return resp, err
+		}
+		delay := c.retry.policy().Delay(attempt)
+		if c.retry.MaxElapsed > 0 && time.Since(start)+delay > c.retry.MaxElapsed {
+			return resp, err
+		}
+
+		if resp != nil {
+			err = fmt.Errorf("status %d", resp.StatusCode)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		log.Printf("Warning: request to %s failed (%v), retry %d of %d in %v", url, err, attempt, c.retry.MaxRetries, delay)
+		c.retries.Add(1)
+		retriesTotal.Inc()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether a request that ended with resp or err may
+// succeed when sent again. Requests rejected by the circuit breaker or
+// abandoned with ctx are not retried.
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, ErrCircuitOpen)
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   int
+		status     int
+		opts       RetryOptions
+		expectErr  bool
+		retries    int64
+		statusCode int
+	}{
+		{"transient 502", 2, http.StatusBadGateway, RetryOptions{MaxRetries: 3, BaseDelay: time.Millisecond}, false, 2, 0},
+		{"persistent 503", 10, http.StatusServiceUnavailable, RetryOptions{MaxRetries: 3, BaseDelay: time.Millisecond}, true, 3, http.StatusServiceUnavailable},
+		{"404 is final", 10, http.StatusNotFound, RetryOptions{MaxRetries: 3, BaseDelay: time.Millisecond}, true, 0, http.StatusNotFound},
+		{"disabled", 1, http.StatusBadGateway, RetryOptions{}, true, 0, http.StatusBadGateway},
+		{"elapsed cap", 10, http.StatusBadGateway, RetryOptions{MaxRetries: 10, BaseDelay: 20 * time.Millisecond, MaxElapsed: 50 * time.Millisecond}, true, 1, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests <= tt.failures {
+					http.Error(w, "upstream failed", tt.status)
+					return
+				}
+				w.Write([]byte(`{"cities": {"Dresden": {"name": "Dresden"}}}`))
+			}))
+			defer server.Close()
+
+			client := NewClientWithOptions(Options{BaseURL: server.URL, Retry: tt.opts})
+			_, err := client.GetCities()
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("got error %v, expected error: %v", err, tt.expectErr)
+			}
+			var statusErr *StatusError
+			if tt.statusCode != 0 && (!errors.As(err, &statusErr) || statusErr.StatusCode != tt.statusCode) {
+				t.Errorf("got %v, expected status %d", err, tt.statusCode)
+			}
+			if got := client.Retries(); got != tt.retries {
+				t.Errorf("got %d retries, expected %d", got, tt.retries)
+			}
+			if requests != int(tt.retries)+1 {
+				t.Errorf("got %d requests, expected %d", requests, tt.retries+1)
+			}
+		})
+	}
+}
+
+func TestClientRetryConnectionError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	client := NewClientWithOptions(Options{BaseURL: url, Retry: RetryOptions{MaxRetries: 2, BaseDelay: time.Millisecond}})
+	if _, err := client.GetCityParkingData("Dresden"); err == nil {
+		t.Fatal("Expected an error from a closed server")
+	}
+	if got := client.Retries(); got != 2 {
+		t.Errorf("got %d retries, expected 2", got)
+	}
+}
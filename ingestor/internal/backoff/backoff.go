@@ -0,0 +1,77 @@
+// Package backoff computes exponential retry delays with optional jitter.
+// API requests, city discovery, database writes, outage backoff and alert
+// webhooks all retry with the -retry-jitter strategy, so one setting
+// decides how much clients that failed together spread out their retries.
+// Only opening the local database file retries without jitter.
+package backoff
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// Jitter is a strategy for randomizing delays
+type Jitter string
+
+const (
+	// JitterNone uses the exact exponential delays
+	JitterNone Jitter = "none"
+	// JitterFull waits a uniformly random time between 0 and the delay
+	JitterFull Jitter = "full"
+	// JitterEqual waits half the delay plus a random part of the other half
+	JitterEqual Jitter = "equal"
+)
+
+// Jitters lists the supported strategies
+var Jitters = []Jitter{JitterNone, JitterFull, JitterEqual}
+
+// ParseJitter parses a strategy name; empty means JitterNone
+func ParseJitter(value string) (Jitter, error) {
+	if value == "" {
+		return JitterNone, nil
+	}
+	for _, j := range Jitters {
+		if strings.EqualFold(value, string(j)) {
+			return j, nil
+		}
+	}
+	return "", fmt.Errorf("unknown jitter strategy %q (expected none, full or equal)", value)
+}
+
+// Policy is an exponential backoff: the delay starts at Base and doubles
+// after every attempt, up to Max
+type Policy struct {
+	Base time.Duration
+	// Max caps the delay before jitter is applied (0 = no cap)
+	Max    time.Duration
+	Jitter Jitter
+}
+
+// Delay returns the wait before the given retry, where 1 is the first
+func (p Policy) Delay(attempt int) time.Duration {
+	d := p.Base
+	for n := 1; n < attempt && d <= math.MaxInt64/2; n++ {
+		if p.Max > 0 && d >= p.Max {
+			break
+		}
+		d *= 2
+	}
+	if p.Max > 0 && d > p.Max {
+		d = p.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	switch p.Jitter {
+	case JitterFull:
+		return time.Duration(rand.Int64N(int64(d) + 1))
+	case JitterEqual:
+		half := d / 2
+		return half + time.Duration(rand.Int64N(int64(d-half)+1))
+	}
+	return d
+}
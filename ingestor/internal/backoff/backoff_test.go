@@ -0,0 +1,64 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		jitter Jitter
+		// bounds returns the allowed range for an unjittered delay d
+		bounds func(d time.Duration) (time.Duration, time.Duration)
+	}{
+		{JitterNone, func(d time.Duration) (time.Duration, time.Duration) { return d, d }},
+		{JitterFull, func(d time.Duration) (time.Duration, time.Duration) { return 0, d }},
+		{JitterEqual, func(d time.Duration) (time.Duration, time.Duration) { return d / 2, d }},
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+
+	for _, tt := range tests {
+		t.Run(string(tt.jitter), func(t *testing.T) {
+			p := Policy{Base: time.Second, Max: 5 * time.Second, Jitter: tt.jitter}
+			for idx, d := range expected {
+				low, high := tt.bounds(d)
+				// Sample repeatedly, since jittered delays are random
+				for range 100 {
+					if got := p.Delay(idx + 1); got < low || got > high {
+						t.Fatalf("attempt %d: got %v, expected within [%v, %v]", idx+1, got, low, high)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDelayWithoutCap(t *testing.T) {
+	p := Policy{Base: time.Second}
+	if got := p.Delay(4); got != 8*time.Second {
+		t.Errorf("got %v, expected %v", got, 8*time.Second)
+	}
+	if got := p.Delay(100); got <= 0 {
+		t.Errorf("got %v for a large attempt, expected no overflow", got)
+	}
+}
+
+func TestParseJitter(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Jitter
+		wantErr  bool
+	}{
+		{"", JitterNone, false},
+		{"full", JitterFull, false},
+		{"Equal", JitterEqual, false},
+		{"decorrelated", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseJitter(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.expected {
+			t.Errorf("ParseJitter(%q): got (%v, %v), expected %v", tt.value, got, err, tt.expected)
+		}
+	}
+}
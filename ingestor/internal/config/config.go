@@ -1,8 +1,19 @@
 package config
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+	"github.com/niklas/parkmonitor/ingestor/internal/backoff"
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/ingestor"
 )
 
 // Config holds the application configuration from CLI flags
@@ -10,38 +21,489 @@ type Config struct {
 	DBPath   string
 	Interval time.Duration
 	Cities   []string
-}
 
-// ParseFlags parses command-line flags and returns the configuration
-func ParseFlags() *Config {
-	dbPath := flag.String("db", "parking.db", "Path to SQLite database file")
-	interval := flag.Duration("interval", 5*time.Minute, "Polling interval")
-	cities := flag.String("cities", "", "Comma-separated list of cities to monitor (empty = all cities)")
-	flag.Parse()
+	// InstanceID is stored with every reading to identify this ingestor
+	// (default: the host name; empty = NULL)
+	InstanceID string
+
+	// MaxCities caps how many cities are monitored when Cities is empty
+	// and all cities are discovered (0 = no cap)
+	MaxCities int
+
+	// AutoRefreshCities, if positive, is how often the city list is
+	// re-fetched when Cities is empty, so that new cities are picked up
+	// without a restart (0 = only at startup)
+	AutoRefreshCities time.Duration
+
+	// Pragmas are applied to every database connection
+	Pragmas []database.Pragma
+
+	// CityNames overrides the city names shown by the read API; every key
+	// must be a monitored city
+	CityNames database.CityNames
+
+	// ExtraDBPaths are additional SQLite databases that receive every
+	// write to DBPath, e.g. a central database on a shared volume
+	ExtraDBPaths []string
+
+	// BaseURL is the ParkenDD API to poll
+	BaseURL string
+
+	// SkipUnchanged makes conditional requests and stores nothing for
+	// cities the API reports as unchanged since the last poll
+	SkipUnchanged bool
+
+	// RateLimit caps outbound API requests per second (0 = unlimited)
+	// with up to RateBurst requests sent back to back
+	RateLimit float64
+	RateBurst int
+
+	// Proxy routes API requests through this proxy; nil uses the
+	// HTTP_PROXY and HTTPS_PROXY environment variables
+	Proxy *url.URL
+
+	// Breaker configures the API circuit breaker; see api.BreakerOptions
+	Breaker api.BreakerOptions
+	// Retry configures retries of failed API requests; see
+	// api.RetryOptions. Its jitter is RetryJitter.
+	Retry api.RetryOptions
+
+	// APIToken is sent as the Authorization header of every API request
+	// (empty = none); it is never logged
+	APIToken string
+
+	// FetchConcurrency caps simultaneous API requests, shared by city polls
+	// and on-demand refreshes (0 = unlimited)
+	FetchConcurrency int
+
+	// CycleTimeout is a hard deadline for each poll cycle (0 = none)
+	CycleTimeout time.Duration
+
+	// ShutdownTimeout bounds how long stopping waits for a poll cycle in
+	// progress before cancelling it (0 = cancel immediately)
+	ShutdownTimeout time.Duration
+
+	// MaxBackoff caps the growing interval used while every city fails
+	// (0 or at most Interval = no backoff)
+	MaxBackoff time.Duration
+
+	// RestartGap skips the first poll of lots read less than this long
+	// before startup (0 = poll every lot immediately)
+	RestartGap time.Duration
+
+	// RetryJitter randomizes retry and backoff delays
+	RetryJitter backoff.Jitter
+
+	// TimestampSource selects the time readings are stored with
+	TimestampSource ingestor.TimestampSource
+	// FirstPoll selects when the first poll cycle runs
+	FirstPoll ingestor.FirstPoll
+	// ActiveHours, if set, limits polling to a daily window in the
+	// server's local time
+	ActiveHours *ingestor.ActiveHours
+	// StaleAfter is the age of a city's last_updated beyond which its
+	// readings are flagged stale (0 = never)
+	StaleAfter time.Duration
+
+	// KeepLast, if positive, prunes all but the latest KeepLast readings
+	// of each lot while ingesting
+	KeepLast int
+
+	// LatestReadings maintains the latest_readings table alongside the
+	// reading history
+	LatestReadings bool
+	// SilentLotAfter, if positive, reports lots without a reading with data
+	// for this long while their city still updates; requires
+	// LatestReadings. SilentLotLog, if positive, also logs them this often.
+	SilentLotAfter time.Duration
+	SilentLotLog   time.Duration
+	// InsertBatchRows caps the readings per INSERT statement (0 = as many
+	// as SQLite's variable limit allows)
+	InsertBatchRows int
+
+	// SanityMinLots, SanityMaxZeroTotal and SanityMaxMissing configure the
+	// structural check of every fetched city; see ingestor.SanityCheck
+	SanityMinLots      int
+	SanityMaxZeroTotal float64
+	SanityMaxMissing   float64
+
+	// MigrateOnly applies pending schema migrations and exits
+	MigrateOnly bool
+
+	// AlertsConfig is the path to a JSON file with webhook alert rules
+	AlertsConfig string
+
+	// RawDir stores raw API responses for debugging and replay when set
+	RawDir string
+
+	// ReplayDir ingests captures from a previous RawDir instead of the live API
+	ReplayDir string
+
+	// LotTypes, if non-empty, restricts storage to lots of these types
+	LotTypes []string
+	// ExcludeLotTypes skips lots of these types
+	ExcludeLotTypes []string
+
+	// Lots, if non-empty, restricts storage to lots with these IDs;
+	// ExcludeLots skips lots with these IDs
+	Lots        []string
+	ExcludeLots []string
 
+	// BoundingBox restricts storage to lots within
+	// [minLat, minLng, maxLat, maxLng]; nil disables the filter
+	BoundingBox []float64
+	// IncludeUnlocated keeps lots without coordinates when BoundingBox is set
+	IncludeUnlocated bool
+	// Regions, if non-empty, restricts storage to lots in these regions
+	Regions []string
+
+	// CompactOnExit runs VACUUM before the database is closed on shutdown;
+	// CompactGzip additionally writes a gzipped copy of the database file
+	CompactOnExit bool
+	CompactGzip   bool
+
+	// APIAddr serves the read API, including POST /refresh, from the
+	// ingest process when set. RefreshPerMinute limits refresh requests.
+	APIAddr          string
+	RefreshPerMinute float64
+
+	// APIKey (bearer token) and APIUser/APIPass (basic auth) protect the
+	// read API when set. MetricsAuth applies them to /metrics and /healthz.
+	APIKey      string
+	APIUser     string
+	APIPass     string
+	MetricsAuth bool
+
+	// MetricsAddr serves /metrics and /healthz on this address when set
+	MetricsAddr string
+
+	// TLSCert and TLSKey serve the read API over HTTPS when set.
+	// MetricsTLS serves /metrics and /healthz over HTTPS too.
+	TLSCert    string
+	TLSKey     string
+	MetricsTLS bool
+
+	// EmitJSON writes every stored reading to stdout as a JSON line
+	EmitJSON bool
+
+	// SummaryJSON writes the shutdown summary to stdout as JSON, in
+	// addition to logging it
+	SummaryJSON bool
+
+	// LogFile receives log output instead of stderr when set. It is
+	// rotated once it reaches LogMaxSizeMB; LogMaxBackups rotated files
+	// are kept for up to LogMaxAgeDays (0 = no limit).
+	LogFile       string
+	LogMaxSizeMB  int
+	LogMaxBackups int
+	LogMaxAgeDays int
+}
+
+// Default returns the configuration used when no flags are given
+func Default() *Config {
 	return &Config{
-		DBPath:   *dbPath,
-		Interval: *interval,
-		Cities:   parseCities(*cities),
+		DBPath:     "parking.db",
+		InstanceID: hostname(),
+		Interval:   5 * time.Minute,
+		BaseURL:    api.BaseURL,
+
+		ShutdownTimeout: 30 * time.Second,
+
+		MaxBackoff:  time.Hour,
+		RetryJitter: backoff.JitterNone,
+
+		TimestampSource: ingestor.TimestampFetch,
+		FirstPoll:       ingestor.FirstPollImmediate,
+		StaleAfter:      time.Hour,
+
+		RateLimit: 5,
+		RateBurst: 5,
+
+		FetchConcurrency: 4,
+
+		Breaker: api.BreakerOptions{Failures: 10, Window: time.Minute, Cooldown: 30 * time.Second},
+		Retry:   api.RetryOptions{MaxRetries: 2, BaseDelay: time.Second, MaxDelay: 10 * time.Second, MaxElapsed: 30 * time.Second},
+
+		SanityMinLots:      3,
+		SanityMaxZeroTotal: 0.5,
+		SanityMaxMissing:   0.1,
+
+		RefreshPerMinute: 6,
+
+		LogMaxSizeMB:  100,
+		LogMaxBackups: 5,
+		LogMaxAgeDays: 28,
+	}
+}
+
+// Error marks an invalid configuration, e.g. an unknown flag, a malformed
+// value or an unreadable cities file
+type Error struct {
+	Err error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// hostname returns the host name, or empty when it is unknown
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// ParseFlags parses the ingest command-line flags and returns the
+// configuration. Every error it returns is an *Error.
+func ParseFlags(args []string) (*Config, error) {
+	cfg, err := parseFlags(args)
+	if err != nil {
+		return nil, &Error{Err: err}
 	}
+	return cfg, nil
+}
+
+func parseFlags(args []string) (*Config, error) {
+	cfg := Default()
+	var cities, citiesFile, lotTypes, excludeLotTypes, lots, excludeLots, bbox, regions, extraDBs, pragmas, jitter, timestampSource, firstPoll, activeHours, cityNames, proxy string
+
+	fs := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	fs.StringVar(&cfg.DBPath, "db", cfg.DBPath, "Path to SQLite database file")
+	fs.StringVar(&pragmas, "pragmas", "", "Comma-separated SQLite pragmas, e.g. cache_size=-64000,mmap_size=268435456")
+	fs.StringVar(&cityNames, "city-names", "", "Comma-separated display names for cities, e.g. Dresden=Dresden (DD)")
+	fs.StringVar(&extraDBs, "extra-db", "", "Comma-separated SQLite databases that also receive every write")
+	fs.DurationVar(&cfg.Interval, "interval", cfg.Interval, "Polling interval")
+	fs.StringVar(&cfg.BaseURL, "api-url", cfg.BaseURL, "Base URL of the ParkenDD API")
+	fs.BoolVar(&cfg.SkipUnchanged, "skip-unchanged", false, "Skip downloading and storing cities that have not changed since the last poll")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "Maximum API requests per second (0 = unlimited)")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", cfg.RateBurst, "API requests allowed back to back before -rate-limit applies")
+	fs.StringVar(&cfg.APIToken, "api-token", "", "Token sent as Authorization header to an API that requires one (empty = none)")
+	fs.StringVar(&proxy, "proxy", "", "HTTP, HTTPS or SOCKS5 proxy for API requests, e.g. http://proxy:3128 (empty = from environment)")
+	fs.IntVar(&cfg.FetchConcurrency, "fetch-concurrency", cfg.FetchConcurrency, "Maximum simultaneous API requests (0 = unlimited)")
+	fs.IntVar(&cfg.Breaker.Failures, "breaker-failures", cfg.Breaker.Failures, "API failures within -breaker-window that stop requests for -breaker-cooldown (0 = no circuit breaker)")
+	fs.DurationVar(&cfg.Breaker.Window, "breaker-window", cfg.Breaker.Window, "Window in which -breaker-failures are counted")
+	fs.DurationVar(&cfg.Breaker.Cooldown, "breaker-cooldown", cfg.Breaker.Cooldown, "How long the circuit breaker rejects API requests before a trial request")
+	fs.IntVar(&cfg.Retry.MaxRetries, "api-retries", cfg.Retry.MaxRetries, "Retries of an API request failing with a network error or 5xx response (0 = none)")
+	fs.DurationVar(&cfg.Retry.BaseDelay, "api-retry-delay", cfg.Retry.BaseDelay, "Wait before the first API retry, doubling after each retry")
+	fs.DurationVar(&cfg.Retry.MaxDelay, "api-retry-max-delay", cfg.Retry.MaxDelay, "Longest wait between API retries (0 = no cap)")
+	fs.DurationVar(&cfg.Retry.MaxElapsed, "api-retry-max-elapsed", cfg.Retry.MaxElapsed, "Give up retrying an API request this long after its first attempt (0 = no cap)")
+	fs.DurationVar(&cfg.CycleTimeout, "cycle-timeout", 0, "Deadline for a whole poll cycle; unpolled cities are skipped (0 = none)")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "How long stopping waits for a poll cycle in progress before cancelling it (0 = cancel immediately)")
+	fs.DurationVar(&cfg.MaxBackoff, "max-backoff", cfg.MaxBackoff, "Longest polling interval while every city fails (0 = no backoff)")
+	fs.StringVar(&firstPoll, "first-poll", string(cfg.FirstPoll), "When the first poll runs: immediate, delayed (after one interval) or aligned (at the next multiple of the interval)")
+	fs.StringVar(&activeHours, "active-hours", "", "Only poll within this daily window in local time, e.g. 06:00-22:00 (empty = always)")
+	fs.DurationVar(&cfg.StaleAfter, "stale-after", cfg.StaleAfter, "Flag readings stale when the city's last_updated is older than this at fetch time (0 = never)")
+	fs.DurationVar(&cfg.RestartGap, "restart-gap", 0, "Skip the first poll of lots read less than this long before startup (0 = poll all)")
+	fs.StringVar(&jitter, "retry-jitter", string(cfg.RetryJitter), "Jitter for retry and backoff delays: none, full or equal")
+	fs.StringVar(&timestampSource, "timestamp-source", string(cfg.TimestampSource), "Time readings are stored with: fetch (when polled) or upstream (the API's last_updated)")
+	fs.StringVar(&cities, "cities", "", "Comma-separated list of cities to monitor (empty = all cities)")
+	fs.StringVar(&cfg.InstanceID, "instance-id", cfg.InstanceID, "Stored with every reading to identify this ingestor (default: the host name)")
+	fs.IntVar(&cfg.MaxCities, "max-cities", 0, "Monitor at most this many cities when discovering all of them (0 = no limit)")
+	fs.DurationVar(&cfg.AutoRefreshCities, "auto-refresh-cities", 0, "Re-fetch the city list this often when monitoring all cities, e.g. 24h (0 = only at startup)")
+	fs.StringVar(&citiesFile, "cities-file", "", "File with one city per line, merged with -cities")
+	fs.BoolVar(&cfg.MigrateOnly, "migrate-only", false, "Apply database migrations and exit")
+	fs.StringVar(&cfg.RawDir, "raw-dir", "", "Directory to store raw API responses in (empty = disabled)")
+	fs.StringVar(&cfg.ReplayDir, "replay-dir", "", "Ingest captured responses from this directory and exit")
+	fs.StringVar(&cfg.AlertsConfig, "alerts-config", "", "Path to a JSON file with occupancy alert rules (empty = no alerts)")
+	fs.StringVar(&lotTypes, "lot-types", "", "Comma-separated lot types to store (empty = all types)")
+	fs.StringVar(&excludeLotTypes, "exclude-lot-types", "", "Comma-separated lot types to skip")
+	fs.StringVar(&lots, "lots", "", "Comma-separated lot IDs to store (empty = all lots)")
+	fs.StringVar(&excludeLots, "exclude-lots", "", "Comma-separated lot IDs to skip")
+	fs.StringVar(&bbox, "bbox", "", "Only store lots within minLat,minLng,maxLat,maxLng")
+	fs.BoolVar(&cfg.IncludeUnlocated, "bbox-include-unlocated", false, "Keep lots without coordinates when -bbox is set")
+	fs.StringVar(&regions, "regions", "", "Comma-separated regions to store (empty = all regions)")
+	fs.IntVar(&cfg.SanityMinLots, "sanity-min-lots", cfg.SanityMinLots, "Lots a city needs before its responses are checked for schema changes")
+	fs.Float64Var(&cfg.SanityMaxZeroTotal, "sanity-max-zero-total", cfg.SanityMaxZeroTotal, "Share of a city's lots that may report a total of 0 before warning (1 = never warn)")
+	fs.Float64Var(&cfg.SanityMaxMissing, "sanity-max-missing", cfg.SanityMaxMissing, "Share of a city's lots that may lack an ID, name or state before warning (1 = never warn)")
+	fs.IntVar(&cfg.KeepLast, "keep-last", 0, "Keep only this many latest readings per lot, pruned hourly (0 = keep all)")
+	fs.BoolVar(&cfg.LatestReadings, "latest-readings", false, "Keep the latest_readings table with each lot's newest reading up to date")
+	fs.DurationVar(&cfg.SilentLotAfter, "silent-lot-after", 0, "Report lots without data for this long while their city updates, requires -latest-readings (0 = off)")
+	fs.DurationVar(&cfg.SilentLotLog, "silent-lot-log", 0, "With -silent-lot-after, log the silent lots this often (0 = metrics only)")
+	fs.IntVar(&cfg.InsertBatchRows, "insert-batch-rows", 0, "Advanced: most readings per INSERT statement (0 = as many as SQLite's variable limit allows)")
+	fs.BoolVar(&cfg.CompactOnExit, "compact-on-exit", false, "VACUUM the database when the ingestor stops")
+	fs.BoolVar(&cfg.CompactGzip, "compact-gzip", false, "With -compact-on-exit, also write a gzipped copy of the database")
+	fs.StringVar(&cfg.LogFile, "log-file", "", "Write logs to this file instead of stderr, with rotation")
+	fs.IntVar(&cfg.LogMaxSizeMB, "log-max-size", cfg.LogMaxSizeMB, "Rotate -log-file after this many megabytes")
+	fs.IntVar(&cfg.LogMaxBackups, "log-max-backups", cfg.LogMaxBackups, "Rotated log files to keep (0 = all)")
+	fs.IntVar(&cfg.LogMaxAgeDays, "log-max-age", cfg.LogMaxAgeDays, "Days to keep rotated log files (0 = forever)")
+	fs.StringVar(&cfg.APIAddr, "api-addr", "", "Address to serve the read API and POST /refresh on (empty = disabled)")
+	fs.Float64Var(&cfg.RefreshPerMinute, "refresh-rate", cfg.RefreshPerMinute, "Maximum POST /refresh requests per minute")
+	fs.StringVar(&cfg.APIKey, "api-key", "", "Bearer token required by the read API (empty = none)")
+	fs.StringVar(&cfg.APIUser, "api-user", "", "Basic auth user required by the read API (empty = none)")
+	fs.StringVar(&cfg.APIPass, "api-pass", "", "Basic auth password for -api-user")
+	fs.BoolVar(&cfg.MetricsAuth, "metrics-auth", false, "Require the read API credentials for /metrics and /healthz too")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", "", "Address to serve /metrics and /healthz on (empty = disabled)")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", "", "Certificate file to serve the read API over HTTPS (requires -tls-key)")
+	fs.StringVar(&cfg.TLSKey, "tls-key", "", "Private key file for -tls-cert")
+	fs.BoolVar(&cfg.MetricsTLS, "metrics-tls", false, "Serve /metrics and /healthz over HTTPS with -tls-cert too")
+	fs.BoolVar(&cfg.EmitJSON, "emit-json", false, "Write every stored reading to stdout as a JSON line")
+	fs.BoolVar(&cfg.SummaryJSON, "summary-json", false, "Also write the summary logged on shutdown to stdout as JSON")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg.Cities = parseCities(cities)
+	if citiesFile != "" {
+		fileCities, err := readCitiesFile(citiesFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Cities = normalizeList(append(cfg.Cities, fileCities...))
+	}
+
+	cfg.LotTypes = parseList(lotTypes)
+	cfg.ExcludeLotTypes = parseList(excludeLotTypes)
+	cfg.Lots = parseList(lots)
+	cfg.ExcludeLots = parseList(excludeLots)
+	cfg.Regions = parseList(regions)
+	cfg.ExtraDBPaths = parseList(extraDBs)
+
+	var err error
+	if cfg.Pragmas, err = database.ParsePragmas(pragmas); err != nil {
+		return nil, fmt.Errorf("invalid -pragmas: %w", err)
+	}
+	if cfg.CityNames, err = database.ParseCityNames(cityNames); err != nil {
+		return nil, fmt.Errorf("invalid -city-names: %w", err)
+	}
+	if cfg.RetryJitter, err = backoff.ParseJitter(jitter); err != nil {
+		return nil, fmt.Errorf("invalid -retry-jitter: %w", err)
+	}
+	if cfg.TimestampSource, err = ingestor.ParseTimestampSource(timestampSource); err != nil {
+		return nil, fmt.Errorf("invalid -timestamp-source: %w", err)
+	}
+	if cfg.FirstPoll, err = ingestor.ParseFirstPoll(firstPoll); err != nil {
+		return nil, fmt.Errorf("invalid -first-poll: %w", err)
+	}
+	if cfg.ActiveHours, err = ingestor.ParseActiveHours(activeHours); err != nil {
+		return nil, fmt.Errorf("invalid -active-hours: %w", err)
+	}
+	if proxy != "" {
+		if cfg.Proxy, err = api.ParseProxy(proxy); err != nil {
+			return nil, fmt.Errorf("invalid -proxy: %w", err)
+		}
+	}
+
+	if (cfg.APIUser == "") != (cfg.APIPass == "") {
+		return nil, fmt.Errorf("-api-user and -api-pass must be set together")
+	}
+	if cfg.MetricsAuth && cfg.APIKey == "" && cfg.APIUser == "" {
+		return nil, fmt.Errorf("-metrics-auth requires -api-key or -api-user")
+	}
+	if cfg.MaxCities < 0 {
+		return nil, fmt.Errorf("invalid -max-cities %d: must not be negative", cfg.MaxCities)
+	}
+	if cfg.Retry.MaxRetries < 0 {
+		return nil, fmt.Errorf("invalid -api-retries %d: must not be negative", cfg.Retry.MaxRetries)
+	}
+	if cfg.AutoRefreshCities < 0 {
+		return nil, fmt.Errorf("invalid -auto-refresh-cities %v: must not be negative", cfg.AutoRefreshCities)
+	}
+	if cfg.KeepLast < 0 {
+		return nil, fmt.Errorf("invalid -keep-last %d: must not be negative", cfg.KeepLast)
+	}
+	if cfg.InsertBatchRows < 0 {
+		return nil, fmt.Errorf("invalid -insert-batch-rows %d: must not be negative", cfg.InsertBatchRows)
+	}
+	if cfg.SilentLotAfter < 0 {
+		return nil, fmt.Errorf("invalid -silent-lot-after %v: must not be negative", cfg.SilentLotAfter)
+	}
+	if cfg.SilentLotAfter > 0 && !cfg.LatestReadings {
+		return nil, fmt.Errorf("-silent-lot-after requires -latest-readings")
+	}
+	if cfg.SilentLotLog < 0 {
+		return nil, fmt.Errorf("invalid -silent-lot-log %v: must not be negative", cfg.SilentLotLog)
+	}
+	if cfg.SilentLotLog > 0 && cfg.SilentLotAfter == 0 {
+		return nil, fmt.Errorf("-silent-lot-log requires -silent-lot-after")
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return nil, fmt.Errorf("-tls-cert and -tls-key must be set together")
+	}
+	if cfg.MetricsTLS && cfg.TLSCert == "" {
+		return nil, fmt.Errorf("-metrics-tls requires -tls-cert and -tls-key")
+	}
+
+	if bbox != "" {
+		box, err := parseBoundingBox(bbox)
+		if err != nil {
+			return nil, err
+		}
+		cfg.BoundingBox = box
+	}
+
+	return cfg, nil
+}
+
+// parseBoundingBox parses "minLat,minLng,maxLat,maxLng"
+func parseBoundingBox(value string) ([]float64, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid -bbox %q: expected minLat,minLng,maxLat,maxLng", value)
+	}
+
+	box := make([]float64, 4)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -bbox %q: %w", value, err)
+		}
+		box[i] = v
+	}
+
+	if box[0] > box[2] || box[1] > box[3] {
+		return nil, fmt.Errorf("invalid -bbox %q: minimum exceeds maximum", value)
+	}
+	return box, nil
+}
+
+// parseList splits a comma-separated string into trimmed, unique entries
+func parseList(list string) []string {
+	return normalizeList(strings.Split(list, ","))
 }
 
 // parseCities splits a comma-separated string into a slice of city names
 func parseCities(cities string) []string {
-	result := []string{}
-	current := ""
-	for _, c := range cities {
-		if c == ',' {
-			if current != "" {
-				result = append(result, current)
-				current = ""
-			}
-		} else {
-			current += string(c)
+	return parseList(cities)
+}
+
+// readCitiesFile reads city names from a file with one city per line.
+// Blank lines and lines starting with # are ignored.
+func readCitiesFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cities file: %w", err)
+	}
+	defer f.Close()
+
+	var cities []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
+		cities = append(cities, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cities file: %w", err)
 	}
-	if current != "" {
-		result = append(result, current)
+
+	return normalizeList(cities), nil
+}
+
+// normalizeList trims whitespace, drops empty entries and removes
+// duplicates while keeping the first occurrence's position
+func normalizeList(items []string) []string {
+	result := []string{}
+	seen := make(map[string]bool)
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" || seen[item] {
+			continue
+		}
+		seen[item] = true
+		result = append(result, item)
 	}
 	return result
 }
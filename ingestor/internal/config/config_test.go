@@ -1,6 +1,10 @@
 package config
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -26,6 +30,16 @@ func TestParseCities(t *testing.T) {
 			input:    "",
 			expected: []string{},
 		},
+		{
+			name:     "Whitespace and empty entries",
+			input:    " Dresden , ,Basel,",
+			expected: []string{"Dresden", "Basel"},
+		},
+		{
+			name:     "Duplicates",
+			input:    "Dresden,Basel,Dresden",
+			expected: []string{"Dresden", "Basel"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -67,3 +81,123 @@ func TestConfig(t *testing.T) {
 		t.Errorf("Expected 2 cities, got %d", len(config.Cities))
 	}
 }
+
+func TestParseFlags(t *testing.T) {
+	cfg, err := ParseFlags([]string{"-db", "test.db", "-interval", "1m", "-cities", "Dresden,Basel"})
+	if err != nil {
+		t.Fatalf("ParseFlags() error: %v", err)
+	}
+
+	if cfg.DBPath != "test.db" {
+		t.Errorf("Expected DBPath to be 'test.db', got '%s'", cfg.DBPath)
+	}
+
+	if cfg.Interval != time.Minute {
+		t.Errorf("Expected Interval to be 1m, got %v", cfg.Interval)
+	}
+
+	if len(cfg.Cities) != 2 {
+		t.Errorf("Expected 2 cities, got %d", len(cfg.Cities))
+	}
+
+	var cfgErr *Error
+	if _, err := ParseFlags([]string{"-unknown"}); !errors.As(err, &cfgErr) {
+		t.Errorf("Expected a config error for an unknown flag, got %v", err)
+	}
+}
+
+func TestParseFlagsDefaults(t *testing.T) {
+	cfg, err := ParseFlags(nil)
+	if err != nil {
+		t.Fatalf("ParseFlags() error: %v", err)
+	}
+
+	defaults := Default()
+	if cfg.DBPath != defaults.DBPath || cfg.Interval != defaults.Interval || cfg.BaseURL != defaults.BaseURL {
+		t.Errorf("Expected defaults %+v, got %+v", defaults, cfg)
+	}
+	if cfg.LogFile != "" || cfg.LogMaxSizeMB != 100 || cfg.LogMaxBackups != 5 || cfg.LogMaxAgeDays != 28 {
+		t.Errorf("Unexpected log defaults: file %q, size %d, backups %d, age %d",
+			cfg.LogFile, cfg.LogMaxSizeMB, cfg.LogMaxBackups, cfg.LogMaxAgeDays)
+	}
+}
+
+func TestParseFlagsMetricsAuth(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		valid bool
+	}{
+		{"bearer token", []string{"-api-key", "secret", "-metrics-auth"}, true},
+		{"basic auth", []string{"-api-user", "ops", "-api-pass", "secret", "-metrics-auth"}, true},
+		{"without credentials", []string{"-metrics-auth"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseFlags(tt.args); (err == nil) != tt.valid {
+				t.Errorf("got error %v, expected valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestParseFlagsSilentLots(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		valid bool
+	}{
+		{"metrics only", []string{"-latest-readings", "-silent-lot-after", "1h"}, true},
+		{"with log", []string{"-latest-readings", "-silent-lot-after", "1h", "-silent-lot-log", "10m"}, true},
+		{"without latest readings", []string{"-silent-lot-after", "1h"}, false},
+		{"negative log interval", []string{"-latest-readings", "-silent-lot-after", "1h", "-silent-lot-log", "-1m"}, false},
+		{"log without threshold", []string{"-silent-lot-log", "10m"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseFlags(tt.args); (err == nil) != tt.valid {
+				t.Errorf("got error %v, expected valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestCitiesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cities.txt")
+	content := "# monitored cities\nDresden\n\n  Hamburg  \nBasel\n# Freiburg\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ParseFlags([]string{"-cities", "Basel,Karlsruhe", "-cities-file", path})
+	if err != nil {
+		t.Fatalf("ParseFlags() error: %v", err)
+	}
+
+	expected := []string{"Basel", "Karlsruhe", "Dresden", "Hamburg"}
+	if !reflect.DeepEqual(cfg.Cities, expected) {
+		t.Errorf("Expected cities %v, got %v", expected, cfg.Cities)
+	}
+
+	if _, err := ParseFlags([]string{"-cities-file", filepath.Join(t.TempDir(), "missing.txt")}); err == nil {
+		t.Error("Expected error for missing cities file")
+	}
+}
+
+func TestParseBoundingBox(t *testing.T) {
+	box, err := parseBoundingBox("49.0, 8.3,49.1,8.5")
+	if err != nil {
+		t.Fatalf("parseBoundingBox() error: %v", err)
+	}
+	if !reflect.DeepEqual(box, []float64{49.0, 8.3, 49.1, 8.5}) {
+		t.Errorf("Unexpected box %v", box)
+	}
+
+	for _, invalid := range []string{"49,8,50", "a,b,c,d", "50,8,49,9"} {
+		if _, err := parseBoundingBox(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
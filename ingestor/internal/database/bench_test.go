@@ -0,0 +1,48 @@
+package database_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+// BenchmarkInsertReadings measures storing one poll of a city: a reading
+// per lot in a single transaction, as the ingestor does
+func BenchmarkInsertReadings(b *testing.B) {
+	for _, lots := range []int{10, 100, 500} {
+		b.Run(fmt.Sprintf("lots=%d", lots), func(b *testing.B) {
+			db := testutil.NewDB(b)
+			ids := make([]string, lots)
+			for idx := range ids {
+				ids[idx] = fmt.Sprintf("lot-%03d", idx)
+				testutil.InsertLots(b, db, testutil.NewLot(ids[idx], "Dresden"))
+			}
+			store := database.NewSQLiteStore(db)
+			ctx := context.Background()
+			start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := range b.N {
+				tx, err := store.Begin(ctx)
+				if err != nil {
+					b.Fatalf("Begin() error: %v", err)
+				}
+				timestamp := start.Add(time.Duration(n) * time.Minute)
+				for _, id := range ids {
+					if err := tx.InsertReading(ctx, testutil.NewReading(id, "Dresden", timestamp, 50)); err != nil {
+						b.Fatalf("InsertReading() error: %v", err)
+					}
+				}
+				if err := tx.Commit(); err != nil {
+					b.Fatalf("Commit() error: %v", err)
+				}
+			}
+			b.ReportMetric(float64(b.N*lots)/b.Elapsed().Seconds(), "readings/s")
+		})
+	}
+}
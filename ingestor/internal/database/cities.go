@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// City holds a city's metadata as listed by the API
+type City struct {
+	ID            string
+	Name          string
+	Source        sql.NullString
+	URL           sql.NullString
+	ActiveSupport bool
+	Latitude      sql.NullFloat64
+	Longitude     sql.NullFloat64
+}
+
+// UpsertCity inserts or updates a city's metadata
+func UpsertCity(db *sql.DB, city *City) error {
+	return upsertCity(context.Background(), db, city)
+}
+
+func upsertCity(ctx context.Context, e execer, city *City) error {
+	_, err := e.ExecContext(ctx, `
+		INSERT INTO cities (id, name, source, url, active_support, latitude, longitude, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(id) DO UPDATE SET
+			name = excluded.name,
+			source = excluded.source,
+			url = excluded.url,
+			active_support = excluded.active_support,
+			latitude = excluded.latitude,
+			longitude = excluded.longitude,
+			updated_at = CURRENT_TIMESTAMP
+	`, city.ID, city.Name, city.Source, city.URL, city.ActiveSupport, city.Latitude, city.Longitude)
+	if err != nil {
+		return fmt.Errorf("failed to upsert city %s: %w", city.ID, err)
+	}
+	return nil
+}
+
+// GetCities returns the metadata of every known city, ordered by ID
+func GetCities(db *sql.DB) ([]City, error) {
+	rows, err := db.Query(`
+		SELECT id, name, source, url, active_support, latitude, longitude
+		FROM cities
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cities []City
+	for rows.Next() {
+		var c City
+		if err := rows.Scan(&c.ID, &c.Name, &c.Source, &c.URL, &c.ActiveSupport, &c.Latitude, &c.Longitude); err != nil {
+			return nil, err
+		}
+		cities = append(cities, c)
+	}
+	return cities, rows.Err()
+}
+
+// CityNames maps city IDs to the names shown in API responses and exports
+// instead of the stored name. It only affects presentation: readings and
+// metadata stay keyed by the ID.
+type CityNames map[string]string
+
+// ParseCityNames parses "id=name,id=name"
+func ParseCityNames(value string) (CityNames, error) {
+	names := CityNames{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, name, ok := strings.Cut(part, "=")
+		id, name = strings.TrimSpace(id), strings.TrimSpace(name)
+		if !ok || id == "" || name == "" {
+			return nil, fmt.Errorf("invalid city name %q: expected id=name", part)
+		}
+		names[id] = name
+	}
+	return names, nil
+}
+
+// Name returns the display name for the city id, or name if there is no
+// override
+func (n CityNames) Name(id, name string) string {
+	if override, ok := n[id]; ok {
+		return override
+	}
+	return name
+}
+
+// Validate reports overrides for cities other than ids, e.g. typos
+func (n CityNames) Validate(ids []string) error {
+	known := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		known[id] = true
+	}
+
+	var unknown []string
+	for id := range n {
+		if !known[id] {
+			unknown = append(unknown, id)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("city names given for cities that are not monitored: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
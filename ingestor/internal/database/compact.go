@@ -0,0 +1,48 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Compact rebuilds the database with VACUUM to release free pages, e.g.
+// after pruning. It returns the database size in bytes before and after.
+// In-memory databases are left untouched.
+func Compact(db *sql.DB) (before, after int64, err error) {
+	file, err := databaseFile(db)
+	if err != nil {
+		return 0, 0, err
+	}
+	if file == "" {
+		return 0, 0, nil
+	}
+
+	if before, err = Size(db); err != nil {
+		return 0, 0, err
+	}
+	if _, err := db.Exec(`VACUUM`); err != nil {
+		return 0, 0, fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	if after, err = Size(db); err != nil {
+		return 0, 0, err
+	}
+	return before, after, nil
+}
+
+// Size returns the size of the main database in bytes
+func Size(db *sql.DB) (int64, error) {
+	var pageCount, pageSize int64
+	err := db.QueryRow(`SELECT page_count, page_size FROM pragma_page_count, pragma_page_size`).Scan(&pageCount, &pageSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read database size: %w", err)
+	}
+	return pageCount * pageSize, nil
+}
+
+// databaseFile returns the file backing the main database, or "" for an
+// in-memory database
+func databaseFile(db *sql.DB) (string, error) {
+	var file string
+	err := db.QueryRow(`SELECT file FROM pragma_database_list WHERE name = 'main'`).Scan(&file)
+	return file, err
+}
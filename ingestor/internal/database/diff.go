@@ -0,0 +1,112 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// LotDiff lists how the lots stored in two databases differ
+type LotDiff struct {
+	// OnlyA and OnlyB are the IDs of lots stored in just one database
+	OnlyA   []string    `json:"only_a"`
+	OnlyB   []string    `json:"only_b"`
+	Changed []LotChange `json:"changed"`
+}
+
+// LotChange lists the metadata fields of a lot that differ
+type LotChange struct {
+	ID     string        `json:"id"`
+	Fields []FieldChange `json:"fields"`
+}
+
+// FieldChange is a metadata field with different values; NULL is shown as
+// "NULL"
+type FieldChange struct {
+	Field string `json:"field"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+}
+
+// Empty reports whether both databases store the same lots
+func (d *LotDiff) Empty() bool {
+	return len(d.OnlyA) == 0 && len(d.OnlyB) == 0 && len(d.Changed) == 0
+}
+
+// DiffLots compares the lots of city (every lot when city is empty) in
+// databases a and b
+func DiffLots(a, b *sql.DB, city string) (*LotDiff, error) {
+	lotsA, err := ListLots(a, city)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list lots of the first database: %w", err)
+	}
+	lotsB, err := ListLots(b, city)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list lots of the second database: %w", err)
+	}
+
+	// Both lists are ordered by ID, so one merge pass finds every lot
+	diff := &LotDiff{OnlyA: []string{}, OnlyB: []string{}, Changed: []LotChange{}}
+	for len(lotsA) > 0 || len(lotsB) > 0 {
+		switch {
+		case len(lotsB) == 0 || (len(lotsA) > 0 && lotsA[0].ID < lotsB[0].ID):
+			diff.OnlyA = append(diff.OnlyA, lotsA[0].ID)
+			lotsA = lotsA[1:]
+		case len(lotsA) == 0 || lotsB[0].ID < lotsA[0].ID:
+			diff.OnlyB = append(diff.OnlyB, lotsB[0].ID)
+			lotsB = lotsB[1:]
+		default:
+			if fields := diffLot(&lotsA[0], &lotsB[0]); len(fields) > 0 {
+				diff.Changed = append(diff.Changed, LotChange{ID: lotsA[0].ID, Fields: fields})
+			}
+			lotsA, lotsB = lotsA[1:], lotsB[1:]
+		}
+	}
+	return diff, nil
+}
+
+// diffLot returns the metadata fields in which a and b differ
+func diffLot(a, b *ParkingLot) []FieldChange {
+	fieldsA, fieldsB := lotFields(a), lotFields(b)
+	var changes []FieldChange
+	for idx, field := range fieldsA {
+		if field.value != fieldsB[idx].value {
+			changes = append(changes, FieldChange{Field: field.name, A: field.value, B: fieldsB[idx].value})
+		}
+	}
+	return changes
+}
+
+type lotField struct {
+	name  string
+	value string
+}
+
+// lotFields returns the compared metadata of lot, formatted as text
+func lotFields(lot *ParkingLot) []lotField {
+	return []lotField{
+		{"city", lot.City},
+		{"name", lot.Name},
+		{"address", nullText(lot.Address)},
+		{"lot_type", nullText(lot.LotType)},
+		{"total", strconv.Itoa(lot.Total)},
+		{"latitude", nullFloatText(lot.Latitude)},
+		{"longitude", nullFloatText(lot.Longitude)},
+		{"region", nullText(lot.Region)},
+		{"extras", nullText(lot.Extras)},
+	}
+}
+
+func nullText(s sql.NullString) string {
+	if !s.Valid {
+		return "NULL"
+	}
+	return s.String
+}
+
+func nullFloatText(f sql.NullFloat64) string {
+	if !f.Valid {
+		return "NULL"
+	}
+	return strconv.FormatFloat(f.Float64, 'f', -1, 64)
+}
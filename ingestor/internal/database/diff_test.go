@@ -0,0 +1,46 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestDiffLots(t *testing.T) {
+	a, b := testutil.NewDB(t), testutil.NewDB(t)
+	testutil.InsertLots(t, a, testutil.NewLot("d1", "Dresden"), testutil.NewLot("d2", "Dresden"), testutil.NewLot("d3", "Dresden"))
+
+	changed := testutil.NewLot("d2", "Dresden")
+	changed.Total = 250
+	changed.Region.Valid = false
+	testutil.InsertLots(t, b, testutil.NewLot("d1", "Dresden"), changed, testutil.NewLot("d4", "Dresden"))
+
+	diff, err := database.DiffLots(a, b, "")
+	if err != nil {
+		t.Fatalf("DiffLots() error: %v", err)
+	}
+	if len(diff.OnlyA) != 1 || diff.OnlyA[0] != "d3" {
+		t.Errorf("got only in a %v, expected [d3]", diff.OnlyA)
+	}
+	if len(diff.OnlyB) != 1 || diff.OnlyB[0] != "d4" {
+		t.Errorf("got only in b %v, expected [d4]", diff.OnlyB)
+	}
+
+	expected := []database.FieldChange{
+		{Field: "total", A: "100", B: "250"},
+		{Field: "region", A: "Innere Altstadt", B: "NULL"},
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].ID != "d2" || len(diff.Changed[0].Fields) != len(expected) {
+		t.Fatalf("got changes %+v, expected d2 with %+v", diff.Changed, expected)
+	}
+	for idx, field := range diff.Changed[0].Fields {
+		if field != expected[idx] {
+			t.Errorf("got %+v, expected %+v", field, expected[idx])
+		}
+	}
+
+	if diff, err = database.DiffLots(a, a, ""); err != nil || !diff.Empty() {
+		t.Errorf("Expected no differences comparing a database with itself, got %+v (err %v)", diff, err)
+	}
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// downsampleBatchBuckets is how many buckets Downsample compacts per
+// transaction, e.g. a day of hourly buckets
+const downsampleBatchBuckets = 24
+
+// DownsampleResult reports what Downsample compacted
+type DownsampleResult struct {
+	// Readings is the number of raw readings merged and deleted
+	Readings int64
+	// Buckets is the number of aggregate rows written or extended
+	Buckets int64
+	Batches int
+}
+
+// Downsample merges the readings before cutoff into per-lot buckets of
+// the given width in reading_aggregates and deletes them. Buckets are
+// aligned to the Unix epoch and cutoff is rounded down to a bucket
+// boundary, so no bucket is split.
+//
+// Each batch of buckets is merged and deleted in its own transaction, so
+// an interrupted run keeps its completed batches and a later run resumes
+// with the remaining readings. Readings that land in an existing bucket
+// are merged into it.
+//
+// free_sum, free_min and free_max only cover valid readings: available
+// and not anomalous, as counted by valid_samples.
+func Downsample(ctx context.Context, db *sql.DB, cutoff time.Time, bucket time.Duration) (DownsampleResult, error) {
+	var result DownsampleResult
+	seconds := int64(bucket / time.Second)
+	if seconds <= 0 || bucket%time.Second != 0 {
+		return result, fmt.Errorf("invalid bucket width %v: must be a positive number of seconds", bucket)
+	}
+	end := time.Unix(cutoff.Unix()/seconds*seconds, 0).UTC()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		var oldest time.Time
+		err := db.QueryRowContext(ctx, `
+			SELECT timestamp FROM parking_readings
+			WHERE timestamp < ?
+			ORDER BY timestamp LIMIT 1
+		`, end).Scan(&oldest)
+		if errors.Is(err, sql.ErrNoRows) {
+			return result, nil
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to find readings to downsample: %w", err)
+		}
+
+		from := time.Unix(oldest.Unix()/seconds*seconds, 0).UTC()
+		to := from.Add(downsampleBatchBuckets * bucket)
+		if to.After(end) {
+			to = end
+		}
+
+		readings, buckets, err := downsampleBatch(ctx, db, from, to, seconds)
+		if err != nil {
+			return result, fmt.Errorf("failed to downsample readings from %s: %w", from.Format(time.RFC3339), err)
+		}
+		// The oldest reading lies in [from, to), so a batch that deletes
+		// nothing would repeat forever
+		if readings == 0 {
+			return result, fmt.Errorf("failed to downsample readings from %s: no readings matched", from.Format(time.RFC3339))
+		}
+		result.Readings += readings
+		result.Buckets += buckets
+		result.Batches++
+	}
+}
+
+// downsampleBatch merges and deletes the readings in [from, to) in one
+// transaction
+func downsampleBatch(ctx context.Context, db *sql.DB, from, to time.Time, seconds int64) (readings, buckets int64, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	// Bucket starts use the driver's timestamp layout, so they compare and
+	// scan like every other stored timestamp
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO reading_aggregates (
+			lot_id, city, bucket_start, bucket_seconds,
+			samples, valid_samples, free_sum, free_min, free_max
+		)
+		SELECT lot_id, city,
+			strftime('%Y-%m-%d %H:%M:%S', CAST(strftime('%s', timestamp) AS INTEGER) / ?1 * ?1, 'unixepoch') || '+00:00' AS bucket,
+			?1,
+			COUNT(*),
+			SUM(valid),
+			SUM(CASE WHEN valid THEN free ELSE 0 END),
+			MIN(CASE WHEN valid THEN free END),
+			MAX(CASE WHEN valid THEN free END)
+		FROM (
+			SELECT lot_id, city, timestamp, free,
+				state NOT IN (?4, ?5) AND NOT anomalous AS valid
+			FROM parking_readings
+			WHERE timestamp >= ?2 AND timestamp < ?3
+		)
+		WHERE true
+		GROUP BY lot_id, bucket
+		ON CONFLICT (lot_id, bucket_start, bucket_seconds) DO UPDATE SET
+			samples = samples + excluded.samples,
+			valid_samples = valid_samples + excluded.valid_samples,
+			free_sum = free_sum + excluded.free_sum,
+			free_min = min(coalesce(free_min, excluded.free_min), coalesce(excluded.free_min, free_min)),
+			free_max = max(coalesce(free_max, excluded.free_max), coalesce(excluded.free_max, free_max))
+	`, seconds, from, to, StateClosed, StateNoData)
+	if err != nil {
+		return 0, 0, err
+	}
+	if buckets, err = res.RowsAffected(); err != nil {
+		return 0, 0, err
+	}
+
+	res, err = tx.ExecContext(ctx, `
+		DELETE FROM parking_readings WHERE timestamp >= ? AND timestamp < ?
+	`, from, to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if readings, err = res.RowsAffected(); err != nil {
+		return 0, 0, err
+	}
+
+	return readings, buckets, tx.Commit()
+}
@@ -0,0 +1,83 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+// aggregate is a reading_aggregates row
+type aggregate struct {
+	samples, validSamples, freeSum int
+	freeMin, freeMax               sql.NullInt64
+}
+
+func readAggregate(t *testing.T, db *sql.DB, lotID string, bucketStart time.Time) aggregate {
+	t.Helper()
+	var a aggregate
+	err := db.QueryRow(`
+		SELECT samples, valid_samples, free_sum, free_min, free_max
+		FROM reading_aggregates WHERE lot_id = ? AND bucket_start = ? AND bucket_seconds = 3600
+	`, lotID, bucketStart).Scan(&a.samples, &a.validSamples, &a.freeSum, &a.freeMin, &a.freeMax)
+	if err != nil {
+		t.Fatalf("Failed to read aggregate for %s at %v: %v", lotID, bucketStart, err)
+	}
+	return a
+}
+
+func TestDownsample(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	closed := testutil.NewReading("lot1", "Dresden", base.Add(30*time.Minute), 0)
+	closed.State = database.StateClosed
+	anomalous := testutil.NewReading("lot1", "Dresden", base.Add(70*time.Minute), 500)
+	anomalous.Anomalous = true
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("lot1", "Dresden", base, 40),
+		testutil.NewReading("lot1", "Dresden", base.Add(5*time.Minute), 30),
+		closed,
+		anomalous,
+		testutil.NewReading("lot1", "Dresden", base.Add(150*time.Minute), 20),
+	)
+
+	// 12:15 rounds down to 12:00, keeping the 12:30 reading
+	ctx := context.Background()
+	result, err := database.Downsample(ctx, db, base.Add(135*time.Minute), time.Hour)
+	if err != nil {
+		t.Fatalf("Downsample() error: %v", err)
+	}
+	if result.Readings != 4 || result.Buckets != 2 {
+		t.Errorf("got %+v, expected 4 readings in 2 buckets", result)
+	}
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 1 {
+		t.Errorf("got %d raw readings left, expected 1", count)
+	}
+
+	a := readAggregate(t, db, "lot1", base)
+	if a.samples != 3 || a.validSamples != 2 || a.freeSum != 70 || a.freeMin.Int64 != 30 || a.freeMax.Int64 != 40 {
+		t.Errorf("Unexpected 10:00 bucket: %+v", a)
+	}
+	if a := readAggregate(t, db, "lot1", base.Add(time.Hour)); a.samples != 1 || a.validSamples != 0 || a.freeMin.Valid {
+		t.Errorf("Unexpected 11:00 bucket: %+v", a)
+	}
+
+	// A later run merges readings into the existing bucket
+	testutil.InsertReadings(t, db, testutil.NewReading("lot1", "Dresden", base.Add(45*time.Minute), 10))
+	if _, err := database.Downsample(ctx, db, base.Add(135*time.Minute), time.Hour); err != nil {
+		t.Fatalf("Second Downsample() error: %v", err)
+	}
+	a = readAggregate(t, db, "lot1", base)
+	if a.samples != 4 || a.validSamples != 3 || a.freeSum != 80 || a.freeMin.Int64 != 10 || a.freeMax.Int64 != 40 {
+		t.Errorf("Unexpected merged 10:00 bucket: %+v", a)
+	}
+
+	if _, err := database.Downsample(ctx, db, base, 1500*time.Millisecond); err == nil {
+		t.Error("Expected an error for a bucket that is not a whole number of seconds")
+	}
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ExportFilter selects the rows to export. Empty fields don't filter.
+type ExportFilter struct {
+	City string
+	From time.Time
+	To   time.Time
+}
+
+// ExportedReading is a reading joined with its lot and city metadata.
+// CityName falls back to the city ID when the city has no metadata.
+type ExportedReading struct {
+	Timestamp  time.Time
+	LotID      string
+	LotName    string
+	City       string
+	CityName   string
+	CitySource sql.NullString
+	LotType    sql.NullString
+	Region     sql.NullString
+	Latitude   sql.NullFloat64
+	Longitude  sql.NullFloat64
+	Total      int
+	Free       int
+	State      string
+
+	// SourceInstance is the ingestor that wrote the reading, NULL for
+	// readings stored before it was recorded
+	SourceInstance sql.NullString
+	Quality        Quality
+}
+
+// ExportReadings calls fn for every reading matching filter, oldest first.
+// Rows are streamed so memory stays flat for large exports; an error from
+// fn stops the export and is returned.
+func ExportReadings(db *sql.DB, filter ExportFilter, fn func(*ExportedReading) error) error {
+	query := `
+		SELECT r.timestamp, r.lot_id, l.name, r.city, COALESCE(c.name, r.city), c.source, l.lot_type, l.region,
+			l.latitude, l.longitude, l.total, r.free, r.state, r.source_instance, r.quality
+		FROM parking_readings r
+		JOIN parking_lots l ON l.id = r.lot_id
+		LEFT JOIN cities c ON c.id = r.city
+		WHERE 1 = 1`
+	args, where := filter.conditions("r.city", "r.timestamp")
+	rows, err := db.Query(query+where+` ORDER BY r.timestamp, r.id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r ExportedReading
+		if err := rows.Scan(&r.Timestamp, &r.LotID, &r.LotName, &r.City, &r.CityName, &r.CitySource, &r.LotType,
+			&r.Region, &r.Latitude, &r.Longitude, &r.Total, &r.Free, &r.State, &r.SourceInstance, &r.Quality); err != nil {
+			return err
+		}
+		if err := fn(&r); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ExportedLot is a lot with its city's metadata and latest reading
+type ExportedLot struct {
+	ParkingLot
+	CityName   string
+	CitySource sql.NullString
+
+	// LastSeen, Free and State come from the latest reading and are only
+	// valid when LastSeen is
+	LastSeen sql.NullTime
+	Free     sql.NullInt64
+	State    sql.NullString
+}
+
+// ExportLots returns every lot matching filter's city with its latest
+// reading, ordered by city and ID
+func ExportLots(db *sql.DB, filter ExportFilter) ([]ExportedLot, error) {
+	query := `
+		SELECT l.id, l.city, l.name, l.address, l.lot_type, l.total, l.latitude, l.longitude,
+			l.region, COALESCE(c.name, l.city), c.source, r.timestamp, r.free, r.state
+		FROM parking_lots l
+		LEFT JOIN cities c ON c.id = l.city
+		LEFT JOIN parking_readings r ON r.id = (
+			SELECT id FROM parking_readings WHERE lot_id = l.id ORDER BY timestamp DESC LIMIT 1
+		)
+		WHERE 1 = 1`
+	args, where := ExportFilter{City: filter.City}.conditions("l.city", "")
+	rows, err := db.Query(query+where+` ORDER BY l.city, l.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lots []ExportedLot
+	for rows.Next() {
+		var l ExportedLot
+		if err := rows.Scan(&l.ID, &l.City, &l.Name, &l.Address, &l.LotType, &l.Total, &l.Latitude,
+			&l.Longitude, &l.Region, &l.CityName, &l.CitySource, &l.LastSeen, &l.Free, &l.State); err != nil {
+			return nil, err
+		}
+		lots = append(lots, l)
+	}
+	return lots, rows.Err()
+}
+
+// conditions builds the WHERE clauses for the filter's non-empty fields
+func (f ExportFilter) conditions(cityColumn, timeColumn string) ([]any, string) {
+	var args []any
+	where := ""
+	if f.City != "" {
+		where += " AND " + cityColumn + " = ?"
+		args = append(args, f.City)
+	}
+	if !f.From.IsZero() && timeColumn != "" {
+		where += " AND " + timeColumn + " >= ?"
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() && timeColumn != "" {
+		where += " AND " + timeColumn + " < ?"
+		args = append(args, f.To.UTC())
+	}
+	return args, where
+}
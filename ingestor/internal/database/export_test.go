@@ -0,0 +1,132 @@
+package database_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestUpsertCity(t *testing.T) {
+	db := testutil.NewDB(t)
+
+	city := &database.City{ID: "Dresden", Name: "Dresden", Source: sql.NullString{String: "https://example.com/v1", Valid: true}}
+	if err := database.UpsertCity(db, city); err != nil {
+		t.Fatalf("UpsertCity() error: %v", err)
+	}
+	city.Source.String = "https://example.com/v2"
+	if err := database.UpsertCity(db, city); err != nil {
+		t.Fatalf("UpsertCity() error: %v", err)
+	}
+
+	cities, err := database.GetCities(db)
+	if err != nil {
+		t.Fatalf("GetCities() error: %v", err)
+	}
+	if len(cities) != 1 || cities[0].Source.String != "https://example.com/v2" {
+		t.Errorf("Unexpected cities: %+v", cities)
+	}
+}
+
+func TestExportReadings(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("d1", "Dresden"), testutil.NewLot("b1", "Basel"))
+	if err := database.UpsertCity(db, &database.City{ID: "Dresden", Name: "Dresden (Sachsen)",
+		Source: sql.NullString{String: "https://dresden.example", Valid: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("d1", "Dresden", base.Add(5*time.Minute), 20),
+		testutil.NewReading("d1", "Dresden", base, 10),
+		testutil.NewReading("d1", "Dresden", base.Add(time.Hour), 30),
+		testutil.NewReading("b1", "Basel", base, 40),
+	)
+
+	var got []database.ExportedReading
+	filter := database.ExportFilter{City: "Dresden", From: base, To: base.Add(time.Hour)}
+	err := database.ExportReadings(db, filter, func(r *database.ExportedReading) error {
+		got = append(got, *r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExportReadings() error: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 readings, got %d", len(got))
+	}
+	if got[0].Free != 10 || got[1].Free != 20 {
+		t.Errorf("Expected readings ordered by time, got %d and %d", got[0].Free, got[1].Free)
+	}
+	if got[0].CitySource.String != "https://dresden.example" || got[0].LotName != "Lot d1" || got[0].CityName != "Dresden (Sachsen)" {
+		t.Errorf("Unexpected metadata: %+v", got[0])
+	}
+
+	// Cities without metadata export a NULL source and their ID as name
+	got = nil
+	err = database.ExportReadings(db, database.ExportFilter{City: "Basel"}, func(r *database.ExportedReading) error {
+		got = append(got, *r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExportReadings() error: %v", err)
+	}
+	if len(got) != 1 || got[0].CitySource.Valid || got[0].CityName != "Basel" {
+		t.Errorf("Expected one Basel reading without source, got %+v", got)
+	}
+}
+
+func TestCityNames(t *testing.T) {
+	names, err := database.ParseCityNames("Dresden=Dresden (DD), Ingolstadt = IN")
+	if err != nil {
+		t.Fatalf("ParseCityNames() error: %v", err)
+	}
+	if got := names.Name("Ingolstadt", "Ingolstadt"); got != "IN" {
+		t.Errorf("got %q, expected the override IN", got)
+	}
+	if got := names.Name("Basel", "Basel (CH)"); got != "Basel (CH)" {
+		t.Errorf("got %q, expected the stored name", got)
+	}
+
+	if err := names.Validate([]string{"Dresden", "Ingolstadt", "Basel"}); err != nil {
+		t.Errorf("Validate() error: %v", err)
+	}
+	if err := names.Validate([]string{"Dresden"}); err == nil {
+		t.Error("Expected an error for an override of a city that is not monitored")
+	}
+
+	for _, value := range []string{"Dresden", "=Dresden", "Dresden="} {
+		if _, err := database.ParseCityNames(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestExportLots(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("d1", "Dresden"), testutil.NewLot("d2", "Dresden"))
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("d1", "Dresden", base, 10),
+		testutil.NewReading("d1", "Dresden", base.Add(time.Hour), 30),
+	)
+
+	lots, err := database.ExportLots(db, database.ExportFilter{City: "Dresden"})
+	if err != nil {
+		t.Fatalf("ExportLots() error: %v", err)
+	}
+	if len(lots) != 2 {
+		t.Fatalf("Expected 2 lots, got %d", len(lots))
+	}
+	if !lots[0].LastSeen.Valid || lots[0].Free.Int64 != 30 {
+		t.Errorf("Expected d1's latest reading, got %+v", lots[0])
+	}
+	if lots[1].LastSeen.Valid {
+		t.Errorf("Expected d2 without readings, got %+v", lots[1])
+	}
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// IntegrityCheck looks for one kind of bad reading, e.g. left over from
+// versions before the ingestor validated its data
+type IntegrityCheck struct {
+	Name        string
+	Description string
+
+	// Fix says what a repair does to the affected readings
+	Fix string
+
+	// count returns the number of affected readings; repair fixes them
+	count  string
+	repair string
+}
+
+// capacityAt is the lot's capacity when reading r was taken
+const capacityAt = `COALESCE((
+	SELECT h.total FROM lot_capacity_history h
+	WHERE h.lot_id = r.lot_id AND h.effective_from <= r.timestamp
+	ORDER BY h.effective_from DESC
+	LIMIT 1
+), l.total)`
+
+// IntegrityChecks lists the checks run by CheckIntegrity, in the order in
+// which they are repaired
+var IntegrityChecks = []IntegrityCheck{
+	{
+		Name:        "orphaned",
+		Description: "readings of lots missing from parking_lots",
+		Fix:         "deleted",
+		count: `SELECT COUNT(*) FROM parking_readings r
+			WHERE NOT EXISTS (SELECT 1 FROM parking_lots l WHERE l.id = r.lot_id)`,
+		repair: `DELETE FROM parking_readings
+			WHERE NOT EXISTS (SELECT 1 FROM parking_lots l WHERE l.id = parking_readings.lot_id)`,
+	},
+	{
+		Name:        "over-capacity",
+		Description: "readings with more free spaces than the lot had",
+		Fix:         "flagged anomalous",
+		count: `SELECT COUNT(*) FROM parking_readings r
+			JOIN parking_lots l ON l.id = r.lot_id
+			WHERE NOT r.anomalous AND r.free > ` + capacityAt + ` AND ` + capacityAt + ` > 0`,
+		repair: `UPDATE parking_readings SET anomalous = 1, quality = quality | ` + strconv.Itoa(int(QualityAnomalous)) + ` WHERE id IN (
+			SELECT r.id FROM parking_readings r
+			JOIN parking_lots l ON l.id = r.lot_id
+			WHERE NOT r.anomalous AND r.free > ` + capacityAt + ` AND ` + capacityAt + ` > 0)`,
+	},
+	{
+		Name:        "unknown-state",
+		Description: "readings with a state other than open, closed or nodata",
+		Fix:         "set to nodata",
+		count: `SELECT COUNT(*) FROM parking_readings
+			WHERE state NOT IN ('open', '` + StateClosed + `', '` + StateNoData + `')`,
+		repair: `UPDATE parking_readings SET state = '` + StateNoData + `', quality = quality | ` + strconv.Itoa(int(QualityUnavailable)) + `
+			WHERE state NOT IN ('open', '` + StateClosed + `', '` + StateNoData + `')`,
+	},
+	{
+		Name:        "duplicate",
+		Description: "extra readings of a lot at the same timestamp",
+		Fix:         "deleted, keeping the first",
+		count: `SELECT COALESCE(SUM(n - 1), 0) FROM (
+			SELECT COUNT(*) AS n FROM parking_readings
+			GROUP BY lot_id, timestamp HAVING n > 1
+		)`,
+		repair: `DELETE FROM parking_readings WHERE id NOT IN (
+			SELECT MIN(id) FROM parking_readings GROUP BY lot_id, timestamp
+		)`,
+	},
+}
+
+// IntegrityResult reports what one check found and, with repair, fixed
+type IntegrityResult struct {
+	Check    IntegrityCheck
+	Found    int64
+	Repaired int64
+}
+
+// CheckIntegrity runs every integrity check. With repair, the problems
+// found are fixed in a single transaction, so a failed repair leaves the
+// database untouched.
+func CheckIntegrity(ctx context.Context, db *sql.DB, repair bool) ([]IntegrityResult, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]IntegrityResult, len(IntegrityChecks))
+	for idx, check := range IntegrityChecks {
+		results[idx].Check = check
+		if err := tx.QueryRowContext(ctx, check.count).Scan(&results[idx].Found); err != nil {
+			return nil, fmt.Errorf("failed to check %s readings: %w", check.Name, err)
+		}
+		if !repair || results[idx].Found == 0 {
+			continue
+		}
+
+		res, err := tx.ExecContext(ctx, check.repair)
+		if err != nil {
+			return nil, fmt.Errorf("failed to repair %s readings: %w", check.Name, err)
+		}
+		if results[idx].Repaired, err = res.RowsAffected(); err != nil {
+			return nil, err
+		}
+	}
+
+	if repair {
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit repairs: %w", err)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,76 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	unknown := testutil.NewReading("lot1", "Dresden", base.Add(10*time.Minute), 20)
+	unknown.State = "broken"
+	flagged := testutil.NewReading("lot1", "Dresden", base.Add(20*time.Minute), 500)
+	flagged.Anomalous = true
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("lot1", "Dresden", base, 40),
+		testutil.NewReading("lot1", "Dresden", base, 41),
+		testutil.NewReading("lot1", "Dresden", base, 42),
+		testutil.NewReading("lot1", "Dresden", base.Add(5*time.Minute), 150),
+		unknown,
+		flagged,
+		testutil.NewReading("gone", "Dresden", base, 10),
+	)
+
+	expected := map[string]int64{"orphaned": 1, "over-capacity": 1, "unknown-state": 1, "duplicate": 2}
+	ctx := context.Background()
+	results, err := database.CheckIntegrity(ctx, db, false)
+	if err != nil {
+		t.Fatalf("CheckIntegrity() error: %v", err)
+	}
+	for _, r := range results {
+		if r.Found != expected[r.Check.Name] || r.Repaired != 0 {
+			t.Errorf("%s: got %d found, %d repaired, expected %d found", r.Check.Name, r.Found, r.Repaired, expected[r.Check.Name])
+		}
+	}
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 7 {
+		t.Fatalf("got %d readings after a check without repair, expected 7", count)
+	}
+
+	if results, err = database.CheckIntegrity(ctx, db, true); err != nil {
+		t.Fatalf("CheckIntegrity() with repair error: %v", err)
+	}
+	for _, r := range results {
+		if r.Repaired != expected[r.Check.Name] {
+			t.Errorf("%s: got %d repaired, expected %d", r.Check.Name, r.Repaired, expected[r.Check.Name])
+		}
+	}
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 4 {
+		t.Errorf("got %d readings after repair, expected 4", count)
+	}
+
+	// The first of the duplicates is kept
+	readings, err := database.GetReadingsForLot(db, "lot1", base, base.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetReadingsForLot() error: %v", err)
+	}
+	if len(readings) != 1 || readings[0].Free != 40 {
+		t.Errorf("got %+v, expected the reading with 40 free", readings)
+	}
+
+	results, err = database.CheckIntegrity(ctx, db, false)
+	if err != nil {
+		t.Fatalf("CheckIntegrity() error: %v", err)
+	}
+	for _, r := range results {
+		if r.Found != 0 {
+			t.Errorf("%s: got %d found after repair, expected 0", r.Check.Name, r.Found)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// DefaultGapTolerance is how many expected intervals may pass between two
+// readings before FindGaps reports a gap, so jitter isn't flagged
+const DefaultGapTolerance = 1.5
+
+// Gap is a time range without readings
+type Gap struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Duration returns the length of the gap
+func (g Gap) Duration() time.Duration {
+	return g.End.Sub(g.Start)
+}
+
+// FindGaps returns the ranges within [from, to) where a lot has no readings
+// for longer than expectedInterval times DefaultGapTolerance
+func FindGaps(db *sql.DB, lotID string, expectedInterval time.Duration, from, to time.Time) ([]Gap, error) {
+	return FindGapsWithTolerance(db, lotID, expectedInterval, DefaultGapTolerance, from, to)
+}
+
+// FindGapsWithTolerance is FindGaps with a custom tolerance factor. The
+// range boundaries count as readings, so missing data at the start or end
+// of the range is reported as well.
+func FindGapsWithTolerance(db *sql.DB, lotID string, expectedInterval time.Duration, tolerance float64, from, to time.Time) ([]Gap, error) {
+	rows, err := db.Query(`
+		SELECT timestamp FROM parking_readings
+		WHERE lot_id = ? AND timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp
+	`, lotID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	maxGap := time.Duration(float64(expectedInterval) * tolerance)
+
+	var gaps []Gap
+	previous := from
+	for rows.Next() {
+		var ts time.Time
+		if err := rows.Scan(&ts); err != nil {
+			return nil, err
+		}
+		if ts.Sub(previous) > maxGap {
+			gaps = append(gaps, Gap{Start: previous, End: ts})
+		}
+		previous = ts
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if to.Sub(previous) > maxGap {
+		gaps = append(gaps, Gap{Start: previous, End: to})
+	}
+	return gaps, nil
+}
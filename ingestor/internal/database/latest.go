@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// upsertLatestReadingSQL replaces a lot's row in latest_readings unless it
+// already holds a newer reading, e.g. when older data is replayed.
+// last_reported only moves on with readings that have data.
+const upsertLatestReadingSQL = `
+	INSERT INTO latest_readings (lot_id, city, timestamp, free, state, anomalous, last_reported)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(lot_id) DO UPDATE SET
+		city = excluded.city,
+		timestamp = excluded.timestamp,
+		free = excluded.free,
+		state = excluded.state,
+		anomalous = excluded.anomalous,
+		last_reported = COALESCE(excluded.last_reported, latest_readings.last_reported)
+	WHERE excluded.timestamp >= latest_readings.timestamp
+`
+
+func upsertLatestReading(ctx context.Context, e execer, reading *ParkingReading) error {
+	var reported sql.NullTime
+	if reading.State != StateNoData {
+		reported = sql.NullTime{Time: reading.Timestamp.UTC(), Valid: true}
+	}
+	_, err := e.ExecContext(ctx, upsertLatestReadingSQL,
+		reading.LotID, reading.City, reading.Timestamp.UTC(), reading.Free, reading.State, reading.Anomalous, reported)
+	return err
+}
+
+// lastReportedSQL is a subquery for the time of the newest reading with
+// data of the lot whose ID is table.lot_id
+func lastReportedSQL(table string) string {
+	return `(SELECT MAX(r.timestamp) FROM parking_readings r
+		WHERE r.lot_id = ` + table + `.lot_id AND r.state != '` + StateNoData + `')`
+}
+
+// latestReadingsSQL selects the newest reading of every lot matching the
+// condition on parking_readings, in the columns of latest_readings
+func latestReadingsSQL(where string) string {
+	return `
+		SELECT lot_id, city, timestamp, free, state, anomalous, ` + lastReportedSQL("latest") + `
+		FROM (
+			SELECT *, ROW_NUMBER() OVER (
+				PARTITION BY lot_id ORDER BY timestamp DESC, id DESC
+			) AS position
+			FROM parking_readings
+			` + where + `
+		) AS latest WHERE position = 1
+	`
+}
+
+// RebuildLatestReadings fills latest_readings with the newest reading of
+// every lot from parking_readings, e.g. before a store starts tracking it
+// after running without
+func RebuildLatestReadings(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM latest_readings`); err != nil {
+		return fmt.Errorf("failed to clear latest readings: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO latest_readings (lot_id, city, timestamp, free, state, anomalous, last_reported)
+	`+latestReadingsSQL("")); err != nil {
+		return fmt.Errorf("failed to rebuild latest readings: %w", err)
+	}
+	return tx.Commit()
+}
+
+// GetLatestReadings returns the newest reading of every lot of a city
+// (every lot when city is empty), ordered by lot ID. It reads
+// latest_readings, which is only kept up to date by stores created with
+// TrackLatest.
+func GetLatestReadings(db *sql.DB, city string) ([]ParkingReading, error) {
+	rows, err := db.Query(`
+		SELECT lot_id, city, timestamp, free, state, anomalous
+		FROM latest_readings
+		WHERE ? = '' OR city = ?
+		ORDER BY lot_id
+	`, city, city)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var readings []ParkingReading
+	for rows.Next() {
+		var r ParkingReading
+		if err := rows.Scan(&r.LotID, &r.City, &r.Timestamp, &r.Free, &r.State, &r.Anomalous); err != nil {
+			return nil, err
+		}
+		readings = append(readings, r)
+	}
+	return readings, rows.Err()
+}
+
+// SilentLot is a lot that stopped reporting data while its city still
+// updates
+type SilentLot struct {
+	LotID string
+	City  string
+	// LastReported is the time of the lot's newest reading with data;
+	// invalid if it never had one
+	LastReported sql.NullTime
+}
+
+// GetSilentLots returns the lots without a reading with data since cutoff,
+// either because they only report nodata or because they vanished from
+// the city's lot list, ordered by city and lot ID. Lots of cities without
+// any reading since cutoff are left out: the whole city is stale then.
+// Lots without any reading since removed are left out as well, as they
+// were dropped by the API or by a lot filter. It reads latest_readings,
+// like GetLatestReadings.
+func GetSilentLots(ctx context.Context, db *sql.DB, cutoff, removed time.Time) ([]SilentLot, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT l.lot_id, l.city, l.last_reported
+		FROM latest_readings l
+		JOIN (
+			SELECT city, MAX(timestamp) AS updated FROM latest_readings GROUP BY city
+		) c ON c.city = l.city
+		WHERE c.updated >= ? AND l.timestamp >= ?
+			AND (l.last_reported IS NULL OR l.last_reported < ?)
+		ORDER BY l.city, l.lot_id
+	`, cutoff.UTC(), removed.UTC(), cutoff.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query silent lots: %w", err)
+	}
+	defer rows.Close()
+
+	var lots []SilentLot
+	for rows.Next() {
+		var lot SilentLot
+		if err := rows.Scan(&lot.LotID, &lot.City, &lot.LastReported); err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+	return lots, rows.Err()
+}
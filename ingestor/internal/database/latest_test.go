@@ -0,0 +1,170 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestLatestReadings(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db,
+		testutil.NewLot("d1", "Dresden"), testutil.NewLot("d2", "Dresden"), testutil.NewLot("l1", "Leipzig"))
+
+	store := database.NewSQLiteStore(db, database.TrackLatest())
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	insert := func(commit bool, readings ...*database.ParkingReading) {
+		t.Helper()
+		tx, err := store.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error: %v", err)
+		}
+		for _, r := range readings {
+			if err := tx.InsertReading(ctx, r); err != nil {
+				t.Fatalf("InsertReading() error: %v", err)
+			}
+		}
+		if !commit {
+			tx.Rollback()
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Commit() error: %v", err)
+		}
+	}
+
+	insert(true,
+		testutil.NewReading("d1", "Dresden", base, 10),
+		testutil.NewReading("d2", "Dresden", base, 20),
+		testutil.NewReading("l1", "Leipzig", base, 30))
+	insert(true, testutil.NewReading("d1", "Dresden", base.Add(time.Minute), 11))
+	// An older reading, e.g. from a replay, is appended but not latest
+	insert(true, testutil.NewReading("d2", "Dresden", base.Add(-time.Minute), 19))
+	// A rolled back reading touches neither table
+	insert(false, testutil.NewReading("d1", "Dresden", base.Add(time.Hour), 99))
+
+	checkLatestMatchesHistory(t, db, map[string]int{"d1": 11, "d2": 20, "l1": 30})
+
+	dresden, err := database.GetLatestReadings(db, "Dresden")
+	if err != nil {
+		t.Fatalf("GetLatestReadings() error: %v", err)
+	}
+	if len(dresden) != 2 || dresden[0].LotID != "d1" || dresden[1].LotID != "d2" {
+		t.Errorf("got %v, expected the latest readings of d1 and d2", dresden)
+	}
+
+	// Readings stored without tracking are picked up by a rebuild
+	testutil.InsertReadings(t, db, testutil.NewReading("l1", "Leipzig", base.Add(time.Hour), 31))
+	if err := database.RebuildLatestReadings(ctx, db); err != nil {
+		t.Fatalf("RebuildLatestReadings() error: %v", err)
+	}
+	checkLatestMatchesHistory(t, db, map[string]int{"d1": 11, "d2": 20, "l1": 31})
+}
+
+func TestGetSilentLots(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db,
+		testutil.NewLot("d1", "Dresden"), testutil.NewLot("d2", "Dresden"),
+		testutil.NewLot("d3", "Dresden"), testutil.NewLot("l1", "Leipzig"))
+
+	store := database.NewSQLiteStore(db, database.TrackLatest())
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	// d1 reports throughout, d2 switches to nodata after 20 minutes, d3
+	// vanishes after 10 minutes and all of Leipzig after the first poll
+	for step := range 6 {
+		at := base.Add(time.Duration(step) * 10 * time.Minute)
+		readings := []*database.ParkingReading{testutil.NewReading("d1", "Dresden", at, 10)}
+		d2 := testutil.NewReading("d2", "Dresden", at, 20)
+		if step >= 3 {
+			d2.State = database.StateNoData
+		}
+		readings = append(readings, d2)
+		if step < 2 {
+			readings = append(readings, testutil.NewReading("d3", "Dresden", at, 30))
+		}
+		if step == 0 {
+			readings = append(readings, testutil.NewReading("l1", "Leipzig", at, 40))
+		}
+
+		tx, err := store.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error: %v", err)
+		}
+		if err := tx.InsertReadings(ctx, readings); err != nil {
+			t.Fatalf("InsertReadings() error: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Commit() error: %v", err)
+		}
+	}
+
+	check := func(name string) {
+		t.Helper()
+		silent, err := database.GetSilentLots(ctx, db, base.Add(25*time.Minute), base)
+		if err != nil {
+			t.Fatalf("GetSilentLots() error: %v", err)
+		}
+		expected := map[string]time.Time{
+			"d2": base.Add(20 * time.Minute),
+			"d3": base.Add(10 * time.Minute),
+		}
+		if len(silent) != len(expected) {
+			t.Fatalf("%s: got %+v, expected d2 and d3", name, silent)
+		}
+		for _, lot := range silent {
+			if !lot.LastReported.Valid || !lot.LastReported.Time.Equal(expected[lot.LotID]) {
+				t.Errorf("%s: %s: got last reported %v, expected %v", name, lot.LotID, lot.LastReported, expected[lot.LotID])
+			}
+		}
+	}
+	check("tracked")
+
+	if err := database.RebuildLatestReadings(ctx, db); err != nil {
+		t.Fatalf("RebuildLatestReadings() error: %v", err)
+	}
+	check("rebuilt")
+
+	// d3 counts as removed once it has no reading since the bound
+	silent, err := database.GetSilentLots(ctx, db, base.Add(25*time.Minute), base.Add(15*time.Minute))
+	if err != nil {
+		t.Fatalf("GetSilentLots() error: %v", err)
+	}
+	if len(silent) != 1 || silent[0].LotID != "d2" {
+		t.Errorf("got %+v, expected only d2", silent)
+	}
+}
+
+// checkLatestMatchesHistory compares latest_readings with the newest
+// parking_readings row of each lot
+func checkLatestMatchesHistory(t *testing.T, db *sql.DB, expected map[string]int) {
+	t.Helper()
+
+	latest, err := database.GetLatestReadings(db, "")
+	if err != nil {
+		t.Fatalf("GetLatestReadings() error: %v", err)
+	}
+	if len(latest) != len(expected) {
+		t.Fatalf("got %d latest readings, expected %d", len(latest), len(expected))
+	}
+	for _, r := range latest {
+		history, err := database.GetReadingsForLot(db, r.LotID, time.Time{}, time.Now())
+		if err != nil {
+			t.Fatalf("GetReadingsForLot() error: %v", err)
+		}
+		newest := history[len(history)-1]
+		if !r.Timestamp.Equal(newest.Timestamp) || r.Free != newest.Free || r.State != newest.State {
+			t.Errorf("%s: got latest %+v, expected newest reading %+v", r.LotID, r, newest)
+		}
+		if r.Free != expected[r.LotID] {
+			t.Errorf("%s: got free %d, expected %d", r.LotID, r.Free, expected[r.LotID])
+		}
+	}
+}
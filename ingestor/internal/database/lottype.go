@@ -0,0 +1,110 @@
+package database
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+)
+
+// LotType is a lot's kind of parking. Sources name types freely and in
+// German or English, so ParseLotType maps the known variants to the
+// constants below; other types are kept as sent.
+type LotType string
+
+// Known lot types
+const (
+	LotTypeGarage      LotType = "garage"
+	LotTypeUnderground LotType = "underground"
+	LotTypeLot         LotType = "lot"
+	LotTypeStreet      LotType = "street"
+	LotTypeParkAndRide LotType = "park_and_ride"
+)
+
+// LotTypes lists the known lot types
+var LotTypes = []LotType{LotTypeGarage, LotTypeUnderground, LotTypeLot, LotTypeStreet, LotTypeParkAndRide}
+
+// lotTypeAliases maps the lotTypeKey of known variants to their type. Each
+// type's own name is added by init.
+var lotTypeAliases = map[string]LotType{
+	"parkhaus":           LotTypeGarage,
+	"parking garage":     LotTypeGarage,
+	"multi storey":       LotTypeGarage,
+	"multistorey":        LotTypeGarage,
+	"parkdeck":           LotTypeGarage,
+	"tiefgarage":         LotTypeUnderground,
+	"underground garage": LotTypeUnderground,
+	"parkplatz":          LotTypeLot,
+	"parking lot":        LotTypeLot,
+	"surface lot":        LotTypeLot,
+	"straße":             LotTypeStreet,
+	"strasse":            LotTypeStreet,
+	"straßenrand":        LotTypeStreet,
+	"on street":          LotTypeStreet,
+	"street parking":     LotTypeStreet,
+	"park ride":          LotTypeParkAndRide,
+	"park & ride":        LotTypeParkAndRide,
+	"park+ride":          LotTypeParkAndRide,
+	"p+r":                LotTypeParkAndRide,
+	"p&r":                LotTypeParkAndRide,
+	"p & r":              LotTypeParkAndRide,
+}
+
+func init() {
+	for _, t := range LotTypes {
+		lotTypeAliases[lotTypeKey(string(t))] = t
+	}
+}
+
+// lotTypeKey folds case, surrounding space and separators, so "Park-Ride"
+// and "park  ride" look the same
+func lotTypeKey(value string) string {
+	value = strings.NewReplacer("-", " ", "_", " ", "/", " ").Replace(strings.ToLower(value))
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// ParseLotType returns the known type value names, e.g. LotTypeUnderground
+// for "Tiefgarage", or value with surrounding space trimmed when the type
+// is unknown
+func ParseLotType(value string) LotType {
+	if t, ok := lotTypeAliases[lotTypeKey(value)]; ok {
+		return t
+	}
+	return LotType(strings.TrimSpace(value))
+}
+
+// normalizeLotType parses a stored lot_type; blank types become NULL
+func normalizeLotType(lotType sql.NullString) sql.NullString {
+	t := ParseLotType(lotType.String)
+	return sql.NullString{String: string(t), Valid: lotType.Valid && t != ""}
+}
+
+// GetDistinctLotTypes returns the lot types of all stored lots, sorted and
+// without duplicates, e.g. to offer as filter options. Types stored before
+// lot types were normalized are parsed, so they merge with newer ones.
+func GetDistinctLotTypes(db *sql.DB) ([]LotType, error) {
+	rows, err := db.Query(`SELECT DISTINCT lot_type FROM parking_lots WHERE lot_type IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[LotType]bool)
+	types := []LotType{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		t := ParseLotType(value)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		types = append(types, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(types, func(a, b int) bool { return types[a] < types[b] })
+	return types, nil
+}
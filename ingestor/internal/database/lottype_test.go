@@ -0,0 +1,74 @@
+package database_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestParseLotType(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected database.LotType
+	}{
+		{"Parkhaus", database.LotTypeGarage},
+		{" PARKHAUS ", database.LotTypeGarage},
+		{"Multi-Storey", database.LotTypeGarage},
+		{"garage", database.LotTypeGarage},
+		{"Tiefgarage", database.LotTypeUnderground},
+		{"Underground", database.LotTypeUnderground},
+		{"Parkplatz", database.LotTypeLot},
+		{"Straße", database.LotTypeStreet},
+		{"on-street", database.LotTypeStreet},
+		{"P+R", database.LotTypeParkAndRide},
+		{"Park & Ride", database.LotTypeParkAndRide},
+		{"park_and_ride", database.LotTypeParkAndRide},
+		{"Park-and-Ride", database.LotTypeParkAndRide},
+		{" Fahrradparkhaus ", "Fahrradparkhaus"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := database.ParseLotType(tt.value); got != tt.expected {
+				t.Errorf("got %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetDistinctLotTypes(t *testing.T) {
+	db := testutil.NewDB(t)
+	typed := func(id, lotType string) *database.ParkingLot {
+		lot := testutil.NewLot(id, "Dresden")
+		lot.LotType.String = lotType
+		return lot
+	}
+	untyped := testutil.NewLot("d4", "Dresden")
+	untyped.LotType.Valid = false
+	testutil.InsertLots(t, db, typed("d1", "Tiefgarage"), typed("d2", "Parkhaus"), typed("d3", "Fahrradparkhaus"), untyped)
+
+	// Written before lot types were normalized
+	if _, err := db.Exec(`UPDATE parking_lots SET lot_type = 'Tiefgarage' WHERE id = 'd4'`); err != nil {
+		t.Fatal(err)
+	}
+
+	lot, err := database.GetLot(db, "d1")
+	if err != nil {
+		t.Fatalf("GetLot() error: %v", err)
+	}
+	if lot.LotType.String != string(database.LotTypeUnderground) {
+		t.Errorf("got stored lot_type %q, expected %q", lot.LotType.String, database.LotTypeUnderground)
+	}
+
+	types, err := database.GetDistinctLotTypes(db)
+	if err != nil {
+		t.Fatalf("GetDistinctLotTypes() error: %v", err)
+	}
+	expected := []database.LotType{"Fahrradparkhaus", database.LotTypeGarage, database.LotTypeUnderground}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("got %v, expected %v", types, expected)
+	}
+}
@@ -0,0 +1,323 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migration is a single numbered schema change. Statements run inside one
+// transaction together with the schema_version bump, so a migration is
+// either fully applied or not at all.
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// migrations lists every schema change in order. Never edit or reorder an
+// existing entry; append a new one with the next version instead.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create parking_lots and parking_readings",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS parking_lots (
+				id TEXT PRIMARY KEY,
+				city TEXT NOT NULL,
+				name TEXT NOT NULL,
+				address TEXT,
+				lot_type TEXT,
+				total INTEGER NOT NULL,
+				latitude REAL,
+				longitude REAL,
+				region TEXT,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS parking_readings (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				lot_id TEXT NOT NULL,
+				city TEXT NOT NULL,
+				timestamp TIMESTAMP NOT NULL,
+				free INTEGER NOT NULL,
+				state TEXT NOT NULL,
+				FOREIGN KEY (lot_id) REFERENCES parking_lots(id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_readings_timestamp
+				ON parking_readings(timestamp)`,
+			`CREATE INDEX IF NOT EXISTS idx_readings_lot_id
+				ON parking_readings(lot_id)`,
+		},
+	},
+	{
+		version:     2,
+		description: "track lot capacity changes",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS lot_capacity_history (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				lot_id TEXT NOT NULL,
+				total INTEGER NOT NULL,
+				effective_from TIMESTAMP NOT NULL,
+				FOREIGN KEY (lot_id) REFERENCES parking_lots(id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_capacity_lot_effective
+				ON lot_capacity_history(lot_id, effective_from)`,
+			// Existing lots get their current capacity from their first reading on
+			`INSERT INTO lot_capacity_history (lot_id, total, effective_from)
+				SELECT l.id, l.total, COALESCE(
+					(SELECT MIN(r.timestamp) FROM parking_readings r WHERE r.lot_id = l.id),
+					l.created_at)
+				FROM parking_lots l`,
+		},
+	},
+	{
+		version:     3,
+		description: "store city metadata",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS cities (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				source TEXT,
+				url TEXT,
+				active_support INTEGER NOT NULL DEFAULT 0,
+				latitude REAL,
+				longitude REAL,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			)`,
+		},
+	},
+	{
+		version:     4,
+		description: "normalize stored timestamps to UTC",
+		// Timestamps used to be stored in the ingesting server's local
+		// zone, e.g. "2024-03-31 03:00:00+02:00", so string comparisons in
+		// range queries mixed offsets. SQLite's strftime converts them to
+		// UTC, keeping the fraction of a second as written.
+		statements: []string{
+			utcUpdate("parking_readings", "timestamp"),
+			utcUpdate("lot_capacity_history", "effective_from"),
+		},
+	},
+	{
+		version:     5,
+		description: "flag anomalous readings",
+		statements: []string{
+			`ALTER TABLE parking_readings ADD COLUMN anomalous INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version:     6,
+		description: "keep undecoded lot fields",
+		statements: []string{
+			`ALTER TABLE parking_lots ADD COLUMN extras TEXT`,
+		},
+	},
+	{
+		version:     7,
+		description: "store downsampled readings",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS reading_aggregates (
+				lot_id TEXT NOT NULL,
+				city TEXT NOT NULL,
+				bucket_start TIMESTAMP NOT NULL,
+				bucket_seconds INTEGER NOT NULL,
+				samples INTEGER NOT NULL,
+				valid_samples INTEGER NOT NULL,
+				free_sum INTEGER NOT NULL,
+				free_min INTEGER,
+				free_max INTEGER,
+				PRIMARY KEY (lot_id, bucket_start, bucket_seconds),
+				FOREIGN KEY (lot_id) REFERENCES parking_lots(id)
+			)`,
+		},
+	},
+	{
+		version:     8,
+		description: "track when lots were first and last seen",
+		statements: []string{
+			`ALTER TABLE parking_lots ADD COLUMN first_seen_at TIMESTAMP`,
+			`ALTER TABLE parking_lots ADD COLUMN last_seen_at TIMESTAMP`,
+			// Existing lots were seen when they have readings, else when
+			// they were written
+			`UPDATE parking_lots SET
+				first_seen_at = COALESCE(
+					(SELECT MIN(r.timestamp) FROM parking_readings r WHERE r.lot_id = parking_lots.id),
+					created_at),
+				last_seen_at = COALESCE(
+					(SELECT MAX(r.timestamp) FROM parking_readings r WHERE r.lot_id = parking_lots.id),
+					updated_at)`,
+		},
+	},
+	{
+		version:     9,
+		description: "index lots by region",
+		statements: []string{
+			`UPDATE parking_lots SET region = NULL WHERE TRIM(region) = ''`,
+			`CREATE INDEX IF NOT EXISTS idx_lots_city_region
+				ON parking_lots(city, region)`,
+		},
+	},
+	{
+		version:     10,
+		description: "keep the latest reading of each lot",
+		// Filled by RebuildLatestReadings when a store starts tracking it
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS latest_readings (
+				lot_id TEXT PRIMARY KEY,
+				city TEXT NOT NULL,
+				timestamp TIMESTAMP NOT NULL,
+				free INTEGER NOT NULL,
+				state TEXT NOT NULL,
+				anomalous INTEGER NOT NULL DEFAULT 0,
+				FOREIGN KEY (lot_id) REFERENCES parking_lots(id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_latest_readings_city
+				ON latest_readings(city)`,
+		},
+	},
+	{
+		version:     11,
+		description: "index readings by city and time",
+		statements: []string{
+			`CREATE INDEX IF NOT EXISTS idx_readings_city_timestamp
+				ON parking_readings(city, timestamp)`,
+		},
+	},
+	{
+		version:     12,
+		description: "record which ingestor wrote each reading",
+		// Existing readings keep NULL: their writer is unknown
+		statements: []string{
+			`ALTER TABLE parking_readings ADD COLUMN source_instance TEXT`,
+		},
+	},
+	{
+		version:     13,
+		description: "checkpoint derived table rebuilds",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS rebuild_progress (
+				target TEXT PRIMARY KEY,
+				last_lot_id TEXT NOT NULL
+			)`,
+		},
+	},
+	{
+		version:     14,
+		description: "score reading quality",
+		// Existing readings get the flags implied by anomalous and state;
+		// whether their source was stale is unknown
+		statements: []string{
+			`ALTER TABLE parking_readings ADD COLUMN quality INTEGER NOT NULL DEFAULT 0`,
+			fmt.Sprintf(`UPDATE parking_readings SET quality =
+				(CASE WHEN anomalous THEN %d ELSE 0 END) |
+				(CASE WHEN state IN ('%s', '%s') THEN %d ELSE 0 END)`,
+				QualityAnomalous, StateClosed, StateNoData, QualityUnavailable),
+		},
+	},
+	{
+		version:     15,
+		description: "track when each lot last reported data",
+		statements: []string{
+			`ALTER TABLE latest_readings ADD COLUMN last_reported TIMESTAMP`,
+			`UPDATE latest_readings SET last_reported = ` + lastReportedSQL("latest_readings"),
+		},
+	},
+}
+
+// timestampGlob matches the driver's timestamp format up to the seconds,
+// "2006-01-02 15:04:05", which the fraction and offset follow
+const timestampGlob = `[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]?[0-9][0-9]:[0-9][0-9]:[0-9][0-9]`
+
+// utcUpdate returns a statement rewriting a column's timestamps that carry
+// a non-UTC offset into the UTC form written by the driver. Offsets are
+// whole minutes, so the fraction of a second is copied as it is.
+func utcUpdate(table, column string) string {
+	return fmt.Sprintf(`UPDATE %[1]s
+		SET %[2]s = strftime('%%Y-%%m-%%d %%H:%%M:%%S', %[2]s)
+			|| substr(%[2]s, 20, length(%[2]s) - 25) || '+00:00'
+		WHERE %[2]s GLOB '%[3]s*[+-][0-9][0-9]:[0-9][0-9]'
+			AND %[2]s NOT GLOB '*+00:00'
+			AND strftime('%%Y-%%m-%%d %%H:%%M:%%S', %[2]s) IS NOT NULL`, table, column, timestampGlob)
+}
+
+// LatestSchemaVersion returns the version the schema has after all migrations
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion returns the currently applied schema version (0 if none)
+func SchemaVersion(db *sql.DB) (int, error) {
+	if err := ensureVersionTable(db); err != nil {
+		return 0, err
+	}
+	return appliedVersion(db)
+}
+
+// appliedVersion is SchemaVersion without creating the version table, for
+// databases that must not be written. A missing table means version 0.
+func appliedVersion(db *sql.DB) (int, error) {
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'`).Scan(&tables); err != nil {
+		return 0, err
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+
+	var version int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// Migrate applies all pending migrations in order. Databases created before
+// versioning existed start at version 0; the first migration only uses
+// IF NOT EXISTS so it converges with their existing tables.
+func Migrate(db *sql.DB) error {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+	}
+
+	return nil
+}
+
+// ensureVersionTable creates the schema_version bookkeeping table
+func ensureVersionTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	return err
+}
+
+// applyMigration runs a single migration and records its version
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT INTO schema_version (version) VALUES (?)`, m.version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,248 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func assertLatestSchema(t *testing.T, db *sql.DB) {
+	t.Helper()
+
+	version, err := SchemaVersion(db)
+	if err != nil {
+		t.Fatalf("SchemaVersion() error: %v", err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Errorf("Expected schema version %d, got %d", LatestSchemaVersion(), version)
+	}
+
+	for _, table := range []string{"parking_lots", "parking_readings", "lot_capacity_history", "cities"} {
+		var name string
+		err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&name)
+		if err != nil {
+			t.Errorf("Expected table %s to exist: %v", table, err)
+		}
+	}
+}
+
+func TestMigrateFreshDB(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate() error: %v", err)
+	}
+	assertLatestSchema(t, db)
+
+	// Running again must be a no-op
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Second Migrate() error: %v", err)
+	}
+	assertLatestSchema(t, db)
+}
+
+func TestMigrateLegacyDB(t *testing.T) {
+	db := openTestDB(t)
+
+	// Schema as created by InitDB before versioning existed
+	legacy := []string{
+		`CREATE TABLE parking_lots (
+			id TEXT PRIMARY KEY,
+			city TEXT NOT NULL,
+			name TEXT NOT NULL,
+			address TEXT,
+			lot_type TEXT,
+			total INTEGER NOT NULL,
+			latitude REAL,
+			longitude REAL,
+			region TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE parking_readings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			lot_id TEXT NOT NULL,
+			city TEXT NOT NULL,
+			timestamp TIMESTAMP NOT NULL,
+			free INTEGER NOT NULL,
+			state TEXT NOT NULL,
+			FOREIGN KEY (lot_id) REFERENCES parking_lots(id)
+		)`,
+		`INSERT INTO parking_lots (id, city, name, total) VALUES ('lot1', 'Dresden', 'Altmarkt', 400)`,
+	}
+	for _, stmt := range legacy {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create legacy schema: %v", err)
+		}
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate() error: %v", err)
+	}
+	assertLatestSchema(t, db)
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM parking_lots`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count lots: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected existing lot to survive migration, got %d lots", count)
+	}
+
+	if err := db.QueryRow(`SELECT COUNT(*) FROM lot_capacity_history WHERE lot_id = 'lot1'`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count capacity history: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected existing lot to be seeded into capacity history, got %d rows", count)
+	}
+
+	if err := db.QueryRow(`SELECT COUNT(*) FROM parking_lots WHERE first_seen_at IS NOT NULL AND last_seen_at IS NOT NULL`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count seen lots: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected existing lot to get first and last seen times, got %d lots", count)
+	}
+}
+
+func TestMigrateNormalizesTimestamps(t *testing.T) {
+	db := openTestDB(t)
+
+	// Bring the schema to version 3, before timestamps were normalized
+	if err := ensureVersionTable(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations[:3] {
+		if err := applyMigration(db, m); err != nil {
+			t.Fatalf("Failed to apply migration %d: %v", m.version, err)
+		}
+	}
+
+	stored := []string{
+		"2024-03-31 03:00:00.5+02:00",
+		"2024-03-31 01:00:00.25+00:00",
+		"2024-03-30 20:00:00-05:00",
+	}
+	for _, ts := range stored {
+		if _, err := db.Exec(`INSERT INTO parking_readings (lot_id, city, timestamp, free, state)
+			VALUES ('lot1', 'Dresden', ?, 10, 'open')`, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate() error: %v", err)
+	}
+
+	expected := []time.Time{
+		time.Date(2024, 3, 31, 1, 0, 0, 500_000_000, time.UTC),
+		time.Date(2024, 3, 31, 1, 0, 0, 250_000_000, time.UTC),
+		time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC),
+	}
+	rows, err := db.Query(`SELECT timestamp FROM parking_readings ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	for idx := 0; rows.Next(); idx++ {
+		var ts time.Time
+		if err := rows.Scan(&ts); err != nil {
+			t.Fatal(err)
+		}
+		if !ts.Equal(expected[idx]) || ts.Location() != time.UTC {
+			t.Errorf("row %d: got %v, expected %v", idx, ts, expected[idx])
+		}
+	}
+
+	// The rewritten values must be exactly what the driver writes, or
+	// string comparisons with new rows go wrong
+	raw, err := db.Query(`SELECT timestamp || '' FROM parking_readings ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	for idx := 0; raw.Next(); idx++ {
+		var ts string
+		if err := raw.Scan(&ts); err != nil {
+			t.Fatal(err)
+		}
+		if want := expected[idx].Format(sqlite3.SQLiteTimestampFormats[0]); ts != want {
+			t.Errorf("row %d: got %q, expected %q", idx, ts, want)
+		}
+	}
+}
+
+func TestMigratedTimestampsPage(t *testing.T) {
+	db := openTestDB(t)
+
+	// Bring the schema to version 3, with readings in local time
+	if err := ensureVersionTable(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations[:3] {
+		if err := applyMigration(db, m); err != nil {
+			t.Fatalf("Failed to apply migration %d: %v", m.version, err)
+		}
+	}
+	for _, ts := range []string{"2024-01-01 11:00:00+01:00", "2024-01-01 11:00:00.5+01:00"} {
+		if _, err := db.Exec(`INSERT INTO parking_readings (lot_id, city, timestamp, free, state)
+			VALUES ('lot1', 'Dresden', ?, 10, 'open')`, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate() error: %v", err)
+	}
+
+	// New readings at the same instants and in between
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for _, offset := range []time.Duration{0, 250 * time.Millisecond, 500 * time.Millisecond} {
+		reading := &ParkingReading{LotID: "lot1", City: "Dresden", Timestamp: base.Add(offset), Free: 10, State: "open"}
+		if err := InsertReading(db, reading); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Paging one row at a time must visit every reading once, in order
+	var ids []int64
+	var last time.Time
+	var after *Cursor
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatalf("paging repeats readings: %v", ids)
+		}
+		page, err := GetReadingsPage(db, "lot1", base, base.Add(time.Second), after, 1)
+		if err != nil {
+			t.Fatalf("GetReadingsPage() error: %v", err)
+		}
+		for _, r := range page.Readings {
+			if r.Timestamp.Before(last) {
+				t.Errorf("reading %d at %v is out of order", r.ID, r.Timestamp)
+			}
+			last = r.Timestamp
+			ids = append(ids, r.ID)
+		}
+		if page.Next == nil {
+			break
+		}
+		after = page.Next
+	}
+	slices.Sort(ids)
+	if len(ids) != 5 || len(slices.Compact(ids)) != 5 {
+		t.Errorf("got readings %v, expected 5 distinct readings", ids)
+	}
+}
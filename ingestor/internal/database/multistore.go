@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MultiStore fans writes out to several stores, e.g. a local database for
+// quick queries and a central one shared by several ingestors.
+//
+// Each Begin opens a transaction on every store, so each store receives a
+// city's complete batch or nothing: a failed write rolls back all of them.
+// Commits cannot be made atomic across stores, though. If some stores
+// commit and others fail, the error reports the partial commit and is never
+// transient, so the batch is not retried into the stores that have it.
+type MultiStore struct {
+	stores []Store
+}
+
+// NewMultiStore returns a store writing to all of stores. The first store
+// is committed first.
+func NewMultiStore(stores ...Store) *MultiStore {
+	return &MultiStore{stores: stores}
+}
+
+// Begin starts a transaction on every store. If any store fails, the
+// transactions already started are rolled back.
+func (m *MultiStore) Begin(ctx context.Context) (Tx, error) {
+	txs := make([]Tx, 0, len(m.stores))
+	for idx, store := range m.stores {
+		tx, err := store.Begin(ctx)
+		if err != nil {
+			for _, started := range txs {
+				started.Rollback()
+			}
+			return nil, fmt.Errorf("store %d: %w", idx, err)
+		}
+		txs = append(txs, tx)
+	}
+	return &multiTx{txs: txs}, nil
+}
+
+// Reconnect reconnects every store that supports it
+func (m *MultiStore) Reconnect(ctx context.Context) error {
+	var errs []error
+	for _, store := range m.stores {
+		if r, ok := store.(Reconnector); ok {
+			if err := r.Reconnect(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LatestReadings reports the latest readings of the first store that
+// supports it, which is the one committed first
+func (m *MultiStore) LatestReadings(ctx context.Context, since time.Time) (map[string]time.Time, error) {
+	for _, store := range m.stores {
+		if r, ok := store.(RecentReader); ok {
+			return r.LatestReadings(ctx, since)
+		}
+	}
+	return nil, nil
+}
+
+// multiTx applies every call to one transaction per store
+type multiTx struct {
+	txs []Tx
+}
+
+func (t *multiTx) UpsertLot(ctx context.Context, lot *ParkingLot) error {
+	return t.each(func(tx Tx) error { return tx.UpsertLot(ctx, lot) })
+}
+
+func (t *multiTx) InsertReading(ctx context.Context, reading *ParkingReading) error {
+	return t.each(func(tx Tx) error { return tx.InsertReading(ctx, reading) })
+}
+
+func (t *multiTx) InsertReadings(ctx context.Context, readings []*ParkingReading) error {
+	return t.each(func(tx Tx) error { return tx.InsertReadings(ctx, readings) })
+}
+
+func (t *multiTx) MarkLotsSeen(ctx context.Context, ids []string, seenAt time.Time) error {
+	return t.each(func(tx Tx) error { return tx.MarkLotsSeen(ctx, ids, seenAt) })
+}
+
+// Commit commits every transaction in order, stopping at the first failure
+// and rolling back the rest
+func (t *multiTx) Commit() error {
+	for idx, tx := range t.txs {
+		if err := tx.Commit(); err != nil {
+			for _, rest := range t.txs[idx+1:] {
+				rest.Rollback()
+			}
+			if idx == 0 {
+				return fmt.Errorf("store 0: %w", err)
+			}
+			// %v drops the cause's type so the partial commit is not retried
+			return fmt.Errorf("store %d: commit failed after %d of %d stores committed: %v", idx, idx, len(t.txs), err)
+		}
+	}
+	return nil
+}
+
+func (t *multiTx) Rollback() error {
+	var errs []error
+	for _, tx := range t.txs {
+		if err := tx.Rollback(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// each calls fn for every transaction and joins their errors
+func (t *multiTx) each(fn func(tx Tx) error) error {
+	var errs []error
+	for idx, tx := range t.txs {
+		if err := fn(tx); err != nil {
+			errs = append(errs, fmt.Errorf("store %d: %w", idx, err))
+		}
+	}
+	return errors.Join(errs...)
+}
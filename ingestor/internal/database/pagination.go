@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned by ParseCursor for malformed cursors
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks the last reading of a page. Readings are ordered by
+// timestamp and then ID, so a cursor stays valid while new rows arrive.
+type Cursor struct {
+	Timestamp time.Time
+	ID        int64
+}
+
+// String encodes the cursor as an opaque URL-safe token
+func (c Cursor) String() string {
+	raw := strconv.FormatInt(c.Timestamp.UnixNano(), 10) + ":" + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor decodes a token produced by Cursor.String
+func ParseCursor(token string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+	ts, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	c := Cursor{Timestamp: time.Unix(0, ts).UTC()}
+	if c.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// ReadingsPage is one page of a lot's readings. Next is nil on the last page.
+type ReadingsPage struct {
+	Readings []ParkingReading
+	Next     *Cursor
+}
+
+// GetReadingsPage returns up to limit readings of a lot with
+// from <= timestamp < to, oldest first, starting after the given cursor
+// (nil for the first page). Anomalous readings are included and flagged.
+func GetReadingsPage(db *sql.DB, lotID string, from, to time.Time, after *Cursor, limit int) (*ReadingsPage, error) {
+	return readingsPage(context.Background(), db, "lot_id = ?", []any{lotID}, from, to, after, limit)
+}
+
+// GetCityReadingsPage is GetReadingsPage for all lots of a city, or of
+// every city when city is empty
+func GetCityReadingsPage(ctx context.Context, db *sql.DB, city string, from, to time.Time, after *Cursor, limit int) (*ReadingsPage, error) {
+	// A plain equality keeps idx_readings_city_timestamp usable
+	if city == "" {
+		return readingsPage(ctx, db, "", nil, from, to, after, limit)
+	}
+	return readingsPage(ctx, db, "city = ?", []any{city}, from, to, after, limit)
+}
+
+// readingsPage pages through the readings matching the condition where,
+// whose placeholders are bound to whereArgs; an empty where matches all
+func readingsPage(ctx context.Context, db *sql.DB, where string, whereArgs []any, from, to time.Time, after *Cursor, limit int) (*ReadingsPage, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("invalid page limit %d", limit)
+	}
+
+	query := `
+		SELECT id, lot_id, city, timestamp, free, state, anomalous, quality
+		FROM parking_readings
+		WHERE timestamp >= ? AND timestamp < ?`
+	args := []any{from.UTC(), to.UTC()}
+	if where != "" {
+		query += ` AND ` + where
+		args = append(args, whereArgs...)
+	}
+	if after != nil {
+		query += ` AND (timestamp > ? OR (timestamp = ? AND id > ?))`
+		ts := after.Timestamp.UTC()
+		args = append(args, ts, ts, after.ID)
+	}
+	// Fetch one extra row to learn whether another page follows
+	query += ` ORDER BY timestamp, id LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &ReadingsPage{}
+	for rows.Next() {
+		var r ParkingReading
+		if err := rows.Scan(&r.ID, &r.LotID, &r.City, &r.Timestamp, &r.Free, &r.State, &r.Anomalous, &r.Quality); err != nil {
+			return nil, err
+		}
+		page.Readings = append(page.Readings, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Readings) > limit {
+		page.Readings = page.Readings[:limit]
+		last := page.Readings[limit-1]
+		page.Next = &Cursor{Timestamp: last.Timestamp, ID: last.ID}
+	}
+	return page, nil
+}
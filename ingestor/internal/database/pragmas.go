@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// allowedPragmas are the pragmas users may set. Pragma names cannot be
+// bound as parameters, so anything else is rejected rather than
+// interpolated into SQL.
+var allowedPragmas = map[string]bool{
+	"busy_timeout":       true,
+	"cache_size":         true,
+	"cache_spill":        true,
+	"journal_mode":       true,
+	"journal_size_limit": true,
+	"locking_mode":       true,
+	"mmap_size":          true,
+	"secure_delete":      true,
+	"synchronous":        true,
+	"temp_store":         true,
+	"wal_autocheckpoint": true,
+}
+
+// pragmaValue matches keywords such as WAL and integers such as -64000
+var pragmaValue = regexp.MustCompile(`^-?[A-Za-z0-9_]+$`)
+
+// Pragma is a SQLite pragma applied to every connection
+type Pragma struct {
+	Name  string
+	Value string
+}
+
+func (p Pragma) String() string {
+	return p.Name + "=" + p.Value
+}
+
+// validate rejects pragmas that are not allowlisted or whose value is not
+// a plain keyword or number
+func (p Pragma) validate() error {
+	if !allowedPragmas[p.Name] {
+		names := make([]string, 0, len(allowedPragmas))
+		for name := range allowedPragmas {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("pragma %q is not supported (allowed: %s)", p.Name, strings.Join(names, ", "))
+	}
+	if !pragmaValue.MatchString(p.Value) {
+		return fmt.Errorf("invalid value %q for pragma %s", p.Value, p.Name)
+	}
+	return nil
+}
+
+// ParsePragmas parses and validates "name=value,name=value"
+func ParsePragmas(value string) ([]Pragma, error) {
+	var pragmas []Pragma
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid pragma %q: expected name=value", part)
+		}
+		p := Pragma{Name: strings.ToLower(strings.TrimSpace(name)), Value: strings.TrimSpace(val)}
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		pragmas = append(pragmas, p)
+	}
+	return pragmas, nil
+}
+
+// pragmaConnector opens SQLite connections and applies pragmas to each,
+// since pragmas only affect the connection they run on
+type pragmaConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func newPragmaConnector(dsn string, pragmas []Pragma) *pragmaConnector {
+	return &pragmaConnector{
+		dsn: dsn,
+		driver: &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				for _, p := range pragmas {
+					if _, err := conn.Exec("PRAGMA "+p.Name+" = "+p.Value, nil); err != nil {
+						return fmt.Errorf("failed to apply pragma %s: %w", p, err)
+					}
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func (c *pragmaConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *pragmaConnector) Driver() driver.Driver {
+	return c.driver
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// PruneReadingsKeepLast deletes all but the n most recent readings of each
+// lot and returns how many were deleted. Readings with the same timestamp
+// are ordered by insertion, so the latest inserted ones are kept.
+func PruneReadingsKeepLast(ctx context.Context, db *sql.DB, n int) (int64, error) {
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid number of readings to keep %d: must be positive", n)
+	}
+
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM parking_readings WHERE id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (
+					PARTITION BY lot_id ORDER BY timestamp DESC, id DESC
+				) AS position
+				FROM parking_readings
+			) WHERE position > ?
+		)
+	`, n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune readings: %w", err)
+	}
+	return result.RowsAffected()
+}
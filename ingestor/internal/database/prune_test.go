@@ -0,0 +1,52 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestPruneReadingsKeepLast(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("d1", "Dresden"), testutil.NewLot("d2", "Dresden"))
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	var readings []*database.ParkingReading
+	for idx := range 5 {
+		readings = append(readings, testutil.NewReading("d1", "Dresden", base.Add(time.Duration(idx)*time.Minute), idx))
+	}
+	readings = append(readings, testutil.NewReading("d2", "Dresden", base, 7))
+	testutil.InsertReadings(t, db, readings...)
+
+	ctx := context.Background()
+	deleted, err := database.PruneReadingsKeepLast(ctx, db, 3)
+	if err != nil {
+		t.Fatalf("PruneReadingsKeepLast() error: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("got %d deleted, expected 2", deleted)
+	}
+
+	expected := map[string][]int{"d1": {2, 3, 4}, "d2": {7}}
+	for lotID, free := range expected {
+		kept, err := database.GetReadingsForLot(db, lotID, base, base.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("GetReadingsForLot() error: %v", err)
+		}
+		if len(kept) != len(free) {
+			t.Fatalf("%s: got %d readings, expected %d", lotID, len(kept), len(free))
+		}
+		for idx, r := range kept {
+			if r.Free != free[idx] {
+				t.Errorf("%s: got free %d at %d, expected %d", lotID, r.Free, idx, free[idx])
+			}
+		}
+	}
+
+	if _, err := database.PruneReadingsKeepLast(ctx, db, 0); err == nil {
+		t.Error("Expected an error keeping 0 readings")
+	}
+}
@@ -0,0 +1,55 @@
+package database
+
+// Quality is a bitfield of the reasons a reading may be less trustworthy,
+// stored in parking_readings.quality; 0 means none, so dashboards can dim
+// every reading with a non-zero quality
+type Quality int
+
+// Quality flags
+const (
+	// QualityAnomalous marks impossible values, see ParkingReading.Anomalous
+	QualityAnomalous Quality = 1 << iota
+	// QualityStale marks readings whose source had not updated for longer
+	// than the ingestor's stale threshold when they were fetched
+	QualityStale
+	// QualityUnavailable marks closed lots and lots without data, whose
+	// free count of 0 does not mean full
+	QualityUnavailable
+	// QualityForecastOnly marks lots without a live count that only
+	// publish forecasts
+	QualityForecastOnly
+)
+
+// qualityNames names the flags, in bit order
+var qualityNames = []string{"anomalous", "stale", "unavailable", "forecast_only"}
+
+// Has reports whether q includes flag
+func (q Quality) Has(flag Quality) bool {
+	return q&flag != 0
+}
+
+// Flags returns the names of q's flags, e.g. ["stale"]; empty for 0
+func (q Quality) Flags() []string {
+	flags := []string{}
+	for bit, name := range qualityNames {
+		if q.Has(1 << bit) {
+			flags = append(flags, name)
+		}
+	}
+	return flags
+}
+
+// quality returns the reading's Quality with the flags implied by its
+// Anomalous and State fields added. QualityStale and QualityForecastOnly
+// must be set by the writer, as neither the source's age nor its forecast
+// flag is stored.
+func (r *ParkingReading) quality() Quality {
+	q := r.Quality
+	if r.Anomalous {
+		q |= QualityAnomalous
+	}
+	if !IsAvailable(r.State) {
+		q |= QualityUnavailable
+	}
+	return q
+}
@@ -0,0 +1,62 @@
+package database_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestReadingQuality(t *testing.T) {
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	anomalous := testutil.NewReading("lot1", "Dresden", base.Add(time.Minute), 500)
+	anomalous.Anomalous = true
+	stale := testutil.NewReading("lot1", "Dresden", base.Add(2*time.Minute), 40)
+	stale.Quality = database.QualityStale
+	closed := testutil.NewReading("lot1", "Dresden", base.Add(3*time.Minute), 0)
+	closed.State = database.StateClosed
+	noData := testutil.NewReading("lot1", "Dresden", base.Add(4*time.Minute), 0)
+	noData.State = database.StateNoData
+	noData.Quality = database.QualityStale
+	forecastOnly := testutil.NewReading("lot1", "Dresden", base.Add(5*time.Minute), 0)
+	forecastOnly.State = database.StateNoData
+	forecastOnly.Quality = database.QualityForecastOnly
+
+	tests := []struct {
+		name     string
+		reading  *database.ParkingReading
+		expected database.Quality
+		flags    []string
+	}{
+		{"good", testutil.NewReading("lot1", "Dresden", base, 40), 0, []string{}},
+		{"anomalous", anomalous, database.QualityAnomalous, []string{"anomalous"}},
+		{"stale", stale, database.QualityStale, []string{"stale"}},
+		{"closed", closed, database.QualityUnavailable, []string{"unavailable"}},
+		{"stale without data", noData, database.QualityStale | database.QualityUnavailable, []string{"stale", "unavailable"}},
+		{"forecast only", forecastOnly, database.QualityUnavailable | database.QualityForecastOnly, []string{"unavailable", "forecast_only"}},
+	}
+
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+	for _, tt := range tests {
+		testutil.InsertReadings(t, db, tt.reading)
+	}
+	page, err := database.GetReadingsPage(db, "lot1", base, base.Add(time.Hour), nil, len(tests))
+	if err != nil {
+		t.Fatalf("GetReadingsPage() error: %v", err)
+	}
+
+	for idx, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := page.Readings[idx].Quality
+			if got != tt.expected {
+				t.Errorf("got quality %d, expected %d", got, tt.expected)
+			}
+			if flags := got.Flags(); !reflect.DeepEqual(flags, tt.flags) {
+				t.Errorf("got flags %v, expected %v", flags, tt.flags)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// GetLot returns a single parking lot by ID. It returns sql.ErrNoRows if the
+// lot does not exist.
+func GetLot(db *sql.DB, id string) (*ParkingLot, error) {
+	var lot ParkingLot
+	err := db.QueryRow(`
+		SELECT id, city, name, address, lot_type, total, latitude, longitude, region, extras
+		FROM parking_lots
+		WHERE id = ?
+	`, id).Scan(&lot.ID, &lot.City, &lot.Name, &lot.Address, &lot.LotType,
+		&lot.Total, &lot.Latitude, &lot.Longitude, &lot.Region, &lot.Extras)
+	if err != nil {
+		return nil, err
+	}
+	return &lot, nil
+}
+
+// ListLots returns all lots of a city (every lot when city is empty),
+// ordered by ID
+func ListLots(db *sql.DB, city string) ([]ParkingLot, error) {
+	return queryLots(db, `WHERE ? = '' OR city = ?`, city, city)
+}
+
+// GetLotsByRegion returns the lots of a city's region, ordered by ID
+func GetLotsByRegion(db *sql.DB, city, region string) ([]ParkingLot, error) {
+	return queryLots(db, `WHERE city = ? AND region = ?`, city, region)
+}
+
+// GetRegions returns the distinct regions of a city's lots, sorted. Lots
+// without a region are left out.
+func GetRegions(db *sql.DB, city string) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT region FROM parking_lots
+		WHERE city = ? AND region IS NOT NULL
+		ORDER BY region
+	`, city)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	regions := []string{}
+	for rows.Next() {
+		var region string
+		if err := rows.Scan(&region); err != nil {
+			return nil, err
+		}
+		regions = append(regions, region)
+	}
+	return regions, rows.Err()
+}
+
+// queryLots returns the lots matching where, ordered by ID
+func queryLots(db *sql.DB, where string, args ...any) ([]ParkingLot, error) {
+	rows, err := db.Query(`
+		SELECT id, city, name, address, lot_type, total, latitude, longitude, region, extras,
+			first_seen_at, last_seen_at
+		FROM parking_lots
+		`+where+`
+		ORDER BY id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lots []ParkingLot
+	for rows.Next() {
+		var lot ParkingLot
+		var firstSeen, lastSeen sql.NullTime
+		if err := rows.Scan(&lot.ID, &lot.City, &lot.Name, &lot.Address, &lot.LotType,
+			&lot.Total, &lot.Latitude, &lot.Longitude, &lot.Region, &lot.Extras,
+			&firstSeen, &lastSeen); err != nil {
+			return nil, err
+		}
+		lot.FirstSeenAt, lot.LastSeenAt = firstSeen.Time, lastSeen.Time
+		lots = append(lots, lot)
+	}
+	return lots, rows.Err()
+}
+
+// GetReadingsForLot returns a lot's readings with from <= timestamp < to,
+// oldest first. Anomalous readings are excluded.
+func GetReadingsForLot(db *sql.DB, lotID string, from, to time.Time) ([]ParkingReading, error) {
+	return queryReadings(db, `
+		SELECT id, lot_id, city, timestamp, free, state
+		FROM parking_readings
+		WHERE lot_id = ? AND timestamp >= ? AND timestamp < ? AND NOT anomalous
+		ORDER BY timestamp
+	`, lotID, from.UTC(), to.UTC())
+}
+
+// GetReadingsForCity returns the readings of all of a city's lots with
+// from <= timestamp < to as one flat list, ordered by timestamp and then
+// lot ID, so each point in time's readings are adjacent. Anomalous
+// readings are excluded.
+func GetReadingsForCity(db *sql.DB, city string, from, to time.Time) ([]ParkingReading, error) {
+	return queryReadings(db, `
+		SELECT id, lot_id, city, timestamp, free, state
+		FROM parking_readings
+		WHERE city = ? AND timestamp >= ? AND timestamp < ? AND NOT anomalous
+		ORDER BY timestamp, lot_id
+	`, city, from.UTC(), to.UTC())
+}
+
+// queryReadings runs a query selecting id, lot_id, city, timestamp, free
+// and state from parking_readings
+func queryReadings(db *sql.DB, query string, args ...any) ([]ParkingReading, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var readings []ParkingReading
+	for rows.Next() {
+		var r ParkingReading
+		if err := rows.Scan(&r.ID, &r.LotID, &r.City, &r.Timestamp, &r.Free, &r.State); err != nil {
+			return nil, err
+		}
+		readings = append(readings, r)
+	}
+	return readings, rows.Err()
+}
+
+// OccupancyPoint is a reading paired with the capacity in effect at the time
+type OccupancyPoint struct {
+	Timestamp time.Time
+	Free      int
+	Total     int
+	State     string
+}
+
+// Occupancy returns the occupied fraction (0 when the capacity is unknown)
+func (p OccupancyPoint) Occupancy() float64 {
+	if p.Total <= 0 {
+		return 0
+	}
+	return 1 - float64(p.Free)/float64(p.Total)
+}
+
+// GetOccupancyForLot returns a lot's readings with from <= timestamp < to,
+// each paired with the capacity that was effective at the reading's time so
+// that later capacity changes don't distort historical occupancy.
+// Anomalous readings are excluded.
+func GetOccupancyForLot(db *sql.DB, lotID string, from, to time.Time) ([]OccupancyPoint, error) {
+	rows, err := db.Query(`
+		SELECT r.timestamp, r.free, r.state, COALESCE((
+			SELECT h.total FROM lot_capacity_history h
+			WHERE h.lot_id = r.lot_id AND h.effective_from <= r.timestamp
+			ORDER BY h.effective_from DESC
+			LIMIT 1
+		), l.total)
+		FROM parking_readings r
+		JOIN parking_lots l ON l.id = r.lot_id
+		WHERE r.lot_id = ? AND r.timestamp >= ? AND r.timestamp < ? AND NOT r.anomalous
+		ORDER BY r.timestamp
+	`, lotID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []OccupancyPoint
+	for rows.Next() {
+		var p OccupancyPoint
+		if err := rows.Scan(&p.Timestamp, &p.Free, &p.State, &p.Total); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// OccupancySummary aggregates occupancy points. Means only cover points
+// where the lot was available; closed and no-data points are counted
+// separately instead of being averaged in as full.
+type OccupancySummary struct {
+	Samples       int
+	Closed        int
+	NoData        int
+	MeanFree      float64
+	MeanOccupancy float64
+}
+
+// SummarizeOccupancy computes an OccupancySummary over points
+func SummarizeOccupancy(points []OccupancyPoint) OccupancySummary {
+	var s OccupancySummary
+	var free, occupancy float64
+	for _, p := range points {
+		switch p.State {
+		case StateClosed:
+			s.Closed++
+			continue
+		case StateNoData:
+			s.NoData++
+			continue
+		}
+		s.Samples++
+		free += float64(p.Free)
+		occupancy += p.Occupancy()
+	}
+
+	if s.Samples > 0 {
+		s.MeanFree = free / float64(s.Samples)
+		s.MeanOccupancy = occupancy / float64(s.Samples)
+	}
+	return s
+}
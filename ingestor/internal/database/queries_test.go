@@ -0,0 +1,410 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestGetLot(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+
+	lot, err := database.GetLot(db, "lot1")
+	if err != nil {
+		t.Fatalf("GetLot() error: %v", err)
+	}
+	if lot.City != "Dresden" || lot.Total != 100 || !lot.Region.Valid {
+		t.Errorf("Unexpected lot: %+v", lot)
+	}
+
+	if _, err := database.GetLot(db, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestGetReadingsForLot(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"), testutil.NewLot("lot2", "Dresden"))
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("lot1", "Dresden", base.Add(10*time.Minute), 30),
+		testutil.NewReading("lot1", "Dresden", base, 10),
+		testutil.NewReading("lot1", "Dresden", base.Add(5*time.Minute), 20),
+		testutil.NewReading("lot1", "Dresden", base.Add(time.Hour), 99),
+		testutil.NewReading("lot2", "Dresden", base, 50),
+	)
+
+	readings, err := database.GetReadingsForLot(db, "lot1", base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetReadingsForLot() error: %v", err)
+	}
+
+	if len(readings) != 3 {
+		t.Fatalf("Expected 3 readings, got %d", len(readings))
+	}
+	for i, expected := range []int{10, 20, 30} {
+		if readings[i].Free != expected {
+			t.Errorf("readings[%d].Free = %d, expected %d", i, readings[i].Free, expected)
+		}
+	}
+}
+
+func TestGetReadingsForCity(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db,
+		testutil.NewLot("lot1", "Dresden"), testutil.NewLot("lot2", "Dresden"), testutil.NewLot("lot3", "Leipzig"))
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("lot2", "Dresden", base.Add(5*time.Minute), 21),
+		testutil.NewReading("lot1", "Dresden", base.Add(5*time.Minute), 11),
+		testutil.NewReading("lot2", "Dresden", base, 20),
+		testutil.NewReading("lot1", "Dresden", base, 10),
+		testutil.NewReading("lot1", "Dresden", base.Add(time.Hour), 99),
+		testutil.NewReading("lot3", "Leipzig", base, 30),
+	)
+
+	readings, err := database.GetReadingsForCity(db, "Dresden", base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetReadingsForCity() error: %v", err)
+	}
+
+	expected := []struct {
+		lotID string
+		free  int
+	}{{"lot1", 10}, {"lot2", 20}, {"lot1", 11}, {"lot2", 21}}
+	if len(readings) != len(expected) {
+		t.Fatalf("Expected %d readings, got %d", len(expected), len(readings))
+	}
+	for i, e := range expected {
+		if readings[i].LotID != e.lotID || readings[i].Free != e.free {
+			t.Errorf("readings[%d] = %s/%d, expected %s/%d", i, readings[i].LotID, readings[i].Free, e.lotID, e.free)
+		}
+	}
+}
+
+func TestGetOccupancyForLotCapacityChange(t *testing.T) {
+	db := testutil.NewDB(t)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	lot := testutil.NewLot("lot1", "Dresden")
+	lot.Total = 100
+	lot.ObservedAt = base
+	testutil.InsertLots(t, db, lot)
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("lot1", "Dresden", base, 50),
+		testutil.NewReading("lot1", "Dresden", base.Add(time.Hour), 25),
+	)
+
+	// Half the garage closes for construction
+	lot.Total = 50
+	lot.ObservedAt = base.Add(2 * time.Hour)
+	testutil.InsertLots(t, db, lot)
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("lot1", "Dresden", base.Add(2*time.Hour), 25),
+	)
+
+	// An unchanged total does not add history
+	lot.ObservedAt = base.Add(3 * time.Hour)
+	testutil.InsertLots(t, db, lot)
+	if count := testutil.CountRows(t, db, "lot_capacity_history"); count != 2 {
+		t.Errorf("Expected 2 capacity history rows, got %d", count)
+	}
+
+	points, err := database.GetOccupancyForLot(db, "lot1", base, base.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetOccupancyForLot() error: %v", err)
+	}
+	if len(points) != 3 {
+		t.Fatalf("Expected 3 points, got %d", len(points))
+	}
+
+	expected := []struct {
+		total     int
+		occupancy float64
+	}{
+		{100, 0.5},
+		{100, 0.75},
+		{50, 0.5},
+	}
+	for i, e := range expected {
+		if points[i].Total != e.total || points[i].Occupancy() != e.occupancy {
+			t.Errorf("points[%d] = total %d occupancy %v, expected total %d occupancy %v",
+				i, points[i].Total, points[i].Occupancy(), e.total, e.occupancy)
+		}
+	}
+}
+
+func TestFindGaps(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("lot1", "Dresden", base, 10),
+		// 7 minutes is within the 1.5x tolerance of a 5 minute interval
+		testutil.NewReading("lot1", "Dresden", base.Add(7*time.Minute), 10),
+		testutil.NewReading("lot1", "Dresden", base.Add(12*time.Minute), 10),
+		// 30 minutes without readings
+		testutil.NewReading("lot1", "Dresden", base.Add(42*time.Minute), 10),
+	)
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		expected []database.Gap
+	}{
+		{
+			name:     "gap between readings",
+			from:     base,
+			to:       base.Add(45 * time.Minute),
+			expected: []database.Gap{{Start: base.Add(12 * time.Minute), End: base.Add(42 * time.Minute)}},
+		},
+		{
+			name: "gaps at the range boundaries",
+			from: base.Add(-time.Hour),
+			to:   base.Add(2 * time.Hour),
+			expected: []database.Gap{
+				{Start: base.Add(-time.Hour), End: base},
+				{Start: base.Add(12 * time.Minute), End: base.Add(42 * time.Minute)},
+				{Start: base.Add(42 * time.Minute), End: base.Add(2 * time.Hour)},
+			},
+		},
+		{
+			name:     "no readings",
+			from:     base.Add(24 * time.Hour),
+			to:       base.Add(25 * time.Hour),
+			expected: []database.Gap{{Start: base.Add(24 * time.Hour), End: base.Add(25 * time.Hour)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gaps, err := database.FindGaps(db, "lot1", 5*time.Minute, tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("FindGaps() error: %v", err)
+			}
+			if len(gaps) != len(tt.expected) {
+				t.Fatalf("Expected %d gaps, got %v", len(tt.expected), gaps)
+			}
+			for i, gap := range gaps {
+				if !gap.Start.Equal(tt.expected[i].Start) || !gap.End.Equal(tt.expected[i].End) {
+					t.Errorf("gaps[%d] = %v - %v, expected %v - %v", i, gap.Start, gap.End, tt.expected[i].Start, tt.expected[i].End)
+				}
+			}
+		})
+	}
+}
+
+func TestListLots(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("d2", "Dresden"), testutil.NewLot("b1", "Basel"), testutil.NewLot("d1", "Dresden"))
+
+	lots, err := database.ListLots(db, "Dresden")
+	if err != nil {
+		t.Fatalf("ListLots() error: %v", err)
+	}
+	if len(lots) != 2 || lots[0].ID != "d1" || lots[1].ID != "d2" {
+		t.Errorf("Expected Dresden lots d1, d2, got %+v", lots)
+	}
+
+	all, err := database.ListLots(db, "")
+	if err != nil {
+		t.Fatalf("ListLots() error: %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("Expected 3 lots, got %d", len(all))
+	}
+}
+
+func TestLotsByRegion(t *testing.T) {
+	db := testutil.NewDB(t)
+	neustadt := testutil.NewLot("d2", "Dresden")
+	neustadt.Region.String = "Neustadt"
+	unassigned := testutil.NewLot("d3", "Dresden")
+	unassigned.Region.Valid = false
+	testutil.InsertLots(t, db, testutil.NewLot("d1", "Dresden"), neustadt, unassigned, testutil.NewLot("b1", "Basel"))
+
+	regions, err := database.GetRegions(db, "Dresden")
+	if err != nil {
+		t.Fatalf("GetRegions() error: %v", err)
+	}
+	if len(regions) != 2 || regions[0] != "Innere Altstadt" || regions[1] != "Neustadt" {
+		t.Errorf("got regions %v, expected [Innere Altstadt Neustadt]", regions)
+	}
+
+	lots, err := database.GetLotsByRegion(db, "Dresden", "Innere Altstadt")
+	if err != nil {
+		t.Fatalf("GetLotsByRegion() error: %v", err)
+	}
+	if len(lots) != 1 || lots[0].ID != "d1" {
+		t.Errorf("got %+v, expected only d1", lots)
+	}
+}
+
+func TestSummarizeOccupancyExcludesClosed(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	closed := testutil.NewReading("lot1", "Dresden", base.Add(10*time.Minute), 0)
+	closed.State = database.StateClosed
+	nodata := testutil.NewReading("lot1", "Dresden", base.Add(15*time.Minute), 0)
+	nodata.State = database.StateNoData
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("lot1", "Dresden", base, 40),
+		testutil.NewReading("lot1", "Dresden", base.Add(5*time.Minute), 60),
+		closed,
+		nodata,
+	)
+
+	points, err := database.GetOccupancyForLot(db, "lot1", base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetOccupancyForLot() error: %v", err)
+	}
+
+	summary := database.SummarizeOccupancy(points)
+	if summary.Samples != 2 || summary.Closed != 1 || summary.NoData != 1 {
+		t.Errorf("Expected 2 samples, 1 closed, 1 no data, got %+v", summary)
+	}
+	// Counting the closures as full would give a mean of 25 free
+	if summary.MeanFree != 50 {
+		t.Errorf("Expected mean free 50, got %v", summary.MeanFree)
+	}
+	if summary.MeanOccupancy != 0.5 {
+		t.Errorf("Expected mean occupancy 0.5, got %v", summary.MeanOccupancy)
+	}
+}
+
+func TestGetReadingsPage(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("lot1", "Dresden", base, 10),
+		testutil.NewReading("lot1", "Dresden", base.Add(5*time.Minute), 20),
+		// Same timestamp as the previous reading; ordered by ID
+		testutil.NewReading("lot1", "Dresden", base.Add(5*time.Minute), 21),
+		testutil.NewReading("lot1", "Dresden", base.Add(10*time.Minute), 30),
+		testutil.NewReading("lot1", "Dresden", base.Add(15*time.Minute), 40),
+	)
+
+	var got []int
+	var cursor *database.Cursor
+	pages := 0
+	for {
+		page, err := database.GetReadingsPage(db, "lot1", base, base.Add(time.Hour), cursor, 2)
+		if err != nil {
+			t.Fatalf("GetReadingsPage() error: %v", err)
+		}
+		pages++
+		for _, r := range page.Readings {
+			got = append(got, r.Free)
+		}
+		if page.Next == nil {
+			break
+		}
+
+		// Round-trip the cursor like an HTTP client would
+		parsed, err := database.ParseCursor(page.Next.String())
+		if err != nil {
+			t.Fatalf("ParseCursor() error: %v", err)
+		}
+		cursor = &parsed
+	}
+
+	expected := []int{10, 20, 21, 30, 40}
+	if len(got) != len(expected) {
+		t.Fatalf("got %v, expected %v", got, expected)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("got %v, expected %v", got, expected)
+			break
+		}
+	}
+	if pages != 3 {
+		t.Errorf("got %d pages, expected 3", pages)
+	}
+
+	if _, err := database.ParseCursor("not-a-cursor"); !errors.Is(err, database.ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestQueriesExcludeAnomalousReadings(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	glitch := testutil.NewReading("lot1", "Dresden", base.Add(5*time.Minute), 500)
+	glitch.Anomalous = true
+	testutil.InsertReadings(t, db, testutil.NewReading("lot1", "Dresden", base, 10), glitch)
+
+	readings, err := database.GetReadingsForLot(db, "lot1", base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetReadingsForLot() error: %v", err)
+	}
+	if len(readings) != 1 || readings[0].Free != 10 {
+		t.Errorf("got %+v, expected only the valid reading", readings)
+	}
+
+	points, err := database.GetOccupancyForLot(db, "lot1", base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetOccupancyForLot() error: %v", err)
+	}
+	if len(points) != 1 {
+		t.Errorf("got %d occupancy points, expected 1", len(points))
+	}
+
+	page, err := database.GetReadingsPage(db, "lot1", base, base.Add(time.Hour), nil, 10)
+	if err != nil {
+		t.Fatalf("GetReadingsPage() error: %v", err)
+	}
+	if len(page.Readings) != 2 || !page.Readings[1].Anomalous {
+		t.Errorf("Expected the page to include the flagged reading, got %+v", page.Readings)
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	db := testutil.NewDB(t)
+
+	stats, err := database.GetStats(db)
+	if err != nil {
+		t.Fatalf("GetStats() error: %v", err)
+	}
+	if stats.Lots != 0 || stats.Readings != 0 || !stats.EarliestReading.IsZero() {
+		t.Errorf("Expected empty stats, got %+v", stats)
+	}
+
+	testutil.InsertLots(t, db,
+		testutil.NewLot("d1", "Dresden"), testutil.NewLot("d2", "Dresden"), testutil.NewLot("l1", "Leipzig"))
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("d1", "Dresden", base.Add(time.Hour), 10),
+		testutil.NewReading("d2", "Dresden", base, 20),
+		testutil.NewReading("l1", "Leipzig", base.Add(2*time.Hour), 30),
+	)
+
+	stats, err = database.GetStats(db)
+	if err != nil {
+		t.Fatalf("GetStats() error: %v", err)
+	}
+	if stats.Lots != 3 || stats.Readings != 3 {
+		t.Errorf("got %d lots and %d readings, expected 3 and 3", stats.Lots, stats.Readings)
+	}
+	if len(stats.Cities) != 2 || stats.Cities[0] != (database.CityLotCount{City: "Dresden", Lots: 2}) {
+		t.Errorf("Unexpected per-city counts: %+v", stats.Cities)
+	}
+	if !stats.EarliestReading.Equal(base) || !stats.LatestReading.Equal(base.Add(2*time.Hour)) {
+		t.Errorf("got range %v to %v, expected %v to %v",
+			stats.EarliestReading, stats.LatestReading, base, base.Add(2*time.Hour))
+	}
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// DefaultRebuildBatchLots is how many lots RebuildDerived recomputes per
+// transaction
+const DefaultRebuildBatchLots = 100
+
+// derivedTable is a table computed from parking_lots and parking_readings
+type derivedTable struct {
+	name string
+	// rebuild recomputes the rows of the lots with from < id <= to
+	rebuild func(ctx context.Context, tx *sql.Tx, from, to string) error
+}
+
+// derivedTables are the tables RebuildDerived recomputes. reading_aggregates
+// is not among them: Downsample deletes the raw readings it is built from.
+var derivedTables = []derivedTable{
+	{name: "latest_readings", rebuild: rebuildLatestReadings},
+	{name: "lot_capacity_history", rebuild: rebuildCapacityHistory},
+}
+
+// rebuildLatestReadings replaces the latest_readings rows of a range of
+// lots with their newest reading
+func rebuildLatestReadings(ctx context.Context, tx *sql.Tx, from, to string) error {
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM latest_readings WHERE lot_id > ? AND lot_id <= ?
+	`, from, to); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO latest_readings (lot_id, city, timestamp, free, state, anomalous, last_reported)
+	`+latestReadingsSQL("WHERE lot_id > ? AND lot_id <= ?"), from, to)
+	return err
+}
+
+// rebuildCapacityHistory gives lots in a range that have no capacity
+// history their current capacity from their first reading on. Readings
+// don't record capacity, so existing history is kept as it is.
+func rebuildCapacityHistory(ctx context.Context, tx *sql.Tx, from, to string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO lot_capacity_history (lot_id, total, effective_from)
+		SELECT l.id, l.total, COALESCE(
+			(SELECT MIN(r.timestamp) FROM parking_readings r WHERE r.lot_id = l.id),
+			l.created_at)
+		FROM parking_lots l
+		WHERE l.id > ? AND l.id <= ?
+			AND NOT EXISTS (SELECT 1 FROM lot_capacity_history h WHERE h.lot_id = l.id)
+	`, from, to)
+	return err
+}
+
+// RebuildProgress reports how far RebuildDerived got with a table
+type RebuildProgress struct {
+	Table string
+	// Lots is the number of lots done so far, including those of an
+	// earlier, interrupted run
+	Lots  int
+	Total int
+}
+
+// RebuildResult is a derived table's row count after RebuildDerived
+type RebuildResult struct {
+	Table string
+	Rows  int64
+}
+
+// RebuildDerived recomputes every derived table from parking_lots and
+// parking_readings, batchLots lots per transaction (DefaultRebuildBatchLots
+// when not positive). progress, if not nil, is called after each batch.
+//
+// Each batch records a checkpoint in rebuild_progress in the same
+// transaction, so an interrupted run resumes after the last completed
+// batch. Rebuilding a lot's rows is idempotent, so repeating a batch or
+// the whole rebuild is harmless.
+func RebuildDerived(ctx context.Context, db *sql.DB, batchLots int, progress func(RebuildProgress)) ([]RebuildResult, error) {
+	if batchLots <= 0 {
+		batchLots = DefaultRebuildBatchLots
+	}
+
+	var results []RebuildResult
+	for _, table := range derivedTables {
+		if err := rebuildTable(ctx, db, table, batchLots, progress); err != nil {
+			return results, fmt.Errorf("failed to rebuild %s: %w", table.name, err)
+		}
+		var rows int64
+		if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table.name).Scan(&rows); err != nil {
+			return results, fmt.Errorf("failed to count %s: %w", table.name, err)
+		}
+		results = append(results, RebuildResult{Table: table.name, Rows: rows})
+	}
+	return results, nil
+}
+
+// rebuildTable recomputes one derived table in batches, starting after its
+// checkpoint, and clears the checkpoint once every lot is done
+func rebuildTable(ctx context.Context, db *sql.DB, table derivedTable, batchLots int, progress func(RebuildProgress)) error {
+	var last string
+	err := db.QueryRowContext(ctx, `SELECT last_lot_id FROM rebuild_progress WHERE target = ?`, table.name).Scan(&last)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var p RebuildProgress
+	p.Table = table.name
+	if err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(CASE WHEN id <= ? THEN 1 END) FROM parking_lots
+	`, last).Scan(&p.Total, &p.Lots); err != nil {
+		return fmt.Errorf("failed to count lots: %w", err)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var to sql.NullString
+		var lots int
+		if err := db.QueryRowContext(ctx, `
+			SELECT MAX(id), COUNT(*) FROM (
+				SELECT id FROM parking_lots WHERE id > ? ORDER BY id LIMIT ?
+			)
+		`, last, batchLots).Scan(&to, &lots); err != nil {
+			return fmt.Errorf("failed to find lots after %q: %w", last, err)
+		}
+		if lots == 0 {
+			break
+		}
+
+		if err := rebuildBatch(ctx, db, table, last, to.String); err != nil {
+			return fmt.Errorf("failed to rebuild lots after %q: %w", last, err)
+		}
+		last = to.String
+		p.Lots += lots
+		if progress != nil {
+			progress(p)
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM rebuild_progress WHERE target = ?`, table.name); err != nil {
+		return fmt.Errorf("failed to clear checkpoint: %w", err)
+	}
+	return nil
+}
+
+// rebuildBatch recomputes the lots with from < id <= to and moves the
+// checkpoint to to in one transaction
+func rebuildBatch(ctx context.Context, db *sql.DB, table derivedTable, from, to string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := table.rebuild(ctx, tx, from, to); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO rebuild_progress (target, last_lot_id) VALUES (?, ?)
+		ON CONFLICT(target) DO UPDATE SET last_lot_id = excluded.last_lot_id
+	`, table.name, to); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,67 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestRebuildDerived(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db,
+		testutil.NewLot("d1", "Dresden"), testutil.NewLot("d2", "Dresden"), testutil.NewLot("l1", "Leipzig"))
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("d1", "Dresden", base, 10),
+		testutil.NewReading("d1", "Dresden", base.Add(time.Minute), 11),
+		testutil.NewReading("d2", "Dresden", base, 20),
+		testutil.NewReading("l1", "Leipzig", base, 30))
+
+	// A lot whose history was lost gets its current capacity back
+	if _, err := db.Exec(`DELETE FROM lot_capacity_history WHERE lot_id = 'd2'`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	var progress []database.RebuildProgress
+	results, err := database.RebuildDerived(ctx, db, 2, func(p database.RebuildProgress) {
+		progress = append(progress, p)
+	})
+	if err != nil {
+		t.Fatalf("RebuildDerived() error: %v", err)
+	}
+	checkLatestMatchesHistory(t, db, map[string]int{"d1": 11, "d2": 20, "l1": 30})
+
+	expected := []database.RebuildResult{{Table: "latest_readings", Rows: 3}, {Table: "lot_capacity_history", Rows: 3}}
+	if len(results) != len(expected) || results[0] != expected[0] || results[1] != expected[1] {
+		t.Errorf("got %v, expected %v", results, expected)
+	}
+	if len(progress) != 4 || progress[1] != (database.RebuildProgress{Table: "latest_readings", Lots: 3, Total: 3}) {
+		t.Errorf("got progress %v, expected two batches of each table", progress)
+	}
+	if count := testutil.CountRows(t, db, "rebuild_progress"); count != 0 {
+		t.Errorf("got %d checkpoints left, expected 0", count)
+	}
+
+	// An interrupted run resumes after its checkpoint; running again
+	// changes nothing
+	if _, err := db.Exec(`INSERT INTO rebuild_progress (target, last_lot_id) VALUES ('latest_readings', 'd2')`); err != nil {
+		t.Fatal(err)
+	}
+	progress = nil
+	if results, err = database.RebuildDerived(ctx, db, 2, func(p database.RebuildProgress) {
+		progress = append(progress, p)
+	}); err != nil {
+		t.Fatalf("RebuildDerived() error: %v", err)
+	}
+	if len(progress) != 3 || progress[0] != (database.RebuildProgress{Table: "latest_readings", Lots: 3, Total: 3}) {
+		t.Errorf("got progress %v, expected latest_readings to resume with l1", progress)
+	}
+	if len(results) != len(expected) || results[0] != expected[0] || results[1] != expected[1] {
+		t.Errorf("got %v, expected %v", results, expected)
+	}
+	checkLatestMatchesHistory(t, db, map[string]int{"d1": 11, "d2": 20, "l1": 30})
+}
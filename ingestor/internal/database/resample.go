@@ -0,0 +1,50 @@
+package database
+
+import "time"
+
+// Sample is a value on a regular time grid. Valid is false when no reading
+// was recent enough to carry forward, i.e. the grid point falls in a gap.
+type Sample struct {
+	Timestamp time.Time
+	Free      int
+	State     string
+	Valid     bool
+}
+
+// ResampleReadings maps readings, sorted oldest first, onto a grid of
+// multiples of step between the first and last reading. Each grid point
+// carries forward the latest reading at or before it; points where that
+// reading is older than maxAge are left invalid (maxAge 0 = no limit).
+// The readings are not modified.
+func ResampleReadings(readings []ParkingReading, step, maxAge time.Duration) []Sample {
+	if len(readings) == 0 || step <= 0 {
+		return nil
+	}
+
+	first := readings[0].Timestamp
+	last := readings[len(readings)-1].Timestamp
+
+	// Align to step boundaries so series of different lots line up
+	start := first.Truncate(step)
+	if start.Before(first) {
+		start = start.Add(step)
+	}
+
+	var samples []Sample
+	idx := 0
+	for t := start; !t.After(last); t = t.Add(step) {
+		for idx+1 < len(readings) && !readings[idx+1].Timestamp.After(t) {
+			idx++
+		}
+
+		sample := Sample{Timestamp: t}
+		current := readings[idx]
+		if maxAge <= 0 || t.Sub(current.Timestamp) <= maxAge {
+			sample.Free = current.Free
+			sample.State = current.State
+			sample.Valid = true
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
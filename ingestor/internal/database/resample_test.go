@@ -0,0 +1,73 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResampleReadings(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(offset time.Duration, free int) ParkingReading {
+		return ParkingReading{Timestamp: base.Add(offset), Free: free, State: "open"}
+	}
+
+	type point struct {
+		offset time.Duration
+		free   int
+		valid  bool
+	}
+
+	tests := []struct {
+		name     string
+		readings []ParkingReading
+		step     time.Duration
+		maxAge   time.Duration
+		expected []point
+	}{
+		{
+			name:     "jittered readings align to step boundaries",
+			readings: []ParkingReading{at(40*time.Second, 10), at(5*time.Minute+20*time.Second, 20), at(10*time.Minute+5*time.Second, 30)},
+			step:     5 * time.Minute,
+			expected: []point{{5 * time.Minute, 10, true}, {10 * time.Minute, 20, true}},
+		},
+		{
+			name:     "reading on a boundary is used at that boundary",
+			readings: []ParkingReading{at(0, 10), at(5*time.Minute, 20)},
+			step:     5 * time.Minute,
+			expected: []point{{0, 10, true}, {5 * time.Minute, 20, true}},
+		},
+		{
+			name:     "gaps beyond max age are invalid",
+			readings: []ParkingReading{at(0, 10), at(30*time.Minute, 40)},
+			step:     10 * time.Minute,
+			maxAge:   15 * time.Minute,
+			expected: []point{{0, 10, true}, {10 * time.Minute, 10, true}, {20 * time.Minute, 0, false}, {30 * time.Minute, 40, true}},
+		},
+		{
+			name:     "no max age carries values across gaps",
+			readings: []ParkingReading{at(0, 10), at(30*time.Minute, 40)},
+			step:     10 * time.Minute,
+			expected: []point{{0, 10, true}, {10 * time.Minute, 10, true}, {20 * time.Minute, 10, true}, {30 * time.Minute, 40, true}},
+		},
+		{
+			name:     "no readings",
+			step:     time.Minute,
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := ResampleReadings(tt.readings, tt.step, tt.maxAge)
+			if len(samples) != len(tt.expected) {
+				t.Fatalf("got %d samples, expected %d: %+v", len(samples), len(tt.expected), samples)
+			}
+			for i, p := range tt.expected {
+				s := samples[i]
+				if !s.Timestamp.Equal(base.Add(p.offset)) || s.Valid != p.valid || (p.valid && s.Free != p.free) {
+					t.Errorf("sample %d: got %+v, expected %v free=%d valid=%v", i, s, base.Add(p.offset), p.free, p.valid)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SchemaError lists every difference between a database and the schema the
+// migrations produce
+type SchemaError struct {
+	Problems []string
+}
+
+func (e *SchemaError) Error() string {
+	return "schema verification failed: " + strings.Join(e.Problems, "; ")
+}
+
+// schema is the introspected shape of a database
+type schema struct {
+	// tables maps table name to column name to declared type
+	tables map[string]map[string]string
+	// indexes maps index name to the table it belongs to
+	indexes map[string]string
+}
+
+// VerifySchema checks that every table, column and index created by the
+// migrations exists in db with the expected column types. Extra tables,
+// columns and indexes are allowed. All discrepancies are reported together
+// in a *SchemaError.
+func VerifySchema(db *sql.DB) error {
+	expected, err := expectedSchema()
+	if err != nil {
+		return fmt.Errorf("failed to build expected schema: %w", err)
+	}
+	actual, err := readSchema(db)
+	if err != nil {
+		return fmt.Errorf("failed to read schema: %w", err)
+	}
+
+	var problems []string
+	for table, columns := range expected.tables {
+		actualColumns, ok := actual.tables[table]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("missing table %s", table))
+			continue
+		}
+		for column, typ := range columns {
+			actualType, ok := actualColumns[column]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("missing column %s.%s", table, column))
+			case !strings.EqualFold(actualType, typ):
+				problems = append(problems, fmt.Sprintf("column %s.%s has type %s, expected %s", table, column, actualType, typ))
+			}
+		}
+	}
+	for index, table := range expected.indexes {
+		actualTable, ok := actual.indexes[index]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("missing index %s on %s", index, table))
+		case actualTable != table:
+			problems = append(problems, fmt.Sprintf("index %s is on %s, expected %s", index, actualTable, table))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return &SchemaError{Problems: problems}
+	}
+	return nil
+}
+
+// expectedSchema migrates a scratch in-memory database and introspects it,
+// so the expected schema always matches the migrations
+func expectedSchema() (*schema, error) {
+	db, err := sql.Open("sqlite3", MemoryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if err := Migrate(db); err != nil {
+		return nil, err
+	}
+	return readSchema(db)
+}
+
+// readSchema lists the tables, columns and indexes of db from sqlite_master
+func readSchema(db *sql.DB) (*schema, error) {
+	rows, err := db.Query(`
+		SELECT type, name, tbl_name FROM sqlite_master
+		WHERE type IN ('table', 'index') AND name NOT LIKE 'sqlite_%'
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	s := &schema{tables: make(map[string]map[string]string), indexes: make(map[string]string)}
+	for rows.Next() {
+		var typ, name, table string
+		if err := rows.Scan(&typ, &name, &table); err != nil {
+			return nil, err
+		}
+		if typ == "index" {
+			s.indexes[name] = table
+		} else {
+			s.tables[name] = nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for table := range s.tables {
+		columns, err := readColumns(db, table)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+		}
+		s.tables[table] = columns
+	}
+	return s, nil
+}
+
+// readColumns returns the declared type of every column in table
+func readColumns(db *sql.DB, table string) (map[string]string, error) {
+	rows, err := db.Query(`SELECT name, type FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]string)
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, err
+		}
+		columns[name] = typ
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestVerifySchemaMigrated(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate() error: %v", err)
+	}
+
+	if err := VerifySchema(db); err != nil {
+		t.Errorf("VerifySchema() error: %v", err)
+	}
+}
+
+func TestVerifySchemaDrift(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate() error: %v", err)
+	}
+
+	for _, stmt := range []string{
+		`DROP INDEX idx_readings_lot_id`,
+		`DROP TABLE lot_capacity_history`,
+		`ALTER TABLE parking_lots DROP COLUMN address`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	err := VerifySchema(db)
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("Expected *SchemaError, got %v", err)
+	}
+
+	expected := []string{
+		"missing column parking_lots.address",
+		"missing index idx_capacity_lot_effective on lot_capacity_history",
+		"missing index idx_readings_lot_id on parking_readings",
+		"missing table lot_capacity_history",
+	}
+	if !reflect.DeepEqual(schemaErr.Problems, expected) {
+		t.Errorf("Problems got %v, expected %v", schemaErr.Problems, expected)
+	}
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+)
+
+// searchFolder maps accented letters to their base letter, so a query
+// typed without them still matches
+var searchFolder = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a",
+	"è", "e", "é", "e", "ê", "e", "ë", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u",
+	"ç", "c", "ñ", "n", "ß", "ss",
+)
+
+// umlautFolder maps the German transliterations of umlauts to their base
+// letter, after searchFolder, so "Muenz", "Münz" and "Munz" are the same
+var umlautFolder = strings.NewReplacer("ae", "a", "oe", "o", "ue", "u")
+
+// folded is a string normalized for case- and accent-insensitive matching,
+// once as is and once with umlautFolder applied. Both are kept because
+// umlautFolder also folds letters that are no transliteration, e.g. the
+// "ue" of "Quelle", which "elle" must still match.
+type folded struct {
+	plain   string
+	umlauts string
+}
+
+// foldSearch normalizes s for matching
+func foldSearch(s string) folded {
+	plain := searchFolder.Replace(strings.ToLower(strings.TrimSpace(s)))
+	return folded{plain: plain, umlauts: umlautFolder.Replace(plain)}
+}
+
+// matches reports whether match holds for s and sub in either form
+func (s folded) matches(sub folded, match func(s, sub string) bool) bool {
+	return match(s.plain, sub.plain) || match(s.umlauts, sub.umlauts)
+}
+
+// hasWordPrefix reports whether a word of s starts with prefix
+func hasWordPrefix(s, prefix string) bool {
+	return strings.Contains(" "+s, " "+prefix)
+}
+
+// Match ranks of SearchLots results, best first
+const (
+	matchNamePrefix = iota
+	matchWordPrefix
+	matchName
+	matchAddress
+)
+
+// SearchLots returns up to limit lots of every city whose name, or failing
+// that address, contains query. Matching ignores case and accents and
+// treats "ae", "oe", "ue" and "ss" like "ä", "ö", "ü" and "ß". Names
+// starting with the query come first, then names with a word starting
+// with it, then other name matches and finally address matches, each by
+// name. A blank query or non-positive limit returns no lots.
+//
+// Lots are matched in memory rather than with LIKE, whose case folding
+// only covers ASCII, or FTS5, which the SQLite driver is built without by
+// default; a database holds a few thousand lots at most.
+func SearchLots(db *sql.DB, query string, limit int) ([]ParkingLot, error) {
+	needle := foldSearch(query)
+	if needle.plain == "" || limit <= 0 {
+		return []ParkingLot{}, nil
+	}
+
+	lots, err := ListLots(db, "")
+	if err != nil {
+		return nil, err
+	}
+
+	type match struct {
+		lot  ParkingLot
+		rank int
+	}
+	var matches []match
+	for _, lot := range lots {
+		if rank, ok := matchLot(lot, needle); ok {
+			matches = append(matches, match{lot, rank})
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool {
+		if matches[a].rank != matches[b].rank {
+			return matches[a].rank < matches[b].rank
+		}
+		return matches[a].lot.Name < matches[b].lot.Name
+	})
+
+	result := make([]ParkingLot, 0, min(limit, len(matches)))
+	for _, m := range matches[:min(limit, len(matches))] {
+		result = append(result, m.lot)
+	}
+	return result, nil
+}
+
+// matchLot reports whether a lot matches the folded needle, and how well
+func matchLot(lot ParkingLot, needle folded) (int, bool) {
+	name := foldSearch(lot.Name)
+	switch {
+	case name.matches(needle, strings.HasPrefix):
+		return matchNamePrefix, true
+	case name.matches(needle, hasWordPrefix):
+		return matchWordPrefix, true
+	case name.matches(needle, strings.Contains):
+		return matchName, true
+	case lot.Address.Valid && foldSearch(lot.Address.String).matches(needle, strings.Contains):
+		return matchAddress, true
+	}
+	return 0, false
+}
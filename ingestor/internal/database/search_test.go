@@ -0,0 +1,74 @@
+package database_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestSearchLots(t *testing.T) {
+	db := testutil.NewDB(t)
+	lot := func(id, city, name, address string) *database.ParkingLot {
+		l := testutil.NewLot(id, city)
+		l.Name = name
+		l.Address = sql.NullString{String: address, Valid: address != ""}
+		return l
+	}
+	testutil.InsertLots(t, db,
+		lot("centrum", "Dresden", "Centrum-Galerie", "Prager Straße"),
+		lot("altmarkt", "Dresden", "Altmarkt", ""),
+		lot("galerie", "Dresden", "Galerie Neustadt", ""),
+		lot("hbf", "Leipzig", "Hauptbahnhof Ost", "Straße des 18. Oktober"),
+		lot("groessel", "Leipzig", "Größelstraße", ""),
+		lot("quelle", "Leipzig", "An der Quelle", ""),
+		lot("bauer", "Leipzig", "Bauerngasse", ""),
+	)
+
+	tests := []struct {
+		query    string
+		limit    int
+		expected []string
+	}{
+		// Name prefix, then word prefix, then substring
+		{"galerie", 10, []string{"galerie", "centrum"}},
+		{"ALTMARKT", 10, []string{"altmarkt"}},
+		// Umlauts, ß and their transliterations
+		{"grössel", 10, []string{"groessel"}},
+		{"groessel", 10, []string{"groessel"}},
+		{"grossel", 10, []string{"groessel"}},
+		// Letters that only look like a transliteration
+		{"elle", 10, []string{"quelle"}},
+		{"quelle", 10, []string{"quelle"}},
+		{"bauer", 10, []string{"bauer"}},
+		{"erngasse", 10, []string{"bauer"}},
+		// Names before addresses
+		{"strasse", 10, []string{"groessel", "centrum", "hbf"}},
+		{"strasse", 2, []string{"groessel", "centrum"}},
+		{"zwinger", 10, []string{}},
+		{"  ", 10, []string{}},
+		{"galerie", 0, []string{}},
+	}
+
+	for _, tt := range tests {
+		lots, err := database.SearchLots(db, tt.query, tt.limit)
+		if err != nil {
+			t.Fatalf("SearchLots(%q) error: %v", tt.query, err)
+		}
+		ids := []string{}
+		for _, l := range lots {
+			ids = append(ids, l.ID)
+		}
+		if len(ids) != len(tt.expected) {
+			t.Errorf("SearchLots(%q, %d): got %v, expected %v", tt.query, tt.limit, ids, tt.expected)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.expected[i] {
+				t.Errorf("SearchLots(%q, %d): got %v, expected %v", tt.query, tt.limit, ids, tt.expected)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// snapshotVersion is bumped whenever Snapshot changes incompatibly
+const snapshotVersion = 1
+
+// Snapshot is the current state of a database: city and lot metadata and
+// each lot's latest reading, without history. It bootstraps development
+// and test databases; use the exports for historical data.
+type Snapshot struct {
+	Version   int
+	CreatedAt time.Time
+	Cities    []City
+	Lots      []ParkingLot
+	Readings  []ParkingReading
+}
+
+// TakeSnapshot reads the current state of db
+func TakeSnapshot(ctx context.Context, db *sql.DB) (*Snapshot, error) {
+	snapshot := &Snapshot{Version: snapshotVersion, CreatedAt: time.Now().UTC()}
+
+	var err error
+	if snapshot.Cities, err = GetCities(db); err != nil {
+		return nil, fmt.Errorf("failed to read cities: %w", err)
+	}
+	if snapshot.Lots, err = ListLots(db, ""); err != nil {
+		return nil, fmt.Errorf("failed to read lots: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT lot_id, city, timestamp, free, state, anomalous FROM (
+			SELECT *, ROW_NUMBER() OVER (
+				PARTITION BY lot_id ORDER BY timestamp DESC, id DESC
+			) AS position
+			FROM parking_readings
+		) WHERE position = 1
+		ORDER BY lot_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read latest readings: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r ParkingReading
+		if err := rows.Scan(&r.LotID, &r.City, &r.Timestamp, &r.Free, &r.State, &r.Anomalous); err != nil {
+			return nil, err
+		}
+		snapshot.Readings = append(snapshot.Readings, r)
+	}
+	return snapshot, rows.Err()
+}
+
+// WriteSnapshot encodes snapshot as gzip-compressed gob
+func WriteSnapshot(w io.Writer, snapshot *Snapshot) error {
+	zw := gzip.NewWriter(w)
+	if err := gob.NewEncoder(zw).Encode(snapshot); err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	return zw.Close()
+}
+
+// ReadSnapshot decodes a snapshot written by WriteSnapshot
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	defer zr.Close()
+
+	var snapshot Snapshot
+	if err := gob.NewDecoder(zr).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d, expected %d", snapshot.Version, snapshotVersion)
+	}
+	return &snapshot, nil
+}
+
+// ErrNotEmpty is returned by RestoreSnapshot for a database that already
+// stores lots
+var ErrNotEmpty = errors.New("database is not empty")
+
+// RestoreSnapshot writes snapshot into db, which must not store any lots
+// yet, in one transaction. Lots keep their first and last seen times.
+func RestoreSnapshot(ctx context.Context, db *sql.DB, snapshot *Snapshot) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var lots int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM parking_lots`).Scan(&lots); err != nil {
+		return err
+	}
+	if lots > 0 {
+		return fmt.Errorf("%w: it stores %d lots", ErrNotEmpty, lots)
+	}
+
+	for idx := range snapshot.Cities {
+		if err := upsertCity(ctx, tx, &snapshot.Cities[idx]); err != nil {
+			return err
+		}
+	}
+	for idx := range snapshot.Lots {
+		lot := &snapshot.Lots[idx]
+		lot.ObservedAt = lot.FirstSeenAt
+		if err := upsertParkingLot(ctx, tx, lot); err != nil {
+			return fmt.Errorf("failed to restore lot %s: %w", lot.ID, err)
+		}
+		if !lot.LastSeenAt.IsZero() {
+			if err := markLotsSeen(ctx, tx, []string{lot.ID}, lot.LastSeenAt, defaultVariableLimit); err != nil {
+				return fmt.Errorf("failed to restore lot %s: %w", lot.ID, err)
+			}
+		}
+	}
+	for idx := range snapshot.Readings {
+		if err := insertReading(ctx, tx, &snapshot.Readings[idx]); err != nil {
+			return fmt.Errorf("failed to restore reading of %s: %w", snapshot.Readings[idx].LotID, err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,78 @@
+package database_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	src := testutil.NewDB(t)
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	if err := database.UpsertCity(src, &database.City{ID: "Dresden", Name: "Dresden"}); err != nil {
+		t.Fatal(err)
+	}
+	lot := testutil.NewLot("d1", "Dresden")
+	lot.ObservedAt = base
+	testutil.InsertLots(t, src, lot, testutil.NewLot("d2", "Dresden"))
+	testutil.InsertReadings(t, src,
+		testutil.NewReading("d1", "Dresden", base, 10),
+		testutil.NewReading("d1", "Dresden", base.Add(5*time.Minute), 20),
+		testutil.NewReading("d2", "Dresden", base, 30),
+	)
+
+	snapshot, err := database.TakeSnapshot(ctx, src)
+	if err != nil {
+		t.Fatalf("TakeSnapshot() error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := database.WriteSnapshot(&buf, snapshot); err != nil {
+		t.Fatalf("WriteSnapshot() error: %v", err)
+	}
+	if snapshot, err = database.ReadSnapshot(&buf); err != nil {
+		t.Fatalf("ReadSnapshot() error: %v", err)
+	}
+
+	dst := testutil.NewDB(t)
+	if err := database.RestoreSnapshot(ctx, dst, snapshot); err != nil {
+		t.Fatalf("RestoreSnapshot() error: %v", err)
+	}
+
+	if diff, err := database.DiffLots(src, dst, ""); err != nil || !diff.Empty() {
+		t.Errorf("Expected restored lots to match, got %+v (err %v)", diff, err)
+	}
+	lots, err := database.ListLots(dst, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lots[0].FirstSeenAt.Equal(base) {
+		t.Errorf("got first seen %v, expected %v", lots[0].FirstSeenAt, base)
+	}
+	cities, err := database.GetCities(dst)
+	if err != nil || len(cities) != 1 {
+		t.Errorf("got cities %+v (err %v), expected Dresden", cities, err)
+	}
+
+	// Only the latest reading of each lot is restored
+	if count := testutil.CountRows(t, dst, "parking_readings"); count != 2 {
+		t.Errorf("got %d readings, expected 2", count)
+	}
+	readings, err := database.GetReadingsForLot(dst, "d1", base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 1 || readings[0].Free != 20 {
+		t.Errorf("got %+v, expected the reading with 20 free", readings)
+	}
+
+	if err := database.RestoreSnapshot(ctx, dst, snapshot); !errors.Is(err, database.ErrNotEmpty) {
+		t.Errorf("got error %v restoring twice, expected ErrNotEmpty", err)
+	}
+}
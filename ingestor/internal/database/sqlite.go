@@ -1,12 +1,18 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/niklas/parkmonitor/ingestor/internal/backoff"
 )
 
 // ParkingLot represents a parking lot/garage
@@ -20,9 +26,23 @@ type ParkingLot struct {
 	Latitude  sql.NullFloat64
 	Longitude sql.NullFloat64
 	Region    sql.NullString
+
+	// Extras is a JSON object with lot fields the API sent beyond the
+	// columns above; NULL when there were none
+	Extras sql.NullString
+
+	// ObservedAt is when this version of the lot was fetched. It dates
+	// capacity changes and sightings; the zero value means now.
+	ObservedAt time.Time
+
+	// FirstSeenAt and LastSeenAt are when the lot first and last appeared
+	// in a fetch. They are read from the database and ignored on writes.
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
 }
 
-// ParkingReading represents a snapshot of parking availability
+// ParkingReading represents a snapshot of parking availability. Timestamp
+// is stored and returned in UTC, whatever its location.
 type ParkingReading struct {
 	ID        int64
 	LotID     string
@@ -30,138 +50,344 @@ type ParkingReading struct {
 	Timestamp time.Time
 	Free      int
 	State     string
+
+	// Anomalous marks impossible values, such as more free spaces than the
+	// lot has. They are stored as reported but left out of analysis.
+	Anomalous bool
+
+	// SourceInstance identifies the ingestor that wrote the reading, e.g.
+	// in HA setups; empty is stored as NULL
+	SourceInstance string
+
+	// Quality flags why the reading may be less trustworthy. Inserts add
+	// the flags implied by Anomalous and State.
+	Quality Quality
 }
 
-// InitDB initializes the SQLite database and creates tables if they don't exist
+// Lot states reported by the API besides "open"
+const (
+	StateClosed = "closed"
+	StateNoData = "nodata"
+)
+
+// IsAvailable reports whether a reading in this state describes a usable
+// lot. Closed lots and lots without data report free=0, which must not be
+// mistaken for a full lot in occupancy statistics.
+func IsAvailable(state string) bool {
+	return state != StateClosed && state != StateNoData
+}
+
+// MemoryPath opens a private in-memory database when passed to InitDB
+const MemoryPath = ":memory:"
+
+// InitDB opens the SQLite database, migrates it to the latest schema and
+// verifies the result
 func InitDB(dbPath string) (*sql.DB, error) {
-	// Ensure the directory exists
-	dir := filepath.Dir(dbPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	return InitDBWithPragmas(dbPath, nil)
+}
+
+// OpenReadOnly opens an existing database for inspection without migrating
+// or otherwise writing to it. It fails when the file does not exist or its
+// schema is older than LatestSchemaVersion.
+func OpenReadOnly(dbPath string) (*sql.DB, error) {
+	if _, err := os.Stat(dbPath); err != nil {
 		return nil, err
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
+	db := sql.OpenDB(newPragmaConnector("file:"+dbPath+"?mode=ro", nil))
+	version, err := appliedVersion(db)
 	if err != nil {
-		return nil, err
+		db.Close()
+		return nil, fmt.Errorf("failed to read schema version of %s: %w", dbPath, err)
 	}
+	if latest := LatestSchemaVersion(); version < latest {
+		db.Close()
+		return nil, fmt.Errorf("%s has schema version %d, expected %d; run the ingestor on it to migrate", dbPath, version, latest)
+	}
+	return db, nil
+}
 
-	// Create parking_lots table
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS parking_lots (
-			id TEXT PRIMARY KEY,
-			city TEXT NOT NULL,
-			name TEXT NOT NULL,
-			address TEXT,
-			lot_type TEXT,
-			total INTEGER NOT NULL,
-			latitude REAL,
-			longitude REAL,
-			region TEXT,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
+// InitError wraps every error returned by InitDB, so callers can tell a
+// database that cannot be opened, migrated or verified from other failures
+type InitError struct {
+	Path string
+	Err  error
+}
+
+func (e *InitError) Error() string { return e.Err.Error() }
+func (e *InitError) Unwrap() error { return e.Err }
+
+// InitDBWithPragmas is like InitDB but applies pragmas, e.g. cache_size or
+// mmap_size, to every connection. Each pragma must be allowlisted.
+func InitDBWithPragmas(dbPath string, pragmas []Pragma) (*sql.DB, error) {
+	db, err := initDB(dbPath, pragmas)
 	if err != nil {
-		return nil, err
+		return nil, &InitError{Path: dbPath, Err: err}
 	}
+	return db, nil
+}
 
-	// Create parking_readings table
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS parking_readings (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			lot_id TEXT NOT NULL,
-			city TEXT NOT NULL,
-			timestamp TIMESTAMP NOT NULL,
-			free INTEGER NOT NULL,
-			state TEXT NOT NULL,
-			FOREIGN KEY (lot_id) REFERENCES parking_lots(id)
-		)
-	`)
-	if err != nil {
-		return nil, err
+// Opening the database is retried with backoff, since its first access can
+// fail transiently, e.g. on a container volume that is mounted late
+var (
+	initAttempts = 5
+	initBackoff  = backoff.Policy{Base: 500 * time.Millisecond, Max: 5 * time.Second}
+	initSleep    = time.Sleep
+)
+
+func initDB(dbPath string, pragmas []Pragma) (*sql.DB, error) {
+	for _, p := range pragmas {
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
 	}
 
-	// Create index on timestamp for efficient queries
-	_, err = db.Exec(`
-		CREATE INDEX IF NOT EXISTS idx_readings_timestamp 
-		ON parking_readings(timestamp)
-	`)
-	if err != nil {
-		return nil, err
+	var db *sql.DB
+	for attempt := 1; ; attempt++ {
+		var err error
+		if db, err = openDB(dbPath, pragmas); err == nil {
+			break
+		}
+		var pathErr *fs.PathError
+		if !IsTransient(err) && !errors.As(err, &pathErr) {
+			return nil, err
+		}
+		if attempt == initAttempts {
+			return nil, fmt.Errorf("failed to open database after %d attempts: %w", attempt, err)
+		}
+		delay := initBackoff.Delay(attempt)
+		log.Printf("Warning: failed to open database %s (attempt %d/%d), retrying in %v: %v",
+			dbPath, attempt, initAttempts, delay, err)
+		initSleep(delay)
 	}
 
-	// Create index on lot_id for efficient queries
-	_, err = db.Exec(`
-		CREATE INDEX IF NOT EXISTS idx_readings_lot_id 
-		ON parking_readings(lot_id)
-	`)
-	if err != nil {
+	if len(pragmas) > 0 {
+		applied := make([]string, len(pragmas))
+		for idx, p := range pragmas {
+			applied[idx] = p.String()
+		}
+		log.Printf("Applied SQLite pragmas to %s: %s", dbPath, strings.Join(applied, ", "))
+	}
+
+	// Catch manual edits or half-applied changes before they fail an insert
+	if err := VerifySchema(db); err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	return db, nil
 }
 
+// openDB opens the database, checks that it can be reached and migrates
+// it. sql.OpenDB is lazy, so the ping is the first real access.
+func openDB(dbPath string, pragmas []Pragma) (*sql.DB, error) {
+	// Ensure the directory exists
+	if dbPath != MemoryPath {
+		dir := filepath.Dir(dbPath)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	db := sql.OpenDB(newPragmaConnector(dbPath, pragmas))
+
+	// Every connection to :memory: gets its own empty database, so pin the
+	// pool to a single connection
+	if dbPath == MemoryPath {
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+const upsertParkingLotSQL = `
+	INSERT INTO parking_lots (
+		id, city, name, address, lot_type, total,
+		latitude, longitude, region, extras, updated_at,
+		first_seen_at, last_seen_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?, ?)
+	ON CONFLICT(id) DO UPDATE SET
+		name = excluded.name,
+		address = excluded.address,
+		lot_type = excluded.lot_type,
+		total = excluded.total,
+		latitude = excluded.latitude,
+		longitude = excluded.longitude,
+		region = excluded.region,
+		extras = excluded.extras,
+		updated_at = CURRENT_TIMESTAMP,
+		first_seen_at = COALESCE(parking_lots.first_seen_at, excluded.first_seen_at),
+		last_seen_at = MAX(COALESCE(parking_lots.last_seen_at, ''), excluded.last_seen_at)
+`
+
+const insertReadingSQL = `
+	INSERT INTO parking_readings (lot_id, city, timestamp, free, state, anomalous, source_instance, quality)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// ErrLotCityConflict is returned when a lot ID is already stored for
+// another city. Lot IDs are the primary key, so the upsert would otherwise
+// overwrite the other city's lot.
+var ErrLotCityConflict = errors.New("lot ID already belongs to another city")
+
+// upsertParkingLot writes the lot and records its capacity in
+// lot_capacity_history when the lot is new or its total changed.
+// Callers must run it inside a transaction so both writes land together.
+func upsertParkingLot(ctx context.Context, e execer, lot *ParkingLot) error {
+	var previousCity string
+	var previousTotal int
+	err := e.QueryRowContext(ctx, `SELECT city, total FROM parking_lots WHERE id = ?`, lot.ID).Scan(&previousCity, &previousTotal)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err == nil && previousCity != lot.City {
+		return fmt.Errorf("%w: %s is stored for %s, not %s", ErrLotCityConflict, lot.ID, previousCity, lot.City)
+	}
+	capacityChanged := err != nil || previousTotal != lot.Total
+
+	observedAt := lot.ObservedAt
+	if observedAt.IsZero() {
+		observedAt = time.Now()
+	}
+	if _, err := e.ExecContext(ctx, upsertParkingLotSQL,
+		lot.ID, lot.City, lot.Name, lot.Address, normalizeLotType(lot.LotType),
+		lot.Total, lot.Latitude, lot.Longitude, lot.Region, lot.Extras,
+		observedAt.UTC(), observedAt.UTC()); err != nil {
+		return err
+	}
+
+	if !capacityChanged {
+		return nil
+	}
+
+	_, err = e.ExecContext(ctx, `
+		INSERT INTO lot_capacity_history (lot_id, total, effective_from)
+		VALUES (?, ?, ?)
+	`, lot.ID, lot.Total, observedAt.UTC())
+	return err
+}
+
+// markLotsSeen sets last_seen_at of the given lots to seenAt unless they
+// were already seen later, e.g. for lots whose unchanged metadata was not
+// upserted. IDs are split into statements of at most maxVars bound
+// variables.
+func markLotsSeen(ctx context.Context, e execer, ids []string, seenAt time.Time, maxVars int) error {
+	perStatement := max(maxVars-2, 1)
+	for len(ids) > 0 {
+		chunk := ids[:min(perStatement, len(ids))]
+		ids = ids[len(chunk):]
+
+		args := make([]any, 0, len(chunk)+2)
+		args = append(args, seenAt.UTC(), seenAt.UTC())
+		for _, id := range chunk {
+			args = append(args, id)
+		}
+		if _, err := e.ExecContext(ctx, `
+			UPDATE parking_lots SET last_seen_at = ?
+			WHERE (last_seen_at IS NULL OR last_seen_at < ?)
+				AND id IN (?`+strings.Repeat(", ?", len(chunk)-1)+`)
+		`, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readingColumns is the number of values insertReadingSQL binds per reading
+const readingColumns = 8
+
+func readingArgs(reading *ParkingReading) []any {
+	return []any{
+		reading.LotID, reading.City, reading.Timestamp.UTC(), reading.Free, reading.State, reading.Anomalous,
+		sql.NullString{String: reading.SourceInstance, Valid: reading.SourceInstance != ""}, reading.quality(),
+	}
+}
+
+func insertReading(ctx context.Context, e execer, reading *ParkingReading) error {
+	_, err := e.ExecContext(ctx, insertReadingSQL, readingArgs(reading)...)
+	return err
+}
+
+// insertReadings inserts readings with multi-row INSERTs of at most
+// batchRows rows each, so that a large city stays below SQLite's limit on
+// bound variables
+func insertReadings(ctx context.Context, e execer, readings []*ParkingReading, batchRows int) error {
+	batchRows = max(batchRows, 1)
+	for len(readings) > 0 {
+		chunk := readings[:min(batchRows, len(readings))]
+		readings = readings[len(chunk):]
+
+		args := make([]any, 0, len(chunk)*readingColumns)
+		for _, reading := range chunk {
+			args = append(args, readingArgs(reading)...)
+		}
+		query := insertReadingSQL + strings.Repeat(", (?, ?, ?, ?, ?, ?, ?, ?)", len(chunk)-1)
+		if _, err := e.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // UpsertParkingLot inserts or updates a parking lot
 func UpsertParkingLot(db *sql.DB, lot *ParkingLot) error {
-	_, err := db.Exec(`
-		INSERT INTO parking_lots (
-			id, city, name, address, lot_type, total, 
-			latitude, longitude, region, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
-		ON CONFLICT(id) DO UPDATE SET
-			name = excluded.name,
-			address = excluded.address,
-			lot_type = excluded.lot_type,
-			total = excluded.total,
-			latitude = excluded.latitude,
-			longitude = excluded.longitude,
-			region = excluded.region,
-			updated_at = CURRENT_TIMESTAMP
-	`, lot.ID, lot.City, lot.Name, lot.Address, lot.LotType,
-		lot.Total, lot.Latitude, lot.Longitude, lot.Region)
+	return UpsertParkingLotContext(context.Background(), db, lot)
+}
 
-	return err
+// UpsertParkingLotContext inserts or updates a parking lot, honoring ctx
+func UpsertParkingLotContext(ctx context.Context, db *sql.DB, lot *ParkingLot) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := upsertParkingLot(ctx, tx, lot); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // InsertReading inserts a new parking reading
 func InsertReading(db *sql.DB, reading *ParkingReading) error {
-	_, err := db.Exec(`
-		INSERT INTO parking_readings (lot_id, city, timestamp, free, state)
-		VALUES (?, ?, ?, ?, ?)
-	`, reading.LotID, reading.City, reading.Timestamp, reading.Free, reading.State)
+	return InsertReadingContext(context.Background(), db, reading)
+}
 
-	return err
+// InsertReadingContext inserts a new parking reading, honoring ctx
+func InsertReadingContext(ctx context.Context, db *sql.DB, reading *ParkingReading) error {
+	return insertReading(ctx, db, reading)
 }
 
 // UpsertParkingLotTx upserts a parking lot within a transaction
 func UpsertParkingLotTx(tx *sql.Tx, lot *ParkingLot) error {
-	_, err := tx.Exec(`
-		INSERT INTO parking_lots (
-			id, city, name, address, lot_type, total, 
-			latitude, longitude, region, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
-		ON CONFLICT(id) DO UPDATE SET
-			name = excluded.name,
-			address = excluded.address,
-			lot_type = excluded.lot_type,
-			total = excluded.total,
-			latitude = excluded.latitude,
-			longitude = excluded.longitude,
-			region = excluded.region,
-			updated_at = CURRENT_TIMESTAMP
-	`, lot.ID, lot.City, lot.Name, lot.Address, lot.LotType,
-		lot.Total, lot.Latitude, lot.Longitude, lot.Region)
+	return UpsertParkingLotTxContext(context.Background(), tx, lot)
+}
 
-	return err
+// UpsertParkingLotTxContext upserts a parking lot within a transaction, honoring ctx
+func UpsertParkingLotTxContext(ctx context.Context, tx *sql.Tx, lot *ParkingLot) error {
+	return upsertParkingLot(ctx, tx, lot)
 }
 
 // InsertReadingTx inserts a reading within a transaction
 func InsertReadingTx(tx *sql.Tx, reading *ParkingReading) error {
-	_, err := tx.Exec(`
-		INSERT INTO parking_readings (lot_id, city, timestamp, free, state)
-		VALUES (?, ?, ?, ?, ?)
-	`, reading.LotID, reading.City, reading.Timestamp, reading.Free, reading.State)
+	return InsertReadingTxContext(context.Background(), tx, reading)
+}
 
-	return err
+// InsertReadingTxContext inserts a reading within a transaction, honoring ctx
+func InsertReadingTxContext(ctx context.Context, tx *sql.Tx, reading *ParkingReading) error {
+	return insertReading(ctx, tx, reading)
 }
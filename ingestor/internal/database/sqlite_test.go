@@ -0,0 +1,211 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestUpsertParkingLotIdempotent(t *testing.T) {
+	db := testutil.NewDB(t)
+	lot := testutil.NewLot("lot1", "Dresden")
+
+	testutil.InsertLots(t, db, lot, lot)
+	if count := testutil.CountRows(t, db, "parking_lots"); count != 1 {
+		t.Fatalf("Expected 1 lot after repeated upsert, got %d", count)
+	}
+
+	// Changed metadata overwrites the existing row
+	lot.Name = "Renamed"
+	lot.Total = 250
+	lot.Extras = sql.NullString{String: `{"forecast":true}`, Valid: true}
+	testutil.InsertLots(t, db, lot)
+
+	var name, extras string
+	var total int
+	if err := db.QueryRow(`SELECT name, total, extras FROM parking_lots WHERE id = ?`, lot.ID).Scan(&name, &total, &extras); err != nil {
+		t.Fatalf("Failed to query lot: %v", err)
+	}
+	if name != "Renamed" || total != 250 || extras != `{"forecast":true}` {
+		t.Errorf("Expected updated lot (Renamed, 250, {\"forecast\":true}), got (%s, %d, %s)", name, total, extras)
+	}
+	if count := testutil.CountRows(t, db, "parking_lots"); count != 1 {
+		t.Errorf("Expected 1 lot after update, got %d", count)
+	}
+}
+
+func TestUpsertParkingLotCityConflict(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+
+	collision := testutil.NewLot("lot1", "Leipzig")
+	collision.Name = "Leipzig Lot"
+	err := database.UpsertParkingLot(db, collision)
+	if !errors.Is(err, database.ErrLotCityConflict) {
+		t.Fatalf("Expected ErrLotCityConflict, got %v", err)
+	}
+
+	lot, err := database.GetLot(db, "lot1")
+	if err != nil {
+		t.Fatalf("GetLot() error: %v", err)
+	}
+	if lot.City != "Dresden" || lot.Name != "Lot lot1" {
+		t.Errorf("Expected Dresden's lot to be untouched, got %+v", lot)
+	}
+}
+
+func TestInsertReading(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("lot1", "Dresden", ts, 40),
+		testutil.NewReading("lot1", "Dresden", ts.Add(5*time.Minute), 35),
+	)
+
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 2 {
+		t.Fatalf("Expected 2 readings, got %d", count)
+	}
+
+	var reading database.ParkingReading
+	err := db.QueryRow(`
+		SELECT lot_id, city, timestamp, free, state FROM parking_readings
+		ORDER BY timestamp DESC LIMIT 1
+	`).Scan(&reading.LotID, &reading.City, &reading.Timestamp, &reading.Free, &reading.State)
+	if err != nil {
+		t.Fatalf("Failed to query reading: %v", err)
+	}
+	if reading.Free != 35 || !reading.Timestamp.Equal(ts.Add(5*time.Minute)) || reading.State != "open" {
+		t.Errorf("Unexpected latest reading: %+v", reading)
+	}
+}
+
+func TestReadingTimestampsStoredInUTC(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+
+	// 01:30 UTC, written by a server in Central European Summer Time
+	cest := time.FixedZone("CEST", 2*60*60)
+	testutil.InsertReadings(t, db, testutil.NewReading("lot1", "Dresden", time.Date(2024, 3, 31, 3, 30, 0, 0, cest), 10))
+
+	// A UTC range ending before 03:30 must still include the reading
+	from := time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC)
+	readings, err := database.GetReadingsForLot(db, "lot1", from, from.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetReadingsForLot() error: %v", err)
+	}
+	if len(readings) != 1 {
+		t.Fatalf("Expected 1 reading, got %d", len(readings))
+	}
+	if ts := readings[0].Timestamp; ts.Location() != time.UTC || ts.Hour() != 1 {
+		t.Errorf("got timestamp %v, expected 2024-03-31 01:30 UTC", ts)
+	}
+}
+
+func TestParsePragmas(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"empty", "", 0, false},
+		{"valid", "cache_size=-64000, temp_store=MEMORY,mmap_size=268435456", 3, false},
+		{"not allowlisted", "key=secret", 0, true},
+		{"missing value", "cache_size", 0, true},
+		{"injection in value", "cache_size=1; DROP TABLE parking_lots", 0, true},
+		{"injection in name", "cache_size=1,journal_mode=WAL; DROP TABLE x=1", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pragmas, err := database.ParsePragmas(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, expected error: %v", err, tt.wantErr)
+			}
+			if len(pragmas) != tt.want {
+				t.Errorf("got %d pragmas, expected %d", len(pragmas), tt.want)
+			}
+		})
+	}
+}
+
+func TestInitDBWithPragmas(t *testing.T) {
+	pragmas := []database.Pragma{{Name: "cache_size", Value: "-12345"}}
+	db, err := database.InitDBWithPragmas(filepath.Join(t.TempDir(), "test.db"), pragmas)
+	if err != nil {
+		t.Fatalf("InitDBWithPragmas() error: %v", err)
+	}
+	defer db.Close()
+
+	// Hold one connection so the next query needs a fresh one
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var cacheSize int
+	if err := db.QueryRow(`PRAGMA cache_size`).Scan(&cacheSize); err != nil {
+		t.Fatal(err)
+	}
+	if cacheSize != -12345 {
+		t.Errorf("got cache_size %d, expected -12345", cacheSize)
+	}
+
+	var initErr *database.InitError
+	bad := []database.Pragma{{Name: "writable_schema", Value: "1"}}
+	if _, err := database.InitDBWithPragmas(database.MemoryPath, bad); !errors.As(err, &initErr) {
+		t.Errorf("Expected an InitError for a pragma that is not allowlisted, got %v", err)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "current.db")
+	db, err := database.InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB() error: %v", err)
+	}
+	db.Close()
+
+	ro, err := database.OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("OpenReadOnly() error: %v", err)
+	}
+	defer ro.Close()
+	if _, err := ro.Exec(`DELETE FROM parking_lots`); err == nil {
+		t.Error("Expected writes to a read-only database to fail")
+	}
+
+	missing := filepath.Join(dir, "missing.db")
+	if _, err := database.OpenReadOnly(missing); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+	if _, err := os.Stat(missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected OpenReadOnly not to create %s, got %v", missing, err)
+	}
+
+	// A database that was never migrated is too old to compare
+	old := filepath.Join(dir, "old.db")
+	oldDB, err := sql.Open("sqlite3", old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oldDB.Exec(`CREATE TABLE parking_lots (id TEXT PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	oldDB.Close()
+	if _, err := database.OpenReadOnly(old); err == nil {
+		t.Error("Expected an error for an outdated schema")
+	}
+}
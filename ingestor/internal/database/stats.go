@@ -0,0 +1,72 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// CityLotCount is the number of lots stored for a city
+type CityLotCount struct {
+	City string
+	Lots int
+}
+
+// Stats summarizes the size and time span of the stored data
+type Stats struct {
+	Lots     int
+	Readings int64
+	Cities   []CityLotCount
+
+	// EarliestReading and LatestReading are zero without readings
+	EarliestReading time.Time
+	LatestReading   time.Time
+}
+
+// GetStats counts lots per city and readings, and finds the first and last
+// reading timestamps. Counting readings scans the table, so callers serving
+// it repeatedly should cache the result.
+func GetStats(db *sql.DB) (*Stats, error) {
+	stats := &Stats{}
+
+	rows, err := db.Query(`SELECT city, COUNT(*) FROM parking_lots GROUP BY city ORDER BY city`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c CityLotCount
+		if err := rows.Scan(&c.City, &c.Lots); err != nil {
+			return nil, err
+		}
+		stats.Cities = append(stats.Cities, c)
+		stats.Lots += c.Lots
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := db.QueryRow(`SELECT COUNT(*) FROM parking_readings`).Scan(&stats.Readings); err != nil {
+		return nil, err
+	}
+
+	// MIN/MAX would return the raw text; ordering on the indexed column
+	// reads a single row and keeps the column's timestamp type
+	if stats.EarliestReading, err = boundaryReading(db, "ASC"); err != nil {
+		return nil, err
+	}
+	if stats.LatestReading, err = boundaryReading(db, "DESC"); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// boundaryReading returns the first reading timestamp in the given order
+func boundaryReading(db *sql.DB, order string) (time.Time, error) {
+	var ts time.Time
+	err := db.QueryRow(`SELECT timestamp FROM parking_readings ORDER BY timestamp ` + order + ` LIMIT 1`).Scan(&ts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return ts, err
+}
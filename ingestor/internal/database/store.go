@@ -0,0 +1,245 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Store persists parking lots and readings. Writes happen inside a Tx so a
+// city's lots and readings are stored together or not at all.
+type Store interface {
+	Begin(ctx context.Context) (Tx, error)
+}
+
+// Tx is a unit of work against a Store
+type Tx interface {
+	UpsertLot(ctx context.Context, lot *ParkingLot) error
+	InsertReading(ctx context.Context, reading *ParkingReading) error
+	// InsertReadings inserts several readings, in as few statements as
+	// the database allows
+	InsertReadings(ctx context.Context, readings []*ParkingReading) error
+	// MarkLotsSeen records that stored lots appeared in a fetch at seenAt
+	// without upserting them
+	MarkLotsSeen(ctx context.Context, ids []string, seenAt time.Time) error
+	Commit() error
+	Rollback() error
+}
+
+// Reconnector is implemented by stores that can re-establish a broken
+// connection to their backing database
+type Reconnector interface {
+	Reconnect(ctx context.Context) error
+}
+
+// RecentReader is implemented by stores that can report when lots were
+// last read, e.g. to avoid storing readings too close together after a
+// restart
+type RecentReader interface {
+	// LatestReadings returns the time of each lot's latest reading at or
+	// after since; lots without one are left out
+	LatestReadings(ctx context.Context, since time.Time) (map[string]time.Time, error)
+}
+
+// IsTransient reports whether err is a database failure that may succeed
+// when retried, such as a busy database or a briefly unavailable file.
+// Constraint violations and other logic errors are not transient.
+func IsTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code {
+		case sqlite3.ErrBusy, sqlite3.ErrLocked, sqlite3.ErrIoErr, sqlite3.ErrCantOpen, sqlite3.ErrProtocol:
+			return true
+		}
+	}
+	return false
+}
+
+// SQLiteStore implements Store on top of a SQLite database handle
+type SQLiteStore struct {
+	db *sql.DB
+
+	// trackLatest upserts every reading into latest_readings as well
+	trackLatest bool
+
+	// batchRows caps the readings per INSERT statement (0 = as many as
+	// the SQLite build's variable limit allows, detected by Begin)
+	batchRows int
+	limitOnce sync.Once
+	maxVars   int
+}
+
+// StoreOption configures optional SQLiteStore behavior
+type StoreOption func(*SQLiteStore)
+
+// TrackLatest keeps latest_readings up to date: every inserted reading
+// also replaces its lot's row there, in the same transaction, unless that
+// row is newer. Call RebuildLatestReadings first if the table may be stale.
+func TrackLatest() StoreOption {
+	return func(s *SQLiteStore) {
+		s.trackLatest = true
+	}
+}
+
+// BatchRows caps how many readings InsertReadings writes per statement.
+// It only lowers the cap derived from SQLite's limit on bound variables.
+func BatchRows(rows int) StoreOption {
+	return func(s *SQLiteStore) {
+		s.batchRows = rows
+	}
+}
+
+// NewSQLiteStore wraps an initialized database as a Store
+func NewSQLiteStore(db *sql.DB, opts ...StoreOption) *SQLiteStore {
+	s := &SQLiteStore{db: db}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// DB returns the underlying database handle
+func (s *SQLiteStore) DB() *sql.DB {
+	return s.db
+}
+
+// Begin starts a new transaction
+func (s *SQLiteStore) Begin(ctx context.Context) (Tx, error) {
+	s.limitOnce.Do(func() { s.maxVars = variableLimit(ctx, s.db) })
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteTx{tx: tx, trackLatest: s.trackLatest, maxVars: s.maxVars, batchRows: s.batchRows}, nil
+}
+
+// LatestReadings returns the time of each lot's latest reading at or after
+// since. Only readings in that window are read, using the timestamp index.
+func (s *SQLiteStore) LatestReadings(ctx context.Context, since time.Time) (map[string]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT lot_id, timestamp FROM parking_readings WHERE timestamp >= ?
+	`, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	latest := make(map[string]time.Time)
+	for rows.Next() {
+		var lotID string
+		var ts time.Time
+		if err := rows.Scan(&lotID, &ts); err != nil {
+			return nil, err
+		}
+		if ts.After(latest[lotID]) {
+			latest[lotID] = ts
+		}
+	}
+	return latest, rows.Err()
+}
+
+// Reconnect drops the pool's idle connections so the database file is
+// opened again, then verifies the new connection. In-memory databases are
+// left alone since closing their only connection would discard them.
+func (s *SQLiteStore) Reconnect(ctx context.Context) error {
+	if file, err := databaseFile(s.db); err == nil && file == "" {
+		return nil
+	}
+
+	s.db.SetMaxIdleConns(0)
+	s.db.SetMaxIdleConns(defaultMaxIdleConns)
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to reconnect to database: %w", err)
+	}
+	return nil
+}
+
+// defaultVariableLimit is the cap on bound variables per statement of
+// SQLite builds before 3.32, assumed when the driver cannot report its own
+const defaultVariableLimit = 999
+
+// variableLimit returns how many bound variables a statement of db may
+// have, e.g. 32766 for current SQLite builds
+func variableLimit(ctx context.Context, db *sql.DB) int {
+	limit := defaultVariableLimit
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return limit
+	}
+	defer conn.Close()
+
+	conn.Raw(func(dc any) error {
+		if c, ok := dc.(*sqlite3.SQLiteConn); ok {
+			if l := c.GetLimit(sqlite3.SQLITE_LIMIT_VARIABLE_NUMBER); l > 0 {
+				limit = l
+			}
+		}
+		return nil
+	})
+	return limit
+}
+
+// defaultMaxIdleConns matches database/sql's default idle pool size
+const defaultMaxIdleConns = 2
+
+// sqliteTx adapts *sql.Tx to the Tx interface
+type sqliteTx struct {
+	tx          *sql.Tx
+	trackLatest bool
+	maxVars     int
+	batchRows   int
+}
+
+func (t *sqliteTx) UpsertLot(ctx context.Context, lot *ParkingLot) error {
+	return UpsertParkingLotTxContext(ctx, t.tx, lot)
+}
+
+func (t *sqliteTx) InsertReading(ctx context.Context, reading *ParkingReading) error {
+	if err := InsertReadingTxContext(ctx, t.tx, reading); err != nil {
+		return err
+	}
+	if t.trackLatest {
+		return upsertLatestReading(ctx, t.tx, reading)
+	}
+	return nil
+}
+
+func (t *sqliteTx) InsertReadings(ctx context.Context, readings []*ParkingReading) error {
+	rows := t.maxVars / readingColumns
+	if t.batchRows > 0 {
+		rows = min(rows, t.batchRows)
+	}
+	if err := insertReadings(ctx, t.tx, readings, rows); err != nil {
+		return err
+	}
+	if !t.trackLatest {
+		return nil
+	}
+	for _, reading := range readings {
+		if err := upsertLatestReading(ctx, t.tx, reading); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sqliteTx) MarkLotsSeen(ctx context.Context, ids []string, seenAt time.Time) error {
+	return markLotsSeen(ctx, t.tx, ids, seenAt, t.maxVars)
+}
+
+func (t *sqliteTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *sqliteTx) Rollback() error {
+	return t.tx.Rollback()
+}
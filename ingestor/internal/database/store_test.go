@@ -0,0 +1,411 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error: %v", err)
+	}
+	defer db.Close()
+
+	store := NewSQLiteStore(db)
+	ctx := context.Background()
+
+	// Committed writes are visible
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin() error: %v", err)
+	}
+	if err := tx.UpsertLot(ctx, &ParkingLot{ID: "lot1", City: "Dresden", Name: "Altmarkt", Total: 400}); err != nil {
+		t.Fatalf("UpsertLot() error: %v", err)
+	}
+	reading := &ParkingReading{LotID: "lot1", City: "Dresden", Timestamp: time.Now(), Free: 100, State: "open"}
+	if err := tx.InsertReading(ctx, reading); err != nil {
+		t.Fatalf("InsertReading() error: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+
+	// Rolled back writes are discarded
+	tx, err = store.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin() error: %v", err)
+	}
+	if err := tx.InsertReading(ctx, reading); err != nil {
+		t.Fatalf("InsertReading() error: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback() error: %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM parking_readings`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count readings: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 reading, got %d", count)
+	}
+}
+
+func TestLotSeenTimes(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error: %v", err)
+	}
+	defer db.Close()
+
+	store := NewSQLiteStore(db)
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	write := func(fn func(tx Tx) error) {
+		t.Helper()
+		tx, err := store.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error: %v", err)
+		}
+		if err := fn(tx); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Commit() error: %v", err)
+		}
+	}
+	upsert := func(seen time.Time) func(tx Tx) error {
+		return func(tx Tx) error {
+			return tx.UpsertLot(ctx, &ParkingLot{ID: "lot1", City: "Dresden", Name: "Altmarkt", Total: 400, ObservedAt: seen})
+		}
+	}
+	markSeen := func(seen time.Time) func(tx Tx) error {
+		return func(tx Tx) error { return tx.MarkLotsSeen(ctx, []string{"lot1", "unknown"}, seen) }
+	}
+
+	tests := []struct {
+		name     string
+		write    func(tx Tx) error
+		lastSeen time.Time
+	}{
+		{"insert", upsert(base), base},
+		{"upsert", upsert(base.Add(5 * time.Minute)), base.Add(5 * time.Minute)},
+		{"marked seen", markSeen(base.Add(10 * time.Minute)), base.Add(10 * time.Minute)},
+		{"older sighting", markSeen(base.Add(time.Minute)), base.Add(10 * time.Minute)},
+		{"older upsert", upsert(base.Add(2 * time.Minute)), base.Add(10 * time.Minute)},
+	}
+	for _, tt := range tests {
+		write(tt.write)
+		lots, err := ListLots(db, "")
+		if err != nil {
+			t.Fatalf("ListLots() error: %v", err)
+		}
+		if len(lots) != 1 {
+			t.Fatalf("%s: got %d lots, expected 1", tt.name, len(lots))
+		}
+		if !lots[0].FirstSeenAt.Equal(base) {
+			t.Errorf("%s: got first seen %v, expected %v", tt.name, lots[0].FirstSeenAt, base)
+		}
+		if !lots[0].LastSeenAt.Equal(tt.lastSeen) {
+			t.Errorf("%s: got last seen %v, expected %v", tt.name, lots[0].LastSeenAt, tt.lastSeen)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"bad connection", fmt.Errorf("begin: %w", driver.ErrBadConn), true},
+		{"busy", sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{"io error", fmt.Errorf("commit: %w", sqlite3.Error{Code: sqlite3.ErrIoErr}), true},
+		{"constraint", sqlite3.Error{Code: sqlite3.ErrConstraint}, false},
+		{"other", errors.New("boom"), false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.expected {
+				t.Errorf("IsTransient(%v) got %v, expected %v", tt.err, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestInitDBRetry(t *testing.T) {
+	defer func(sleep func(time.Duration)) { initSleep = sleep }(initSleep)
+
+	// A file where the directory should be, as before a volume is mounted
+	blocker := filepath.Join(t.TempDir(), "volume")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(blocker, "test.db")
+
+	var sleeps int
+	initSleep = func(time.Duration) { sleeps++ }
+	_, err := InitDB(path)
+	var initErr *InitError
+	if !errors.As(err, &initErr) || !strings.Contains(err.Error(), "after 5 attempts") {
+		t.Fatalf("got %v, expected an InitError after 5 attempts", err)
+	}
+	if sleeps != 4 {
+		t.Errorf("got %d retries, expected 4", sleeps)
+	}
+
+	// The volume appears during the retries
+	sleeps = 0
+	initSleep = func(time.Duration) {
+		sleeps++
+		os.Remove(blocker)
+	}
+	db, err := InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB() error: %v", err)
+	}
+	defer db.Close()
+	if sleeps != 1 {
+		t.Errorf("got %d retries, expected 1", sleeps)
+	}
+}
+
+func TestSQLiteStoreReconnect(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error: %v", err)
+	}
+	defer db.Close()
+	if err := NewSQLiteStore(db).Reconnect(ctx); err != nil {
+		t.Errorf("Reconnect() error: %v", err)
+	}
+
+	// In-memory databases keep their data
+	mem, err := InitDB(MemoryPath)
+	if err != nil {
+		t.Fatalf("InitDB() error: %v", err)
+	}
+	defer mem.Close()
+	if err := NewSQLiteStore(mem).Reconnect(ctx); err != nil {
+		t.Errorf("Reconnect() error: %v", err)
+	}
+	if _, err := SchemaVersion(mem); err != nil {
+		t.Errorf("Expected in-memory schema to survive Reconnect, got %v", err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("InitDB() error: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE filler (data BLOB)`); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := db.Exec(`INSERT INTO filler VALUES (zeroblob(4096))`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`DELETE FROM filler`); err != nil {
+		t.Fatal(err)
+	}
+
+	before, after, err := Compact(db)
+	if err != nil {
+		t.Fatalf("Compact() error: %v", err)
+	}
+	if after >= before {
+		t.Errorf("Expected VACUUM to shrink the database, got %d -> %d bytes", before, after)
+	}
+
+	// In-memory databases are skipped
+	mem, err := InitDB(MemoryPath)
+	if err != nil {
+		t.Fatalf("InitDB() error: %v", err)
+	}
+	defer mem.Close()
+	if before, after, err := Compact(mem); err != nil || before != 0 || after != 0 {
+		t.Errorf("Expected in-memory Compact to be skipped, got %d, %d, %v", before, after, err)
+	}
+}
+
+// failingStore wraps a store so that InsertReading or Commit fails
+type failingStore struct {
+	Store
+	insertErr, commitErr error
+}
+
+func (s *failingStore) Begin(ctx context.Context) (Tx, error) {
+	tx, err := s.Store.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &failingTx{Tx: tx, store: s}, nil
+}
+
+type failingTx struct {
+	Tx
+	store *failingStore
+}
+
+func (t *failingTx) InsertReading(ctx context.Context, reading *ParkingReading) error {
+	if t.store.insertErr != nil {
+		return t.store.insertErr
+	}
+	return t.Tx.InsertReading(ctx, reading)
+}
+
+func (t *failingTx) Commit() error {
+	if t.store.commitErr != nil {
+		t.Tx.Rollback()
+		return t.store.commitErr
+	}
+	return t.Tx.Commit()
+}
+
+func TestMultiStore(t *testing.T) {
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+
+	tests := []struct {
+		name             string
+		insertErr        error
+		commitErr        error
+		expectedReadings [2]int
+		transient        bool
+	}{
+		{"all stores commit", nil, nil, [2]int{1, 1}, false},
+		{"write fails in one store", busy, nil, [2]int{0, 0}, true},
+		{"commit fails after primary", nil, busy, [2]int{1, 0}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dbs [2]*SQLiteStore
+			for idx := range dbs {
+				db, err := InitDB(filepath.Join(t.TempDir(), fmt.Sprintf("db%d.db", idx)))
+				if err != nil {
+					t.Fatalf("InitDB() error: %v", err)
+				}
+				defer db.Close()
+				dbs[idx] = NewSQLiteStore(db)
+			}
+
+			store := NewMultiStore(dbs[0], &failingStore{Store: dbs[1], insertErr: tt.insertErr, commitErr: tt.commitErr})
+			ctx := context.Background()
+
+			err := func() error {
+				tx, err := store.Begin(ctx)
+				if err != nil {
+					return err
+				}
+				defer tx.Rollback()
+
+				if err := tx.UpsertLot(ctx, &ParkingLot{ID: "lot1", City: "Dresden", Name: "Altmarkt", Total: 400}); err != nil {
+					return err
+				}
+				reading := &ParkingReading{LotID: "lot1", City: "Dresden", Timestamp: time.Now(), Free: 100, State: "open"}
+				if err := tx.InsertReading(ctx, reading); err != nil {
+					return err
+				}
+				return tx.Commit()
+			}()
+
+			failing := tt.insertErr != nil || tt.commitErr != nil
+			if (err != nil) != failing {
+				t.Fatalf("got error %v, expected failure: %v", err, failing)
+			}
+			if IsTransient(err) != tt.transient {
+				t.Errorf("IsTransient(%v) = %v, expected %v", err, !tt.transient, tt.transient)
+			}
+
+			for idx, s := range dbs {
+				var count int
+				if err := s.DB().QueryRow(`SELECT COUNT(*) FROM parking_readings`).Scan(&count); err != nil {
+					t.Fatalf("Failed to count readings: %v", err)
+				}
+				if count != tt.expectedReadings[idx] {
+					t.Errorf("store %d: got %d readings, expected %d", idx, count, tt.expectedReadings[idx])
+				}
+			}
+		})
+	}
+}
+
+func TestInsertReadingsChunks(t *testing.T) {
+	ctx := context.Background()
+	db, err := InitDB(MemoryPath)
+	if err != nil {
+		t.Fatalf("InitDB() error: %v", err)
+	}
+	defer db.Close()
+
+	const count = 10000
+	ids := make([]string, count)
+	readings := make([]*ParkingReading, count)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := range count {
+		ids[idx] = fmt.Sprintf("lot%05d", idx)
+		if err := upsertParkingLot(ctx, tx, &ParkingLot{ID: ids[idx], City: "Berlin", Name: ids[idx], Total: 100}); err != nil {
+			t.Fatal(err)
+		}
+		readings[idx] = &ParkingReading{LotID: ids[idx], City: "Berlin", Timestamp: time.Now(), Free: idx % 100, State: "open"}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 10,000 readings bind 70,000 variables, over even current limits;
+	// an old build's limit and an explicit cap split them further
+	oldBuild := NewSQLiteStore(db)
+	oldBuild.limitOnce.Do(func() { oldBuild.maxVars = defaultVariableLimit })
+	for _, s := range []*SQLiteStore{NewSQLiteStore(db), oldBuild, NewSQLiteStore(db, BatchRows(3))} {
+		tx, err := s.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error: %v", err)
+		}
+		if err := tx.InsertReadings(ctx, readings); err != nil {
+			t.Fatalf("InsertReadings() error: %v", err)
+		}
+		if err := tx.MarkLotsSeen(ctx, ids, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("MarkLotsSeen() error: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Commit() error: %v", err)
+		}
+	}
+
+	var stored, seen int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM parking_readings`).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 3*count {
+		t.Errorf("got %d readings, expected %d", stored, 3*count)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM parking_lots WHERE last_seen_at > first_seen_at`).Scan(&seen); err != nil {
+		t.Fatal(err)
+	}
+	if seen != count {
+		t.Errorf("got %d lots marked seen, expected %d", seen, count)
+	}
+}
@@ -0,0 +1,302 @@
+// Package export writes stored readings and lots in file formats for
+// sharing and analysis.
+package export
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// ReadingWriter writes exported readings one at a time. Close flushes any
+// buffered output and writes trailing syntax; it does not close the
+// underlying writer.
+type ReadingWriter interface {
+	Write(r *database.ExportedReading) error
+	Close() error
+}
+
+// Formats lists the supported reading export formats
+var Formats = []string{"csv", "json", "parquet"}
+
+// Options transform exported readings
+type Options struct {
+	// OccupancyBands, if positive, replaces each reading's exact free count
+	// with the band of occupancy it falls in, e.g. 4 gives 0-25%, 25-50%,
+	// 50-75% and 75-100%, for publishing coarse data. Only CSV and JSON
+	// support it.
+	OccupancyBands int
+}
+
+// NewReadingWriter returns a writer for the named format
+func NewReadingWriter(format string, w io.Writer) (ReadingWriter, error) {
+	return NewReadingWriterWithOptions(format, w, Options{})
+}
+
+// NewReadingWriterWithOptions returns a writer for the named format that
+// applies opts
+func NewReadingWriterWithOptions(format string, w io.Writer, opts Options) (ReadingWriter, error) {
+	if opts.OccupancyBands < 0 {
+		return nil, fmt.Errorf("invalid number of occupancy bands %d", opts.OccupancyBands)
+	}
+	switch format {
+	case "csv":
+		return newCSVWriter(w, opts.OccupancyBands), nil
+	case "json":
+		return &jsonWriter{w: w, bands: opts.OccupancyBands}, nil
+	case "parquet":
+		if opts.OccupancyBands > 0 {
+			return nil, errors.New("occupancy bands are only supported for csv and json")
+		}
+		return newParquetWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// occupancyBand returns the label of the band of bands equal bands that
+// r's occupancy falls in, e.g. "25-50%" for 30% of 4 bands. Each band
+// includes its lower bound; full lots fall in the last one. Readings
+// without a meaningful occupancy (closed, no data, unknown capacity or
+// anomalous) have no band.
+func occupancyBand(r *database.ExportedReading, bands int) (string, bool) {
+	if r.Total <= 0 || !database.IsAvailable(r.State) || r.Quality.Has(database.QualityAnomalous) {
+		return "", false
+	}
+	// Integer math keeps exact boundaries such as 20% of 50 spaces exact
+	idx := min((r.Total-r.Free)*bands/r.Total, bands-1)
+	return fmt.Sprintf("%d-%d%%", idx*100/bands, (idx+1)*100/bands), true
+}
+
+// readingRecord is the JSON form of an exported reading
+type readingRecord struct {
+	Timestamp  time.Time `json:"timestamp"`
+	LotID      string    `json:"lot_id"`
+	LotName    string    `json:"lot_name"`
+	City       string    `json:"city"`
+	CityName   string    `json:"city_name"`
+	CitySource *string   `json:"city_source"`
+	LotType    *string   `json:"lot_type"`
+	Region     *string   `json:"region"`
+	Latitude   *float64  `json:"latitude"`
+	Longitude  *float64  `json:"longitude"`
+	Total      int       `json:"total"`
+	Free       *int      `json:"free,omitempty"`
+	State      string    `json:"state"`
+
+	SourceInstance *string `json:"source_instance"`
+	Quality        int     `json:"quality"`
+
+	// OccupancyBand replaces Free when exporting occupancy bands
+	OccupancyBand *string `json:"occupancy_band,omitempty"`
+}
+
+func newRecord(r *database.ExportedReading) readingRecord {
+	return readingRecord{
+		Timestamp:  r.Timestamp.UTC(),
+		LotID:      r.LotID,
+		LotName:    r.LotName,
+		City:       r.City,
+		CityName:   r.CityName,
+		CitySource: nullString(r.CitySource),
+		LotType:    nullString(r.LotType),
+		Region:     nullString(r.Region),
+		Latitude:   nullFloat(r.Latitude),
+		Longitude:  nullFloat(r.Longitude),
+		Total:      r.Total,
+		Free:       &r.Free,
+		State:      r.State,
+
+		SourceInstance: nullString(r.SourceInstance),
+		Quality:        int(r.Quality),
+	}
+}
+
+// csvHeader names the columns written by the CSV exporter
+var csvHeader = []string{
+	"timestamp", "lot_id", "lot_name", "city", "city_name", "city_source", "lot_type", "region",
+	"latitude", "longitude", "total", "free", "state", "source_instance", "quality",
+}
+
+// csvFreeColumn is the index of "free" in csvHeader
+const csvFreeColumn = 11
+
+// csvWriter writes one row per reading after a header row
+type csvWriter struct {
+	w             *csv.Writer
+	header        []string
+	bands         int
+	headerWritten bool
+}
+
+func newCSVWriter(w io.Writer, bands int) *csvWriter {
+	header := csvHeader
+	if bands > 0 {
+		header = slices.Clone(csvHeader)
+		header[csvFreeColumn] = "occupancy_band"
+	}
+	return &csvWriter{w: csv.NewWriter(w), header: header, bands: bands}
+}
+
+func (c *csvWriter) Write(r *database.ExportedReading) error {
+	if !c.headerWritten {
+		if err := c.w.Write(c.header); err != nil {
+			return err
+		}
+		c.headerWritten = true
+	}
+
+	row := []string{
+		r.Timestamp.UTC().Format(time.RFC3339),
+		r.LotID,
+		r.LotName,
+		r.City,
+		r.CityName,
+		r.CitySource.String,
+		r.LotType.String,
+		r.Region.String,
+		formatNullFloat(r.Latitude),
+		formatNullFloat(r.Longitude),
+		strconv.Itoa(r.Total),
+		strconv.Itoa(r.Free),
+		r.State,
+		r.SourceInstance.String,
+		strconv.Itoa(int(r.Quality)),
+	}
+	if c.bands > 0 {
+		row[csvFreeColumn], _ = occupancyBand(r, c.bands)
+	}
+	return c.w.Write(row)
+}
+
+func (c *csvWriter) Close() error {
+	if !c.headerWritten {
+		if err := c.w.Write(c.header); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonWriter streams readings as a JSON array
+type jsonWriter struct {
+	w     io.Writer
+	bands int
+	count int
+}
+
+func (j *jsonWriter) Write(r *database.ExportedReading) error {
+	record := newRecord(r)
+	if j.bands > 0 {
+		record.Free = nil
+		if band, ok := occupancyBand(r, j.bands); ok {
+			record.OccupancyBand = &band
+		}
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	sep := ",\n"
+	if j.count == 0 {
+		sep = "[\n"
+	}
+	j.count++
+	if _, err := io.WriteString(j.w, sep); err != nil {
+		return err
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	end := "\n]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+// WriteLotsGeoJSON writes lots with coordinates as a GeoJSON
+// FeatureCollection of points. Lots without coordinates are skipped; the
+// number written is returned.
+func WriteLotsGeoJSON(w io.Writer, lots []database.ExportedLot) (int, error) {
+	type geometry struct {
+		Type        string     `json:"type"`
+		Coordinates [2]float64 `json:"coordinates"`
+	}
+	type feature struct {
+		Type       string         `json:"type"`
+		ID         string         `json:"id"`
+		Geometry   geometry       `json:"geometry"`
+		Properties map[string]any `json:"properties"`
+	}
+
+	features := []feature{}
+	for _, lot := range lots {
+		if !lot.Latitude.Valid || !lot.Longitude.Valid {
+			continue
+		}
+
+		props := map[string]any{
+			"name":        lot.Name,
+			"city":        lot.City,
+			"city_name":   lot.CityName,
+			"city_source": nullString(lot.CitySource),
+			"address":     nullString(lot.Address),
+			"lot_type":    nullString(lot.LotType),
+			"region":      nullString(lot.Region),
+			"total":       lot.Total,
+		}
+		if lot.LastSeen.Valid {
+			props["free"] = lot.Free.Int64
+			props["state"] = lot.State.String
+			props["last_seen"] = lot.LastSeen.Time.UTC()
+		}
+
+		features = append(features, feature{
+			Type: "Feature",
+			ID:   lot.ID,
+			// GeoJSON orders coordinates longitude first
+			Geometry:   geometry{Type: "Point", Coordinates: [2]float64{lot.Longitude.Float64, lot.Latitude.Float64}},
+			Properties: props,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(map[string]any{"type": "FeatureCollection", "features": features})
+	return len(features), err
+}
+
+func nullString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+func nullFloat(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
+	}
+	return &f.Float64
+}
+
+func formatNullFloat(f sql.NullFloat64) string {
+	if !f.Valid {
+		return ""
+	}
+	return strconv.FormatFloat(f.Float64, 'f', -1, 64)
+}
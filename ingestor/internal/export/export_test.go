@@ -0,0 +1,212 @@
+package export
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+func testReading(free int) *database.ExportedReading {
+	return &database.ExportedReading{
+		Timestamp:  time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		LotID:      "d1",
+		LotName:    "Altmarkt",
+		City:       "Dresden",
+		CityName:   "Dresden",
+		CitySource: sql.NullString{String: "https://dresden.example", Valid: true},
+		Latitude:   sql.NullFloat64{Float64: 51.05, Valid: true},
+		Longitude:  sql.NullFloat64{Float64: 13.74, Valid: true},
+		Total:      400,
+		Free:       free,
+		State:      "open",
+
+		SourceInstance: sql.NullString{String: "ingest-1", Valid: true},
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewReadingWriter("csv", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(testReading(120)); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	expected := "timestamp,lot_id,lot_name,city,city_name,city_source,lot_type,region,latitude,longitude,total,free,state,source_instance,quality\n" +
+		"2024-01-01T12:00:00Z,d1,Altmarkt,Dresden,Dresden,https://dresden.example,,,51.05,13.74,400,120,open,ingest-1,0\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected CSV:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestJSONWriter(t *testing.T) {
+	for _, count := range []int{0, 2} {
+		var buf bytes.Buffer
+		w, err := NewReadingWriter("json", &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < count; i++ {
+			if err := w.Write(testReading(i)); err != nil {
+				t.Fatalf("Write() error: %v", err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+
+		var records []map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+			t.Fatalf("Invalid JSON for %d readings: %v\n%s", count, err, buf.String())
+		}
+		if len(records) != count {
+			t.Errorf("Expected %d records, got %d", count, len(records))
+		}
+		if count > 0 && records[0]["city_source"] != "https://dresden.example" {
+			t.Errorf("Expected city_source, got %v", records[0]["city_source"])
+		}
+	}
+}
+
+func TestOccupancyBand(t *testing.T) {
+	tests := []struct {
+		name     string
+		free     int
+		total    int
+		state    string
+		bands    int
+		expected string
+	}{
+		{"empty", 100, 100, "open", 4, "0-25%"},
+		{"below first boundary", 76, 100, "open", 4, "0-25%"},
+		{"at first boundary", 75, 100, "open", 4, "25-50%"},
+		{"at half", 50, 100, "open", 4, "50-75%"},
+		{"just below full", 1, 100, "open", 4, "75-100%"},
+		{"full", 0, 100, "open", 4, "75-100%"},
+		{"exact boundary despite rounding", 40, 50, "open", 5, "20-40%"},
+		{"uneven bands", 50, 150, "open", 3, "66-100%"},
+		{"closed", 0, 100, database.StateClosed, 4, ""},
+		{"unknown capacity", 10, 0, "open", 4, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testReading(tt.free)
+			r.Total, r.State = tt.total, tt.state
+			if got, _ := occupancyBand(r, tt.bands); got != tt.expected {
+				t.Errorf("got %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+
+	anomalous := testReading(500)
+	anomalous.Quality = database.QualityAnomalous
+	if band, ok := occupancyBand(anomalous, 4); ok {
+		t.Errorf("got band %q for an anomalous reading, expected none", band)
+	}
+}
+
+func TestOccupancyBandsWriters(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewReadingWriterWithOptions("csv", &buf, Options{OccupancyBands: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(testReading(120)); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	expected := "timestamp,lot_id,lot_name,city,city_name,city_source,lot_type,region,latitude,longitude,total,occupancy_band,state,source_instance,quality\n" +
+		"2024-01-01T12:00:00Z,d1,Altmarkt,Dresden,Dresden,https://dresden.example,,,51.05,13.74,400,50-75%,open,ingest-1,0\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected CSV:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+
+	buf.Reset()
+	if w, err = NewReadingWriterWithOptions("json", &buf, Options{OccupancyBands: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(testReading(120)); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	var records []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, buf.String())
+	}
+	if _, ok := records[0]["free"]; ok || records[0]["occupancy_band"] != "50-75%" {
+		t.Errorf("got %v, expected occupancy_band 50-75%% instead of free", records[0])
+	}
+
+	if _, err := NewReadingWriterWithOptions("parquet", &bytes.Buffer{}, Options{OccupancyBands: 4}); err == nil {
+		t.Error("Expected an error for occupancy bands in Parquet")
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := NewReadingWriter("xml", &bytes.Buffer{}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestWriteLotsGeoJSON(t *testing.T) {
+	located := database.ExportedLot{
+		ParkingLot: database.ParkingLot{
+			ID: "d1", City: "Dresden", Name: "Altmarkt", Total: 400,
+			Latitude:  sql.NullFloat64{Float64: 51.05, Valid: true},
+			Longitude: sql.NullFloat64{Float64: 13.74, Valid: true},
+		},
+		CitySource: sql.NullString{String: "https://dresden.example", Valid: true},
+	}
+	unlocated := database.ExportedLot{ParkingLot: database.ParkingLot{ID: "d2", City: "Dresden"}}
+
+	var buf bytes.Buffer
+	n, err := WriteLotsGeoJSON(&buf, []database.ExportedLot{located, unlocated})
+	if err != nil {
+		t.Fatalf("WriteLotsGeoJSON() error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 feature, got %d", n)
+	}
+
+	var fc struct {
+		Type     string `json:"type"`
+		Features []struct {
+			ID       string `json:"id"`
+			Geometry struct {
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]any `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+		t.Fatalf("Invalid GeoJSON: %v", err)
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 1 {
+		t.Fatalf("Unexpected collection: %s", buf.String())
+	}
+	f := fc.Features[0]
+	if f.Geometry.Coordinates[0] != 13.74 || f.Geometry.Coordinates[1] != 51.05 {
+		t.Errorf("Expected [lng, lat] coordinates, got %v", f.Geometry.Coordinates)
+	}
+	if f.Properties["city_source"] != "https://dresden.example" {
+		t.Errorf("Expected city_source property, got %v", f.Properties["city_source"])
+	}
+	if strings.Contains(buf.String(), `"free"`) {
+		t.Error("Expected no reading properties for a lot without readings")
+	}
+}
@@ -0,0 +1,167 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	// parquetBatchSize is how many rows are handed to the Parquet writer
+	// at once
+	parquetBatchSize = 1024
+
+	// parquetRowGroupSize caps the rows buffered in memory before a row
+	// group is written out
+	parquetRowGroupSize = 64 * 1024
+)
+
+// parquetRecord is the Parquet schema of an exported reading
+type parquetRecord struct {
+	Timestamp  time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	LotID      string    `parquet:"lot_id,dict"`
+	LotName    string    `parquet:"lot_name,dict"`
+	City       string    `parquet:"city,dict"`
+	CityName   string    `parquet:"city_name,dict"`
+	CitySource *string   `parquet:"city_source,optional,dict"`
+	LotType    *string   `parquet:"lot_type,optional,dict"`
+	Region     *string   `parquet:"region,optional,dict"`
+	Latitude   *float64  `parquet:"latitude,optional"`
+	Longitude  *float64  `parquet:"longitude,optional"`
+	Total      int32     `parquet:"total"`
+	Free       int32     `parquet:"free"`
+	State      string    `parquet:"state,dict"`
+
+	SourceInstance *string `parquet:"source_instance,optional,dict"`
+	Quality        int32   `parquet:"quality"`
+}
+
+// parquetWriter writes readings as a single Parquet file, flushing a row
+// group every parquetRowGroupSize rows so memory use stays flat
+type parquetWriter struct {
+	w     *parquet.GenericWriter[parquetRecord]
+	batch []parquetRecord
+}
+
+func newParquetWriter(w io.Writer) *parquetWriter {
+	return &parquetWriter{
+		w: parquet.NewGenericWriter[parquetRecord](w,
+			parquet.Compression(&parquet.Snappy),
+			parquet.MaxRowsPerRowGroup(parquetRowGroupSize),
+		),
+		batch: make([]parquetRecord, 0, parquetBatchSize),
+	}
+}
+
+func (p *parquetWriter) Write(r *database.ExportedReading) error {
+	p.batch = append(p.batch, parquetRecord{
+		Timestamp:  r.Timestamp.UTC(),
+		LotID:      r.LotID,
+		LotName:    r.LotName,
+		City:       r.City,
+		CityName:   r.CityName,
+		CitySource: nullString(r.CitySource),
+		LotType:    nullString(r.LotType),
+		Region:     nullString(r.Region),
+		Latitude:   nullFloat(r.Latitude),
+		Longitude:  nullFloat(r.Longitude),
+		Total:      int32(r.Total),
+		Free:       int32(r.Free),
+		State:      r.State,
+
+		SourceInstance: nullString(r.SourceInstance),
+		Quality:        int32(r.Quality),
+	})
+	if len(p.batch) == cap(p.batch) {
+		return p.flush()
+	}
+	return nil
+}
+
+func (p *parquetWriter) flush() error {
+	if _, err := p.w.Write(p.batch); err != nil {
+		return err
+	}
+	p.batch = p.batch[:0]
+	return nil
+}
+
+func (p *parquetWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	return p.w.Close()
+}
+
+// PartitionedParquetWriter writes readings into one Parquet file per city
+// and day, laid out as dir/city=<city>/date=<YYYY-MM-DD>/readings.parquet
+// so that Spark and DuckDB can prune partitions. Readings must arrive in
+// timestamp order, as ExportReadings returns them: a day's files are closed
+// as soon as a later day begins.
+type PartitionedParquetWriter struct {
+	dir  string
+	day  string
+	open map[string]*partitionFile
+}
+
+// partitionFile is an open Parquet file of one partition
+type partitionFile struct {
+	f *os.File
+	w *parquetWriter
+}
+
+// NewPartitionedParquetWriter returns a writer creating partitions in dir
+func NewPartitionedParquetWriter(dir string) *PartitionedParquetWriter {
+	return &PartitionedParquetWriter{dir: dir, open: make(map[string]*partitionFile)}
+}
+
+// Write appends a reading to its city and day partition
+func (p *PartitionedParquetWriter) Write(r *database.ExportedReading) error {
+	day := r.Timestamp.UTC().Format("2006-01-02")
+	if day != p.day {
+		if day < p.day {
+			return fmt.Errorf("reading at %s is out of order", r.Timestamp.Format(time.RFC3339))
+		}
+		if err := p.Close(); err != nil {
+			return err
+		}
+		p.day = day
+	}
+
+	part, ok := p.open[r.City]
+	if !ok {
+		dir := filepath.Join(p.dir, "city="+url.PathEscape(r.City), "date="+day)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create partition: %w", err)
+		}
+		f, err := os.Create(filepath.Join(dir, "readings.parquet"))
+		if err != nil {
+			return fmt.Errorf("failed to create partition: %w", err)
+		}
+		part = &partitionFile{f: f, w: newParquetWriter(f)}
+		p.open[r.City] = part
+	}
+	return part.w.Write(r)
+}
+
+// Close finishes every open partition file
+func (p *PartitionedParquetWriter) Close() error {
+	var firstErr error
+	for city, part := range p.open {
+		err := part.w.Close()
+		if closeErr := part.f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to write partition for %s: %w", city, err)
+		}
+		delete(p.open, city)
+	}
+	return firstErr
+}
@@ -0,0 +1,93 @@
+package export
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestParquetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewReadingWriter("parquet", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// More rows than one batch, to cover flushing mid-export
+	count := parquetBatchSize + 10
+	for i := 0; i < count; i++ {
+		if err := w.Write(testReading(i)); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	rows, err := parquet.Read[parquetRecord](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read Parquet: %v", err)
+	}
+	if len(rows) != count {
+		t.Fatalf("got %d rows, expected %d", len(rows), count)
+	}
+
+	last := rows[count-1]
+	if last.Free != int32(count-1) || last.City != "Dresden" || last.LotType != nil {
+		t.Errorf("Unexpected row: %+v", last)
+	}
+	if last.CitySource == nil || *last.CitySource != "https://dresden.example" {
+		t.Errorf("got city_source %v, expected https://dresden.example", last.CitySource)
+	}
+	if !last.Timestamp.Equal(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("got timestamp %v, expected 2024-01-01T12:00:00Z", last.Timestamp)
+	}
+}
+
+func TestPartitionedParquetWriter(t *testing.T) {
+	dir := t.TempDir()
+	w := NewPartitionedParquetWriter(dir)
+
+	day1 := testReading(1)
+	leipzig := testReading(2)
+	leipzig.City = "Leipzig"
+	day2 := testReading(3)
+	day2.Timestamp = day2.Timestamp.AddDate(0, 0, 1)
+
+	for _, r := range []*database.ExportedReading{day1, leipzig, day2} {
+		if err := w.Write(r); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+	if err := w.Write(day1); err == nil {
+		t.Error("Expected an error for an out-of-order reading")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	expected := map[string]int32{
+		"city=Dresden/date=2024-01-01": 1,
+		"city=Leipzig/date=2024-01-01": 2,
+		"city=Dresden/date=2024-01-02": 3,
+	}
+	for partition, free := range expected {
+		path := filepath.Join(dir, partition, "readings.parquet")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Missing partition %s: %v", partition, err)
+		}
+		rows, err := parquet.Read[parquetRecord](bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", partition, err)
+		}
+		if len(rows) != 1 || rows[0].Free != free {
+			t.Errorf("%s: got %+v, expected one row with free=%d", partition, rows, free)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Package forecast predicts parking availability from historical readings.
+package forecast
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// ErrInsufficientData is returned when the history has no usable samples
+var ErrInsufficientData = errors.New("not enough historical readings")
+
+// Prediction is the expected number of free spaces at a point in time
+type Prediction struct {
+	At      time.Time `json:"at"`
+	Free    float64   `json:"free"`
+	StdDev  float64   `json:"std_dev"`
+	Samples int       `json:"samples"`
+	// Unavailable counts matching readings skipped because the lot was
+	// closed or had no data
+	Unavailable int    `json:"unavailable_samples"`
+	Model       string `json:"model"`
+}
+
+// Model predicts free spaces for a lot from its reading history.
+// Implementations must not modify history.
+type Model interface {
+	Name() string
+	Predict(history []database.ParkingReading, at time.Time) (Prediction, error)
+}
+
+// WeeklyProfile averages all readings that share the target's day of week
+// and hour of day, capturing the usual weekly rhythm of a lot
+type WeeklyProfile struct{}
+
+// Name identifies the model in API responses
+func (WeeklyProfile) Name() string {
+	return "weekly_profile"
+}
+
+// Predict returns the mean and standard deviation of free spaces over the
+// matching weekday/hour slot. Weekday and hour are evaluated in at's location.
+// Readings of a closed lot are left out so closures don't look like a full lot.
+func (m WeeklyProfile) Predict(history []database.ParkingReading, at time.Time) (Prediction, error) {
+	loc := at.Location()
+	var values []float64
+	unavailable := 0
+	for _, r := range history {
+		ts := r.Timestamp.In(loc)
+		if ts.Weekday() != at.Weekday() || ts.Hour() != at.Hour() {
+			continue
+		}
+		if !database.IsAvailable(r.State) {
+			unavailable++
+			continue
+		}
+		values = append(values, float64(r.Free))
+	}
+
+	if len(values) == 0 {
+		return Prediction{}, ErrInsufficientData
+	}
+
+	mean, stdDev := meanStdDev(values)
+	return Prediction{
+		At:          at,
+		Free:        mean,
+		StdDev:      stdDev,
+		Samples:     len(values),
+		Unavailable: unavailable,
+		Model:       m.Name(),
+	}, nil
+}
+
+// meanStdDev returns the mean and population standard deviation
+func meanStdDev(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
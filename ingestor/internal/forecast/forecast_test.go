@@ -0,0 +1,74 @@
+package forecast
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+func TestWeeklyProfile(t *testing.T) {
+	// Monday 2024-01-01 08:00 UTC and the following Mondays
+	monday := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	history := []database.ParkingReading{
+		{Timestamp: monday, Free: 10},
+		{Timestamp: monday.Add(30 * time.Minute), Free: 20},
+		{Timestamp: monday.AddDate(0, 0, 7), Free: 30},
+		{Timestamp: monday.AddDate(0, 0, 14).Add(15 * time.Minute), Free: 40},
+		// Different hour and different weekday are ignored
+		{Timestamp: monday.Add(time.Hour), Free: 500},
+		{Timestamp: monday.AddDate(0, 0, 1), Free: 500},
+	}
+
+	at := monday.AddDate(0, 0, 21).Add(45 * time.Minute)
+	prediction, err := WeeklyProfile{}.Predict(history, at)
+	if err != nil {
+		t.Fatalf("Predict() error: %v", err)
+	}
+
+	if prediction.Samples != 4 {
+		t.Errorf("Expected 4 samples, got %d", prediction.Samples)
+	}
+	if prediction.Free != 25 {
+		t.Errorf("Expected mean 25, got %v", prediction.Free)
+	}
+	if prediction.StdDev < 11.18 || prediction.StdDev > 11.19 {
+		t.Errorf("Expected std dev ~11.18, got %v", prediction.StdDev)
+	}
+	if prediction.Model != "weekly_profile" {
+		t.Errorf("Unexpected model name %q", prediction.Model)
+	}
+}
+
+func TestWeeklyProfileNoData(t *testing.T) {
+	history := []database.ParkingReading{
+		{Timestamp: time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC), Free: 10},
+	}
+	at := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+
+	if _, err := (WeeklyProfile{}).Predict(history, at); !errors.Is(err, ErrInsufficientData) {
+		t.Errorf("Expected ErrInsufficientData, got %v", err)
+	}
+}
+
+func TestWeeklyProfileSkipsClosedReadings(t *testing.T) {
+	monday := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	history := []database.ParkingReading{
+		{Timestamp: monday, Free: 40, State: "open"},
+		{Timestamp: monday.AddDate(0, 0, 7), Free: 0, State: database.StateClosed},
+		{Timestamp: monday.AddDate(0, 0, 14), Free: 60, State: "open"},
+		{Timestamp: monday.AddDate(0, 0, 21), Free: 0, State: database.StateNoData},
+	}
+
+	prediction, err := WeeklyProfile{}.Predict(history, monday.AddDate(0, 0, 28))
+	if err != nil {
+		t.Fatalf("Predict() error: %v", err)
+	}
+	if prediction.Free != 50 || prediction.Samples != 2 {
+		t.Errorf("Expected mean 50 from 2 open samples, got %v from %d", prediction.Free, prediction.Samples)
+	}
+	if prediction.Unavailable != 2 {
+		t.Errorf("Expected 2 unavailable samples, got %d", prediction.Unavailable)
+	}
+}
@@ -0,0 +1,83 @@
+package ingestor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ActiveHours is a daily window in the server's local time outside of
+// which Start does not poll. A window whose end lies before its start
+// wraps past midnight, e.g. 22:00-06:00.
+type ActiveHours struct {
+	// From and To are minutes after midnight; From is inside the window
+	// and To is not
+	From, To int
+}
+
+// ParseActiveHours parses a window such as "06:00-22:00"; empty means no
+// window (always active) and returns nil
+func ParseActiveHours(value string) (*ActiveHours, error) {
+	if value == "" {
+		return nil, nil
+	}
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid active hours %q (expected HH:MM-HH:MM)", value)
+	}
+	var h ActiveHours
+	var err error
+	if h.From, err = parseClock(from); err != nil {
+		return nil, err
+	}
+	if h.To, err = parseClock(to); err != nil {
+		return nil, err
+	}
+	if h.From == h.To {
+		return nil, fmt.Errorf("invalid active hours %q: start and end are equal", value)
+	}
+	return &h, nil
+}
+
+// parseClock parses "HH:MM" into minutes after midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (expected HH:MM)", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (h ActiveHours) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", h.From/60, h.From%60, h.To/60, h.To%60)
+}
+
+// Contains reports whether t, in its own location, falls in the window
+func (h ActiveHours) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if h.From < h.To {
+		return minute >= h.From && minute < h.To
+	}
+	return minute >= h.From || minute < h.To
+}
+
+// Until returns how long after t the window opens next, 0 when t is
+// inside it
+func (h ActiveHours) Until(t time.Time) time.Duration {
+	if h.Contains(t) {
+		return 0
+	}
+	open := time.Date(t.Year(), t.Month(), t.Day(), h.From/60, h.From%60, 0, 0, t.Location())
+	if !open.After(t) {
+		open = time.Date(t.Year(), t.Month(), t.Day()+1, h.From/60, h.From%60, 0, 0, t.Location())
+	}
+	return open.Sub(t)
+}
+
+// WithActiveHours makes Start poll only within the window, in the server's
+// local time, and idle outside it. Entering and leaving it is logged.
+func WithActiveHours(hours ActiveHours) Option {
+	return func(i *Ingestor) {
+		i.activeHours = &hours
+	}
+}
@@ -0,0 +1,60 @@
+package ingestor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+// benchLotCounts are the city sizes benchmarked; 500 is a large city
+var benchLotCounts = []int{10, 100, 500}
+
+// largeCity returns canned data for a city with n lots
+func largeCity(city string, n int) *api.CityParkingData {
+	ids := make([]string, n)
+	for idx := range ids {
+		ids[idx] = fmt.Sprintf("%s-%03d", city, idx)
+	}
+	return cityData(city, ids...)
+}
+
+// quietLog discards the ingestor's per-poll log lines for the rest of b
+func quietLog(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// BenchmarkPollCity measures polling one city per iteration. The mock
+// client leaves out the network and JSON parsing, and after the first poll
+// the lot cache skips unchanged lots, so this is the steady state of a
+// running ingestor.
+func BenchmarkPollCity(b *testing.B) {
+	quietLog(b)
+	for _, lots := range benchLotCounts {
+		b.Run(fmt.Sprintf("lots=%d", lots), func(b *testing.B) {
+			data := largeCity("Dresden", lots)
+			client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": data}}
+			ing := New(testutil.NewDB(b), client, []string{"Dresden"}, time.Minute)
+			ctx := context.Background()
+			start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := range b.N {
+				// A new fetch time per poll, so nothing is skipped as stored
+				data.FetchedAt = start.Add(time.Duration(n) * time.Minute)
+				if err := ing.PollCity(ctx, "Dresden"); err != nil {
+					b.Fatalf("PollCity() error: %v", err)
+				}
+			}
+			b.ReportMetric(float64(b.N*lots)/b.Elapsed().Seconds(), "readings/s")
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package ingestor
+
+import (
+	"sync"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/metrics"
+)
+
+var busDisconnected = metrics.Default.NewCounterVec("parkmonitor_bus_slow_subscribers_total",
+	"Live reading subscribers disconnected because they fell behind.")
+
+// Bus fans reading events out to live subscribers, e.g. WebSocket clients.
+// Publishing never blocks: a subscriber whose buffer is full is
+// disconnected instead of delaying ingestion.
+type Bus struct {
+	mu     sync.Mutex
+	closed bool
+	subs   map[*Subscription]struct{}
+}
+
+// NewBus returns a bus without subscribers
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives the events published after it was created
+type Subscription struct {
+	bus    *Bus
+	events chan ReadingEvent
+}
+
+// Events returns the subscription's events. The channel is closed when the
+// subscription is closed, fell behind, or the bus was closed.
+func (s *Subscription) Events() <-chan ReadingEvent {
+	return s.events
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.remove(s)
+}
+
+// Subscribe starts a subscription that queues up to buffer events
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := &Subscription{bus: b, events: make(chan ReadingEvent, buffer)}
+	if b.closed {
+		close(s.events)
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// Publish queues events for every subscriber without blocking
+func (b *Bus) Publish(events ...ReadingEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subs {
+		if !s.offer(events) {
+			busDisconnected.Inc()
+			b.remove(s)
+		}
+	}
+}
+
+// offer queues events without blocking and reports whether all fit
+func (s *Subscription) offer(events []ReadingEvent) bool {
+	for _, event := range events {
+		select {
+		case s.events <- event:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Close ends every subscription; later ones are closed immediately
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subs {
+		b.remove(s)
+	}
+}
+
+// remove closes s if it is still subscribed. Callers must hold b.mu.
+func (b *Bus) remove(s *Subscription) {
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.events)
+	}
+}
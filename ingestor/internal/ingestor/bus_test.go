@@ -0,0 +1,35 @@
+package ingestor
+
+import "testing"
+
+func TestBusDisconnectsSlowSubscribers(t *testing.T) {
+	bus := NewBus()
+	fast := bus.Subscribe(10)
+	slow := bus.Subscribe(2)
+	before := busDisconnected.Value()
+
+	bus.Publish(ReadingEvent{LotID: "a"}, ReadingEvent{LotID: "b"}, ReadingEvent{LotID: "c"})
+
+	if got := len(fast.Events()); got != 3 {
+		t.Errorf("got %d queued events, expected 3", got)
+	}
+	// The slow subscriber's queued events are still delivered before its
+	// channel reports the disconnect
+	var received int
+	for range slow.Events() {
+		received++
+	}
+	if received != 2 {
+		t.Errorf("got %d events for the slow subscriber, expected 2", received)
+	}
+	if got := busDisconnected.Value() - before; got != 1 {
+		t.Errorf("got %v disconnects, expected 1", got)
+	}
+
+	fast.Close()
+	fast.Close() // closing twice is a no-op
+	bus.Close()
+	if _, ok := <-bus.Subscribe(1).Events(); ok {
+		t.Error("Expected a subscription to a closed bus to be closed")
+	}
+}
@@ -0,0 +1,112 @@
+package ingestor
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+	"github.com/niklas/parkmonitor/ingestor/internal/metrics"
+)
+
+// ReadingEvent is written as one JSON line for every stored reading
+type ReadingEvent struct {
+	City      string    `json:"city"`
+	LotID     string    `json:"lot_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Free      int       `json:"free"`
+	Total     int       `json:"total"`
+	State     string    `json:"state"`
+	Anomalous bool      `json:"anomalous,omitempty"`
+}
+
+var emitDropped = metrics.Default.NewCounterVec("parkmonitor_emit_dropped_total",
+	"Reading events dropped because the -emit-json buffer was full.")
+
+// Emitter writes reading events as JSON lines. Events are queued in a
+// bounded buffer and written by a background goroutine, so a slow reader
+// never blocks polling: events that do not fit are dropped and counted.
+type Emitter struct {
+	mu     sync.Mutex
+	closed bool
+	events chan ReadingEvent
+	done   chan struct{}
+}
+
+// NewEmitter starts writing events to w, queueing up to buffer of them
+func NewEmitter(w io.Writer, buffer int) *Emitter {
+	e := &Emitter{
+		events: make(chan ReadingEvent, buffer),
+		done:   make(chan struct{}),
+	}
+	go e.run(w)
+	return e
+}
+
+func (e *Emitter) run(w io.Writer) {
+	defer close(e.done)
+
+	enc := json.NewEncoder(w)
+	failed := false
+	for event := range e.events {
+		// Keep draining after a write error so Emit never blocks, but only
+		// log the first one
+		if err := enc.Encode(event); err != nil && !failed {
+			log.Printf("Warning: failed to emit reading events: %v", err)
+			failed = true
+		}
+	}
+}
+
+// Emit queues events without blocking, dropping those that do not fit
+func (e *Emitter) Emit(events ...ReadingEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+
+	for idx, event := range events {
+		select {
+		case e.events <- event:
+		default:
+			dropped := len(events) - idx
+			emitDropped.Add(float64(dropped))
+			log.Printf("Warning: emit buffer full, dropped %d reading events", dropped)
+			return
+		}
+	}
+}
+
+// Close stops accepting events and waits until the queued ones are written
+func (e *Emitter) Close() {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.events)
+	}
+	e.mu.Unlock()
+
+	<-e.done
+}
+
+// readingEvents builds the events for the lots of data at the given
+// indexes, which storeCity reports as stored
+func readingEvents(city string, data *api.CityParkingData, stored []int, timestamp time.Time) []ReadingEvent {
+	events := make([]ReadingEvent, len(stored))
+	for idx, lotIdx := range stored {
+		lot, reading := data.Lots[lotIdx], data.LotReadings[lotIdx]
+		events[idx] = ReadingEvent{
+			City:      city,
+			LotID:     lot.ID,
+			Timestamp: timestamp,
+			Free:      reading.Free,
+			Total:     lot.Total,
+			State:     reading.State,
+			Anomalous: anomalous(reading.Free, lot.Total),
+		}
+	}
+	return events
+}
@@ -0,0 +1,86 @@
+package ingestor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestEmitStoredReadings(t *testing.T) {
+	client := &fakeAPI{
+		data: map[string]*api.CityParkingData{
+			"Dresden": cityData("Dresden", "shared"),
+			"Leipzig": cityData("Leipzig", "shared", "l1"),
+		},
+	}
+	var out bytes.Buffer
+	emitter := NewEmitter(&out, 10)
+	ing := New(testutil.NewDB(t), client, []string{"Dresden", "Leipzig"}, time.Minute, WithEmitter(emitter))
+
+	if err := ing.poll(context.Background()); err != nil {
+		t.Fatalf("poll() error: %v", err)
+	}
+	emitter.Close()
+
+	var events []ReadingEvent
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var event ReadingEvent
+		if err := dec.Decode(&event); err != nil {
+			t.Fatalf("Failed to decode event: %v", err)
+		}
+		events = append(events, event)
+	}
+
+	// Leipzig's colliding lot was not stored, so it must not be emitted
+	if len(events) != 2 {
+		t.Fatalf("got %d events, expected 2: %+v", len(events), events)
+	}
+	if events[0].City != "Dresden" || events[0].LotID != "shared" {
+		t.Errorf("Unexpected first event: %+v", events[0])
+	}
+	if e := events[1]; e.City != "Leipzig" || e.LotID != "l1" || e.Free != 50 || e.Total != 100 || e.State != "open" {
+		t.Errorf("Unexpected second event: %+v", e)
+	}
+}
+
+// blockingWriter blocks every write until release is closed
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestEmitterDropsWhenFull(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	emitter := NewEmitter(w, 1)
+	before := emitDropped.Value()
+
+	done := make(chan struct{})
+	go func() {
+		emitter.Emit(make([]ReadingEvent, 5)...)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Emit blocked on a slow writer")
+	}
+
+	// One event may be in flight and one buffered; the rest are dropped
+	if dropped := emitDropped.Value() - before; dropped < 3 {
+		t.Errorf("got %v dropped events, expected at least 3", dropped)
+	}
+
+	close(w.release)
+	emitter.Close()
+	emitter.Emit(ReadingEvent{}) // no-op after Close
+}
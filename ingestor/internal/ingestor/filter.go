@@ -0,0 +1,118 @@
+package ingestor
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// LotFilter decides whether a fetched lot is stored. Lots rejected by any
+// configured filter are neither upserted nor recorded as a reading.
+type LotFilter func(lot *api.ParkingLot) bool
+
+// WithLotFilter adds a filter applied to every fetched lot
+func WithLotFilter(filter LotFilter) Option {
+	return func(i *Ingestor) {
+		i.filters = append(i.filters, filter)
+	}
+}
+
+// LotTypeFilter keeps lots whose type is in include (or any type when
+// include is empty) and not in exclude. Types are compared as parsed by
+// database.ParseLotType, so "Parkhaus" matches "garage", and unknown types
+// case-insensitively; lots without a type only pass when include is empty.
+func LotTypeFilter(include, exclude []string) LotFilter {
+	includeSet := normalizedSet(include)
+	excludeSet := normalizedSet(exclude)
+
+	return func(lot *api.ParkingLot) bool {
+		lotType := normalizeLotType(lot.LotType.String)
+		if excludeSet[lotType] {
+			return false
+		}
+		return len(includeSet) == 0 || includeSet[lotType]
+	}
+}
+
+// LotIDFilter keeps lots whose ID is in include (or any lot when include
+// is empty) and not in exclude. IDs must match exactly.
+func LotIDFilter(include, exclude []string) LotFilter {
+	return func(lot *api.ParkingLot) bool {
+		if slices.Contains(exclude, lot.ID) {
+			return false
+		}
+		return len(include) == 0 || slices.Contains(include, lot.ID)
+	}
+}
+
+// BoundingBoxFilter keeps lots whose coordinates lie within the box (edges
+// inclusive). Lots without coordinates are kept only if includeUnlocated.
+func BoundingBoxFilter(minLat, minLng, maxLat, maxLng float64, includeUnlocated bool) LotFilter {
+	return func(lot *api.ParkingLot) bool {
+		if !lot.Latitude.Valid || !lot.Longitude.Valid {
+			return includeUnlocated
+		}
+		lat, lng := lot.Latitude.Float64, lot.Longitude.Float64
+		return lat >= minLat && lat <= maxLat && lng >= minLng && lng <= maxLng
+	}
+}
+
+// RegionFilter keeps lots whose region is one of regions, compared
+// case-insensitively. Lots without a region are rejected.
+func RegionFilter(regions []string) LotFilter {
+	set := make(map[string]bool, len(regions))
+	for _, r := range regions {
+		set[strings.ToLower(strings.TrimSpace(r))] = true
+	}
+
+	return func(lot *api.ParkingLot) bool {
+		return set[strings.ToLower(strings.TrimSpace(lot.Region.String))]
+	}
+}
+
+// normalizeLotType maps lot types to a canonical form for comparisons
+func normalizeLotType(lotType string) string {
+	return strings.ToLower(string(database.ParseLotType(lotType)))
+}
+
+func normalizedSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[normalizeLotType(v)] = true
+	}
+	return set
+}
+
+// filterLots returns the data with all lots rejected by a filter removed
+func (i *Ingestor) filterLots(data *api.CityParkingData) *api.CityParkingData {
+	if len(i.filters) == 0 {
+		return data
+	}
+	return selectLots(data, i.keepLot)
+}
+
+// selectLots returns a copy of data with only the lots keep accepts
+func selectLots(data *api.CityParkingData, keep func(lot *api.ParkingLot) bool) *api.CityParkingData {
+	filtered := *data
+	filtered.Lots = nil
+	filtered.LotReadings = nil
+	for idx := range data.Lots {
+		if keep(&data.Lots[idx]) {
+			filtered.Lots = append(filtered.Lots, data.Lots[idx])
+			filtered.LotReadings = append(filtered.LotReadings, data.LotReadings[idx])
+		}
+	}
+	return &filtered
+}
+
+// keepLot reports whether a lot passes every filter
+func (i *Ingestor) keepLot(lot *api.ParkingLot) bool {
+	for _, filter := range i.filters {
+		if !filter(lot) {
+			return false
+		}
+	}
+	return true
+}
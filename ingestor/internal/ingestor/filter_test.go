@@ -0,0 +1,172 @@
+package ingestor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func lotOfType(lotType string) *api.ParkingLot {
+	lot := &api.ParkingLot{ID: "lot"}
+	if lotType != "" {
+		lot.LotType.String, lot.LotType.Valid = lotType, true
+	}
+	return lot
+}
+
+func TestLotTypeFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		lotType  string
+		expected bool
+	}{
+		{"no filter", nil, nil, "Parkhaus", true},
+		{"included", []string{"Parkhaus", "Tiefgarage"}, nil, "Tiefgarage", true},
+		{"included ignores case", []string{"parkhaus"}, nil, " PARKHAUS ", true},
+		{"not included", []string{"Parkhaus"}, nil, "Parkplatz", false},
+		{"excluded", nil, []string{"Parkplatz"}, "Parkplatz", false},
+		{"not excluded", nil, []string{"Parkplatz"}, "Parkhaus", true},
+		{"exclude wins", []string{"Parkhaus"}, []string{"Parkhaus"}, "Parkhaus", false},
+		{"untyped without include", nil, []string{"Parkplatz"}, "", true},
+		{"untyped with include", []string{"Parkhaus"}, nil, "", false},
+		{"included by canonical type", []string{"garage"}, nil, "Parkhaus", true},
+		{"excluded by variant", nil, []string{"P+R"}, "Park & Ride", false},
+		{"unknown type ignores case", []string{"Fahrradparkhaus"}, nil, "fahrradparkhaus", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := LotTypeFilter(tt.include, tt.exclude)
+			if got := filter(lotOfType(tt.lotType)); got != tt.expected {
+				t.Errorf("filter(%q) = %v, expected %v", tt.lotType, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBoundingBoxFilter(t *testing.T) {
+	located := func(lat, lng float64) *api.ParkingLot {
+		lot := &api.ParkingLot{ID: "lot"}
+		lot.Latitude.Float64, lot.Latitude.Valid = lat, true
+		lot.Longitude.Float64, lot.Longitude.Valid = lng, true
+		return lot
+	}
+
+	filter := BoundingBoxFilter(49.0, 8.3, 49.1, 8.5, false)
+	tests := []struct {
+		name     string
+		lot      *api.ParkingLot
+		expected bool
+	}{
+		{"inside", located(49.05, 8.4), true},
+		{"on edge", located(49.0, 8.5), true},
+		{"north", located(49.2, 8.4), false},
+		{"west", located(49.05, 8.2), false},
+		{"no coordinates", &api.ParkingLot{ID: "lot"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filter(tt.lot); got != tt.expected {
+				t.Errorf("filter() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+
+	if !BoundingBoxFilter(49.0, 8.3, 49.1, 8.5, true)(&api.ParkingLot{ID: "lot"}) {
+		t.Error("Expected unlocated lot to be kept with includeUnlocated")
+	}
+}
+
+func TestRegionFilter(t *testing.T) {
+	filter := RegionFilter([]string{"Innere Altstadt", "neustadt"})
+	inRegion := func(region string) *api.ParkingLot {
+		lot := &api.ParkingLot{ID: "lot"}
+		lot.Region.String, lot.Region.Valid = region, region != ""
+		return lot
+	}
+
+	if !filter(inRegion("Innere Altstadt")) || !filter(inRegion("Neustadt")) {
+		t.Error("Expected listed regions to be kept")
+	}
+	if filter(inRegion("Prager Straße")) || filter(inRegion("")) {
+		t.Error("Expected other regions and lots without region to be skipped")
+	}
+}
+
+func TestLotIDFilter(t *testing.T) {
+	tests := []struct {
+		include, exclude []string
+		id               string
+		expected         bool
+	}{
+		{nil, nil, "a", true},
+		{nil, []string{"a"}, "a", false},
+		{nil, []string{"a"}, "b", true},
+		{[]string{"a"}, nil, "a", true},
+		{[]string{"a"}, nil, "b", false},
+		{[]string{"a"}, []string{"a"}, "a", false},
+		{nil, []string{"a"}, "A", true},
+	}
+	for _, tt := range tests {
+		got := LotIDFilter(tt.include, tt.exclude)(&api.ParkingLot{ID: tt.id})
+		if got != tt.expected {
+			t.Errorf("include %v, exclude %v, lot %s: got %v, expected %v", tt.include, tt.exclude, tt.id, got, tt.expected)
+		}
+	}
+}
+
+func TestPollCityAppliesFilters(t *testing.T) {
+	data := cityData("Dresden", "garage", "street")
+	data.Lots[0].LotType.String, data.Lots[0].LotType.Valid = "Parkhaus", true
+	data.Lots[1].LotType.String, data.Lots[1].LotType.Valid = "Parkplatz", true
+
+	client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": data}}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden"}, time.Minute,
+		WithLotFilter(LotTypeFilter(nil, []string{"parkplatz"})))
+
+	if err := ing.pollCity(context.Background(), "Dresden"); err != nil {
+		t.Fatalf("pollCity() error: %v", err)
+	}
+
+	var lotID string
+	if err := db.QueryRow(`SELECT lot_id FROM parking_readings`).Scan(&lotID); err != nil {
+		t.Fatal(err)
+	}
+	if lotID != "garage" {
+		t.Errorf("Expected only the garage to be stored, got %s", lotID)
+	}
+	if count := testutil.CountRows(t, db, "parking_lots"); count != 1 {
+		t.Errorf("Expected 1 lot, got %d", count)
+	}
+}
+
+func TestPollCityExcludesLots(t *testing.T) {
+	client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": cityData("Dresden", "a", "b", "c")}}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden"}, time.Minute,
+		WithLotFilter(LotIDFilter(nil, []string{"b", "c"})))
+
+	if err := ing.pollCity(context.Background(), "Dresden"); err != nil {
+		t.Fatalf("pollCity() error: %v", err)
+	}
+
+	queries := map[string]string{
+		"parking_lots":     `SELECT id FROM parking_lots`,
+		"parking_readings": `SELECT lot_id FROM parking_readings`,
+	}
+	for table, query := range queries {
+		var lotID string
+		if err := db.QueryRow(query).Scan(&lotID); err != nil {
+			t.Fatal(err)
+		}
+		if count := testutil.CountRows(t, db, table); count != 1 || lotID != "a" {
+			t.Errorf("%s: got %d rows of %s, expected only lot a", table, count, lotID)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package ingestor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FirstPoll selects when Start runs its first cycle
+type FirstPoll string
+
+const (
+	// FirstPollImmediate polls right away
+	FirstPollImmediate FirstPoll = "immediate"
+	// FirstPollDelayed waits one interval
+	FirstPollDelayed FirstPoll = "delayed"
+	// FirstPollAligned waits for the next multiple of the interval, e.g.
+	// the top of the hour for hourly polls, so that readings get tidy
+	// timestamps. Boundaries are counted in UTC.
+	FirstPollAligned FirstPoll = "aligned"
+)
+
+// ParseFirstPoll parses a first poll mode; empty means FirstPollImmediate
+func ParseFirstPoll(value string) (FirstPoll, error) {
+	switch FirstPoll(strings.ToLower(value)) {
+	case "", FirstPollImmediate:
+		return FirstPollImmediate, nil
+	case FirstPollDelayed:
+		return FirstPollDelayed, nil
+	case FirstPollAligned:
+		return FirstPollAligned, nil
+	}
+	return "", fmt.Errorf("unknown first poll mode %q (expected immediate, delayed or aligned)", value)
+}
+
+// WithFirstPoll selects when Start runs its first cycle. Later cycles
+// follow every interval after it, so aligned polls stay aligned.
+func WithFirstPoll(mode FirstPoll) Option {
+	return func(i *Ingestor) {
+		i.firstPoll = mode
+	}
+}
+
+// firstPollDelay returns how long Start waits at now before its first cycle
+func (i *Ingestor) firstPollDelay(now time.Time) time.Duration {
+	switch i.firstPoll {
+	case FirstPollDelayed:
+		return i.interval
+	case FirstPollAligned:
+		if i.interval <= 0 {
+			return 0
+		}
+		next := now.Truncate(i.interval)
+		if next.Before(now) {
+			next = next.Add(i.interval)
+		}
+		return next.Sub(now)
+	}
+	return 0
+}
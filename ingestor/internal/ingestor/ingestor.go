@@ -3,77 +3,782 @@ package ingestor
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/niklas/parkmonitor/ingestor/internal/alert"
 	"github.com/niklas/parkmonitor/ingestor/internal/api"
+	"github.com/niklas/parkmonitor/ingestor/internal/backoff"
 	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/tracing"
 )
 
-// Ingestor handles the periodic polling and data storage
+// errNoLots is returned by pollCity when the API answered successfully but
+// listed no parking lots, which usually means the upstream source is down.
+var errNoLots = errors.New("no parking lots returned")
+
+// ErrUnknownCity is returned by RefreshCity for cities that aren't monitored
+var ErrUnknownCity = errors.New("city is not monitored")
+
+// ParkingAPI is the subset of the ParkenDD client used by the ingestor.
+// *api.Client implements it; tests substitute canned data.
+type ParkingAPI interface {
+	GetCityParkingDataContext(ctx context.Context, city string) (*api.CityParkingData, error)
+}
+
+// StoredMarker is implemented by clients making conditional requests. The
+// ingestor marks each response once it is stored, so the next request only
+// skips data that is already in the database.
+type StoredMarker interface {
+	MarkStored(city string, data *api.CityParkingData)
+}
+
+// Ingestor handles the periodic polling and data storage.
+//
+// Scheduled cycles, RefreshCity and RefreshLots may run concurrently. Polls
+// of the same city are serialized by a per-city lock, while different
+// cities proceed in parallel, so all state shared across cities is guarded
+// by a mutex: mu, cityMu and the own locks of the lot cache and stats.
+// Options are applied once in NewWithStore and read-only afterwards.
 type Ingestor struct {
-	db       *sql.DB
-	client   *api.Client
+	store    database.Store
+	client   ParkingAPI
 	cities   []string
 	interval time.Duration
+	alerts   *alert.Engine
+	emitter  *Emitter
+	bus      *Bus
+	tracer   tracing.Tracer
+	filters  []LotFilter
+	lots     *lotCache
+	sanity   *SanityCheck
+	stats    *stats
+
+	// storeAttempts and storeBackoff bound retries of transient database
+	// errors; the backoff doubles after each failed attempt, with jitter
+	storeAttempts int
+	storeBackoff  time.Duration
+
+	// cycleTimeout bounds the wall-clock time of a poll cycle (0 = none)
+	cycleTimeout time.Duration
+
+	// maxBackoff caps the doubled wait between cycles in which every city
+	// failed; backoff is disabled unless it exceeds interval
+	maxBackoff time.Duration
+
+	// jitter randomizes the store retry and outage backoff delays
+	jitter backoff.Jitter
+
+	// restartGap is the minimum time between a lot's last reading before
+	// Start and its first one after (0 = no minimum)
+	restartGap time.Duration
+
+	// timestampSource selects the time readings are stored with
+	timestampSource TimestampSource
+
+	// firstPoll selects when Start runs its first cycle
+	firstPoll FirstPoll
+
+	// activeHours, if set, is the daily window outside of which Start
+	// does not poll
+	activeHours *ActiveHours
+
+	// staleAfter is how old a city's last_updated may be at fetch time
+	// before its readings are flagged stale (0 = never)
+	staleAfter time.Duration
+
+	// instance is recorded as every reading's source_instance
+	instance string
+
+	// shutdownTimeout is how long Stop lets a cycle in progress finish
+	// before cancelling it (0 = cancel immediately)
+	shutdownTimeout time.Duration
+
+	// mu guards stop, cancel and done, which let Stop end a running Start,
+	// cities, which SetCities may replace while polling, and recent, the
+	// latest reading of each lot read less than restartGap before the first
+	// cycle. stop ends the loop between cycles; cancel also interrupts a
+	// cycle in progress.
+	mu     sync.Mutex
+	stop   context.CancelFunc
+	cancel context.CancelFunc
+	done   chan struct{}
+	recent map[string]time.Time
+
+	// cityMu guards cityLocks, which serialize scheduled and on-demand
+	// polls of a city, and lastStored, the latest fetch time stored per
+	// city. Holding the city's lock keeps lastStored[city] from changing.
+	cityMu     sync.Mutex
+	cityLocks  map[string]*sync.Mutex
+	lastStored map[string]time.Time
+}
+
+// Option configures optional ingestor behavior
+type Option func(*Ingestor)
+
+// WithAlerts evaluates alert rules after every successfully stored city
+func WithAlerts(engine *alert.Engine) Option {
+	return func(i *Ingestor) {
+		i.alerts = engine
+	}
+}
+
+// WithEmitter writes every stored reading to emitter once its city's
+// transaction has committed
+func WithEmitter(emitter *Emitter) Option {
+	return func(i *Ingestor) {
+		i.emitter = emitter
+	}
+}
+
+// WithBus publishes every stored reading to bus once its city's
+// transaction has committed
+func WithBus(bus *Bus) Option {
+	return func(i *Ingestor) {
+		i.bus = bus
+	}
+}
+
+// WithRestartGap makes Start skip the first poll of lots read less than gap
+// ago, e.g. by the previous run before a quick restart, so the time series
+// keeps its spacing. The store must implement database.RecentReader.
+func WithRestartGap(gap time.Duration) Option {
+	return func(i *Ingestor) {
+		i.restartGap = gap
+	}
+}
+
+// New creates a new ingestor instance backed by a SQLite database
+func New(db *sql.DB, client ParkingAPI, cities []string, interval time.Duration, opts ...Option) *Ingestor {
+	return NewWithStore(database.NewSQLiteStore(db), client, cities, interval, opts...)
+}
+
+// WithTracer records spans for poll cycles, city fetches and database writes
+func WithTracer(tracer tracing.Tracer) Option {
+	return func(i *Ingestor) {
+		i.tracer = tracer
+	}
+}
+
+// WithStoreRetry retries a city's transaction up to attempts times when
+// the database fails transiently, waiting delay (doubling each time)
+// between attempts. Persistent errors are returned immediately.
+func WithStoreRetry(attempts int, delay time.Duration) Option {
+	return func(i *Ingestor) {
+		i.storeAttempts = attempts
+		i.storeBackoff = delay
+	}
+}
+
+// WithCycleTimeout gives every poll cycle a hard deadline. Cities that
+// have not been polled when it expires are skipped until the next cycle.
+func WithCycleTimeout(timeout time.Duration) Option {
+	return func(i *Ingestor) {
+		i.cycleTimeout = timeout
+	}
+}
+
+// WithOutageBackoff doubles the wait before the next cycle, up to limit,
+// after every cycle in which all cities failed, e.g. while the API is
+// down. The first cycle with a successful city restores the interval.
+func WithOutageBackoff(limit time.Duration) Option {
+	return func(i *Ingestor) {
+		i.maxBackoff = limit
+	}
+}
+
+// WithJitter randomizes the store retry and outage backoff delays with the
+// given strategy
+func WithJitter(jitter backoff.Jitter) Option {
+	return func(i *Ingestor) {
+		i.jitter = jitter
+	}
+}
+
+// WithInstance records id as the source_instance of every reading, so
+// readings of several ingestors writing to one database can be told apart
+func WithInstance(id string) Option {
+	return func(i *Ingestor) {
+		i.instance = id
+	}
+}
+
+// WithStaleAfter flags readings with database.QualityStale when their
+// city's last_updated was more than age old when they were fetched, e.g.
+// because the source's scraper stopped
+func WithStaleAfter(age time.Duration) Option {
+	return func(i *Ingestor) {
+		i.staleAfter = age
+	}
 }
 
-// New creates a new ingestor instance
-func New(db *sql.DB, client *api.Client, cities []string, interval time.Duration) *Ingestor {
-	return &Ingestor{
-		db:       db,
+// WithShutdownTimeout makes Stop wait up to timeout for a cycle in
+// progress to finish before cancelling it, so that a stuck city cannot
+// keep the process from exiting
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(i *Ingestor) {
+		i.shutdownTimeout = timeout
+	}
+}
+
+// NewWithStore creates a new ingestor instance writing to an arbitrary Store
+func NewWithStore(store database.Store, client ParkingAPI, cities []string, interval time.Duration, opts ...Option) *Ingestor {
+	i := &Ingestor{
+		store:    store,
 		client:   client,
 		cities:   cities,
 		interval: interval,
+		tracer:   tracing.Noop{},
+		lots:     newLotCache(),
+		stats:    newStats(),
+
+		storeAttempts: 3,
+		storeBackoff:  500 * time.Millisecond,
+
+		cityLocks:  make(map[string]*sync.Mutex),
+		lastStored: make(map[string]time.Time),
 	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
 }
 
-// Start begins the periodic polling process
-func (i *Ingestor) Start() {
-	// Run immediately on startup
-	i.poll()
+// Start polls immediately (or as selected by WithFirstPoll) and then on
+// every interval until ctx is cancelled or Stop is called. Outside the
+// WithActiveHours window it idles until the window opens. A cycle in
+// progress when ctx is cancelled is interrupted and its open transaction
+// rolled back; see Stop for the grace it gets there.
+func (i *Ingestor) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	loop, stop := context.WithCancel(ctx)
+	defer stop()
+
+	i.mu.Lock()
+	if i.done != nil {
+		i.mu.Unlock()
+		return errors.New("ingestor already started")
+	}
+	i.stop, i.cancel, i.done = stop, cancel, make(chan struct{})
+	i.mu.Unlock()
 
-	// Then run periodically
-	ticker := time.NewTicker(i.interval)
-	defer ticker.Stop()
+	defer func() {
+		i.mu.Lock()
+		close(i.done)
+		i.stop, i.cancel, i.done = nil, nil, nil
+		i.mu.Unlock()
+	}()
 
-	for range ticker.C {
-		i.poll()
+	if i.restartGap > 0 {
+		i.loadRecent(ctx)
+	}
+
+	// Run on startup, then every interval measured from the start of the
+	// previous cycle, or longer while backing off
+	outages := 0
+	paused := false
+	cycle := func() time.Duration {
+		start := time.Now()
+		if h := i.activeHours; h != nil {
+			if wait := h.Until(start); wait > 0 {
+				if !paused {
+					log.Printf("Outside active hours %s, pausing polls until %s", h, start.Add(wait).Format("15:04"))
+					paused = true
+				}
+				return wait
+			}
+			if paused {
+				log.Printf("Inside active hours %s, resuming polls", h)
+				paused = false
+			}
+		}
+		succeeded, err := i.pollCycle(ctx)
+		i.logCycle(err)
+		if ctx.Err() == nil {
+			outages = i.countOutage(outages, succeeded == 0 && len(i.Cities()) > 0)
+		}
+		// recent only applies to the first cycle
+		i.mu.Lock()
+		i.recent = nil
+		i.mu.Unlock()
+		return max(i.nextDelay(outages)-time.Since(start), 0)
+	}
+
+	delay := i.firstPollDelay(time.Now())
+	if delay > 0 {
+		log.Printf("First poll in %v", delay.Round(time.Second))
+	} else {
+		delay = cycle()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-loop.Done():
+			return nil
+		case <-timer.C:
+			if loop.Err() != nil {
+				return nil
+			}
+			timer.Reset(cycle())
+		}
 	}
 }
 
-// poll fetches data for all configured cities and stores it
-func (i *Ingestor) poll() {
+// loadRecent remembers the lots read less than restartGap ago, e.g. just
+// before a restart, so that the first cycle does not read them again
+func (i *Ingestor) loadRecent(ctx context.Context) {
+	r, ok := i.store.(database.RecentReader)
+	if !ok {
+		return
+	}
+	recent, err := r.LatestReadings(ctx, time.Now().Add(-i.restartGap))
+	if err != nil {
+		log.Printf("Warning: failed to read the latest readings, polling all lots immediately: %v", err)
+		return
+	}
+
+	i.mu.Lock()
+	i.recent = recent
+	i.mu.Unlock()
+}
+
+// dropRecent returns data without the lots whose last reading before Start
+// is less than restartGap older than timestamp
+func (i *Ingestor) dropRecent(data *api.CityParkingData, timestamp time.Time) *api.CityParkingData {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.recent) == 0 {
+		return data
+	}
+	return selectLots(data, func(lot *api.ParkingLot) bool {
+		last, ok := i.recent[lot.ID]
+		return !ok || timestamp.Sub(last) >= i.restartGap
+	})
+}
+
+// backingOff reports whether outages change the wait between cycles
+func (i *Ingestor) backingOff() bool {
+	return i.maxBackoff > i.interval
+}
+
+// countOutage returns the number of consecutive cycles in which every
+// city failed, given the previous count and whether the last cycle was
+// one. Entering and leaving backoff is logged.
+func (i *Ingestor) countOutage(outages int, outage bool) int {
+	if !outage {
+		if outages > 0 && i.backingOff() {
+			log.Printf("Poll cycle succeeded again, resuming polling every %v", i.interval)
+		}
+		return 0
+	}
+	if outages == 0 && i.backingOff() {
+		log.Printf("Warning: every city failed, backing off from polling every %v (up to %v)", i.interval, i.maxBackoff)
+	}
+	return outages + 1
+}
+
+// nextDelay returns the wait between cycles after the given number of
+// consecutive outages: the interval, doubled per outage up to maxBackoff
+// and jittered, but never shorter than the interval
+func (i *Ingestor) nextDelay(outages int) time.Duration {
+	if outages == 0 || !i.backingOff() {
+		return i.interval
+	}
+	policy := backoff.Policy{Base: 2 * i.interval, Max: i.maxBackoff, Jitter: i.jitter}
+	return max(policy.Delay(outages), i.interval)
+}
+
+// Stop ends a running Start and waits for it to return. A cycle in
+// progress is given the shutdown timeout to finish and then cancelled;
+// which of both happened is logged. It is a no-op when the ingestor is not
+// running.
+func (i *Ingestor) Stop() {
+	i.mu.Lock()
+	stop, cancel, done := i.stop, i.cancel, i.done
+	i.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	if i.shutdownTimeout <= 0 {
+		cancel()
+		<-done
+		return
+	}
+
+	stop()
+	timer := time.NewTimer(i.shutdownTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		log.Printf("Shutdown clean: no poll cycle was interrupted")
+	case <-timer.C:
+		log.Printf("Warning: poll cycle still running after %v, forcing shutdown", i.shutdownTimeout)
+		cancel()
+		<-done
+	}
+}
+
+// logCycle reports the outcome of a poll cycle
+func (i *Ingestor) logCycle(err error) {
+	if err != nil {
+		log.Printf("Poll cycle finished with errors: %v", err)
+	}
+}
+
+// poll fetches data for all configured cities and stores it. Every city is
+// attempted; the returned error joins the failures of all cities that
+// could not be stored (cities without lots only produce a warning).
+func (i *Ingestor) poll(ctx context.Context) error {
+	_, err := i.pollCycle(ctx)
+	return err
+}
+
+// pollCycle is poll, additionally reporting how many cities were stored
+func (i *Ingestor) pollCycle(ctx context.Context) (succeeded int, err error) {
+	cities := i.Cities()
+	ctx, span := i.tracer.Start(ctx, "ingestor.poll", tracing.Int("city_count", len(cities)))
+	defer func() { tracing.Finish(span, err) }()
+
 	log.Printf("Starting poll cycle at %s", time.Now().Format(time.RFC3339))
 
-	for _, city := range i.cities {
-		if err := i.pollCity(city); err != nil {
+	if i.cycleTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.cycleTimeout)
+		defer cancel()
+	}
+
+	var errs []error
+	readings := 0
+	defer func() {
+		cycleReadings.Set(float64(readings))
+		i.stats.cycle()
+	}()
+	for idx, city := range cities {
+		if ctx.Err() != nil {
+			skipped := cities[idx:]
+			log.Printf("Warning: poll cycle interrupted (%v), skipping %d cities: %s", ctx.Err(), len(skipped), strings.Join(skipped, ", "))
+			errs = append(errs, fmt.Errorf("skipped %d cities: %w", len(skipped), ctx.Err()))
+			break
+		}
+		result, err := i.pollCityRecover(ctx, city)
+		if err != nil {
+			if errors.Is(err, errNoLots) {
+				log.Printf("Warning: %s returned no parking lots, source may be unavailable", city)
+				continue
+			}
 			log.Printf("Error polling city %s: %v", city, err)
+			errs = append(errs, fmt.Errorf("city %s: %w", city, err))
 			continue
 		}
 		log.Printf("Successfully polled city: %s", city)
+		readings += result.Lots
+		succeeded++
+	}
+
+	return succeeded, errors.Join(errs...)
+}
+
+// PollCity fetches and stores data for a single city outside the regular
+// polling schedule
+func (i *Ingestor) PollCity(ctx context.Context, city string) error {
+	return i.pollCity(ctx, city)
+}
+
+// CityResult summarizes a city poll
+type CityResult struct {
+	City string `json:"city"`
+	// Lots is the number of lots stored; 0 when the data was already stored
+	Lots      int       `json:"lots_updated"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Cities returns a copy of the cities being polled
+func (i *Ingestor) Cities() []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return slices.Clone(i.cities)
+}
+
+// SetCities replaces the cities being polled, e.g. when the API lists new
+// ones. A cycle in progress finishes with the cities it started with.
+func (i *Ingestor) SetCities(cities []string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.cities = slices.Clone(cities)
+}
+
+// RefreshCity polls a monitored city immediately. It waits for a scheduled
+// poll of the same city to finish first, and never stores a second set of
+// readings for a fetch time that was already stored.
+func (i *Ingestor) RefreshCity(ctx context.Context, city string) (*CityResult, error) {
+	if !slices.Contains(i.Cities(), city) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCity, city)
 	}
+	return i.pollCityResult(ctx, city)
+}
+
+// pollCityRecover is pollCity for the poll cycle: a panic, e.g. from
+// malformed data, is logged and returned as an error so the remaining
+// cities are still polled
+func (i *Ingestor) pollCityRecover(ctx context.Context, city string) (result *CityResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic while polling %s: %v\n%s", city, r, debug.Stack())
+			result, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return i.pollCityResult(ctx, city)
 }
 
 // pollCity fetches and stores data for a single city
-func (i *Ingestor) pollCity(city string) error {
-	// Fetch parking data
-	data, err := i.client.GetCityParkingData(city)
+func (i *Ingestor) pollCity(ctx context.Context, city string) error {
+	_, err := i.pollCityResult(ctx, city)
+	return err
+}
+
+// pollCityResult fetches and stores data for a single city and reports
+// what was stored. Polls of the same city are serialized.
+func (i *Ingestor) pollCityResult(ctx context.Context, city string) (result *CityResult, err error) {
+	ctx, span := i.tracer.Start(ctx, "ingestor.poll_city", tracing.String("city", city))
+	defer func() {
+		tracing.Finish(span, err)
+		i.stats.poll(city, result, err)
+	}()
+
+	unlock := i.lockCity(city)
+	defer unlock()
+
+	// Fetch parking data. With conditional requests, an unchanged city
+	// has nothing new to store.
+	data, err := i.fetchCity(ctx, city)
+	if errors.Is(err, api.ErrNotModified) {
+		log.Printf("%s is unchanged since the last poll, skipping", city)
+		return &CityResult{City: city, Timestamp: i.storedAt(city)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fetched := data
+	span.SetAttributes(tracing.Int("lot_count", len(data.Lots)))
+	cityFreshness.record(city, data.LastUpdatedAt)
+	i.checkSanity(city, data)
+
+	// An empty lot list is not worth a transaction, but should not look
+	// like a successful poll either
+	if len(data.Lots) == 0 {
+		return nil, errNoLots
+	}
+
+	if filtered := i.filterLots(data); len(filtered.Lots) != len(data.Lots) {
+		log.Printf("Skipping %d of %d lots for %s due to filters", len(data.Lots)-len(filtered.Lots), len(data.Lots), city)
+		data = filtered
+	}
+
+	timestamp := i.readingTime(data)
+
+	if recent := i.dropRecent(data, timestamp); len(recent.Lots) != len(data.Lots) {
+		log.Printf("Skipping %d of %d lots for %s read less than %v before the restart",
+			len(data.Lots)-len(recent.Lots), len(data.Lots), city, i.restartGap)
+		if len(recent.Lots) == 0 {
+			i.markStored(city, fetched)
+			return &CityResult{City: city, Timestamp: i.storedAt(city)}, nil
+		}
+		data = recent
+	}
+
+	result = &CityResult{City: city, Timestamp: timestamp}
+	if last := i.storedAt(city); !last.IsZero() && !timestamp.After(last) {
+		log.Printf("Readings for %s at %s are already stored, skipping", city, timestamp.Format(time.RFC3339))
+		i.markStored(city, fetched)
+		return result, nil
+	}
+
+	stored, err := i.storeCityWithRetry(ctx, city, data, timestamp, true)
+	if err != nil {
+		return nil, err
+	}
+	i.setStoredAt(city, timestamp)
+	i.markStored(city, fetched)
+	result.Lots = len(stored)
+
+	// Replayed captures were stored long after they were fetched
+	if !data.Replayed {
+		recordIngestLag(city, data.LastUpdatedAt, time.Now())
+	}
+
+	log.Printf("Stored %d parking lots for %s", len(stored), city)
+
+	if i.emitter != nil || i.bus != nil {
+		events := readingEvents(city, data, stored, timestamp)
+		if i.emitter != nil {
+			i.emitter.Emit(events...)
+		}
+		if i.bus != nil {
+			i.bus.Publish(events...)
+		}
+	}
+	if i.alerts != nil {
+		i.alerts.Evaluate(ctx, observations(data, stored, timestamp))
+	}
+
+	return result, nil
+}
+
+// lockCity acquires the city's poll lock and returns its release function.
+// The city's stored fetch time only changes while the lock is held.
+func (i *Ingestor) lockCity(city string) func() {
+	i.cityMu.Lock()
+	lock, ok := i.cityLocks[city]
+	if !ok {
+		lock = &sync.Mutex{}
+		i.cityLocks[city] = lock
+	}
+	i.cityMu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// storedAt returns the latest fetch time stored for city, or the zero time
+func (i *Ingestor) storedAt(city string) time.Time {
+	i.cityMu.Lock()
+	defer i.cityMu.Unlock()
+	return i.lastStored[city]
+}
+
+// setStoredAt records timestamp as the latest fetch time stored for city
+func (i *Ingestor) setStoredAt(city string, timestamp time.Time) {
+	i.cityMu.Lock()
+	defer i.cityMu.Unlock()
+	i.lastStored[city] = timestamp
+}
+
+// markStored tells a conditional client that the city's response has been
+// handled, making it the watermark of the next request
+func (i *Ingestor) markStored(city string, data *api.CityParkingData) {
+	if m, ok := i.client.(StoredMarker); ok {
+		m.MarkStored(city, data)
+	}
+}
+
+// fetchCity retrieves a city's parking data from the API
+func (i *Ingestor) fetchCity(ctx context.Context, city string) (data *api.CityParkingData, err error) {
+	ctx, span := i.tracer.Start(ctx, "api.get_city_parking_data", tracing.String("city", city))
+	defer func() { tracing.Finish(span, err) }()
+
+	return i.client.GetCityParkingDataContext(ctx, city)
+}
+
+// RefreshLots fetches every monitored city and upserts its lots without
+// storing readings, e.g. to fill columns added to existing lots. Like a
+// poll cycle, it continues past failing cities and returns their errors.
+func (i *Ingestor) RefreshLots(ctx context.Context) error {
+	var errs []error
+	for _, city := range i.Cities() {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		count, err := i.refreshCityLots(ctx, city)
+		if err != nil {
+			log.Printf("Error refreshing lots of %s: %v", city, err)
+			errs = append(errs, fmt.Errorf("city %s: %w", city, err))
+			continue
+		}
+		log.Printf("Refreshed %d parking lots for %s", count, city)
+	}
+	return errors.Join(errs...)
+}
+
+// refreshCityLots fetches a city and upserts its lots, returning how many
+// were written
+func (i *Ingestor) refreshCityLots(ctx context.Context, city string) (int, error) {
+	unlock := i.lockCity(city)
+	defer unlock()
+
+	data, err := i.fetchCity(ctx, city)
+	if errors.Is(err, api.ErrNotModified) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(data.Lots) == 0 {
+		return 0, errNoLots
+	}
+	data = i.filterLots(data)
+
+	stored, err := i.storeCityWithRetry(ctx, city, data, fetchTime(data), false)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	return len(stored), nil
+}
+
+// storeCityWithRetry stores a city, retrying the whole transaction on
+// transient database errors and reconnecting the store between attempts
+// when it supports it. The last error is returned once attempts run out.
+func (i *Ingestor) storeCityWithRetry(ctx context.Context, city string, data *api.CityParkingData, timestamp time.Time, withReadings bool) ([]int, error) {
+	policy := backoff.Policy{Base: i.storeBackoff, Jitter: i.jitter}
+	for attempt := 1; ; attempt++ {
+		stored, err := i.storeCity(ctx, city, data, timestamp, withReadings)
+		if err == nil || !database.IsTransient(err) {
+			return stored, err
+		}
+		if attempt >= i.storeAttempts {
+			return nil, fmt.Errorf("failed to store after %d attempts: %w", attempt, err)
+		}
+
+		log.Printf("Warning: transient database error storing %s (attempt %d/%d): %v", city, attempt, i.storeAttempts, err)
+		if r, ok := i.store.(database.Reconnector); ok {
+			if err := r.Reconnect(ctx); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(policy.Delay(attempt)):
+		}
+	}
+}
+
+// storeCity upserts a city's lots and, if withReadings is set, inserts
+// their readings in one transaction. It returns the indexes in data.Lots
+// of the lots that were written.
+func (i *Ingestor) storeCity(ctx context.Context, city string, data *api.CityParkingData, timestamp time.Time, withReadings bool) (stored []int, err error) {
+	ctx, span := i.tracer.Start(ctx, "database.store_city",
+		tracing.String("city", city), tracing.Int("lot_count", len(data.Lots)))
+	defer func() { tracing.Finish(span, err) }()
 
 	// Start transaction
-	ctx := context.Background()
-	tx, err := i.db.BeginTx(ctx, nil)
+	tx, err := i.store.Begin(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer tx.Rollback()
 
-	timestamp := time.Now()
-
-	// Store/update parking lots and insert readings
+	// Store/update parking lots and insert readings. Lots whose metadata
+	// is unchanged since the last committed upsert only get their reading
+	// and are marked as seen.
+	var upserted []*database.ParkingLot
+	var seen []string
+	var readings []*database.ParkingReading
+	var quality database.Quality
+	if i.stale(data) {
+		quality |= database.QualityStale
+	}
 	for idx, lot := range data.Lots {
 		// Convert api.ParkingLot to database.ParkingLot
 		dbLot := &database.ParkingLot{
@@ -86,31 +791,96 @@ func (i *Ingestor) pollCity(city string) error {
 			Latitude:  lot.Latitude,
 			Longitude: lot.Longitude,
 			Region:    lot.Region,
+			Extras:    sql.NullString{String: string(lot.Extras), Valid: lot.Extras != nil},
+
+			ObservedAt: timestamp,
 		}
 
-		// Upsert parking lot
-		if err := database.UpsertParkingLotTx(tx, dbLot); err != nil {
-			return err
+		// Upsert parking lot. A lot whose ID another city already uses is
+		// skipped so it cannot overwrite that city's lot.
+		if !i.lots.unchanged(dbLot) {
+			if err := tx.UpsertLot(ctx, dbLot); errors.Is(err, database.ErrLotCityConflict) {
+				log.Printf("Warning: skipping lot: %v", err)
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			upserted = append(upserted, dbLot)
+		} else {
+			seen = append(seen, dbLot.ID)
+		}
+		stored = append(stored, idx)
+		if !withReadings {
+			continue
 		}
 
-		// Insert reading
+		// Collect reading, inserted with the others below
 		reading := &database.ParkingReading{
 			LotID:     data.LotReadings[idx].LotID,
 			City:      city,
 			Timestamp: timestamp,
 			Free:      data.LotReadings[idx].Free,
 			State:     data.LotReadings[idx].State,
+
+			SourceInstance: i.instance,
+			Quality:        quality,
+		}
+		if data.LotReadings[idx].Forecast && reading.State == database.StateNoData {
+			reading.Quality |= database.QualityForecastOnly
 		}
-		if err := database.InsertReadingTx(tx, reading); err != nil {
-			return err
+		if anomalous(reading.Free, lot.Total) {
+			log.Printf("Warning: anomalous reading for %s in %s: free=%d total=%d", lot.ID, city, reading.Free, lot.Total)
+			reading.Anomalous = true
 		}
+		readings = append(readings, reading)
+	}
+
+	if err := tx.InsertReadings(ctx, readings); err != nil {
+		return nil, err
+	}
+	if err := tx.MarkLotsSeen(ctx, seen, timestamp); err != nil {
+		return nil, err
 	}
 
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
-		return err
+		return nil, err
 	}
+	i.lots.store(upserted)
+	lotUpserts.Add(float64(len(upserted)), "performed")
+	lotUpserts.Add(float64(len(stored)-len(upserted)), "skipped")
+	return stored, nil
+}
 
-	log.Printf("Stored %d parking lots for %s", len(data.Lots), city)
-	return nil
+// stale reports whether data's source had not updated for longer than
+// staleAfter when it was fetched. Data without last_updated is not stale.
+func (i *Ingestor) stale(data *api.CityParkingData) bool {
+	if i.staleAfter <= 0 || data.LastUpdatedAt.IsZero() {
+		return false
+	}
+	return fetchTime(data).Sub(data.LastUpdatedAt) > i.staleAfter
+}
+
+// anomalous reports whether a reading's free count is impossible for the
+// lot. A total of 0 means the source does not know the capacity.
+func anomalous(free, total int) bool {
+	return free < 0 || (total > 0 && free > total)
+}
+
+// observations converts the lots of data at the given indexes, which
+// storeCity reports as stored, into alert observations
+func observations(data *api.CityParkingData, stored []int, timestamp time.Time) []alert.Observation {
+	result := make([]alert.Observation, len(stored))
+	for idx, lotIdx := range stored {
+		lot := data.Lots[lotIdx]
+		result[idx] = alert.Observation{
+			LotID:     lot.ID,
+			LotName:   lot.Name,
+			City:      lot.City,
+			Free:      data.LotReadings[lotIdx].Free,
+			Total:     lot.Total,
+			Timestamp: timestamp,
+		}
+	}
+	return result
 }
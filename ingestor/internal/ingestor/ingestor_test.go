@@ -0,0 +1,1222 @@
+package ingestor
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/alert"
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+	"github.com/niklas/parkmonitor/ingestor/internal/backoff"
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+	"github.com/niklas/parkmonitor/ingestor/internal/tracing"
+)
+
+const dresdenJSON = `{
+	"last_downloaded": "2024-01-01T12:00:00",
+	"last_updated": "2024-01-01T11:55:00",
+	"lots": [
+		{"id": "dresdenaltmarkt", "name": "Altmarkt", "total": 400, "free": 120, "state": "open",
+		 "coords": {"lat": 51.05, "lng": 13.74}, "lot_type": "Tiefgarage", "region": "Innere Altstadt"},
+		{"id": "dresdenzwinger", "name": "Zwinger", "total": 200, "free": 0, "state": "closed"}
+	]
+}`
+
+func newTestServer(t *testing.T, responses map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPollCityRoundTrip(t *testing.T) {
+	server := newTestServer(t, map[string]string{"/Dresden": dresdenJSON})
+	db := testutil.NewDB(t)
+	ing := New(db, api.NewClientWithBaseURL(server.URL), []string{"Dresden"}, time.Minute)
+
+	if err := ing.pollCity(context.Background(), "Dresden"); err != nil {
+		t.Fatalf("pollCity() error: %v", err)
+	}
+
+	if count := testutil.CountRows(t, db, "parking_lots"); count != 2 {
+		t.Errorf("Expected 2 lots, got %d", count)
+	}
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 2 {
+		t.Errorf("Expected 2 readings, got %d", count)
+	}
+
+	var name, region string
+	var total int
+	err := db.QueryRow(`SELECT name, total, region FROM parking_lots WHERE id = 'dresdenaltmarkt'`).Scan(&name, &total, &region)
+	if err != nil {
+		t.Fatalf("Failed to query lot: %v", err)
+	}
+	if name != "Altmarkt" || total != 400 || region != "Innere Altstadt" {
+		t.Errorf("Unexpected lot (%s, %d, %s)", name, total, region)
+	}
+
+	var free int
+	var state string
+	err = db.QueryRow(`SELECT free, state FROM parking_readings WHERE lot_id = 'dresdenzwinger'`).Scan(&free, &state)
+	if err != nil {
+		t.Fatalf("Failed to query reading: %v", err)
+	}
+	if free != 0 || state != "closed" {
+		t.Errorf("Unexpected reading (%d, %s)", free, state)
+	}
+
+	// A second poll updates lots in place and appends readings
+	if err := ing.pollCity(context.Background(), "Dresden"); err != nil {
+		t.Fatalf("Second pollCity() error: %v", err)
+	}
+	if count := testutil.CountRows(t, db, "parking_lots"); count != 2 {
+		t.Errorf("Expected 2 lots after second poll, got %d", count)
+	}
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 4 {
+		t.Errorf("Expected 4 readings after second poll, got %d", count)
+	}
+}
+
+// fakeAPI serves canned city data and errors without touching the network
+type fakeAPI struct {
+	data   map[string]*api.CityParkingData
+	errors map[string]error
+}
+
+func (f *fakeAPI) GetCityParkingDataContext(ctx context.Context, city string) (*api.CityParkingData, error) {
+	if err, ok := f.errors[city]; ok {
+		return nil, err
+	}
+	if data, ok := f.data[city]; ok {
+		return data, nil
+	}
+	return nil, fmt.Errorf("unknown city %s", city)
+}
+
+// cityData builds canned API data with one open lot per ID
+func cityData(city string, lotIDs ...string) *api.CityParkingData {
+	data := &api.CityParkingData{}
+	for _, id := range lotIDs {
+		data.Lots = append(data.Lots, api.ParkingLot{ID: id, City: city, Name: "Lot " + id, Total: 100})
+		data.LotReadings = append(data.LotReadings, api.ParkingLotReading{LotID: id, Free: 50, State: "open"})
+	}
+	return data
+}
+
+func TestPollPartialFailure(t *testing.T) {
+	client := &fakeAPI{
+		data: map[string]*api.CityParkingData{
+			"Dresden": cityData("Dresden", "d1", "d2"),
+			"Basel":   cityData("Basel", "b1"),
+		},
+		errors: map[string]error{"Hamburg": errors.New("connection refused")},
+	}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden", "Hamburg", "Basel"}, time.Minute)
+
+	succeeded, err := ing.pollCycle(context.Background())
+	if err == nil {
+		t.Fatal("Expected poll() to report the failing city")
+	}
+	if succeeded != 2 {
+		t.Errorf("got %d succeeded cities, expected 2", succeeded)
+	}
+	if !strings.Contains(err.Error(), "city Hamburg") || strings.Contains(err.Error(), "Dresden") {
+		t.Errorf("Expected error naming only Hamburg, got %q", err)
+	}
+	if !errors.Is(err, client.errors["Hamburg"]) {
+		t.Errorf("Expected joined error to wrap the fetch error, got %v", err)
+	}
+
+	// The failing city in the middle must not prevent the others
+	if count := testutil.CountRows(t, db, "parking_lots"); count != 3 {
+		t.Errorf("Expected 3 lots, got %d", count)
+	}
+	var hamburg int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM parking_readings WHERE city = 'Hamburg'`).Scan(&hamburg); err != nil {
+		t.Fatal(err)
+	}
+	if hamburg != 0 {
+		t.Errorf("Expected no readings for failing city, got %d", hamburg)
+	}
+}
+
+func TestPollRecoversCityPanic(t *testing.T) {
+	// Lots without matching readings make storeCity index out of range
+	broken := cityData("Broken", "x1")
+	broken.LotReadings = nil
+	client := &fakeAPI{
+		data: map[string]*api.CityParkingData{
+			"Broken":  broken,
+			"Dresden": cityData("Dresden", "d1"),
+		},
+	}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Broken", "Dresden"}, time.Minute)
+
+	err := ing.poll(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "city Broken: panic") {
+		t.Fatalf("got error %v, expected a recovered panic for Broken", err)
+	}
+
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 1 {
+		t.Errorf("got %d readings, expected 1 from Dresden", count)
+	}
+	if count := testutil.CountRows(t, db, "parking_lots"); count != 1 {
+		t.Errorf("got %d lots, expected Broken's transaction to be rolled back", count)
+	}
+
+	// The panicking poll released the city's lock
+	if err := ing.poll(context.Background()); err == nil {
+		t.Error("Expected the second poll to fail for Broken again")
+	}
+}
+
+func TestPollAllSucceeded(t *testing.T) {
+	client := &fakeAPI{data: map[string]*api.CityParkingData{
+		"Dresden": cityData("Dresden", "d1"),
+		"Empty":   cityData("Empty"),
+	}}
+	ing := New(testutil.NewDB(t), client, []string{"Dresden", "Empty"}, time.Minute)
+
+	if err := ing.poll(context.Background()); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestPollCityFetchError(t *testing.T) {
+	fetchErr := errors.New("boom")
+	client := &fakeAPI{errors: map[string]error{"Dresden": fetchErr}}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden"}, time.Minute)
+
+	if err := ing.pollCity(context.Background(), "Dresden"); !errors.Is(err, fetchErr) {
+		t.Errorf("Expected fetch error, got %v", err)
+	}
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 0 {
+		t.Errorf("Expected no readings, got %d", count)
+	}
+}
+
+func TestPollCityNotModified(t *testing.T) {
+	client := &fakeAPI{errors: map[string]error{"Dresden": fmt.Errorf("Dresden: %w", api.ErrNotModified)}}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden"}, time.Minute)
+
+	succeeded, err := ing.pollCycle(context.Background())
+	if err != nil || succeeded != 1 {
+		t.Fatalf("got (%d, %v), expected an unchanged city to count as polled", succeeded, err)
+	}
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 0 {
+		t.Errorf("got %d readings, expected none for an unchanged city", count)
+	}
+}
+
+func TestPollCityNoLots(t *testing.T) {
+	client := &fakeAPI{data: map[string]*api.CityParkingData{"Empty": cityData("Empty")}}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Empty"}, time.Minute)
+
+	if err := ing.pollCity(context.Background(), "Empty"); !errors.Is(err, errNoLots) {
+		t.Errorf("Expected errNoLots, got %v", err)
+	}
+}
+
+func TestPollCityUsesFetchTime(t *testing.T) {
+	fetchedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	data := cityData("Dresden", "d1")
+	data.FetchedAt = fetchedAt
+	client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": data}}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden"}, time.Minute)
+
+	if err := ing.PollCity(context.Background(), "Dresden"); err != nil {
+		t.Fatalf("PollCity() error: %v", err)
+	}
+
+	var ts time.Time
+	if err := db.QueryRow(`SELECT timestamp FROM parking_readings`).Scan(&ts); err != nil {
+		t.Fatal(err)
+	}
+	if !ts.Equal(fetchedAt) {
+		t.Errorf("Expected reading timestamp %v, got %v", fetchedAt, ts)
+	}
+}
+
+func TestPollCityTimestampSource(t *testing.T) {
+	fetchedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	updatedAt := fetchedAt.Add(-3 * time.Minute)
+
+	tests := []struct {
+		name      string
+		source    TimestampSource
+		updatedAt time.Time
+		expected  time.Time
+	}{
+		{"fetch", TimestampFetch, updatedAt, fetchedAt},
+		{"upstream", TimestampUpstream, updatedAt, updatedAt},
+		{"upstream without last_updated", TimestampUpstream, time.Time{}, fetchedAt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := cityData("Dresden", "d1")
+			data.FetchedAt = fetchedAt
+			data.LastUpdatedAt = tt.updatedAt
+			client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": data}}
+			db := testutil.NewDB(t)
+			ing := New(db, client, []string{"Dresden"}, time.Minute, WithTimestampSource(tt.source))
+
+			if err := ing.PollCity(context.Background(), "Dresden"); err != nil {
+				t.Fatalf("PollCity() error: %v", err)
+			}
+			var ts time.Time
+			if err := db.QueryRow(`SELECT timestamp FROM parking_readings`).Scan(&ts); err != nil {
+				t.Fatal(err)
+			}
+			if !ts.Equal(tt.expected) {
+				t.Errorf("got reading timestamp %v, expected %v", ts, tt.expected)
+			}
+
+			// A later poll of unchanged upstream data stores nothing new
+			data.FetchedAt = fetchedAt.Add(time.Minute)
+			if err := ing.PollCity(context.Background(), "Dresden"); err != nil {
+				t.Fatalf("PollCity() error: %v", err)
+			}
+			expected := 2
+			if tt.source == TimestampUpstream && !tt.updatedAt.IsZero() {
+				expected = 1
+			}
+			if count := testutil.CountRows(t, db, "parking_readings"); count != expected {
+				t.Errorf("got %d readings after the second poll, expected %d", count, expected)
+			}
+		})
+	}
+
+	if _, err := ParseTimestampSource("server"); err == nil {
+		t.Error("Expected an error for an unknown timestamp source")
+	}
+}
+
+func TestPollCityStaleQuality(t *testing.T) {
+	fetchedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		updatedAt time.Time
+		expected  database.Quality
+	}{
+		{"fresh", fetchedAt.Add(-10 * time.Minute), 0},
+		{"stale", fetchedAt.Add(-2 * time.Hour), database.QualityStale},
+		{"without last_updated", time.Time{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := cityData("Dresden", "d1")
+			data.FetchedAt = fetchedAt
+			data.LastUpdatedAt = tt.updatedAt
+			client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": data}}
+			db := testutil.NewDB(t)
+			ing := New(db, client, []string{"Dresden"}, time.Minute, WithStaleAfter(time.Hour))
+
+			if err := ing.PollCity(context.Background(), "Dresden"); err != nil {
+				t.Fatalf("PollCity() error: %v", err)
+			}
+			var quality database.Quality
+			if err := db.QueryRow(`SELECT quality FROM parking_readings`).Scan(&quality); err != nil {
+				t.Fatal(err)
+			}
+			if quality != tt.expected {
+				t.Errorf("got quality %d, expected %d", quality, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPollCityForecastOnlyQuality(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		forecast bool
+		expected database.Quality
+	}{
+		{"live", "open", true, 0},
+		{"forecast only", database.StateNoData, true, database.QualityUnavailable | database.QualityForecastOnly},
+		{"no data", database.StateNoData, false, database.QualityUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := cityData("Dresden", "d1")
+			data.LotReadings[0].State = tt.state
+			data.LotReadings[0].Forecast = tt.forecast
+			client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": data}}
+			db := testutil.NewDB(t)
+			ing := New(db, client, []string{"Dresden"}, time.Minute)
+
+			if err := ing.PollCity(context.Background(), "Dresden"); err != nil {
+				t.Fatalf("PollCity() error: %v", err)
+			}
+			var quality database.Quality
+			if err := db.QueryRow(`SELECT quality FROM parking_readings`).Scan(&quality); err != nil {
+				t.Fatal(err)
+			}
+			if quality != tt.expected {
+				t.Errorf("got quality %d, expected %d", quality, tt.expected)
+			}
+		})
+	}
+}
+
+// recordingNotifier collects alert events
+type recordingNotifier struct {
+	events []alert.Event
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, event alert.Event) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestPollCitySkipsConflictingLots(t *testing.T) {
+	client := &fakeAPI{data: map[string]*api.CityParkingData{
+		"Dresden": cityData("Dresden", "shared"),
+		"Leipzig": cityData("Leipzig", "shared", "l1"),
+	}}
+	notifier := &recordingNotifier{}
+	engine := alert.NewEngineWithNotifier([]alert.Rule{
+		{LotID: "shared", FreeBelow: 60},
+		{LotID: "l1", FreeBelow: 60},
+	}, notifier)
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden", "Leipzig"}, time.Minute, WithAlerts(engine))
+
+	if err := ing.PollCity(context.Background(), "Dresden"); err != nil {
+		t.Fatalf("PollCity(Dresden) error: %v", err)
+	}
+	notifier.events = nil
+	result, err := ing.pollCityResult(context.Background(), "Leipzig")
+	if err != nil {
+		t.Fatalf("PollCity(Leipzig) error: %v", err)
+	}
+	if result.Lots != 1 {
+		t.Errorf("got %d stored lots, expected 1", result.Lots)
+	}
+
+	// Only the stored lot reaches the alert engine
+	if len(notifier.events) != 1 || notifier.events[0].LotID != "l1" {
+		t.Errorf("got events %+v, expected one for l1", notifier.events)
+	}
+}
+
+func TestPollCityRecordsInstance(t *testing.T) {
+	client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": cityData("Dresden", "d1")}}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden"}, time.Minute, WithInstance("ingest-1"))
+	if err := ing.PollCity(context.Background(), "Dresden"); err != nil {
+		t.Fatalf("PollCity() error: %v", err)
+	}
+
+	var instance sql.NullString
+	if err := db.QueryRow(`SELECT source_instance FROM parking_readings`).Scan(&instance); err != nil {
+		t.Fatal(err)
+	}
+	if instance.String != "ingest-1" {
+		t.Errorf("got source_instance %v, expected ingest-1", instance)
+	}
+}
+
+func TestPollCitySkipsLotIDCollisions(t *testing.T) {
+	client := &fakeAPI{
+		data: map[string]*api.CityParkingData{
+			"Dresden": cityData("Dresden", "shared"),
+			"Leipzig": cityData("Leipzig", "shared", "l1"),
+		},
+	}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden", "Leipzig"}, time.Minute)
+
+	if err := ing.poll(context.Background()); err != nil {
+		t.Fatalf("poll() error: %v", err)
+	}
+
+	lot, err := database.GetLot(db, "shared")
+	if err != nil {
+		t.Fatalf("GetLot() error: %v", err)
+	}
+	if lot.City != "Dresden" {
+		t.Errorf("got city %s for the shared lot, expected Dresden", lot.City)
+	}
+
+	var leipzig int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM parking_readings WHERE city = 'Leipzig'`).Scan(&leipzig); err != nil {
+		t.Fatal(err)
+	}
+	if leipzig != 1 {
+		t.Errorf("got %d Leipzig readings, expected 1 for the non-colliding lot", leipzig)
+	}
+}
+
+func TestPollCityFlagsAnomalies(t *testing.T) {
+	tests := []struct {
+		name      string
+		free      int
+		total     int
+		anomalous bool
+	}{
+		{"valid", 50, 100, false},
+		{"full", 0, 100, false},
+		{"empty", 100, 100, false},
+		{"more free than total", 500, 100, true},
+		{"negative free", -1, 100, true},
+		{"unknown capacity", 10, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := cityData("Dresden", "d1")
+			data.Lots[0].Total = tt.total
+			data.LotReadings[0].Free = tt.free
+			client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": data}}
+			db := testutil.NewDB(t)
+			ing := New(db, client, []string{"Dresden"}, time.Minute)
+
+			if err := ing.PollCity(context.Background(), "Dresden"); err != nil {
+				t.Fatalf("PollCity() error: %v", err)
+			}
+
+			var free int
+			var anomalous bool
+			if err := db.QueryRow(`SELECT free, anomalous FROM parking_readings`).Scan(&free, &anomalous); err != nil {
+				t.Fatal(err)
+			}
+			if free != tt.free {
+				t.Errorf("got free %d, expected the raw value %d", free, tt.free)
+			}
+			if anomalous != tt.anomalous {
+				t.Errorf("got anomalous %v, expected %v", anomalous, tt.anomalous)
+			}
+		})
+	}
+}
+
+// liveAPI stamps the canned data with the fetch time, as the API client
+// does
+type liveAPI struct {
+	fakeAPI
+}
+
+func (l *liveAPI) GetCityParkingDataContext(ctx context.Context, city string) (*api.CityParkingData, error) {
+	data, err := l.fakeAPI.GetCityParkingDataContext(ctx, city)
+	if err != nil {
+		return nil, err
+	}
+	fetched := *data
+	fetched.FetchedAt = time.Now().UTC()
+	return &fetched, nil
+}
+
+func TestPollCityRecordsFreshness(t *testing.T) {
+	lastUpdated := time.Date(2024, 1, 1, 11, 55, 0, 0, time.UTC)
+	data := cityData("FreshCity", "f1")
+	data.LastUpdatedAt = lastUpdated
+	replayed := cityData("ReplayCity", "r1")
+	replayed.LastUpdatedAt = lastUpdated
+	replayed.FetchedAt, replayed.Replayed = lastUpdated, true
+	client := &liveAPI{fakeAPI{data: map[string]*api.CityParkingData{"FreshCity": data, "ReplayCity": replayed}}}
+	ing := New(testutil.NewDB(t), client, []string{"FreshCity", "ReplayCity"}, time.Minute)
+
+	for _, city := range []string{"FreshCity", "ReplayCity"} {
+		if err := ing.PollCity(context.Background(), city); err != nil {
+			t.Fatalf("PollCity(%s) error: %v", city, err)
+		}
+	}
+	if got := ingestLag.Count("FreshCity"); got != 1 {
+		t.Errorf("got %d ingest lag observations, expected 1", got)
+	}
+	if got := ingestLag.Count("ReplayCity"); got != 0 {
+		t.Errorf("got %d ingest lag observations for a replay, expected 0", got)
+	}
+
+	for _, f := range Freshness() {
+		if f.City == "FreshCity" {
+			if !f.LastUpdated.Equal(lastUpdated) {
+				t.Errorf("LastUpdated got %v, expected %v", f.LastUpdated, lastUpdated)
+			}
+			return
+		}
+	}
+	t.Error("Expected FreshCity in Freshness()")
+}
+
+func TestStartSkipsLotsReadBeforeRestart(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("d1", "Dresden"), testutil.NewLot("d2", "Dresden"))
+
+	// The previous run read d1 just before the restart and d2 long before
+	now := time.Now()
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("d1", "Dresden", now.Add(-30*time.Second), 10),
+		testutil.NewReading("d2", "Dresden", now.Add(-10*time.Minute), 20),
+	)
+
+	client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": cityData("Dresden", "d1", "d2")}}
+	ing := New(db, client, []string{"Dresden"}, time.Hour, WithRestartGap(time.Minute))
+	done := make(chan error, 1)
+	go func() { done <- ing.Start(context.Background()) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.CountRows(t, db, "parking_readings") < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the first poll")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ing.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	for lotID, expected := range map[string]int{"d1": 1, "d2": 2} {
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM parking_readings WHERE lot_id = ?`, lotID).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != expected {
+			t.Errorf("got %d readings for %s, expected %d", count, lotID, expected)
+		}
+	}
+
+	// Only the first cycle skips recently read lots
+	if err := ing.poll(context.Background()); err != nil {
+		t.Fatalf("poll() error: %v", err)
+	}
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 5 {
+		t.Errorf("got %d readings after the next cycle, expected 5", count)
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": cityData("Dresden", "d1")}}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden"}, time.Hour)
+
+	// Stop before Start is a no-op
+	ing.Stop()
+
+	done := make(chan error, 1)
+	go func() { done <- ing.Start(context.Background()) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.CountRows(t, db, "parking_readings") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the first poll")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ing.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start() error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Stop")
+	}
+
+	// The ingestor can be started again once stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ing.Start(ctx); err != nil {
+		t.Errorf("Start() after Stop error: %v", err)
+	}
+}
+
+func TestFirstPollDelay(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 20, 30, 0, time.UTC)
+	tests := []struct {
+		mode     FirstPoll
+		interval time.Duration
+		now      time.Time
+		expected time.Duration
+	}{
+		{FirstPollImmediate, time.Hour, now, 0},
+		{FirstPollDelayed, time.Hour, now, time.Hour},
+		{FirstPollAligned, time.Hour, now, 39*time.Minute + 30*time.Second},
+		{FirstPollAligned, time.Minute, now, 30 * time.Second},
+		{FirstPollAligned, 15 * time.Minute, now, 9*time.Minute + 30*time.Second},
+		{FirstPollAligned, time.Hour, now.Truncate(time.Hour), 0},
+	}
+
+	for _, tt := range tests {
+		ing := New(nil, nil, nil, tt.interval, WithFirstPoll(tt.mode))
+		if got := ing.firstPollDelay(tt.now); got != tt.expected {
+			t.Errorf("%s every %v at %s: got %v, expected %v", tt.mode, tt.interval, tt.now.Format(time.TimeOnly), got, tt.expected)
+		}
+	}
+}
+
+func TestStartDelayedFirstPoll(t *testing.T) {
+	client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": cityData("Dresden", "d1")}}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden"}, 300*time.Millisecond, WithFirstPoll(FirstPollDelayed))
+
+	done := make(chan error, 1)
+	go func() { done <- ing.Start(context.Background()) }()
+	defer func() {
+		ing.Stop()
+		<-done
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 0 {
+		t.Fatalf("got %d readings before the first interval, expected 0", count)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.CountRows(t, db, "parking_readings") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the first poll")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	client := &fakeAPI{
+		data: map[string]*api.CityParkingData{
+			"Dresden": cityData("Dresden", "d1", "d2"),
+			"Leipzig": cityData("Leipzig", "l1"),
+		},
+		errors: map[string]error{"Basel": errors.New("unavailable")},
+	}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden", "Leipzig", "Basel"}, time.Hour)
+
+	ctx := context.Background()
+	for range 2 {
+		// Distinct fetch times, so the second cycle stores again
+		time.Sleep(time.Millisecond)
+		ing.poll(ctx)
+	}
+	if _, err := ing.RefreshCity(ctx, "Leipzig"); err != nil {
+		t.Fatalf("RefreshCity() error: %v", err)
+	}
+
+	summary := ing.Summary()
+	if summary.Cycles != 2 {
+		t.Errorf("got %d cycles, expected 2", summary.Cycles)
+	}
+	if rows := testutil.CountRows(t, db, "parking_readings"); summary.Readings != rows {
+		t.Errorf("got %d readings, expected the %d rows stored", summary.Readings, rows)
+	}
+	expected := map[string]CityStats{"Dresden": {2, 0}, "Leipzig": {3, 0}, "Basel": {0, 2}}
+	for city, stats := range expected {
+		if got := summary.Cities[city]; got == nil || *got != stats {
+			t.Errorf("%s: got %+v, expected %+v", city, got, stats)
+		}
+	}
+	if !strings.Contains(summary.String(), "Basel: 0 succeeded, 2 failed") {
+		t.Errorf("Unexpected summary text:\n%s", summary)
+	}
+}
+
+func TestNextDelay(t *testing.T) {
+	ing := New(testutil.NewDB(t), &fakeAPI{}, nil, time.Minute, WithOutageBackoff(5*time.Minute))
+	tests := []struct {
+		name     string
+		outages  int
+		expected time.Duration
+	}{
+		{"healthy", 0, time.Minute},
+		{"first outage", 1, 2 * time.Minute},
+		{"ongoing outage", 2, 4 * time.Minute},
+		{"capped", 3, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ing.nextDelay(tt.outages); got != tt.expected {
+				t.Errorf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+
+	if got := ing.countOutage(ing.countOutage(0, true), false); got != 0 {
+		t.Errorf("got %d outages after a successful cycle, expected 0", got)
+	}
+
+	// Full jitter never polls more often than the interval
+	jittered := New(testutil.NewDB(t), &fakeAPI{}, nil, time.Minute,
+		WithOutageBackoff(5*time.Minute), WithJitter(backoff.JitterFull))
+	for range 100 {
+		if got := jittered.nextDelay(2); got < time.Minute || got > 4*time.Minute {
+			t.Fatalf("got %v with full jitter, expected within [1m, 4m]", got)
+		}
+	}
+
+	// Without backoff an outage keeps the interval
+	plain := New(testutil.NewDB(t), &fakeAPI{}, nil, time.Minute)
+	if got := plain.nextDelay(3); got != time.Minute {
+		t.Errorf("got %v without backoff, expected %v", got, time.Minute)
+	}
+}
+
+func TestRefreshCity(t *testing.T) {
+	data := cityData("Dresden", "d1", "d2")
+	data.FetchedAt = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": data}}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden"}, time.Minute)
+	ctx := context.Background()
+
+	result, err := ing.RefreshCity(ctx, "Dresden")
+	if err != nil {
+		t.Fatalf("RefreshCity() error: %v", err)
+	}
+	if result.Lots != 2 || !result.Timestamp.Equal(data.FetchedAt) {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	// The same fetch time is not stored twice
+	result, err = ing.RefreshCity(ctx, "Dresden")
+	if err != nil {
+		t.Fatalf("Second RefreshCity() error: %v", err)
+	}
+	if result.Lots != 0 {
+		t.Errorf("Expected no lots updated for an already stored fetch, got %d", result.Lots)
+	}
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 2 {
+		t.Errorf("Expected 2 readings, got %d", count)
+	}
+
+	if _, err := ing.RefreshCity(ctx, "Basel"); !errors.Is(err, ErrUnknownCity) {
+		t.Errorf("Expected ErrUnknownCity, got %v", err)
+	}
+}
+
+// TestConcurrentPolls exercises the shared maps from parallel polls of
+// different and identical cities; run it with -race
+func TestConcurrentPolls(t *testing.T) {
+	cities := []string{"Dresden", "Basel", "Hamburg"}
+	client := &fakeAPI{data: map[string]*api.CityParkingData{}}
+	for _, city := range cities {
+		client.data[city] = cityData(city, city+"1", city+"2")
+	}
+	db := testutil.NewDB(t)
+	ing := New(db, client, cities, time.Minute, WithRestartGap(time.Hour))
+	ctx := context.Background()
+	ing.loadRecent(ctx)
+
+	var wg sync.WaitGroup
+	for round := range 5 {
+		for _, city := range cities {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var err error
+				if round%2 == 0 {
+					err = ing.pollCity(ctx, city)
+				} else {
+					_, err = ing.RefreshCity(ctx, city)
+				}
+				if err != nil {
+					t.Errorf("poll of %s failed: %v", city, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if count := testutil.CountRows(t, db, "parking_lots"); count != 6 {
+		t.Errorf("got %d lots, expected 6", count)
+	}
+	for _, city := range cities {
+		if ing.storedAt(city).IsZero() {
+			t.Errorf("Expected a stored fetch time for %s", city)
+		}
+	}
+}
+
+func TestRefreshLots(t *testing.T) {
+	data := cityData("Dresden", "d1", "d2")
+	client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": data}}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden"}, time.Minute)
+
+	if err := ing.RefreshLots(context.Background()); err != nil {
+		t.Fatalf("RefreshLots() error: %v", err)
+	}
+	if count := testutil.CountRows(t, db, "parking_lots"); count != 2 {
+		t.Errorf("got %d lots, expected 2", count)
+	}
+
+	// Renamed lots are updated without ever storing a reading
+	data.Lots[0].Name = "Altmarkt"
+	if err := ing.RefreshLots(context.Background()); err != nil {
+		t.Fatalf("Second RefreshLots() error: %v", err)
+	}
+	lot, err := database.GetLot(db, "d1")
+	if err != nil {
+		t.Fatalf("GetLot() error: %v", err)
+	}
+	if lot.Name != "Altmarkt" {
+		t.Errorf("got name %s, expected Altmarkt", lot.Name)
+	}
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 0 {
+		t.Errorf("got %d readings, expected 0", count)
+	}
+}
+
+// slowAPI blocks requests for one city until release is closed or the
+// request's context is done
+type slowAPI struct {
+	fakeAPI
+	slowCity string
+	release  chan struct{}
+}
+
+func (s *slowAPI) GetCityParkingDataContext(ctx context.Context, city string) (*api.CityParkingData, error) {
+	if city == s.slowCity {
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return s.fakeAPI.GetCityParkingDataContext(ctx, city)
+}
+
+func TestPollCycleTimeout(t *testing.T) {
+	client := &slowAPI{
+		fakeAPI: fakeAPI{data: map[string]*api.CityParkingData{
+			"Dresden": cityData("Dresden", "d1"),
+			"Slow":    cityData("Slow", "s1"),
+			"Basel":   cityData("Basel", "b1"),
+		}},
+		slowCity: "Slow",
+		release:  make(chan struct{}),
+	}
+	defer close(client.release)
+
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"Dresden", "Slow", "Basel"}, time.Minute, WithCycleTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	err := ing.poll(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected poll to stop at the deadline, took %v", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "skipped 1 cities") {
+		t.Errorf("Expected Basel to be reported as skipped, got %v", err)
+	}
+
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 1 {
+		t.Errorf("Expected only Dresden's reading, got %d", count)
+	}
+}
+
+func TestStopShutdownTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		release  bool
+		expected int
+	}{
+		{"slow cycle finishes in time", 5 * time.Second, true, 2},
+		{"stuck cycle is cancelled", 50 * time.Millisecond, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &slowAPI{
+				fakeAPI: fakeAPI{data: map[string]*api.CityParkingData{
+					"Dresden": cityData("Dresden", "d1"),
+					"Slow":    cityData("Slow", "s1"),
+				}},
+				slowCity: "Slow",
+				release:  make(chan struct{}),
+			}
+			defer close(client.release)
+
+			db := testutil.NewDB(t)
+			ing := New(db, client, []string{"Dresden", "Slow"}, time.Hour, WithShutdownTimeout(tt.timeout))
+			done := make(chan error, 1)
+			go func() { done <- ing.Start(context.Background()) }()
+
+			// Dresden is stored while Slow blocks the rest of the cycle
+			deadline := time.Now().Add(5 * time.Second)
+			for testutil.CountRows(t, db, "parking_readings") == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("Timed out waiting for the first city")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if tt.release {
+				time.AfterFunc(50*time.Millisecond, func() { client.release <- struct{}{} })
+			}
+			ing.Stop()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Errorf("Start() error: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Start did not return after Stop")
+			}
+
+			if count := testutil.CountRows(t, db, "parking_readings"); count != tt.expected {
+				t.Errorf("got %d readings, expected %d", count, tt.expected)
+			}
+		})
+	}
+}
+
+// flakyStore fails Begin with err until failures is exhausted
+type flakyStore struct {
+	database.Store
+	err        error
+	failures   int
+	begins     int
+	reconnects int
+}
+
+func (s *flakyStore) Begin(ctx context.Context) (database.Tx, error) {
+	s.begins++
+	if s.failures > 0 {
+		s.failures--
+		return nil, s.err
+	}
+	return s.Store.Begin(ctx)
+}
+
+func (s *flakyStore) Reconnect(ctx context.Context) error {
+	s.reconnects++
+	return nil
+}
+
+func TestPollCityStoreRetry(t *testing.T) {
+	tests := []struct {
+		name               string
+		err                error
+		failures           int
+		wantErr            bool
+		expectedBegins     int
+		expectedReconnects int
+	}{
+		{"recovers", driver.ErrBadConn, 2, false, 3, 2},
+		{"exhausts attempts", driver.ErrBadConn, 5, true, 3, 2},
+		{"persistent error", errors.New("no such table"), 1, true, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testutil.NewDB(t)
+			store := &flakyStore{Store: database.NewSQLiteStore(db), err: tt.err, failures: tt.failures}
+			client := &fakeAPI{data: map[string]*api.CityParkingData{"Dresden": cityData("Dresden", "d1")}}
+			ing := NewWithStore(store, client, []string{"Dresden"}, time.Minute, WithStoreRetry(3, time.Millisecond))
+
+			err := ing.PollCity(context.Background(), "Dresden")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PollCity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("Expected error to wrap %v, got %v", tt.err, err)
+			}
+			if store.begins != tt.expectedBegins {
+				t.Errorf("Expected %d Begin calls, got %d", tt.expectedBegins, store.begins)
+			}
+			if store.reconnects != tt.expectedReconnects {
+				t.Errorf("Expected %d reconnects, got %d", tt.expectedReconnects, store.reconnects)
+			}
+
+			expectedRows := 0
+			if !tt.wantErr {
+				expectedRows = 1
+			}
+			if got := testutil.CountRows(t, db, "parking_readings"); got != expectedRows {
+				t.Errorf("Expected %d readings, got %d", expectedRows, got)
+			}
+		})
+	}
+}
+
+func TestPollCityConditionalAfterFailedStore(t *testing.T) {
+	const etag = `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(dresdenJSON))
+	}))
+	defer server.Close()
+
+	db := testutil.NewDB(t)
+	store := &flakyStore{Store: database.NewSQLiteStore(db), err: errors.New("disk full"), failures: 1}
+	client := api.NewClientWithOptions(api.Options{BaseURL: server.URL, Conditional: true})
+	ing := NewWithStore(store, client, []string{"Dresden"}, time.Minute)
+
+	if err := ing.pollCity(context.Background(), "Dresden"); err == nil {
+		t.Fatal("Expected the first poll to fail storing")
+	}
+
+	// The lost response must be fetched again, not answered with a 304
+	if err := ing.pollCity(context.Background(), "Dresden"); err != nil {
+		t.Fatalf("Second pollCity() error: %v", err)
+	}
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 2 {
+		t.Errorf("got %d readings, expected 2", count)
+	}
+
+	// Once stored, the city's watermark is used
+	if _, err := client.GetCityParkingData("Dresden"); !errors.Is(err, api.ErrNotModified) {
+		t.Errorf("got error %v, expected ErrNotModified", err)
+	}
+}
+
+// recordingTracer collects finished spans for assertions
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name  string
+	attrs map[string]any
+	err   error
+	ended bool
+}
+
+func (r *recordingTracer) Start(ctx context.Context, name string, attrs ...tracing.Attribute) (context.Context, tracing.Span) {
+	span := &recordedSpan{name: name, attrs: make(map[string]any)}
+	span.SetAttributes(attrs...)
+	r.spans = append(r.spans, span)
+	return ctx, span
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...tracing.Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) RecordError(err error) { s.err = err }
+func (s *recordedSpan) End()                  { s.ended = true }
+
+func TestPollTracing(t *testing.T) {
+	client := &fakeAPI{
+		data:   map[string]*api.CityParkingData{"Dresden": cityData("Dresden", "d1", "d2")},
+		errors: map[string]error{"Hamburg": errors.New("connection refused")},
+	}
+	tracer := &recordingTracer{}
+	ing := New(testutil.NewDB(t), client, []string{"Dresden", "Hamburg"}, time.Minute, WithTracer(tracer))
+
+	ing.poll(context.Background())
+
+	byKey := make(map[string]*recordedSpan)
+	for _, span := range tracer.spans {
+		if !span.ended {
+			t.Errorf("Span %s was not ended", span.name)
+		}
+		key := span.name
+		if city, ok := span.attrs["city"]; ok {
+			key += "/" + city.(string)
+		}
+		byKey[key] = span
+	}
+
+	if _, ok := byKey["ingestor.poll"]; !ok {
+		t.Error("Expected a poll cycle span")
+	}
+
+	dresden := byKey["ingestor.poll_city/Dresden"]
+	if dresden == nil || dresden.attrs["status"] != "ok" || dresden.attrs["lot_count"] != 2 {
+		t.Errorf("Unexpected Dresden span: %+v", dresden)
+	}
+	if store := byKey["database.store_city/Dresden"]; store == nil || store.attrs["status"] != "ok" {
+		t.Errorf("Unexpected store span: %+v", store)
+	}
+
+	hamburg := byKey["ingestor.poll_city/Hamburg"]
+	if hamburg == nil || hamburg.attrs["status"] != "error" || hamburg.err == nil {
+		t.Errorf("Unexpected Hamburg span: %+v", hamburg)
+	}
+}
+
+func TestActiveHours(t *testing.T) {
+	day := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.UTC)
+	}
+	daytime := ActiveHours{From: 6 * 60, To: 22 * 60}
+	overnight := ActiveHours{From: 22 * 60, To: 6 * 60}
+
+	tests := []struct {
+		name     string
+		hours    ActiveHours
+		at       time.Time
+		contains bool
+		until    time.Duration
+	}{
+		{"before start", daytime, day(5, 59), false, time.Minute},
+		{"at start", daytime, day(6, 0), true, 0},
+		{"last minute", daytime, day(21, 59), true, 0},
+		{"at end", daytime, day(22, 0), false, 8 * time.Hour},
+		{"midnight", daytime, day(0, 0), false, 6 * time.Hour},
+		{"overnight before start", overnight, day(21, 59), false, time.Minute},
+		{"overnight at start", overnight, day(22, 0), true, 0},
+		{"overnight past midnight", overnight, day(0, 30), true, 0},
+		{"overnight at end", overnight, day(6, 0), false, 16 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hours.Contains(tt.at); got != tt.contains {
+				t.Errorf("Contains() got %v, expected %v", got, tt.contains)
+			}
+			if got := tt.hours.Until(tt.at); got != tt.until {
+				t.Errorf("Until() got %v, expected %v", got, tt.until)
+			}
+		})
+	}
+}
+
+func TestParseActiveHours(t *testing.T) {
+	hours, err := ParseActiveHours("06:00-22:30")
+	if err != nil {
+		t.Fatalf("ParseActiveHours() error: %v", err)
+	}
+	if *hours != (ActiveHours{From: 360, To: 1350}) || hours.String() != "06:00-22:30" {
+		t.Errorf("got %v, expected 06:00-22:30", hours)
+	}
+
+	if hours, err := ParseActiveHours(""); hours != nil || err != nil {
+		t.Errorf("got %v, %v, expected no window", hours, err)
+	}
+	for _, value := range []string{"06:00", "6-22", "06:00-25:00", "08:00-08:00"} {
+		if _, err := ParseActiveHours(value); err == nil {
+			t.Errorf("ParseActiveHours(%q) expected an error", value)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package ingestor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/metrics"
+)
+
+var lotUpserts = metrics.Default.NewCounterVec("parkmonitor_lot_upserts_total",
+	"Lot metadata upserts, by whether they were performed or skipped as unchanged.", "result")
+
+// lotCache remembers the metadata last written for each lot ID, so lots
+// that did not change are not upserted again every cycle
+type lotCache struct {
+	mu   sync.Mutex
+	lots map[string]database.ParkingLot
+}
+
+func newLotCache() *lotCache {
+	return &lotCache{lots: make(map[string]database.ParkingLot)}
+}
+
+// lotKey is the part of a lot that decides whether it needs an upsert
+func lotKey(lot *database.ParkingLot) database.ParkingLot {
+	key := *lot
+	key.ObservedAt = time.Time{}
+	return key
+}
+
+// unchanged reports whether lot matches what was last written for its ID
+func (c *lotCache) unchanged(lot *database.ParkingLot) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.lots[lot.ID]
+	return ok && cached == lotKey(lot)
+}
+
+// store records lots as written. Call it only once their transaction has
+// committed, so a rollback never leaves a lot cached that isn't stored.
+func (c *lotCache) store(lots []*database.ParkingLot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, lot := range lots {
+		c.lots[lot.ID] = lotKey(lot)
+	}
+}
@@ -0,0 +1,52 @@
+package ingestor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestUnchangedLotsSkipUpsert(t *testing.T) {
+	data := cityData("CacheCity", "c1", "c2")
+	client := &fakeAPI{data: map[string]*api.CityParkingData{"CacheCity": data}}
+	db := testutil.NewDB(t)
+	ing := New(db, client, []string{"CacheCity"}, time.Minute)
+	ctx := context.Background()
+
+	performed, skipped := lotUpserts.Value("performed"), lotUpserts.Value("skipped")
+	poll := func(expectedPerformed, expectedSkipped float64) {
+		t.Helper()
+		if err := ing.PollCity(ctx, "CacheCity"); err != nil {
+			t.Fatalf("PollCity() error: %v", err)
+		}
+		if got := lotUpserts.Value("performed") - performed; got != expectedPerformed {
+			t.Errorf("got %v upserts performed, expected %v", got, expectedPerformed)
+		}
+		if got := lotUpserts.Value("skipped") - skipped; got != expectedSkipped {
+			t.Errorf("got %v upserts skipped, expected %v", got, expectedSkipped)
+		}
+		performed, skipped = lotUpserts.Value("performed"), lotUpserts.Value("skipped")
+	}
+
+	poll(2, 0)
+	poll(0, 2)
+
+	// Changed metadata is written again
+	data.Lots[0].Total = 150
+	poll(1, 1)
+
+	lot, err := database.GetLot(db, "c1")
+	if err != nil {
+		t.Fatalf("GetLot() error: %v", err)
+	}
+	if lot.Total != 150 {
+		t.Errorf("got total %d, expected 150", lot.Total)
+	}
+	if count := testutil.CountRows(t, db, "parking_readings"); count != 6 {
+		t.Errorf("got %d readings, expected every poll to store its readings", count)
+	}
+}
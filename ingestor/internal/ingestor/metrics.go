@@ -0,0 +1,81 @@
+package ingestor
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/metrics"
+)
+
+var cycleReadings = metrics.Default.NewGaugeVec("parkmonitor_cycle_readings",
+	"Readings stored by the last poll cycle.")
+
+var ingestLag = metrics.Default.NewHistogramVec("parkmonitor_ingest_lag_seconds",
+	"Seconds between the upstream source updating a city and its readings being stored.",
+	[]float64{30, 60, 120, 300, 600, 900, 1800, 3600, 7200, 21600}, "city")
+
+// recordIngestLag observes how long after the upstream update at
+// lastUpdated a city's readings were stored. Clock skew can make the
+// difference negative, which is counted as no lag.
+func recordIngestLag(city string, lastUpdated, storedAt time.Time) {
+	if lastUpdated.IsZero() {
+		return
+	}
+	ingestLag.Observe(max(storedAt.Sub(lastUpdated).Seconds(), 0), city)
+}
+
+// CityFreshness is the latest upstream update time seen for a city
+type CityFreshness struct {
+	City        string    `json:"city"`
+	LastUpdated time.Time `json:"last_updated"`
+}
+
+// freshness tracks the parsed last_updated value of every polled city
+type freshness struct {
+	mu     sync.Mutex
+	cities map[string]time.Time
+}
+
+var cityFreshness = &freshness{cities: make(map[string]time.Time)}
+
+func init() {
+	metrics.Default.NewGaugeFunc("parkmonitor_city_data_age_seconds",
+		"Seconds since the upstream source last updated a city's data.",
+		[]string{"city"}, func() []metrics.LabeledValue {
+			now := time.Now()
+			snapshot := Freshness()
+			values := make([]metrics.LabeledValue, len(snapshot))
+			for idx, f := range snapshot {
+				values[idx] = metrics.LabeledValue{
+					LabelValues: []string{f.City},
+					Value:       now.Sub(f.LastUpdated).Seconds(),
+				}
+			}
+			return values
+		})
+}
+
+// record stores the upstream update time of a city
+func (f *freshness) record(city string, lastUpdated time.Time) {
+	if lastUpdated.IsZero() {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cities[city] = lastUpdated
+}
+
+// Freshness returns the latest upstream update time of every city polled
+// so far, sorted by city
+func Freshness() []CityFreshness {
+	cityFreshness.mu.Lock()
+	result := make([]CityFreshness, 0, len(cityFreshness.cities))
+	for city, t := range cityFreshness.cities {
+		result = append(result, CityFreshness{City: city, LastUpdated: t})
+	}
+	cityFreshness.mu.Unlock()
+
+	sort.Slice(result, func(a, b int) bool { return result[a].City < result[b].City })
+	return result
+}
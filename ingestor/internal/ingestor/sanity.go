@@ -0,0 +1,84 @@
+package ingestor
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+	"github.com/niklas/parkmonitor/ingestor/internal/metrics"
+)
+
+var suspiciousResponses = metrics.Default.NewCounterVec("parkmonitor_suspicious_responses_total",
+	"City responses that look structurally wrong, e.g. after an upstream schema change, by failed check.",
+	"city", "check")
+
+// SanityCheck flags city responses that decoded but look structurally
+// wrong: a renamed field decodes as its zero value instead of failing.
+// Shares are fractions of a city's lots; a share of 1 or more disables
+// its check.
+type SanityCheck struct {
+	// MinLots is how many lots a city needs before it is checked, so small
+	// cities with a few incomplete lots are not flagged
+	MinLots int
+	// MaxZeroTotal is the largest share of lots that may report a total
+	// capacity of 0
+	MaxZeroTotal float64
+	// MaxMissingFields is the largest share of lots that may lack an ID,
+	// name or state
+	MaxMissingFields float64
+}
+
+// WithSanityCheck runs check on every fetched city, logging a warning and
+// counting parkmonitor_suspicious_responses_total for each failed check.
+// The data is stored regardless.
+func WithSanityCheck(check SanityCheck) Option {
+	return func(i *Ingestor) {
+		i.sanity = &check
+	}
+}
+
+// sanityProblem is a failed check with a description for the log
+type sanityProblem struct {
+	check  string
+	detail string
+}
+
+// problems returns the checks that data fails
+func (c *SanityCheck) problems(data *api.CityParkingData) []sanityProblem {
+	lots := len(data.Lots)
+	if lots == 0 || lots < c.MinLots {
+		return nil
+	}
+
+	var zeroTotal, missing int
+	for idx, lot := range data.Lots {
+		if lot.Total == 0 {
+			zeroTotal++
+		}
+		if lot.ID == "" || lot.Name == "" || data.LotReadings[idx].State == "" {
+			missing++
+		}
+	}
+
+	var problems []sanityProblem
+	if share := float64(zeroTotal) / float64(lots); share > c.MaxZeroTotal && c.MaxZeroTotal < 1 {
+		problems = append(problems, sanityProblem{"zero-total",
+			fmt.Sprintf("%d of %d lots have a total of 0", zeroTotal, lots)})
+	}
+	if share := float64(missing) / float64(lots); share > c.MaxMissingFields && c.MaxMissingFields < 1 {
+		problems = append(problems, sanityProblem{"missing-fields",
+			fmt.Sprintf("%d of %d lots lack an ID, name or state", missing, lots)})
+	}
+	return problems
+}
+
+// checkSanity logs and counts the checks a city's response fails
+func (i *Ingestor) checkSanity(city string, data *api.CityParkingData) {
+	if i.sanity == nil {
+		return
+	}
+	for _, p := range i.sanity.problems(data) {
+		log.Printf("Warning: response for %s looks structurally wrong, the API schema may have changed: %s", city, p.detail)
+		suspiciousResponses.Inc(city, p.check)
+	}
+}
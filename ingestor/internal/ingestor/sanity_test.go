@@ -0,0 +1,65 @@
+package ingestor
+
+import (
+	"testing"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+)
+
+// sanityData returns a city response of lots with the given totals, all
+// with complete fields
+func sanityData(totals ...int) *api.CityParkingData {
+	data := &api.CityParkingData{}
+	for idx, total := range totals {
+		id := string(rune('a' + idx))
+		data.Lots = append(data.Lots, api.ParkingLot{ID: id, Name: "Lot " + id, Total: total})
+		data.LotReadings = append(data.LotReadings, api.ParkingLotReading{LotID: id, State: "open"})
+	}
+	return data
+}
+
+func TestSanityCheckProblems(t *testing.T) {
+	check := SanityCheck{MinLots: 3, MaxZeroTotal: 0.5, MaxMissingFields: 0.1}
+
+	missingNames := sanityData(100, 100, 100, 100)
+	missingNames.Lots[0].Name = ""
+	missingNames.Lots[1].Name = ""
+
+	tests := []struct {
+		name     string
+		check    SanityCheck
+		data     *api.CityParkingData
+		expected []string
+	}{
+		{"healthy", check, sanityData(100, 200, 0, 50), nil},
+		{"all totals zero", check, sanityData(0, 0, 0, 0), []string{"zero-total"}},
+		{"missing fields", check, missingNames, []string{"missing-fields"}},
+		{"too few lots", check, sanityData(0, 0), nil},
+		{"checks disabled", SanityCheck{MaxZeroTotal: 1, MaxMissingFields: 1}, sanityData(0, 0, 0), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := tt.check.problems(tt.data)
+			if len(problems) != len(tt.expected) {
+				t.Fatalf("got %+v, expected %v", problems, tt.expected)
+			}
+			for idx, p := range problems {
+				if p.check != tt.expected[idx] {
+					t.Errorf("got %s, expected %s", p.check, tt.expected[idx])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckSanityCountsProblems(t *testing.T) {
+	i := &Ingestor{}
+	WithSanityCheck(SanityCheck{MaxZeroTotal: 0.5, MaxMissingFields: 1})(i)
+
+	before := suspiciousResponses.Value("Sanitytown", "zero-total")
+	i.checkSanity("Sanitytown", sanityData(0, 0, 0))
+	if got := suspiciousResponses.Value("Sanitytown", "zero-total") - before; got != 1 {
+		t.Errorf("got %v suspicious responses, expected 1", got)
+	}
+}
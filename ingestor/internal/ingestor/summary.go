@@ -0,0 +1,115 @@
+package ingestor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Summary is what an ingestor did since it was created, e.g. for a report
+// on shutdown
+type Summary struct {
+	StartedAt time.Time `json:"started_at"`
+	Uptime    Seconds   `json:"uptime_seconds"`
+	Cycles    int       `json:"cycles"`
+	// Readings is the number of readings stored, by scheduled polls and
+	// refreshes alike, so it matches the rows added to parking_readings
+	Readings int                   `json:"readings"`
+	Cities   map[string]*CityStats `json:"cities"`
+}
+
+// CityStats counts the polls of one city
+type CityStats struct {
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+}
+
+// Seconds is a duration encoded in JSON as fractional seconds
+type Seconds time.Duration
+
+// MarshalJSON encodes the duration as seconds
+func (s Seconds) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%.3f", time.Duration(s).Seconds())), nil
+}
+
+// String renders the summary as log lines: the totals, then one line per
+// city in name order
+func (s *Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Ingest summary: %d poll cycles, %d readings stored, up %v",
+		s.Cycles, s.Readings, time.Duration(s.Uptime).Round(time.Second))
+	cities := make([]string, 0, len(s.Cities))
+	for city := range s.Cities {
+		cities = append(cities, city)
+	}
+	slices.Sort(cities)
+	for _, city := range cities {
+		stats := s.Cities[city]
+		fmt.Fprintf(&b, "\n  %s: %d succeeded, %d failed", city, stats.Succeeded, stats.Failed)
+	}
+	return b.String()
+}
+
+// stats accumulates a Summary while the ingestor runs
+type stats struct {
+	mu       sync.Mutex
+	started  time.Time
+	cycles   int
+	readings int
+	cities   map[string]*CityStats
+}
+
+func newStats() *stats {
+	return &stats{started: time.Now(), cities: make(map[string]*CityStats)}
+}
+
+// cycle counts a finished poll cycle
+func (s *stats) cycle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cycles++
+}
+
+// poll counts a poll of city and the readings it stored. A poll without a
+// result, e.g. one that panicked, failed.
+func (s *stats) poll(city string, result *CityResult, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.cities[city]
+	if c == nil {
+		c = &CityStats{}
+		s.cities[city] = c
+	}
+	if err != nil || result == nil {
+		c.Failed++
+		return
+	}
+	c.Succeeded++
+	s.readings += result.Lots
+}
+
+// summary returns a copy of the counters
+func (s *stats) summary() *Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary := &Summary{
+		StartedAt: s.started,
+		Uptime:    Seconds(time.Since(s.started)),
+		Cycles:    s.cycles,
+		Readings:  s.readings,
+		Cities:    make(map[string]*CityStats, len(s.cities)),
+	}
+	for city, c := range s.cities {
+		copied := *c
+		summary.Cities[city] = &copied
+	}
+	return summary
+}
+
+// Summary reports the poll cycles, stored readings and per-city outcomes
+// since the ingestor was created
+func (i *Ingestor) Summary() *Summary {
+	return i.stats.summary()
+}
@@ -0,0 +1,58 @@
+package ingestor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+)
+
+// TimestampSource selects the time readings are stored with
+type TimestampSource string
+
+const (
+	// TimestampFetch stamps readings with the time they were fetched
+	TimestampFetch TimestampSource = "fetch"
+	// TimestampUpstream stamps readings with the city's last_updated time,
+	// when the source measured them, falling back to the fetch time when
+	// the API sends none
+	TimestampUpstream TimestampSource = "upstream"
+)
+
+// ParseTimestampSource parses a source name; empty means TimestampFetch
+func ParseTimestampSource(value string) (TimestampSource, error) {
+	switch TimestampSource(strings.ToLower(value)) {
+	case "", TimestampFetch:
+		return TimestampFetch, nil
+	case TimestampUpstream:
+		return TimestampUpstream, nil
+	}
+	return "", fmt.Errorf("unknown timestamp source %q (expected fetch or upstream)", value)
+}
+
+// WithTimestampSource selects the time readings are stored with. With
+// TimestampUpstream, a city whose last_updated has not changed since the
+// previous poll is skipped as already stored.
+func WithTimestampSource(source TimestampSource) Option {
+	return func(i *Ingestor) {
+		i.timestampSource = source
+	}
+}
+
+// fetchTime returns when data was fetched; replayed captures carry their
+// original fetch time
+func fetchTime(data *api.CityParkingData) time.Time {
+	if data.FetchedAt.IsZero() {
+		return time.Now().UTC()
+	}
+	return data.FetchedAt
+}
+
+// readingTime returns the time data's readings are stored with
+func (i *Ingestor) readingTime(data *api.CityParkingData) time.Time {
+	if i.timestampSource == TimestampUpstream && !data.LastUpdatedAt.IsZero() {
+		return data.LastUpdatedAt.UTC()
+	}
+	return fetchTime(data)
+}
@@ -0,0 +1,366 @@
+// Package metrics is a small, dependency-free implementation of the
+// Prometheus text exposition format. It supports the counter, gauge and
+// histogram types the ingestor needs, optionally partitioned by labels.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry the ingestor's metrics are registered with
+var Default = NewRegistry()
+
+// Registry holds a set of metrics and renders them for scraping
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// metric is anything that can render itself in exposition format
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[m.name()] {
+		panic(fmt.Sprintf("metrics: duplicate metric %s", m.name()))
+	}
+	r.names[m.name()] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Write renders all metrics in registration order
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the registry in Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// vec stores values keyed by label values
+type vec struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+func newVec(name, help, kind string, labels []string) *vec {
+	return &vec{
+		metricName: name,
+		help:       help,
+		kind:       kind,
+		labels:     labels,
+		values:     make(map[string]*sample),
+	}
+}
+
+func (v *vec) name() string {
+	return v.metricName
+}
+
+// update applies fn to the sample for the given label values
+func (v *vec) update(labelValues []string, fn func(float64) float64) {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.metricName, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.values[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		v.values[key] = s
+	}
+	s.value = fn(s.value)
+}
+
+// get returns the current value for the given label values
+func (v *vec) get(labelValues []string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.values[strings.Join(labelValues, "\xff")]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	samples := make([]sample, 0, len(v.values))
+	for _, s := range v.values {
+		samples = append(samples, *s)
+	}
+	v.mu.Unlock()
+
+	sort.Slice(samples, func(a, b int) bool {
+		return strings.Join(samples[a].labelValues, "\xff") < strings.Join(samples[b].labelValues, "\xff")
+	})
+
+	writeHeader(w, v.metricName, v.help, v.kind)
+	for _, s := range samples {
+		writeSample(w, v.metricName, v.labels, s.labelValues, s.value)
+	}
+}
+
+// CounterVec is a monotonically increasing value per label combination
+type CounterVec struct{ v *vec }
+
+// NewCounterVec registers a counter partitioned by the given labels
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{v: newVec(name, help, "counter", labels)}
+	r.register(c.v)
+	return c
+}
+
+// Inc adds one to the counter
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter by delta (negative deltas are ignored)
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.v.update(labelValues, func(old float64) float64 { return old + delta })
+}
+
+// Value returns the current count
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	return c.v.get(labelValues)
+}
+
+// GaugeVec is a value that can go up and down per label combination
+type GaugeVec struct{ v *vec }
+
+// NewGaugeVec registers a gauge partitioned by the given labels
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{v: newVec(name, help, "gauge", labels)}
+	r.register(g.v)
+	return g
+}
+
+// Set replaces the gauge value
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.v.update(labelValues, func(float64) float64 { return value })
+}
+
+// Add changes the gauge value by delta
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.v.update(labelValues, func(old float64) float64 { return old + delta })
+}
+
+// Value returns the current gauge value
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	return g.v.get(labelValues)
+}
+
+// LabeledValue is one sample returned by a gauge function
+type LabeledValue struct {
+	LabelValues []string
+	Value       float64
+}
+
+// gaugeFunc computes its samples at scrape time
+type gaugeFunc struct {
+	metricName string
+	help       string
+	labels     []string
+	fn         func() []LabeledValue
+}
+
+// NewGaugeFunc registers a gauge whose samples are computed by fn on every
+// scrape, for values such as ages that change continuously
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, fn func() []LabeledValue) {
+	r.register(&gaugeFunc{metricName: name, help: help, labels: labels, fn: fn})
+}
+
+func (g *gaugeFunc) name() string {
+	return g.metricName
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	samples := g.fn()
+	sort.Slice(samples, func(a, b int) bool {
+		return strings.Join(samples[a].LabelValues, "\xff") < strings.Join(samples[b].LabelValues, "\xff")
+	})
+
+	writeHeader(w, g.metricName, g.help, "gauge")
+	for _, s := range samples {
+		writeSample(w, g.metricName, g.labels, s.LabelValues, s.Value)
+	}
+}
+
+// HistogramVec counts observations in buckets per label combination
+type HistogramVec struct {
+	metricName string
+	help       string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	values map[string]*histogram
+}
+
+// histogram holds the observations of one label combination. counts has
+// one entry per bucket plus one for +Inf and is not cumulative.
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+// NewHistogramVec registers a histogram with the given bucket upper bounds,
+// partitioned by the given labels. A +Inf bucket is always added.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		buckets:    buckets,
+		values:     make(map[string]*histogram),
+	}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) name() string {
+	return h.metricName
+}
+
+// Observe adds value to the histogram
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", h.metricName, len(h.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.values[key]
+	if !ok {
+		s = &histogram{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)+1),
+		}
+		h.values[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, value)]++
+	s.sum += value
+	s.count++
+}
+
+// Count returns the number of observations
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.values[strings.Join(labelValues, "\xff")]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	samples := make([]histogram, 0, len(h.values))
+	for _, s := range h.values {
+		c := *s
+		c.counts = append([]uint64(nil), s.counts...)
+		samples = append(samples, c)
+	}
+	h.mu.Unlock()
+
+	sort.Slice(samples, func(a, b int) bool {
+		return strings.Join(samples[a].labelValues, "\xff") < strings.Join(samples[b].labelValues, "\xff")
+	})
+
+	writeHeader(w, h.metricName, h.help, "histogram")
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	for _, s := range samples {
+		var cumulative uint64
+		for idx, count := range s.counts {
+			cumulative += count
+			upper := math.Inf(1)
+			if idx < len(h.buckets) {
+				upper = h.buckets[idx]
+			}
+			labelValues := append(append([]string(nil), s.labelValues...), formatValue(upper))
+			writeSample(w, h.metricName+"_bucket", bucketLabels, labelValues, float64(cumulative))
+		}
+		writeSample(w, h.metricName+"_sum", h.labels, s.labelValues, s.sum)
+		writeSample(w, h.metricName+"_count", h.labels, s.labelValues, float64(s.count))
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func writeSample(w io.Writer, name string, labels, labelValues []string, value float64) {
+	fmt.Fprint(w, name)
+	if len(labels) > 0 {
+		pairs := make([]string, len(labels))
+		for i, label := range labels {
+			pairs[i] = fmt.Sprintf(`%s="%s"`, label, escapeLabel(labelValues[i]))
+		}
+		fmt.Fprintf(w, "{%s}", strings.Join(pairs, ","))
+	}
+	fmt.Fprintf(w, " %s\n", formatValue(value))
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
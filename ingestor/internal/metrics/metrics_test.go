@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	reg := NewRegistry()
+	polls := reg.NewCounterVec("test_polls_total", "Polls per city.", "city")
+	lots := reg.NewGaugeVec("test_lots", "Stored lots.")
+	reg.NewGaugeFunc("test_age_seconds", "Data age.", []string{"city"}, func() []LabeledValue {
+		return []LabeledValue{{LabelValues: []string{`Quote"City`}, Value: 1.5}}
+	})
+
+	polls.Inc("Dresden")
+	polls.Inc("Dresden")
+	polls.Add(3, "Basel")
+	polls.Add(-1, "Basel")
+	lots.Set(10)
+	lots.Add(-2)
+
+	if got := polls.Value("Dresden"); got != 2 {
+		t.Errorf("Expected Dresden count 2, got %v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := `# HELP test_polls_total Polls per city.
+# TYPE test_polls_total counter
+test_polls_total{city="Basel"} 3
+test_polls_total{city="Dresden"} 2
+# HELP test_lots Stored lots.
+# TYPE test_lots gauge
+test_lots 8
+# HELP test_age_seconds Data age.
+# TYPE test_age_seconds gauge
+test_age_seconds{city="Quote\"City"} 1.5
+`
+	if got := rec.Body.String(); got != expected {
+		t.Errorf("Unexpected exposition:\n%s\nexpected:\n%s", got, expected)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	reg := NewRegistry()
+	lag := reg.NewHistogramVec("test_lag_seconds", "Lag.", []float64{60, 10}, "city")
+
+	lag.Observe(5, "Dresden")
+	lag.Observe(10, "Dresden")
+	lag.Observe(30, "Dresden")
+	lag.Observe(120, "Dresden")
+
+	if got := lag.Count("Dresden"); got != 4 {
+		t.Errorf("got %d observations, expected 4", got)
+	}
+
+	var buf strings.Builder
+	reg.Write(&buf)
+	expected := `# HELP test_lag_seconds Lag.
+# TYPE test_lag_seconds histogram
+test_lag_seconds_bucket{city="Dresden",le="10"} 2
+test_lag_seconds_bucket{city="Dresden",le="60"} 3
+test_lag_seconds_bucket{city="Dresden",le="+Inf"} 4
+test_lag_seconds_sum{city="Dresden"} 165
+test_lag_seconds_count{city="Dresden"} 4
+`
+	if got := buf.String(); got != expected {
+		t.Errorf("Unexpected exposition:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestDuplicateMetricPanics(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewGaugeVec("dup", "first")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected duplicate registration to panic")
+		}
+	}()
+	reg.NewCounterVec("dup", "second")
+}
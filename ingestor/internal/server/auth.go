@@ -0,0 +1,79 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Auth holds the credentials accepted by RequireAuth. Requests may present
+// the API key as a bearer token, or the user and password via basic auth.
+// The zero value disables authentication.
+type Auth struct {
+	APIKey   string
+	User     string
+	Password string
+}
+
+// Enabled reports whether any credentials are configured
+func (a Auth) Enabled() bool {
+	return a.APIKey != "" || a.User != ""
+}
+
+// Validate checks that basic auth credentials are complete
+func (a Auth) Validate() error {
+	if (a.User == "") != (a.Password == "") {
+		return errors.New("basic auth needs both a user and a password")
+	}
+	return nil
+}
+
+// WithAuth requires credentials for every route
+func WithAuth(auth Auth) Option {
+	return func(s *Server) {
+		s.auth = auth
+	}
+}
+
+// RequireAuth wraps next so that requests without valid credentials get
+// 401 Unauthorized. It returns next unchanged when auth is disabled.
+func RequireAuth(auth Auth, next http.Handler) http.Handler {
+	if !auth.Enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.allows(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if auth.User != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="parkmonitor"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="parkmonitor"`)
+		}
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+	})
+}
+
+// allows reports whether r carries one of the configured credentials
+func (a Auth) allows(r *http.Request) bool {
+	if a.APIKey != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secureEqual(token, a.APIKey) {
+			return true
+		}
+	}
+	if a.User != "" {
+		if user, pass, ok := r.BasicAuth(); ok && secureEqual(user, a.User) && secureEqual(pass, a.Password) {
+			return true
+		}
+	}
+	return false
+}
+
+// secureEqual compares secrets in constant time
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
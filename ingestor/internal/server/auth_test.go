@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestRequireAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	auth := Auth{APIKey: "s3cret", User: "admin", Password: "hunter2"}
+
+	tests := []struct {
+		name   string
+		auth   Auth
+		setup  func(r *http.Request)
+		status int
+	}{
+		{"disabled", Auth{}, func(r *http.Request) {}, http.StatusOK},
+		{"no credentials", auth, func(r *http.Request) {}, http.StatusUnauthorized},
+		{"valid token", auth, func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, http.StatusOK},
+		{"wrong token", auth, func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"valid basic auth", auth, func(r *http.Request) { r.SetBasicAuth("admin", "hunter2") }, http.StatusOK},
+		{"wrong password", auth, func(r *http.Request) { r.SetBasicAuth("admin", "nope") }, http.StatusUnauthorized},
+		{"token only", Auth{APIKey: "s3cret"}, func(r *http.Request) { r.SetBasicAuth("admin", "hunter2") }, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/cities", nil)
+			tt.setup(req)
+			rec := httptest.NewRecorder()
+			RequireAuth(tt.auth, ok).ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, expected %d", rec.Code, tt.status)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected WWW-Authenticate header on 401")
+			}
+		})
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	db := testutil.NewDB(t)
+	handler := New(db, WithAuth(Auth{APIKey: "s3cret"})).Handler()
+
+	req := httptest.NewRequest(http.MethodGet, "/cities", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, expected %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/cities", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d, expected %d", rec.Code, http.StatusOK)
+	}
+}
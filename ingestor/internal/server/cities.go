@@ -0,0 +1,51 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// cityResponse is one entry of GET /cities
+type cityResponse struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Source        *string  `json:"source"`
+	URL           *string  `json:"url"`
+	ActiveSupport bool     `json:"active_support"`
+	Latitude      *float64 `json:"latitude"`
+	Longitude     *float64 `json:"longitude"`
+}
+
+// handleCities lists the stored city metadata, including each city's
+// upstream data source
+func (s *Server) handleCities(w http.ResponseWriter, r *http.Request) {
+	cities, err := database.GetCities(s.db)
+	if err != nil {
+		log.Printf("Failed to load cities: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to load cities")
+		return
+	}
+
+	result := make([]cityResponse, len(cities))
+	for idx, c := range cities {
+		result[idx] = cityResponse{
+			ID:            c.ID,
+			Name:          s.cityNames.Name(c.ID, c.Name),
+			ActiveSupport: c.ActiveSupport,
+		}
+		if c.Source.Valid {
+			result[idx].Source = &c.Source.String
+		}
+		if c.URL.Valid {
+			result[idx].URL = &c.URL.String
+		}
+		if c.Latitude.Valid && c.Longitude.Valid {
+			result[idx].Latitude = &c.Latitude.Float64
+			result[idx].Longitude = &c.Longitude.Float64
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
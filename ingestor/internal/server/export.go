@@ -0,0 +1,101 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// exportPageSize is how many readings GET /export loads per query. The
+// response is flushed after every page, so memory use stays flat however
+// large the range is.
+const exportPageSize = 1000
+
+// exportRecord is one line of GET /export
+type exportRecord struct {
+	LotID string `json:"lot_id"`
+	City  string `json:"city"`
+	readingResponse
+}
+
+// handleExport streams the readings of a city (every city when "city" is
+// empty) between "from" and "to" as newline-delimited JSON, oldest first.
+// The body is gzipped when the client accepts it. A failure after the
+// first page aborts the connection, so a truncated export never looks
+// complete.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	city := query.Get("city")
+	from, to, err := s.parseRange(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	page, err := s.exportPage(ctx, s.db, city, from, to, nil, exportPageSize)
+	if err != nil {
+		log.Printf("Failed to export readings: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to load readings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Add("Vary", "Accept-Encoding")
+	var out io.Writer = w
+	flush := func() error { return nil }
+	finish := func() error { return nil }
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		out, flush, finish = gz, gz.Flush, gz.Close
+	}
+	w.WriteHeader(http.StatusOK)
+
+	// Once the status is sent, an error can only abort the stream. The
+	// gzip trailer is written only after the last page, so clients see a
+	// broken stream instead of a cleanly ended one.
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(out)
+	for {
+		for _, reading := range page.Readings {
+			record := exportRecord{LotID: reading.LotID, City: reading.City, readingResponse: newReadingResponse(reading)}
+			if err := enc.Encode(record); err != nil {
+				panic(http.ErrAbortHandler)
+			}
+		}
+		if err := flush(); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		if err := rc.Flush(); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+
+		if page.Next == nil {
+			if err := finish(); err != nil {
+				panic(http.ErrAbortHandler)
+			}
+			return
+		}
+		if page, err = s.exportPage(ctx, s.db, city, from, to, page.Next, exportPageSize); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to export readings: %v", err)
+			}
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(name) == "gzip" && strings.TrimSpace(params) != "q=0" {
+			return true
+		}
+	}
+	return false
+}
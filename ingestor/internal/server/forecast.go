@@ -0,0 +1,71 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/forecast"
+)
+
+// forecastResponse is returned by GET /lots/{id}/forecast
+type forecastResponse struct {
+	LotID string `json:"lot_id"`
+	Total int    `json:"total"`
+	forecast.Prediction
+}
+
+// handleForecast predicts free spaces for a lot at the time given by the
+// "at" query parameter (RFC 3339, default one hour from now)
+func (s *Server) handleForecast(w http.ResponseWriter, r *http.Request) {
+	lotID := r.PathValue("id")
+
+	at := s.now().Add(time.Hour)
+	if raw := r.URL.Query().Get("at"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "at must be an RFC 3339 timestamp")
+			return
+		}
+		at = parsed
+	}
+
+	lot, err := database.GetLot(s.db, lotID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "lot not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load lot %s: %v", lotID, err)
+		writeError(w, http.StatusInternalServerError, "failed to load lot")
+		return
+	}
+
+	now := s.now()
+	history, err := database.GetReadingsForLot(s.db, lotID, now.Add(-s.historyWindow), now)
+	if err != nil {
+		log.Printf("Failed to load readings for %s: %v", lotID, err)
+		writeError(w, http.StatusInternalServerError, "failed to load readings")
+		return
+	}
+
+	prediction, err := s.model.Predict(history, at)
+	if errors.Is(err, forecast.ErrInsufficientData) {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Forecast failed for %s: %v", lotID, err)
+		writeError(w, http.StatusInternalServerError, "forecast failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, forecastResponse{
+		LotID:      lot.ID,
+		Total:      lot.Total,
+		Prediction: prediction,
+	})
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/ingestor"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// liveBuffer is how many events a WebSocket client may lag behind
+	// before it is disconnected
+	liveBuffer = 1000
+
+	// liveWriteTimeout bounds writing one event to a WebSocket client
+	liveWriteTimeout = 10 * time.Second
+)
+
+// WithEvents enables GET /ws, which streams the readings published to bus
+func WithEvents(bus *ingestor.Bus) Option {
+	return func(s *Server) {
+		s.events = bus
+	}
+}
+
+// handleLive upgrades to a WebSocket and sends every new reading as a JSON
+// message, optionally only those of the "city" or "lot" query parameters
+func (s *Server) handleLive(w http.ResponseWriter, r *http.Request) {
+	city, lotID := r.URL.Query().Get("city"), r.URL.Query().Get("lot")
+
+	// Subscribe before the handshake, so no reading stored after the client
+	// connected is missed
+	sub := s.events.Subscribe(liveBuffer)
+	defer sub.Close()
+
+	// websocket.Server accepts any Origin; the read API is not tied to a site
+	ws := websocket.Server{Handler: func(conn *websocket.Conn) {
+		streamEvents(conn, sub, city, lotID)
+	}}
+	ws.ServeHTTP(w, r)
+}
+
+// streamEvents writes the subscription's matching events to conn until
+// either side closes
+func streamEvents(conn *websocket.Conn, sub *ingestor.Subscription, city, lotID string) {
+	// Clients only listen; reading detects when they disconnect
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if (city != "" && event.City != city) || (lotID != "" && event.LotID != lotID) {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+			if err := websocket.JSON.Send(conn, event); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
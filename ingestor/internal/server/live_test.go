@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/ingestor"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+	"golang.org/x/net/websocket"
+)
+
+func TestLiveEndpoint(t *testing.T) {
+	bus := ingestor.NewBus()
+	ts := httptest.NewServer(New(testutil.NewDB(t), WithEvents(bus)).Handler())
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?city=Dresden"
+	conn, err := websocket.Dial(url, "", ts.URL)
+	if err != nil {
+		t.Fatalf("Dial() error: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	bus.Publish(
+		ingestor.ReadingEvent{City: "Leipzig", LotID: "l1", Free: 1},
+		ingestor.ReadingEvent{City: "Dresden", LotID: "d1", Free: 42, Total: 100, State: "open"},
+	)
+
+	var event ingestor.ReadingEvent
+	if err := websocket.JSON.Receive(conn, &event); err != nil {
+		t.Fatalf("Receive() error: %v", err)
+	}
+	if event.City != "Dresden" || event.LotID != "d1" || event.Free != 42 {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+
+	// Closing the bus on shutdown ends the stream
+	bus.Close()
+	if err := websocket.JSON.Receive(conn, &event); err == nil {
+		t.Errorf("Expected the connection to close, got %+v", event)
+	}
+}
+
+func TestLiveDisabled(t *testing.T) {
+	rec := httptest.NewRecorder()
+	New(testutil.NewDB(t)).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without an event bus, got %d", rec.Code)
+	}
+}
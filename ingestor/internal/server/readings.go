@@ -0,0 +1,141 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+const (
+	// defaultPageSize and maxPageSize bound the "limit" parameter
+	defaultPageSize = 100
+	maxPageSize     = 1000
+
+	// defaultReadingsWindow is the range served when "from" is not given
+	defaultReadingsWindow = 24 * time.Hour
+)
+
+// readingResponse is one reading of GET /lots/{id}/readings
+type readingResponse struct {
+	Timestamp time.Time `json:"timestamp"`
+	Free      int       `json:"free"`
+	State     string    `json:"state"`
+	Anomalous bool      `json:"anomalous"`
+	// Quality is the reading's quality bitfield, QualityFlags its names
+	Quality      int      `json:"quality"`
+	QualityFlags []string `json:"quality_flags"`
+}
+
+func newReadingResponse(reading database.ParkingReading) readingResponse {
+	return readingResponse{
+		Timestamp: reading.Timestamp,
+		Free:      reading.Free,
+		State:     reading.State,
+		Anomalous: reading.Anomalous,
+
+		Quality:      int(reading.Quality),
+		QualityFlags: reading.Quality.Flags(),
+	}
+}
+
+// readingsResponse is returned by GET /lots/{id}/readings
+type readingsResponse struct {
+	LotID      string            `json:"lot_id"`
+	Readings   []readingResponse `json:"readings"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// handleReadings pages through a lot's readings between "from" and "to"
+// (RFC 3339, default the last 24 hours), oldest first. Pass the returned
+// next_cursor as "cursor" to fetch the following page.
+func (s *Server) handleReadings(w http.ResponseWriter, r *http.Request) {
+	lotID := r.PathValue("id")
+	query := r.URL.Query()
+
+	from, to, err := s.parseRange(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit := defaultPageSize
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageSize))
+			return
+		}
+		limit = parsed
+	}
+
+	var after *database.Cursor
+	if raw := query.Get("cursor"); raw != "" {
+		cursor, err := database.ParseCursor(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		after = &cursor
+	}
+
+	if !s.lotExists(w, lotID) {
+		return
+	}
+
+	page, err := database.GetReadingsPage(s.db, lotID, from.UTC(), to.UTC(), after, limit)
+	if err != nil {
+		log.Printf("Failed to load readings for %s: %v", lotID, err)
+		writeError(w, http.StatusInternalServerError, "failed to load readings")
+		return
+	}
+
+	result := readingsResponse{LotID: lotID, Readings: make([]readingResponse, len(page.Readings))}
+	for idx, reading := range page.Readings {
+		result.Readings[idx] = newReadingResponse(reading)
+	}
+	if page.Next != nil {
+		result.NextCursor = page.Next.String()
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// parseRange reads the "from" and "to" query parameters (RFC 3339),
+// defaulting to the last 24 hours
+func (s *Server) parseRange(query url.Values) (from, to time.Time, err error) {
+	to = s.now()
+	if raw := query.Get("to"); raw != "" {
+		if to, err = time.Parse(time.RFC3339, raw); err != nil {
+			return from, to, errors.New("to must be an RFC 3339 timestamp")
+		}
+	}
+	from = to.Add(-defaultReadingsWindow)
+	if raw := query.Get("from"); raw != "" {
+		if from, err = time.Parse(time.RFC3339, raw); err != nil {
+			return from, to, errors.New("from must be an RFC 3339 timestamp")
+		}
+	}
+	return from, to, nil
+}
+
+// lotExists reports whether a lot is stored, writing a 404 or 500 response
+// when it is not
+func (s *Server) lotExists(w http.ResponseWriter, lotID string) bool {
+	_, err := database.GetLot(s.db, lotID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "lot not found")
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to load lot %s: %v", lotID, err)
+		writeError(w, http.StatusInternalServerError, "failed to load lot")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/ingestor"
+)
+
+// Refresher polls a city on demand; *ingestor.Ingestor implements it
+type Refresher interface {
+	RefreshCity(ctx context.Context, city string) (*ingestor.CityResult, error)
+}
+
+// handleRefresh polls the city given by the "city" query parameter
+// immediately and reports how many lots were updated
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	city := r.URL.Query().Get("city")
+	if city == "" {
+		writeError(w, http.StatusBadRequest, "city is required")
+		return
+	}
+
+	if !s.refreshLimiter.Allow() {
+		w.Header().Set("Retry-After", "60")
+		writeError(w, http.StatusTooManyRequests, "too many refresh requests")
+		return
+	}
+
+	result, err := s.refresher.RefreshCity(r.Context(), city)
+	if errors.Is(err, ingestor.ErrUnknownCity) {
+		writeError(w, http.StatusNotFound, "city is not monitored")
+		return
+	}
+	if err != nil {
+		log.Printf("Refresh of %s failed: %v", city, err)
+		writeError(w, http.StatusBadGateway, "refresh failed")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, result)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/ingestor"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+	"golang.org/x/time/rate"
+)
+
+// fakeRefresher refreshes Dresden and fails for Broken
+type fakeRefresher struct{}
+
+func (fakeRefresher) RefreshCity(ctx context.Context, city string) (*ingestor.CityResult, error) {
+	switch city {
+	case "Dresden":
+		return &ingestor.CityResult{City: city, Lots: 3, Timestamp: time.Now()}, nil
+	case "Broken":
+		return nil, errors.New("upstream down")
+	}
+	return nil, fmt.Errorf("%w: %s", ingestor.ErrUnknownCity, city)
+}
+
+func TestRefreshEndpoint(t *testing.T) {
+	s := New(testutil.NewDB(t), WithRefresher(fakeRefresher{}, rate.Every(time.Hour), 3))
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"refreshed", "/refresh?city=Dresden", http.StatusAccepted},
+		{"missing city", "/refresh", http.StatusBadRequest},
+		{"unknown city", "/refresh?city=Atlantis", http.StatusNotFound},
+		{"upstream failure", "/refresh?city=Broken", http.StatusBadGateway},
+		{"rate limited", "/refresh?city=Dresden", http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRefreshDisabled(t *testing.T) {
+	rec := httptest.NewRecorder()
+	New(testutil.NewDB(t)).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/refresh?city=Dresden", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a refresher, got %d", rec.Code)
+	}
+}
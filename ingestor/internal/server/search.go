@@ -0,0 +1,72 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// defaultSearchLimit and maxSearchLimit bound the "limit" parameter of
+// GET /search
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// lotMatchResponse is one entry of GET /search
+type lotMatchResponse struct {
+	ID      string  `json:"id"`
+	City    string  `json:"city"`
+	Name    string  `json:"name"`
+	Address *string `json:"address"`
+	LotType *string `json:"lot_type"`
+	Total   int     `json:"total"`
+}
+
+// handleSearch finds lots of every city by partial name or address, e.g.
+// for autocompletion
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		writeError(w, http.StatusBadRequest, "missing q parameter")
+		return
+	}
+
+	limit := defaultSearchLimit
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxSearchLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxSearchLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	lots, err := database.SearchLots(s.db, q, limit)
+	if err != nil {
+		log.Printf("Failed to search lots for %q: %v", q, err)
+		writeError(w, http.StatusInternalServerError, "failed to search lots")
+		return
+	}
+
+	result := make([]lotMatchResponse, len(lots))
+	for idx, lot := range lots {
+		result[idx] = lotMatchResponse{
+			ID:    lot.ID,
+			City:  lot.City,
+			Name:  lot.Name,
+			Total: lot.Total,
+		}
+		if lot.Address.Valid {
+			result[idx].Address = &lot.Address.String
+		}
+		if lot.LotType.Valid {
+			result[idx].LotType = &lot.LotType.String
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+const (
+	// defaultSeriesStep is the grid spacing when "step" is not given
+	defaultSeriesStep = 5 * time.Minute
+
+	// maxSeriesSamples bounds the grid size of a single request
+	maxSeriesSamples = 10000
+)
+
+// sampleResponse is one grid point of GET /lots/{id}/series; Free and
+// State are null inside gaps
+type sampleResponse struct {
+	Timestamp time.Time `json:"timestamp"`
+	Free      *int      `json:"free"`
+	State     *string   `json:"state"`
+}
+
+// seriesResponse is returned by GET /lots/{id}/series
+type seriesResponse struct {
+	LotID    string            `json:"lot_id"`
+	Step     string            `json:"step"`
+	Readings []readingResponse `json:"readings"`
+	Samples  []sampleResponse  `json:"samples"`
+}
+
+// handleSeries returns a lot's readings between "from" and "to" together
+// with the same data resampled onto an even grid of "step" (default 5m).
+// Grid points more than "max_age" (default three steps) after the last
+// reading are null.
+func (s *Server) handleSeries(w http.ResponseWriter, r *http.Request) {
+	lotID := r.PathValue("id")
+	query := r.URL.Query()
+
+	from, to, err := s.parseRange(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	step := defaultSeriesStep
+	if raw := query.Get("step"); raw != "" {
+		if step, err = time.ParseDuration(raw); err != nil || step <= 0 {
+			writeError(w, http.StatusBadRequest, "step must be a positive duration such as 5m")
+			return
+		}
+	}
+	if to.Sub(from)/step > maxSeriesSamples {
+		writeError(w, http.StatusBadRequest, "range too large for step; use a larger step or a shorter range")
+		return
+	}
+
+	maxAge := 3 * step
+	if raw := query.Get("max_age"); raw != "" {
+		if maxAge, err = time.ParseDuration(raw); err != nil || maxAge < 0 {
+			writeError(w, http.StatusBadRequest, "max_age must be a duration such as 15m")
+			return
+		}
+	}
+
+	if !s.lotExists(w, lotID) {
+		return
+	}
+
+	readings, err := database.GetReadingsForLot(s.db, lotID, from, to)
+	if err != nil {
+		log.Printf("Failed to load readings for %s: %v", lotID, err)
+		writeError(w, http.StatusInternalServerError, "failed to load readings")
+		return
+	}
+
+	result := seriesResponse{
+		LotID:    lotID,
+		Step:     step.String(),
+		Readings: make([]readingResponse, len(readings)),
+		Samples:  []sampleResponse{},
+	}
+	for idx, reading := range readings {
+		result.Readings[idx] = readingResponse{Timestamp: reading.Timestamp, Free: reading.Free, State: reading.State}
+	}
+	for _, sample := range database.ResampleReadings(readings, step, maxAge) {
+		point := sampleResponse{Timestamp: sample.Timestamp}
+		if sample.Valid {
+			point.Free, point.State = &sample.Free, &sample.State
+		}
+		result.Samples = append(result.Samples, point)
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
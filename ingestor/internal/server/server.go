@@ -0,0 +1,124 @@
+// Package server exposes the stored parking data over an HTTP API.
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/forecast"
+	"github.com/niklas/parkmonitor/ingestor/internal/ingestor"
+	"golang.org/x/time/rate"
+)
+
+// defaultHistoryWindow is how much history forecasts are computed from
+const defaultHistoryWindow = 8 * 7 * 24 * time.Hour
+
+// Server serves the read API
+type Server struct {
+	db            *sql.DB
+	model         forecast.Model
+	historyWindow time.Duration
+	now           func() time.Time
+	mux           *http.ServeMux
+
+	// exportPage loads the pages of GET /export
+	exportPage func(ctx context.Context, db *sql.DB, city string, from, to time.Time, after *database.Cursor, limit int) (*database.ReadingsPage, error)
+
+	refresher      Refresher
+	refreshLimiter *rate.Limiter
+
+	events *ingestor.Bus
+
+	auth Auth
+
+	cityNames database.CityNames
+
+	// statsMu guards the cached GET /stats result
+	statsMu  sync.Mutex
+	statsTTL time.Duration
+	stats    *database.Stats
+	statsAt  time.Time
+}
+
+// Option configures optional server behavior
+type Option func(*Server)
+
+// WithForecastModel replaces the default forecasting model
+func WithForecastModel(model forecast.Model) Option {
+	return func(s *Server) {
+		s.model = model
+	}
+}
+
+// WithRefresher enables POST /refresh, which polls a city on demand.
+// Requests beyond burst are rejected until the limit allows more.
+func WithRefresher(refresher Refresher, limit rate.Limit, burst int) Option {
+	return func(s *Server) {
+		s.refresher = refresher
+		s.refreshLimiter = rate.NewLimiter(limit, burst)
+	}
+}
+
+// WithCityNames shows the given names instead of the stored city names
+func WithCityNames(names database.CityNames) Option {
+	return func(s *Server) {
+		s.cityNames = names
+	}
+}
+
+// New creates a read API server backed by db
+func New(db *sql.DB, opts ...Option) *Server {
+	s := &Server{
+		db:            db,
+		model:         forecast.WeeklyProfile{},
+		historyWindow: defaultHistoryWindow,
+		statsTTL:      defaultStatsTTL,
+		now:           time.Now,
+		exportPage:    database.GetCityReadingsPage,
+		mux:           http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.mux.HandleFunc("GET /cities", s.handleCities)
+	s.mux.HandleFunc("GET /stats", s.handleStats)
+	s.mux.HandleFunc("GET /search", s.handleSearch)
+	s.mux.HandleFunc("GET /lots/{id}/readings", s.handleReadings)
+	s.mux.HandleFunc("GET /lots/{id}/series", s.handleSeries)
+	s.mux.HandleFunc("GET /lots/{id}/forecast", s.handleForecast)
+	s.mux.HandleFunc("GET /export", s.handleExport)
+	if s.refresher != nil {
+		s.mux.HandleFunc("POST /refresh", s.handleRefresh)
+	}
+	if s.events != nil {
+		s.mux.HandleFunc("GET /ws", s.handleLive)
+	}
+
+	return s
+}
+
+// Handler returns the HTTP handler serving all routes
+func (s *Server) Handler() http.Handler {
+	return RequireAuth(s.auth, s.mux)
+}
+
+// writeJSON encodes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// writeError sends a JSON error body with the given status
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
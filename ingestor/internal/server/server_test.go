@@ -0,0 +1,435 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestForecastEndpoint(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+
+	// Two Mondays at 08:xx
+	monday := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("lot1", "Dresden", monday, 20),
+		testutil.NewReading("lot1", "Dresden", monday.AddDate(0, 0, 7).Add(20*time.Minute), 40),
+	)
+
+	s := New(db)
+	s.now = func() time.Time { return monday.AddDate(0, 0, 14) }
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"prediction", "/lots/lot1/forecast?at=2024-01-15T08:30:00Z", http.StatusOK},
+		{"bad timestamp", "/lots/lot1/forecast?at=tomorrow", http.StatusBadRequest},
+		{"unknown lot", "/lots/missing/forecast?at=2024-01-15T08:30:00Z", http.StatusNotFound},
+		{"no history", "/lots/lot1/forecast?at=2024-01-16T08:30:00Z", http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var resp forecastResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.LotID != "lot1" || resp.Free != 30 || resp.Samples != 2 {
+				t.Errorf("Unexpected forecast: %+v", resp)
+			}
+		})
+	}
+}
+
+func TestCitiesEndpoint(t *testing.T) {
+	db := testutil.NewDB(t)
+	err := database.UpsertCity(db, &database.City{
+		ID: "Dresden", Name: "Dresden",
+		Source: sql.NullString{String: "https://dresden.example", Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	New(db).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cities", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp []cityResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp) != 1 || resp[0].Source == nil || *resp[0].Source != "https://dresden.example" {
+		t.Errorf("Unexpected cities: %+v", resp)
+	}
+
+	// Overrides replace the name but keep the ID
+	rec = httptest.NewRecorder()
+	New(db, WithCityNames(database.CityNames{"Dresden": "DD"})).Handler().
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cities", nil))
+	resp = nil
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp) != 1 || resp[0].ID != "Dresden" || resp[0].Name != "DD" {
+		t.Errorf("Expected the overridden name, got %+v", resp)
+	}
+}
+
+func TestSearchEndpoint(t *testing.T) {
+	db := testutil.NewDB(t)
+	altmarkt := testutil.NewLot("altmarkt", "Dresden")
+	altmarkt.Name = "Altmarkt-Galerie"
+	muenz := testutil.NewLot("muenz", "Leipzig")
+	muenz.Name = "Parkhaus Münzgasse"
+	testutil.InsertLots(t, db, altmarkt, muenz)
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		expected []string
+	}{
+		{"prefix", "/search?q=alt", http.StatusOK, []string{"altmarkt"}},
+		{"transliterated umlaut", "/search?q=MUENZ", http.StatusOK, []string{"muenz"}},
+		{"across cities", "/search?q=a", http.StatusOK, []string{"altmarkt", "muenz"}},
+		{"limit", "/search?q=a&limit=1", http.StatusOK, []string{"altmarkt"}},
+		{"no match", "/search?q=zwinger", http.StatusOK, []string{}},
+		{"missing query", "/search", http.StatusBadRequest, nil},
+		{"bad limit", "/search?q=a&limit=0", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			New(db).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var resp []lotMatchResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			ids := []string{}
+			for _, lot := range resp {
+				ids = append(ids, lot.ID)
+			}
+			if !slices.Equal(ids, tt.expected) {
+				t.Errorf("got %v, expected %v", ids, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReadingsEndpoint(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		testutil.InsertReadings(t, db,
+			testutil.NewReading("lot1", "Dresden", base.Add(time.Duration(i)*5*time.Minute), i))
+	}
+
+	s := New(db)
+	s.now = func() time.Time { return base.Add(time.Hour) }
+
+	fetch := func(path string) (int, readingsResponse) {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var body readingsResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return rec.Code, body
+	}
+
+	var got []int
+	path := "/lots/lot1/readings?limit=2"
+	for path != "" {
+		status, body := fetch(path)
+		if status != http.StatusOK {
+			t.Fatalf("got status %d, expected %d", status, http.StatusOK)
+		}
+		for _, r := range body.Readings {
+			got = append(got, r.Free)
+		}
+		path = ""
+		if body.NextCursor != "" {
+			path = "/lots/lot1/readings?limit=2&cursor=" + body.NextCursor
+		}
+	}
+	if len(got) != 5 || got[0] != 0 || got[4] != 4 {
+		t.Errorf("got %v, expected [0 1 2 3 4]", got)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"bad limit", "/lots/lot1/readings?limit=0", http.StatusBadRequest},
+		{"limit too large", "/lots/lot1/readings?limit=5000", http.StatusBadRequest},
+		{"bad cursor", "/lots/lot1/readings?cursor=%21%21", http.StatusBadRequest},
+		{"bad from", "/lots/lot1/readings?from=yesterday", http.StatusBadRequest},
+		{"unknown lot", "/lots/missing/readings", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := fetch(tt.path); status != tt.status {
+				t.Errorf("got status %d, expected %d", status, tt.status)
+			}
+		})
+	}
+}
+
+func TestExportEndpoint(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("d1", "Dresden"), testutil.NewLot("l1", "Leipzig"))
+
+	// More readings than fit a page, so the stream spans several queries
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	const dresden = exportPageSize + 500
+	for i := range dresden {
+		testutil.InsertReadings(t, db, testutil.NewReading("d1", "Dresden", base.Add(time.Duration(i)*time.Second), i%100))
+	}
+	testutil.InsertReadings(t, db, testutil.NewReading("l1", "Leipzig", base, 7))
+
+	s := New(db)
+	s.now = func() time.Time { return base.Add(time.Hour) }
+
+	export := func(path string, gzipped bool) []exportRecord {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if gzipped {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, expected %d", path, rec.Code, http.StatusOK)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/x-ndjson" {
+			t.Errorf("got content type %q, expected application/x-ndjson", got)
+		}
+
+		var body io.Reader = rec.Body
+		if gzipped {
+			if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+				t.Fatalf("got content encoding %q, expected gzip", got)
+			}
+			gz, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("Failed to read gzip body: %v", err)
+			}
+			body = gz
+		}
+
+		// Every line must be a complete JSON object
+		var records []exportRecord
+		scanner := bufio.NewScanner(body)
+		for scanner.Scan() {
+			var record exportRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatalf("line %d is not JSON: %v: %s", len(records)+1, err, scanner.Text())
+			}
+			records = append(records, record)
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return records
+	}
+
+	for _, gzipped := range []bool{false, true} {
+		records := export("/export", gzipped)
+		if len(records) != dresden+1 {
+			t.Fatalf("gzip %v: got %d records, expected %d", gzipped, len(records), dresden+1)
+		}
+		for idx := 1; idx < len(records); idx++ {
+			if records[idx].Timestamp.Before(records[idx-1].Timestamp) {
+				t.Fatalf("gzip %v: record %d is out of order", gzipped, idx)
+			}
+		}
+	}
+
+	leipzig := export("/export?city=Leipzig", false)
+	if len(leipzig) != 1 || leipzig[0].LotID != "l1" || leipzig[0].City != "Leipzig" || leipzig[0].Free != 7 {
+		t.Errorf("got %+v, expected the reading of l1", leipzig)
+	}
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/export?from=yesterday", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, expected %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestExportAbortsOnFailure(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("d1", "Dresden"))
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range exportPageSize + 1 {
+		testutil.InsertReadings(t, db, testutil.NewReading("d1", "Dresden", base.Add(time.Duration(i)*time.Second), 10))
+	}
+
+	s := New(db)
+	s.now = func() time.Time { return base.Add(time.Hour) }
+	// The second page fails after the first was streamed
+	s.exportPage = func(ctx context.Context, db *sql.DB, city string, from, to time.Time, after *database.Cursor, limit int) (*database.ReadingsPage, error) {
+		if after != nil {
+			return nil, errors.New("disk I/O error")
+		}
+		return database.GetCityReadingsPage(ctx, db, city, from, to, after, limit)
+	}
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	for _, gzipped := range []bool{false, true} {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/export", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gzipped {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body io.Reader = resp.Body
+		if gzipped {
+			gz, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read gzip body: %v", err)
+			}
+			body = gz
+		}
+		if _, err := io.ReadAll(body); err == nil {
+			t.Errorf("gzip %v: expected a broken stream, got a complete body", gzipped)
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestStatsEndpoint(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testutil.InsertReadings(t, db, testutil.NewReading("lot1", "Dresden", base, 10))
+
+	now := base
+	s := New(db, WithStatsTTL(time.Minute))
+	s.now = func() time.Time { return now }
+
+	fetch := func() statsResponse {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %d, expected %d", rec.Code, http.StatusOK)
+		}
+		var body statsResponse
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return body
+	}
+
+	body := fetch()
+	if body.Lots != 1 || body.Readings != 1 || body.LatestReading == nil || !body.LatestReading.Equal(base) {
+		t.Errorf("Unexpected stats: %+v", body)
+	}
+
+	// Served from the cache until the TTL expires
+	testutil.InsertReadings(t, db, testutil.NewReading("lot1", "Dresden", base.Add(time.Hour), 20))
+	if body := fetch(); body.Readings != 1 {
+		t.Errorf("got %d readings, expected the cached 1", body.Readings)
+	}
+
+	now = now.Add(time.Minute)
+	if body := fetch(); body.Readings != 2 {
+		t.Errorf("got %d readings after the TTL, expected 2", body.Readings)
+	}
+}
+
+func TestSeriesEndpoint(t *testing.T) {
+	db := testutil.NewDB(t)
+	testutil.InsertLots(t, db, testutil.NewLot("lot1", "Dresden"))
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testutil.InsertReadings(t, db,
+		testutil.NewReading("lot1", "Dresden", base.Add(10*time.Second), 10),
+		testutil.NewReading("lot1", "Dresden", base.Add(5*time.Minute+30*time.Second), 20),
+		testutil.NewReading("lot1", "Dresden", base.Add(30*time.Minute), 30),
+	)
+
+	s := New(db)
+	s.now = func() time.Time { return base.Add(time.Hour) }
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/lots/lot1/series?step=5m&max_age=10m", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, expected %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var body seriesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if len(body.Readings) != 3 {
+		t.Errorf("got %d readings, expected the 3 originals", len(body.Readings))
+	}
+	// 12:05 through 12:30; 12:20 and 12:25 are more than 10m after 12:05:30
+	if len(body.Samples) != 6 {
+		t.Fatalf("got %d samples, expected 6", len(body.Samples))
+	}
+	for idx, expected := range []*int{intPtr(10), intPtr(20), intPtr(20), nil, nil, intPtr(30)} {
+		got := body.Samples[idx].Free
+		if (got == nil) != (expected == nil) || (got != nil && *got != *expected) {
+			t.Errorf("sample %d at %v: got %v, expected %v", idx, body.Samples[idx].Timestamp, got, expected)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/lots/lot1/series?step=1s&from=2020-01-01T00:00:00Z", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for an oversized grid, expected %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func intPtr(v int) *int {
+	return &v
+}
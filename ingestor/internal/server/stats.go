@@ -0,0 +1,67 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// defaultStatsTTL is how long GET /stats serves a cached result
+const defaultStatsTTL = time.Minute
+
+// cityStatsResponse is one city of GET /stats
+type cityStatsResponse struct {
+	City string `json:"city"`
+	Lots int    `json:"lots"`
+}
+
+// statsResponse is returned by GET /stats
+type statsResponse struct {
+	Lots            int                 `json:"lots"`
+	Readings        int64               `json:"readings"`
+	EarliestReading *time.Time          `json:"earliest_reading"`
+	LatestReading   *time.Time          `json:"latest_reading"`
+	Cities          []cityStatsResponse `json:"cities"`
+}
+
+// WithStatsTTL sets how long GET /stats results are cached (0 = no cache)
+func WithStatsTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.statsTTL = ttl
+	}
+}
+
+// handleStats reports dataset totals, cached for the stats TTL since
+// counting readings scans the whole table
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	now := s.now()
+	if s.stats == nil || now.Sub(s.statsAt) >= s.statsTTL {
+		stats, err := database.GetStats(s.db)
+		if err != nil {
+			log.Printf("Failed to compute stats: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to compute stats")
+			return
+		}
+		s.stats, s.statsAt = stats, now
+	}
+
+	result := statsResponse{
+		Lots:     s.stats.Lots,
+		Readings: s.stats.Readings,
+		Cities:   make([]cityStatsResponse, len(s.stats.Cities)),
+	}
+	if !s.stats.EarliestReading.IsZero() {
+		result.EarliestReading = &s.stats.EarliestReading
+		result.LatestReading = &s.stats.LatestReading
+	}
+	for idx, c := range s.stats.Cities {
+		result.Cities[idx] = cityStatsResponse{City: c.City, Lots: c.Lots}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
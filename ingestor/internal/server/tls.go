@@ -0,0 +1,43 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// TLS holds the certificate and key files to serve HTTPS with. The zero
+// value serves plain HTTP.
+type TLS struct {
+	CertFile string
+	KeyFile  string
+}
+
+// Enabled reports whether a certificate is configured
+func (t TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// Validate checks that the certificate and key are set together and can be
+// loaded, so a bad file fails at startup rather than in the listener
+func (t TLS) Validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("TLS needs both a certificate and a key file")
+	}
+	if !t.Enabled() {
+		return nil
+	}
+	if _, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile); err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	return nil
+}
+
+// ListenAndServe serves srv over HTTPS when TLS is enabled, else over HTTP
+func (t TLS) ListenAndServe(srv *http.Server) error {
+	if !t.Enabled() {
+		return srv.ListenAndServe()
+	}
+	return srv.ListenAndServeTLS(t.CertFile, t.KeyFile)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for 127.0.0.1 and its
+// key to dir
+func writeCertificate(t *testing.T, dir string) TLS {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	cfg := TLS{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(cfg.CertFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cfg.KeyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestTLSValidate(t *testing.T) {
+	cfg := writeCertificate(t, t.TempDir())
+
+	tests := []struct {
+		name    string
+		tls     TLS
+		wantErr bool
+	}{
+		{"disabled", TLS{}, false},
+		{"certificate and key", cfg, false},
+		{"certificate only", TLS{CertFile: cfg.CertFile}, true},
+		{"key only", TLS{KeyFile: cfg.KeyFile}, true},
+		{"missing files", TLS{CertFile: "missing.pem", KeyFile: "missing.key"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.tls.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, expected error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSRejectsPlaintext(t *testing.T) {
+	cfg := writeCertificate(t, t.TempDir())
+
+	// Reserve a free port for ListenAndServe
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	srv := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})}
+	go cfg.ListenAndServe(srv)
+	t.Cleanup(func() { srv.Close() })
+
+	client := &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	var resp *http.Response
+	for range 50 {
+		if resp, err = client.Get("https://" + addr + "/"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got HTTPS status %d, expected %d", resp.StatusCode, http.StatusOK)
+	}
+
+	// Go's TLS server answers plain HTTP with 400 Bad Request
+	resp, err = client.Get("http://" + addr + "/")
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("got plaintext status %d, expected the request to be rejected", resp.StatusCode)
+		}
+	}
+}
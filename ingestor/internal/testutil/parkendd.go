@@ -0,0 +1,146 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// DresdenJSON is a ParkenDD city response with lots of every kind: with and
+// without coordinates, region, address and forecast, open and closed
+const DresdenJSON = `{
+	"last_downloaded": "2024-01-01T12:00:00",
+	"last_updated": "2024-01-01T11:55:00",
+	"lots": [
+		{"id": "dresdenaltmarkt", "name": "Altmarkt", "lot_type": "Tiefgarage", "address": "Wilsdruffer Straße",
+		 "region": "Innere Altstadt", "coords": {"lat": 51.05031, "lng": 13.73754},
+		 "forecast": true, "state": "open", "free": 120, "total": 400},
+		{"id": "dresdenzwinger", "name": "Zwinger", "lot_type": "Parkplatz",
+		 "region": "Innere Altstadt", "coords": {"lat": 51.05267, "lng": 13.73432},
+		 "forecast": false, "state": "closed", "free": 0, "total": 200},
+		{"id": "dresdenneustadt", "name": "Neustädter Markt", "lot_type": "Parkhaus",
+		 "region": "Neustadt", "forecast": false, "state": "open", "free": 35, "total": 150}
+	]
+}`
+
+// EmptyCityJSON is a ParkenDD city response without lots, as sent while a
+// city's upstream source is down
+const EmptyCityJSON = `{
+	"last_downloaded": "2024-01-01T12:00:00",
+	"last_updated": "2024-01-01T11:00:00",
+	"lots": []
+}`
+
+// FakeCity is a city served by a ParkenDD server
+type FakeCity struct {
+	ID       string
+	Name     string
+	Lat, Lng float64
+	// Response is the body of GET /{ID}
+	Response string
+}
+
+// DefaultCities are the cities served by NewParkenDD: Dresden with three
+// lots and Leipzig without any
+var DefaultCities = []FakeCity{
+	{ID: "Dresden", Name: "Dresden", Lat: 51.05089, Lng: 13.73832, Response: DresdenJSON},
+	{ID: "Leipzig", Name: "Leipzig", Lat: 51.33970, Lng: 12.37307, Response: EmptyCityJSON},
+}
+
+// ParkenDD is a fake ParkenDD API for end-to-end tests of the real client.
+// It serves the city list at / and each city's response at /{city};
+// unknown cities get 404 Not Found.
+type ParkenDD struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	cities   []FakeCity
+	requests map[string]int
+}
+
+// NewParkenDD starts a fake ParkenDD API serving cities, or DefaultCities
+// when none are given. It is closed when the test ends.
+func NewParkenDD(t testing.TB, cities ...FakeCity) *ParkenDD {
+	t.Helper()
+	if len(cities) == 0 {
+		cities = DefaultCities
+	}
+	p := &ParkenDD{cities: cities, requests: make(map[string]int)}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serve))
+	t.Cleanup(p.Close)
+	return p
+}
+
+// SetResponse replaces the response of a served city, e.g. to simulate a
+// new upstream update
+func (p *ParkenDD) SetResponse(city, response string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for idx := range p.cities {
+		if p.cities[idx].ID == city {
+			p.cities[idx].Response = response
+		}
+	}
+}
+
+// Requests returns how many requests were made for path, e.g. "/Dresden"
+func (p *ParkenDD) Requests(path string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requests[path]
+}
+
+func (p *ParkenDD) serve(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests[r.URL.Path]++
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/" {
+		json.NewEncoder(w).Encode(p.cityList())
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/")
+	for _, city := range p.cities {
+		if city.ID == id {
+			w.Write([]byte(city.Response))
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// cityList returns the body of GET / in the shape of the real API
+func (p *ParkenDD) cityList() map[string]any {
+	type coords struct {
+		Lat float64 `json:"lat"`
+		Lng float64 `json:"lng"`
+	}
+	type city struct {
+		Name          string `json:"name"`
+		Coords        coords `json:"coords"`
+		Source        string `json:"source"`
+		URL           string `json:"url"`
+		ActiveSupport bool   `json:"active_support"`
+	}
+
+	cities := make(map[string]city, len(p.cities))
+	for _, c := range p.cities {
+		cities[c.ID] = city{
+			Name:          c.Name,
+			Coords:        coords{Lat: c.Lat, Lng: c.Lng},
+			Source:        "https://" + strings.ToLower(c.ID) + ".example",
+			URL:           "https://" + strings.ToLower(c.ID) + ".example/parken",
+			ActiveSupport: true,
+		}
+	}
+	return map[string]any{
+		"api_version":    "1.0",
+		"server_version": "1.3.3",
+		"reference":      "https://github.com/offenesdresden/ParkAPI",
+		"cities":         cities,
+	}
+}
@@ -0,0 +1,81 @@
+// Package testutil provides an in-memory database and fixture builders
+// shared by tests across packages.
+package testutil
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// NewDB returns an in-memory SQLite database with the latest schema.
+// It is closed automatically when the test finishes.
+func NewDB(t testing.TB) *sql.DB {
+	t.Helper()
+
+	db, err := database.InitDB(database.MemoryPath)
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// NewLot returns a parking lot fixture with all optional fields set
+func NewLot(id, city string) *database.ParkingLot {
+	lot := &database.ParkingLot{
+		ID:    id,
+		City:  city,
+		Name:  "Lot " + id,
+		Total: 100,
+	}
+	lot.Address.String, lot.Address.Valid = "Hauptstraße 1", true
+	lot.LotType.String, lot.LotType.Valid = "Parkhaus", true
+	lot.Latitude.Float64, lot.Latitude.Valid = 51.05, true
+	lot.Longitude.Float64, lot.Longitude.Valid = 13.74, true
+	lot.Region.String, lot.Region.Valid = "Innere Altstadt", true
+	return lot
+}
+
+// NewReading returns an open reading fixture for a lot
+func NewReading(lotID, city string, timestamp time.Time, free int) *database.ParkingReading {
+	return &database.ParkingReading{
+		LotID:     lotID,
+		City:      city,
+		Timestamp: timestamp,
+		Free:      free,
+		State:     "open",
+	}
+}
+
+// InsertLots upserts lot fixtures, failing the test on error
+func InsertLots(t testing.TB, db *sql.DB, lots ...*database.ParkingLot) {
+	t.Helper()
+	for _, lot := range lots {
+		if err := database.UpsertParkingLot(db, lot); err != nil {
+			t.Fatalf("Failed to insert lot %s: %v", lot.ID, err)
+		}
+	}
+}
+
+// InsertReadings inserts reading fixtures, failing the test on error
+func InsertReadings(t testing.TB, db *sql.DB, readings ...*database.ParkingReading) {
+	t.Helper()
+	for _, reading := range readings {
+		if err := database.InsertReading(db, reading); err != nil {
+			t.Fatalf("Failed to insert reading for %s: %v", reading.LotID, err)
+		}
+	}
+}
+
+// CountRows returns the number of rows in a table
+func CountRows(t testing.TB, db *sql.DB, table string) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&count); err != nil {
+		t.Fatalf("Failed to count rows in %s: %v", table, err)
+	}
+	return count
+}
@@ -0,0 +1,73 @@
+// Package tracing defines the minimal span API the ingestor is instrumented
+// with. It has no dependencies so tracing stays optional: the default Noop
+// tracer does nothing, and embedders that use OpenTelemetry plug it in with
+// a small adapter in their own module, for example:
+//
+//	type otelTracer struct{ t trace.Tracer }
+//
+//	func (o otelTracer) Start(ctx context.Context, name string, attrs ...tracing.Attribute) (context.Context, tracing.Span) {
+//		ctx, span := o.t.Start(ctx, name)
+//		s := otelSpan{span}
+//		s.SetAttributes(attrs...)
+//		return ctx, s
+//	}
+//
+// where otelSpan converts each Attribute to an attribute.KeyValue.
+package tracing
+
+import "context"
+
+// Attribute is a key/value pair attached to a span
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String returns a string attribute
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int returns an integer attribute
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a single traced operation
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts spans. The returned context carries the new span so child
+// spans started from it are nested.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Noop is a Tracer that records nothing
+type Noop struct{}
+
+// Start returns ctx unchanged and a span that ignores all calls
+func (Noop) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
+
+// Finish records the outcome of an operation as a "status" attribute
+// ("ok" or "error"), records err if any, and ends the span
+func Finish(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(String("status", "error"))
+	} else {
+		span.SetAttributes(String("status", "ok"))
+	}
+	span.End()
+}
@@ -0,0 +1,23 @@
+// Package version holds build metadata injected at link time, e.g.
+//
+//	go build -ldflags "-X github.com/niklas/parkmonitor/ingestor/internal/version.Version=v1.2.0"
+package version
+
+import "fmt"
+
+// Set via -ldflags at build time; the defaults identify a development build
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+// String returns a human-readable version line
+func String() string {
+	return fmt.Sprintf("parking-ingestor %s (commit %s, built %s)", Version, Commit, Date)
+}
+
+// UserAgent returns the User-Agent sent with outbound API requests
+func UserAgent() string {
+	return fmt.Sprintf("parkmonitor-ingestor/%s (+https://github.com/nimalu/parkmonitor)", Version)
+}
@@ -0,0 +1,73 @@
+package parkmonitor
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// refreshCities re-fetches the city list every m.citiesInterval until ctx
+// is done, so that cities listed after startup are polled too
+func (m *Monitor) refreshCities(ctx context.Context) {
+	ticker := time.NewTicker(m.citiesInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		m.updateCities(ctx)
+	}
+}
+
+// updateCities fetches the city list once and replaces the polled cities
+// with it. A failed fetch or an empty list, which the API returns during
+// outages, keeps the current cities.
+func (m *Monitor) updateCities(ctx context.Context) {
+	listed, err := m.client.GetCitiesContext(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("Warning: failed to refresh cities, keeping the current ones: %v", err)
+		return
+	}
+	if len(listed) == 0 {
+		log.Printf("Warning: API listed no cities, keeping the current ones")
+		return
+	}
+	storeCities(m.db, listed)
+
+	cities := discoverCities(listed, m.maxCities)
+	added, removed := diffCities(m.ingestor.Cities(), cities)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	if len(added) > 0 {
+		log.Printf("Monitoring %d new cities: %s", len(added), strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		log.Printf("No longer monitoring %d cities: %s", len(removed), strings.Join(removed, ", "))
+	}
+	m.ingestor.SetCities(cities)
+}
+
+// diffCities returns the cities of next missing from prev, and those of
+// prev missing from next
+func diffCities(prev, next []string) (added, removed []string) {
+	for _, city := range next {
+		if !slices.Contains(prev, city) {
+			added = append(added, city)
+		}
+	}
+	for _, city := range prev {
+		if !slices.Contains(next, city) {
+			removed = append(removed, city)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,80 @@
+package parkmonitor
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/metrics"
+)
+
+// dbMetricsInterval is how often the database gauges are refreshed
+const dbMetricsInterval = time.Minute
+
+var (
+	dbSize = metrics.Default.NewGaugeVec("parkmonitor_database_size_bytes",
+		"Size of the SQLite database file and its write-ahead log on disk.")
+	dbReadings = metrics.Default.NewGaugeVec("parkmonitor_database_readings",
+		"Readings stored in the database.")
+	dbLots = metrics.Default.NewGaugeVec("parkmonitor_database_lots",
+		"Parking lots stored in the database.")
+)
+
+// refreshDBMetrics updates the database gauges now and then every
+// dbMetricsInterval until ctx is done
+func (m *Monitor) refreshDBMetrics(ctx context.Context) {
+	ticker := time.NewTicker(dbMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.updateDBMetrics(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Warning: failed to update database metrics: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// updateDBMetrics sets the database gauges. The file size is left out for
+// in-memory databases.
+func (m *Monitor) updateDBMetrics(ctx context.Context) error {
+	if m.dbPath != database.MemoryPath {
+		size, err := fileSize(m.dbPath)
+		if err != nil {
+			return err
+		}
+		// The write-ahead log takes disk space until it is checkpointed
+		wal, err := fileSize(m.dbPath + "-wal")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		dbSize.Set(float64(size + wal))
+	}
+
+	var lots, readings int64
+	if err := m.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM parking_lots`).Scan(&lots); err != nil {
+		return err
+	}
+	if err := m.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM parking_readings`).Scan(&readings); err != nil {
+		return err
+	}
+	dbLots.Set(float64(lots))
+	dbReadings.Set(float64(readings))
+	return nil
+}
+
+// fileSize returns the size of the file at path in bytes
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,644 @@
+// Package parkmonitor embeds ParkenDD ingestion in another Go program.
+//
+// It exposes the same behavior as "parking-ingestor ingest" without the
+// CLI. Build a Config, either from DefaultConfig or from ParseFlags, then
+// run a Monitor:
+//
+//	cfg := parkmonitor.DefaultConfig()
+//	cfg.DBPath = "/var/lib/parking.db"
+//	cfg.Cities = []string{"Dresden"}
+//
+//	mon, err := parkmonitor.New(cfg)
+//	if err != nil {
+//		return err
+//	}
+//	defer mon.Close()
+//
+//	go mon.Start(ctx)
+//	...
+//	mon.Stop()
+//
+// Errors are returned to the caller; nothing in this package exits the
+// process or parses os.Args.
+package parkmonitor
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/alert"
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+	"github.com/niklas/parkmonitor/ingestor/internal/backoff"
+	"github.com/niklas/parkmonitor/ingestor/internal/config"
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/ingestor"
+)
+
+// Config configures a Monitor. See the ingest command's flags for the
+// meaning of each field.
+type Config = config.Config
+
+// ConfigError marks errors caused by an invalid Config or invalid flags
+type ConfigError = config.Error
+
+// DiscoveryError is returned by New when no cities are configured and the
+// API's city list could not be fetched, even after retrying
+type DiscoveryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *DiscoveryError) Error() string {
+	return fmt.Sprintf("failed to discover cities after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *DiscoveryError) Unwrap() error { return e.Err }
+
+// discoveryAttempts and discoveryBackoff bound the retries of city
+// discovery at startup; the wait doubles after each failed attempt
+var (
+	discoveryAttempts = 3
+	discoveryBackoff  = 2 * time.Second
+)
+
+// DefaultConfig returns the configuration the CLI uses when no flags are given
+func DefaultConfig() *Config {
+	return config.Default()
+}
+
+// ParseFlags builds a Config from ingest command-line arguments
+func ParseFlags(args []string) (*Config, error) {
+	return config.ParseFlags(args)
+}
+
+// emitBuffer is how many reading events -emit-json queues for a slow
+// stdout before dropping new ones
+const emitBuffer = 10000
+
+// Monitor polls ParkenDD and stores readings in a SQLite database
+type Monitor struct {
+	db       *sql.DB
+	dbPath   string
+	extraDBs []*sql.DB
+	client   *api.Client
+	ingestor *ingestor.Ingestor
+	replay   *api.ReplayClient
+	emitter  *ingestor.Emitter
+	events   *ingestor.Bus
+	alerts   *alert.Engine
+
+	compactOnExit bool
+	compactGzip   bool
+
+	// keepLast, if positive, is how many readings per lot are kept
+	keepLast int
+
+	// latestReadings keeps latest_readings up to date in every database
+	latestReadings bool
+
+	// silentAfter, if positive, is how long a lot may go without data
+	// before it counts as silent; silentLog is how often they are logged
+	silentAfter time.Duration
+	silentLog   time.Duration
+
+	// insertBatchRows caps the readings per INSERT statement (0 = no cap)
+	insertBatchRows int
+
+	// citiesInterval, if positive, is how often discovered cities are
+	// refreshed from the API; maxCities caps them as at startup
+	citiesInterval time.Duration
+	maxCities      int
+}
+
+// New opens and migrates the database and prepares ingestion for cfg.
+// When cfg.Cities is empty, all cities known to the API are monitored.
+// The caller must Close the monitor to release the database.
+func New(cfg *Config) (*Monitor, error) {
+	return NewContext(context.Background(), cfg)
+}
+
+// NewContext is New, giving up on retrying city discovery when ctx is done
+func NewContext(ctx context.Context, cfg *Config) (*Monitor, error) {
+	if cfg.Interval <= 0 {
+		return nil, &ConfigError{Err: fmt.Errorf("invalid polling interval %v", cfg.Interval)}
+	}
+
+	opts, err := options(cfg)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	var alertCfg *alert.Config
+	if cfg.AlertsConfig != "" {
+		if alertCfg, err = alert.LoadConfig(cfg.AlertsConfig); err != nil {
+			return nil, &ConfigError{Err: err}
+		}
+		alertCfg.Jitter = cfg.RetryJitter
+	}
+
+	db, err := database.InitDBWithPragmas(cfg.DBPath, cfg.Pragmas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	m := &Monitor{
+		db:              db,
+		dbPath:          cfg.DBPath,
+		events:          ingestor.NewBus(),
+		compactOnExit:   cfg.CompactOnExit,
+		compactGzip:     cfg.CompactGzip,
+		keepLast:        cfg.KeepLast,
+		latestReadings:  cfg.LatestReadings,
+		silentAfter:     cfg.SilentLotAfter,
+		silentLog:       cfg.SilentLotLog,
+		insertBatchRows: cfg.InsertBatchRows,
+	}
+	for _, path := range cfg.ExtraDBPaths {
+		extra, err := database.InitDBWithPragmas(path, cfg.Pragmas)
+		if err != nil {
+			m.closeDBs()
+			return nil, fmt.Errorf("failed to initialize database %s: %w", path, err)
+		}
+		m.extraDBs = append(m.extraDBs, extra)
+	}
+	if m.latestReadings {
+		// The table may have missed readings while tracking was off
+		for _, db := range m.dbs() {
+			if err := database.RebuildLatestReadings(context.Background(), db); err != nil {
+				m.closeDBs()
+				return nil, fmt.Errorf("failed to rebuild latest readings: %w", err)
+			}
+		}
+	}
+
+	if alertCfg != nil {
+		if m.alerts, err = alert.NewEngine(alertCfg); err != nil {
+			m.closeDBs()
+			return nil, &ConfigError{Err: err}
+		}
+		log.Printf("Loaded %d alert rules", len(alertCfg.Rules))
+		opts = append(opts, ingestor.WithAlerts(m.alerts))
+	}
+	if cfg.EmitJSON {
+		m.emitter = ingestor.NewEmitter(os.Stdout, emitBuffer)
+		opts = append(opts, ingestor.WithEmitter(m.emitter))
+	}
+	opts = append(opts, ingestor.WithBus(m.events))
+
+	if err := m.init(ctx, cfg, opts); err != nil {
+		if m.alerts != nil {
+			m.alerts.Close()
+		}
+		if m.emitter != nil {
+			m.emitter.Close()
+		}
+		m.closeDBs()
+		return nil, err
+	}
+	return m, nil
+}
+
+// store returns the store the ingestor writes to: the primary database,
+// teed to any extra databases
+func (m *Monitor) store() database.Store {
+	var opts []database.StoreOption
+	if m.latestReadings {
+		opts = append(opts, database.TrackLatest())
+	}
+	if m.insertBatchRows > 0 {
+		opts = append(opts, database.BatchRows(m.insertBatchRows))
+	}
+
+	primary := database.NewSQLiteStore(m.db, opts...)
+	if len(m.extraDBs) == 0 {
+		return primary
+	}
+
+	stores := []database.Store{primary}
+	for _, db := range m.extraDBs {
+		stores = append(stores, database.NewSQLiteStore(db, opts...))
+	}
+	return database.NewMultiStore(stores...)
+}
+
+// dbs returns the primary database followed by the extra databases
+func (m *Monitor) dbs() []*sql.DB {
+	return append([]*sql.DB{m.db}, m.extraDBs...)
+}
+
+// closeDBs closes the primary and extra databases, returning the first error
+func (m *Monitor) closeDBs() error {
+	var err error
+	for _, db := range m.dbs() {
+		if closeErr := db.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// init creates the API client and ingestor
+func (m *Monitor) init(ctx context.Context, cfg *Config, opts []ingestor.Option) error {
+	if cfg.ReplayDir != "" {
+		replay, err := api.NewReplayClient(cfg.ReplayDir)
+		if err != nil {
+			return fmt.Errorf("failed to load captures: %w", err)
+		}
+		m.replay = replay
+		cities := replay.Cities()
+		if err := cfg.CityNames.Validate(cities); err != nil {
+			return &ConfigError{Err: err}
+		}
+		m.ingestor = ingestor.NewWithStore(m.store(), replay, cities, cfg.Interval, opts...)
+		return nil
+	}
+
+	client := api.NewClientWithOptions(api.Options{
+		BaseURL:   cfg.BaseURL,
+		RawDir:    cfg.RawDir,
+		RateLimit: cfg.RateLimit,
+		Burst:     cfg.RateBurst,
+
+		MaxConnections: cfg.FetchConcurrency,
+		Proxy:          cfg.Proxy,
+		Token:          cfg.APIToken,
+		Breaker:        cfg.Breaker,
+		Retry:          retryOptions(cfg),
+
+		Conditional: cfg.SkipUnchanged,
+	})
+	if cfg.RawDir != "" {
+		log.Printf("Storing raw API responses in %s", cfg.RawDir)
+	}
+	if cfg.Breaker.Failures > 0 {
+		log.Printf("API circuit breaker: %v", cfg.Breaker)
+	}
+
+	// City metadata is stored for provenance; without it, configured
+	// cities can still be polled. Discovery depends on it, so it is retried.
+	attempts := 1
+	if len(cfg.Cities) == 0 {
+		attempts = discoveryAttempts
+	}
+	citiesMap, err := fetchCities(ctx, client, attempts, cfg.RetryJitter)
+	if err != nil {
+		if len(cfg.Cities) == 0 {
+			return err
+		}
+		log.Printf("Warning: failed to fetch city metadata, polling the configured cities: %v", err)
+	} else {
+		storeCities(m.db, citiesMap)
+	}
+
+	cities := cfg.Cities
+	if len(cities) == 0 {
+		log.Printf("No cities specified, monitoring all available cities")
+		cities = discoverCities(citiesMap, cfg.MaxCities)
+		log.Printf("Found %d cities", len(citiesMap))
+		if cfg.AutoRefreshCities > 0 {
+			log.Printf("Refreshing cities every %v", cfg.AutoRefreshCities)
+			m.citiesInterval = cfg.AutoRefreshCities
+			m.maxCities = cfg.MaxCities
+		}
+	}
+	if err := cfg.CityNames.Validate(cities); err != nil {
+		return &ConfigError{Err: err}
+	}
+
+	m.client = client
+	m.ingestor = ingestor.NewWithStore(m.store(), client, cities, cfg.Interval, opts...)
+	return nil
+}
+
+// retryOptions returns cfg.Retry with the configured jitter
+func retryOptions(cfg *Config) api.RetryOptions {
+	retry := cfg.Retry
+	retry.Jitter = cfg.RetryJitter
+	return retry
+}
+
+// fetchCities fetches the API's city list, trying up to attempts times
+// until ctx is done. The error after the last attempt is returned as a
+// *DiscoveryError.
+func fetchCities(ctx context.Context, client *api.Client, attempts int, jitter backoff.Jitter) (map[string]api.CityInfo, error) {
+	policy := backoff.Policy{Base: discoveryBackoff, Jitter: jitter}
+	var err error
+	for attempt := range attempts {
+		if attempt > 0 {
+			delay := policy.Delay(attempt)
+			log.Printf("Warning: failed to fetch cities (attempt %d of %d), retrying in %v: %v", attempt, attempts, delay, err)
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, &DiscoveryError{Attempts: attempt, Err: ctx.Err()}
+			case <-timer.C:
+			}
+		}
+
+		var cities map[string]api.CityInfo
+		if cities, err = client.GetCitiesContext(ctx); err == nil {
+			return cities, nil
+		}
+	}
+	return nil, &DiscoveryError{Attempts: attempts, Err: err}
+}
+
+// discoverCities returns the IDs of the listed cities sorted by ID, so
+// that polling order and logs are the same on every start. With a positive
+// limit, only limit cities are returned, actively supported ones first and
+// then by name, and the rest are named in a warning.
+func discoverCities(cities map[string]api.CityInfo, limit int) []string {
+	ids := make([]string, 0, len(cities))
+	for id := range cities {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		ca, cb := cities[ids[a]], cities[ids[b]]
+		if ca.ActiveSupport != cb.ActiveSupport {
+			return ca.ActiveSupport
+		}
+		if ca.Name != cb.Name {
+			return ca.Name < cb.Name
+		}
+		return ids[a] < ids[b]
+	})
+
+	if limit > 0 && len(ids) > limit {
+		log.Printf("Warning: -max-cities %d leaves %d of %d cities unmonitored: %s",
+			limit, len(ids)-limit, len(ids), strings.Join(ids[limit:], ", "))
+		ids = ids[:limit]
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// storeCities saves the metadata of every city listed by the API
+func storeCities(db *sql.DB, cities map[string]api.CityInfo) {
+	for id, info := range cities {
+		city := &database.City{
+			ID:            id,
+			Name:          info.Name,
+			Source:        sql.NullString{String: info.Source, Valid: info.Source != ""},
+			URL:           sql.NullString{String: info.URL, Valid: info.URL != ""},
+			ActiveSupport: info.ActiveSupport,
+		}
+		if info.Coords.Lat != 0 || info.Coords.Lng != 0 {
+			city.Latitude = sql.NullFloat64{Float64: info.Coords.Lat, Valid: true}
+			city.Longitude = sql.NullFloat64{Float64: info.Coords.Lng, Valid: true}
+		}
+		if err := database.UpsertCity(db, city); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+}
+
+// options translates cfg into ingestor options
+func options(cfg *Config) ([]ingestor.Option, error) {
+	var opts []ingestor.Option
+	if cfg.CycleTimeout > 0 {
+		log.Printf("Poll cycle timeout: %v", cfg.CycleTimeout)
+		opts = append(opts, ingestor.WithCycleTimeout(cfg.CycleTimeout))
+	}
+	if cfg.InstanceID != "" {
+		opts = append(opts, ingestor.WithInstance(cfg.InstanceID))
+	}
+	if cfg.TimestampSource != "" {
+		opts = append(opts, ingestor.WithTimestampSource(cfg.TimestampSource))
+	}
+	if cfg.FirstPoll != "" {
+		opts = append(opts, ingestor.WithFirstPoll(cfg.FirstPoll))
+	}
+	if cfg.ActiveHours != nil {
+		log.Printf("Polling only during active hours %s", cfg.ActiveHours)
+		opts = append(opts, ingestor.WithActiveHours(*cfg.ActiveHours))
+	}
+	if cfg.StaleAfter > 0 {
+		opts = append(opts, ingestor.WithStaleAfter(cfg.StaleAfter))
+	}
+	if cfg.ShutdownTimeout > 0 {
+		opts = append(opts, ingestor.WithShutdownTimeout(cfg.ShutdownTimeout))
+	}
+	if cfg.MaxBackoff > 0 {
+		opts = append(opts, ingestor.WithOutageBackoff(cfg.MaxBackoff))
+	}
+	if cfg.RestartGap > 0 {
+		opts = append(opts, ingestor.WithRestartGap(cfg.RestartGap))
+	}
+	if cfg.RetryJitter != "" {
+		opts = append(opts, ingestor.WithJitter(cfg.RetryJitter))
+	}
+	opts = append(opts, ingestor.WithSanityCheck(ingestor.SanityCheck{
+		MinLots:          cfg.SanityMinLots,
+		MaxZeroTotal:     cfg.SanityMaxZeroTotal,
+		MaxMissingFields: cfg.SanityMaxMissing,
+	}))
+
+	if len(cfg.LotTypes) > 0 || len(cfg.ExcludeLotTypes) > 0 {
+		log.Printf("Filtering lot types: include %v, exclude %v", cfg.LotTypes, cfg.ExcludeLotTypes)
+		opts = append(opts, ingestor.WithLotFilter(ingestor.LotTypeFilter(cfg.LotTypes, cfg.ExcludeLotTypes)))
+	}
+	if len(cfg.Lots) > 0 || len(cfg.ExcludeLots) > 0 {
+		log.Printf("Filtering lots: include %v, exclude %v", cfg.Lots, cfg.ExcludeLots)
+		opts = append(opts, ingestor.WithLotFilter(ingestor.LotIDFilter(cfg.Lots, cfg.ExcludeLots)))
+	}
+
+	if box := cfg.BoundingBox; box != nil {
+		if len(box) != 4 {
+			return nil, errors.New("bounding box needs minLat, minLng, maxLat and maxLng")
+		}
+		log.Printf("Filtering lots to bounding box %v", box)
+		opts = append(opts, ingestor.WithLotFilter(
+			ingestor.BoundingBoxFilter(box[0], box[1], box[2], box[3], cfg.IncludeUnlocated)))
+	}
+	if len(cfg.Regions) > 0 {
+		log.Printf("Filtering lots to regions %v", cfg.Regions)
+		opts = append(opts, ingestor.WithLotFilter(ingestor.RegionFilter(cfg.Regions)))
+	}
+
+	return opts, nil
+}
+
+// Cities returns the cities being monitored
+func (m *Monitor) Cities() []string {
+	return m.ingestor.Cities()
+}
+
+// DB returns the database the monitor writes to, e.g. for queries
+func (m *Monitor) DB() *sql.DB {
+	return m.db
+}
+
+// Start polls until ctx is cancelled or Stop is called. In replay mode it
+// ingests every capture once, in chronological order, and returns. While
+// it runs, the database size and row count metrics are kept up to date
+// and, with Config.KeepLast, old readings are pruned. With
+// Config.AutoRefreshCities, discovered cities are refreshed, and with
+// Config.SilentLotAfter, silent lots are reported.
+func (m *Monitor) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.refreshDBMetrics(ctx)
+	}()
+	if m.keepLast > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.pruneReadings(ctx)
+		}()
+	}
+	if m.silentAfter > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.watchSilentLots(ctx)
+		}()
+	}
+	if m.citiesInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.refreshCities(ctx)
+		}()
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	if m.replay != nil {
+		return m.runReplay(ctx)
+	}
+	return m.ingestor.Start(ctx)
+}
+
+// RefreshCity polls a monitored city immediately, coordinating with the
+// scheduled polls so the same data is never stored twice
+func (m *Monitor) RefreshCity(ctx context.Context, city string) (*ingestor.CityResult, error) {
+	return m.ingestor.RefreshCity(ctx, city)
+}
+
+// RefreshLots fetches every monitored city once and updates its lots
+// metadata without storing readings
+func (m *Monitor) RefreshLots(ctx context.Context) error {
+	return m.ingestor.RefreshLots(ctx)
+}
+
+// Summary reports what the monitor ingested since it was created
+func (m *Monitor) Summary() *ingestor.Summary {
+	return m.ingestor.Summary()
+}
+
+// Events returns the bus every stored reading is published to
+func (m *Monitor) Events() *ingestor.Bus {
+	return m.events
+}
+
+// Stop ends a running Start and waits for it to return
+func (m *Monitor) Stop() {
+	m.ingestor.Stop()
+}
+
+// Close releases the databases. Stop the monitor first, so that with
+// CompactOnExit the database is compacted after the final poll.
+func (m *Monitor) Close() error {
+	m.events.Close()
+	if m.emitter != nil {
+		m.emitter.Close()
+	}
+	if m.alerts != nil {
+		m.alerts.Close()
+	}
+
+	compact := m.compactOnExit && m.dbPath != database.MemoryPath
+	if compact {
+		m.compact()
+	}
+
+	if err := m.closeDBs(); err != nil {
+		return err
+	}
+
+	if compact && m.compactGzip {
+		archive, err := gzipFile(m.dbPath)
+		if err != nil {
+			return fmt.Errorf("failed to compress database: %w", err)
+		}
+		log.Printf("Wrote compressed copy of the database to %s", archive)
+	}
+	return nil
+}
+
+// compact runs VACUUM and logs how much space it freed
+func (m *Monitor) compact() {
+	log.Printf("Compacting database %s...", m.dbPath)
+	before, after, err := database.Compact(m.db)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	log.Printf("Compacted database from %d to %d bytes", before, after)
+}
+
+// gzipFile writes a gzip-compressed copy of path to path + ".gz"
+func gzipFile(path string) (archive string, err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	archive = path + ".gz"
+	out, err := os.Create(archive)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		return "", err
+	}
+	return archive, zw.Close()
+}
+
+// runReplay ingests every loaded capture in chronological order. A
+// capture that fails is logged and skipped, and fails the replay once all
+// others are ingested.
+func (m *Monitor) runReplay(ctx context.Context) error {
+	captures := m.replay.Captures()
+	log.Printf("Replaying %d captures for %d cities", len(captures), len(m.Cities()))
+
+	failed := 0
+	for _, capture := range captures {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := m.ingestor.PollCity(ctx, capture.City); err != nil {
+			log.Printf("Error replaying %s: %v", capture.Path, err)
+			failed++
+		}
+	}
+
+	log.Printf("Replay complete: %d captures ingested, %d failed", len(captures)-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("failed to replay %d of %d captures", failed, len(captures))
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package parkmonitor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/api"
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/testutil"
+)
+
+func TestMonitor(t *testing.T) {
+	server := testutil.NewParkenDD(t, testutil.DefaultCities[0])
+
+	cfg := DefaultConfig()
+	cfg.DBPath = database.MemoryPath
+	cfg.BaseURL = server.URL
+	cfg.Interval = time.Hour
+	cfg.ExtraDBPaths = []string{filepath.Join(t.TempDir(), "central.db")}
+
+	mon, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer mon.Close()
+
+	if cities := mon.Cities(); len(cities) != 1 || cities[0] != "Dresden" {
+		t.Fatalf("Expected discovered city Dresden, got %v", cities)
+	}
+
+	cities, err := database.GetCities(mon.DB())
+	if err != nil {
+		t.Fatalf("GetCities() error: %v", err)
+	}
+	if len(cities) != 1 || cities[0].Source.String != "https://dresden.example" {
+		t.Errorf("Expected stored city source, got %+v", cities)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- mon.Start(context.Background()) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var count int
+		if err := mon.DB().QueryRow(`SELECT COUNT(*) FROM parking_readings`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the first poll")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mon.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Start() error: %v", err)
+	}
+
+	var teed int
+	if err := mon.extraDBs[0].QueryRow(`SELECT COUNT(*) FROM parking_readings`).Scan(&teed); err != nil {
+		t.Fatal(err)
+	}
+	if teed == 0 {
+		t.Error("Expected readings in the extra database")
+	}
+}
+
+func TestMonitorEndToEnd(t *testing.T) {
+	server := testutil.NewParkenDD(t)
+
+	cfg := DefaultConfig()
+	cfg.DBPath = database.MemoryPath
+	cfg.BaseURL = server.URL
+	cfg.Interval = time.Hour
+
+	mon, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer mon.Close()
+
+	ctx := context.Background()
+	result, err := mon.RefreshCity(ctx, "Dresden")
+	if err != nil {
+		t.Fatalf("RefreshCity() error: %v", err)
+	}
+	if result.Lots != 3 {
+		t.Errorf("got %d lots stored, expected 3", result.Lots)
+	}
+
+	regions, err := database.GetRegions(mon.DB(), "Dresden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regions) != 2 {
+		t.Errorf("got regions %v, expected Innere Altstadt and Neustadt", regions)
+	}
+	lot, err := database.GetLot(mon.DB(), "dresdenaltmarkt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lot.Latitude.Valid || !lot.Extras.Valid {
+		t.Errorf("Expected coordinates and the forecast flag to be stored, got %+v", lot)
+	}
+
+	// A city without lots fails instead of storing nothing
+	if _, err := mon.RefreshCity(ctx, "Leipzig"); err == nil {
+		t.Error("Expected an error refreshing a city without lots")
+	}
+	if server.Requests("/Leipzig") != 1 {
+		t.Errorf("got %d requests for Leipzig, expected 1", server.Requests("/Leipzig"))
+	}
+}
+
+func TestUpdateDBMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "parking.db")
+	db, err := database.InitDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	lot := &database.ParkingLot{ID: "d1", City: "Dresden", Name: "Altmarkt", Total: 400}
+	if err := database.UpsertParkingLot(db, lot); err != nil {
+		t.Fatal(err)
+	}
+	reading := &database.ParkingReading{LotID: "d1", City: "Dresden", Timestamp: time.Now(), Free: 10, State: "open"}
+	if err := database.InsertReading(db, reading); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Monitor{db: db, dbPath: path}
+	if err := m.updateDBMetrics(context.Background()); err != nil {
+		t.Fatalf("updateDBMetrics() error: %v", err)
+	}
+	if dbLots.Value() != 1 || dbReadings.Value() != 1 {
+		t.Errorf("got %v lots and %v readings, expected 1 and 1", dbLots.Value(), dbReadings.Value())
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbSize.Value() < float64(info.Size()) {
+		t.Errorf("got size %v, expected at least the file's %d bytes", dbSize.Value(), info.Size())
+	}
+}
+
+func TestNewRetriesDiscovery(t *testing.T) {
+	defer func(backoff time.Duration) { discoveryBackoff = backoff }(discoveryBackoff)
+	discoveryBackoff = time.Millisecond
+
+	var requests, failures int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"cities": {"Dresden": {"name": "Dresden"}}}`))
+	}))
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.DBPath = database.MemoryPath
+	cfg.BaseURL = server.URL
+	// Count discovery attempts, not the client's retries of each
+	cfg.Retry = api.RetryOptions{}
+
+	// A transient failure is retried
+	failures = discoveryAttempts - 1
+	mon, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	mon.Close()
+	if cities := mon.Cities(); len(cities) != 1 || cities[0] != "Dresden" {
+		t.Errorf("Expected discovered city Dresden, got %v", cities)
+	}
+
+	// A persistent failure is reported as a DiscoveryError
+	requests, failures = 0, discoveryAttempts
+	var discErr *DiscoveryError
+	if _, err := New(cfg); !errors.As(err, &discErr) || discErr.Attempts != discoveryAttempts {
+		t.Errorf("Expected a DiscoveryError after %d attempts, got %v", discoveryAttempts, err)
+	}
+
+	// Cancelling ends the retries early
+	requests, failures = 0, discoveryAttempts
+	discoveryBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := NewContext(ctx, cfg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a cancelled discovery, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, expected 1 before cancelling", requests)
+	}
+
+	// Configured cities are polled without metadata and without retrying
+	requests = 0
+	cfg.Cities = []string{"Dresden"}
+	if mon, err = New(cfg); err != nil {
+		t.Fatalf("New() with cities error: %v", err)
+	}
+	mon.Close()
+	if requests != 1 {
+		t.Errorf("got %d requests, expected 1", requests)
+	}
+}
+
+func TestDiscoverCities(t *testing.T) {
+	cities := map[string]api.CityInfo{
+		"Zwickau": {Name: "Zwickau", ActiveSupport: true},
+		"Basel":   {Name: "Basel"},
+		"Dresden": {Name: "Dresden", ActiveSupport: true},
+		"Aachen":  {Name: "Aachen"},
+	}
+
+	tests := []struct {
+		limit    int
+		expected []string
+	}{
+		{0, []string{"Aachen", "Basel", "Dresden", "Zwickau"}},
+		{3, []string{"Aachen", "Dresden", "Zwickau"}},
+		{1, []string{"Dresden"}},
+		{10, []string{"Aachen", "Basel", "Dresden", "Zwickau"}},
+	}
+	for _, tt := range tests {
+		// Map iteration order must not matter
+		for range 5 {
+			got := discoverCities(cities, tt.limit)
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("limit %d: got %v, expected %v", tt.limit, got, tt.expected)
+			}
+		}
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DBPath = database.MemoryPath
+	cfg.Interval = 0
+
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected an error for a zero interval")
+	}
+}
+
+func TestGzipFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "parking.db")
+	content := []byte("SQLite format 3\x00")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := gzipFile(path)
+	if err != nil {
+		t.Fatalf("gzipFile() error: %v", err)
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Invalid gzip archive: %v", err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Archive content %q, expected %q", got, content)
+	}
+}
+
+func TestUpdateCities(t *testing.T) {
+	body := `{"cities": {"Dresden": {"name": "Dresden"}}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body == "" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.DBPath = database.MemoryPath
+	cfg.BaseURL = server.URL
+	cfg.AutoRefreshCities = time.Hour
+	cfg.Retry = api.RetryOptions{}
+	mon, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer mon.Close()
+
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+	}{
+		{"added", `{"cities": {"Dresden": {"name": "Dresden"}, "Basel": {"name": "Basel"}}}`, []string{"Basel", "Dresden"}},
+		{"failed fetch", "", []string{"Basel", "Dresden"}},
+		{"empty list", `{"cities": {}}`, []string{"Basel", "Dresden"}},
+		{"removed", `{"cities": {"Basel": {"name": "Basel"}}}`, []string{"Basel"}},
+	}
+	for _, tt := range tests {
+		body = tt.body
+		mon.updateCities(context.Background())
+		if got := mon.Cities(); !slices.Equal(got, tt.expected) {
+			t.Errorf("%s: got %v, expected %v", tt.name, got, tt.expected)
+		}
+	}
+}
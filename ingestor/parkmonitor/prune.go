@@ -0,0 +1,35 @@
+package parkmonitor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+)
+
+// pruneInterval is how often readings beyond Config.KeepLast are deleted
+const pruneInterval = time.Hour
+
+// pruneReadings deletes all but the latest m.keepLast readings per lot from
+// every database now and then every pruneInterval until ctx is done
+func (m *Monitor) pruneReadings(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, db := range m.dbs() {
+			deleted, err := database.PruneReadingsKeepLast(ctx, db, m.keepLast)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Warning: %v", err)
+			} else if deleted > 0 {
+				log.Printf("Pruned %d readings beyond the latest %d per lot", deleted, m.keepLast)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package parkmonitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/niklas/parkmonitor/ingestor/internal/database"
+	"github.com/niklas/parkmonitor/ingestor/internal/metrics"
+)
+
+// silentCheckInterval is how often silent lots are looked for
+const silentCheckInterval = time.Minute
+
+// silentForgetAfter is how long a lot without any reading counts as
+// silent before it is taken as removed from the city's lot list
+const silentForgetAfter = 24 * time.Hour
+
+var silentLots = metrics.Default.NewGaugeVec("parkmonitor_silent_lots",
+	"Lots without a reading with data within -silent-lot-after while their city still updates.", "city")
+
+// watchSilentLots updates the silent lot gauge now and then every
+// silentCheckInterval until ctx is done, logging the silent lots every
+// m.silentLog if it is positive
+func (m *Monitor) watchSilentLots(ctx context.Context) {
+	ticker := time.NewTicker(silentCheckInterval)
+	defer ticker.Stop()
+
+	var lastLog time.Time
+	cities := make(map[string]bool)
+	for {
+		now := time.Now()
+		lots, err := database.GetSilentLots(ctx, m.db, now.Add(-m.silentAfter), now.Add(-m.silentAfter-silentForgetAfter))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Warning: %v", err)
+		} else if err == nil {
+			updateSilentLots(cities, lots)
+			if m.silentLog > 0 && len(lots) > 0 && now.Sub(lastLog) >= m.silentLog {
+				logSilentLots(lots, m.silentAfter)
+				lastLog = now
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// updateSilentLots sets the gauge of every city with silent lots, and
+// resets it for the cities in seen that no longer have any
+func updateSilentLots(seen map[string]bool, lots []database.SilentLot) {
+	counts := make(map[string]int)
+	for _, lot := range lots {
+		counts[lot.City]++
+	}
+	for city := range seen {
+		if counts[city] == 0 {
+			silentLots.Set(0, city)
+		}
+	}
+	for city, count := range counts {
+		silentLots.Set(float64(count), city)
+		seen[city] = true
+	}
+}
+
+// logSilentLots warns about each silent lot and when it last had data
+func logSilentLots(lots []database.SilentLot, after time.Duration) {
+	names := make([]string, len(lots))
+	for idx, lot := range lots {
+		since := "never"
+		if lot.LastReported.Valid {
+			since = lot.LastReported.Time.Local().Format(time.RFC3339)
+		}
+		names[idx] = fmt.Sprintf("%s/%s (last data %s)", lot.City, lot.LotID, since)
+	}
+	log.Printf("Warning: %d lots reported no data for over %v: %s", len(lots), after, strings.Join(names, ", "))
+}